@@ -11,6 +11,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/pomerium/datasource/internal/bamboohr"
+	"github.com/pomerium/datasource/internal/httputil"
 	"github.com/pomerium/datasource/internal/server"
 )
 
@@ -60,7 +61,7 @@ func (cmd *bambooCmd) exec(c *cobra.Command, _ []string) error {
 	log := zerolog.New(os.Stdout)
 	log.Info().Msg("ready")
 
-	return server.RunHTTPServer(c.Context(), cmd.Address, srv)
+	return runHTTPServer(c.Context(), cmd.Address, "bamboohr", srv)
 }
 
 func (cmd *bambooCmd) newServer() (http.Handler, error) {
@@ -78,9 +79,9 @@ func (cmd *bambooCmd) newServer() (http.Handler, error) {
 		Auth:     auth,
 		Location: location,
 	}
-	client := http.DefaultClient
+	client := httputil.NewRetryClient(httputil.NewInstrumentedClient(upstreamHTTPClient))
 	if cmd.Debug {
-		client = server.NewDebugClient(http.DefaultClient, cmd.Logger)
+		client = server.NewDebugClient(client, cmd.Logger)
 	}
 	srv := bamboohr.NewServer(emplReq, client, cmd.Logger)
 	return srv, nil
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 
-	"github.com/pomerium/datasource/internal/server"
+	"github.com/pomerium/datasource/internal/metrics"
 	"github.com/pomerium/datasource/pkg/blob"
 )
 
@@ -15,15 +19,84 @@ func blobCommand(logger zerolog.Logger) *cobra.Command {
 	}
 	addr := ":8080"
 	debug := false
+	checkInterval := blob.DefaultCheckInterval
 	source := requiredStringFlag(cmd.Flags(), "source", "blob url to serve files from")
+	version := optionalStringFlag(cmd.Flags(), "version", "serve this version from the bundle history instead of the latest bundle")
+	verifyKey := optionalStringFlag(cmd.Flags(), "verify-key", "PEM file with an Ed25519 public key, refuse to serve bundles that aren't signed with it")
 	cmd.Flags().StringVar(&addr, "address", ":8080", "tcp address to listen to")
 	cmd.Flags().BoolVar(&debug, "debug", false, "debug mode")
+	cmd.Flags().DurationVar(&checkInterval, "check-interval", blob.DefaultCheckInterval,
+		"how often to check the bucket for bundle changes, 0 to check on every request")
 	cmd.Run = func(cmd *cobra.Command, _ []string) {
 		if debug {
 			zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		}
 
-		err := server.RunHTTPServer(cmd.Context(), addr, blob.NewHandler(*source))
+		options := []blob.HandlerOption{
+			blob.WithCheckInterval(checkInterval),
+			blob.WithMetricsRecorder(metrics.Recorder{}),
+		}
+		if *version != "" {
+			err := blob.ValidateVersion(*version)
+			if err != nil {
+				logger.Fatal().Err(err).Send()
+			}
+			options = append(options, blob.WithVersion(*version))
+		}
+		if *verifyKey != "" {
+			publicKey, err := blob.ReadVerifyKeyFile(*verifyKey)
+			if err != nil {
+				logger.Fatal().Err(err).Send()
+			}
+			options = append(options, blob.WithVerifyKey(publicKey))
+		}
+
+		h := blob.NewHandler(*source, options...)
+		defer h.Close()
+
+		err := runHTTPServer(cmd.Context(), addr, "blob", h)
+		if err != nil {
+			logger.Fatal().Err(err).Send()
+		}
+	}
+
+	cmd.AddCommand(blobHistoryCommand(logger))
+	cmd.AddCommand(blobRollbackCommand(logger))
+
+	return cmd
+}
+
+func blobHistoryCommand(logger zerolog.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "list the versions in the bundle history, newest first",
+	}
+	source := requiredStringFlag(cmd.Flags(), "source", "blob url with the bundle history")
+	cmd.Run = func(cmd *cobra.Command, _ []string) {
+		versions, err := blob.ListBundleVersions(cmd.Context(), *source)
+		if err != nil {
+			logger.Fatal().Err(err).Send()
+		}
+
+		tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "VERSION\tCREATED AT\tSIZE")
+		for _, v := range versions {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\n", v.ID, v.CreatedAt.Format(time.RFC3339), v.Size)
+		}
+		_ = tw.Flush()
+	}
+	return cmd
+}
+
+func blobRollbackCommand(logger zerolog.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "replace the bundle with a version from the bundle history",
+	}
+	source := requiredStringFlag(cmd.Flags(), "source", "blob url with the bundle history")
+	to := requiredStringFlag(cmd.Flags(), "to", "the version to roll back to, see the history command")
+	cmd.Run = func(cmd *cobra.Command, _ []string) {
+		err := blob.RollbackBundle(cmd.Context(), *source, *to)
 		if err != nil {
 			logger.Fatal().Err(err).Send()
 		}
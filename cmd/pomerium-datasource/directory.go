@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 
+	oktasdk "github.com/okta/okta-sdk-golang/v2/okta"
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"gocloud.dev/gcerrors"
+	"golang.org/x/sync/errgroup"
 
-	"github.com/pomerium/datasource/internal/server"
+	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/metrics"
 	"github.com/pomerium/datasource/pkg/blob"
 	"github.com/pomerium/datasource/pkg/directory"
 	"github.com/pomerium/datasource/pkg/directory/auth0"
@@ -31,146 +36,237 @@ func directoryCommand(logger zerolog.Logger) *cobra.Command {
 		Short: "runs the directory server",
 	}
 
-	cmd.AddCommand(
-		directorySubCommand(logger, "auth0",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				clientID := requiredStringFlag(flags, "client-id", "client id")
-				clientSecret := requiredStringFlag(flags, "client-secret", "client secret")
-				domain := requiredStringFlag(flags, "domain", "domain")
-				return func() directory.Provider {
-					return auth0.New(
-						auth0.WithClientID(*clientID),
-						auth0.WithClientSecret(*clientSecret),
-						auth0.WithDomain(*domain),
-						auth0.WithLogger(logger),
-					)
+	providers := directoryProviders(logger)
+	for _, p := range providers {
+		cmd.AddCommand(directorySubCommand(logger, p.name, p.setupFlags))
+	}
+	cmd.AddCommand(directorySubCommand(logger, "multi", multiDirectorySetupFlags(logger, providers)))
+	return cmd
+}
+
+type directoryProvider struct {
+	name       string
+	setupFlags func(flags *pflag.FlagSet) func() directory.Provider
+}
+
+func directoryProviders(logger zerolog.Logger) []directoryProvider {
+	return []directoryProvider{
+		{"auth0", func(flags *pflag.FlagSet) func() directory.Provider {
+			clientID := requiredStringFlag(flags, "client-id", "client id")
+			clientSecret, clientSecretFile := secretFlags(flags, "client-secret", "client secret")
+			domain := requiredStringFlag(flags, "domain", "domain")
+			return func() directory.Provider {
+				return auth0.New(
+					auth0.WithClientID(*clientID),
+					auth0.WithClientSecret(*clientSecret),
+					auth0.WithClientSecretFile(*clientSecretFile),
+					auth0.WithDomain(*domain),
+					auth0.WithHTTPClient(upstreamHTTPClient),
+					auth0.WithLogger(logger),
+				)
+			}
+		}},
+		{"azure", func(flags *pflag.FlagSet) func() directory.Provider {
+			clientID := requiredStringFlag(flags, "client-id", "client id")
+			clientSecret, clientSecretFile := secretFlags(flags, "client-secret", "client secret")
+			directoryID := requiredStringFlag(flags, "directory-id", "directory id")
+			userAttributes := optionalStringSliceFlag(flags, "user-attribute", "on-premises extension attribute to include in the directory, may be repeated")
+			graphURL := optionalURLFlag(flags, "graph-url", "microsoft graph api url")
+			loginURL := optionalURLFlag(flags, "login-url", "microsoft login url")
+			return func() directory.Provider {
+				options := []azure.Option{
+					azure.WithClientID(*clientID),
+					azure.WithClientSecret(*clientSecret),
+					azure.WithClientSecretFile(*clientSecretFile),
+					azure.WithDirectoryID(*directoryID),
+					azure.WithHTTPClient(upstreamHTTPClient),
+					azure.WithLogger(logger),
+					azure.WithUserAttributes(*userAttributes),
 				}
-			}),
-		directorySubCommand(logger, "azure",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				clientID := requiredStringFlag(flags, "client-id", "client id")
-				clientSecret := requiredStringFlag(flags, "client-secret", "client secret")
-				directoryID := requiredStringFlag(flags, "directory-id", "directory id")
-				return func() directory.Provider {
-					return azure.New(
-						azure.WithClientID(*clientID),
-						azure.WithClientSecret(*clientSecret),
-						azure.WithDirectoryID(*directoryID),
-						azure.WithLogger(logger),
-					)
+				if graphURL.URL != nil {
+					options = append(options, azure.WithGraphURL(graphURL.URL))
 				}
-			}),
-		directorySubCommand(logger, "cognito",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				accessKeyID := optionalStringFlag(flags, "access-key-id", "access key id")
-				region := optionalStringFlag(flags, "region", "aws region")
-				secretAccessKey := optionalStringFlag(flags, "secret-access-key", "secret access key")
-				sessionToken := optionalStringFlag(flags, "session-token", "session token")
-				userPoolID := optionalStringFlag(flags, "user-pool-id", "user pool id")
-				return func() directory.Provider {
-					return cognito.New(
-						cognito.WithAccessKeyID(*accessKeyID),
-						cognito.WithRegion(*region),
-						cognito.WithSecretAccessKey(*secretAccessKey),
-						cognito.WithSessionToken(*sessionToken),
-						cognito.WithUserPoolID(*userPoolID),
-					)
+				if loginURL.URL != nil {
+					options = append(options, azure.WithLoginURL(loginURL.URL))
 				}
-			}),
-		directorySubCommand(logger, "github",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				personalAccessToken := requiredStringFlag(flags, "personal-access-token", "personal access token")
-				username := requiredStringFlag(flags, "username", "username")
-				useNodeIDs := optionalBoolFlag(flags, "use-node-ids", "use node ids instead of logins for ids")
-				return func() directory.Provider {
-					return github.New(
-						github.WithLogger(logger),
-						github.WithPersonalAccessToken(*personalAccessToken),
-						github.WithUseNodeIDs(*useNodeIDs),
-						github.WithUsername(*username),
-					)
+				return azure.New(options...)
+			}
+		}},
+		{"cognito", func(flags *pflag.FlagSet) func() directory.Provider {
+			accessKeyID := optionalStringFlag(flags, "access-key-id", "access key id")
+			region := optionalStringFlag(flags, "region", "aws region")
+			secretAccessKey := optionalStringFlag(flags, "secret-access-key", "secret access key")
+			sessionToken := optionalStringFlag(flags, "session-token", "session token")
+			userPoolID := optionalStringFlag(flags, "user-pool-id", "user pool id")
+			userAttributes := optionalStringSliceFlag(flags, "user-attribute", "user attribute to include in the directory, may be repeated")
+			concurrency := concurrencyFlag(flags)
+			return func() directory.Provider {
+				return cognito.New(
+					cognito.WithAccessKeyID(*accessKeyID),
+					cognito.WithConcurrency(*concurrency),
+					cognito.WithHTTPClient(upstreamHTTPClient),
+					cognito.WithRegion(*region),
+					cognito.WithSecretAccessKey(*secretAccessKey),
+					cognito.WithSessionToken(*sessionToken),
+					cognito.WithUserAttributes(*userAttributes),
+					cognito.WithUserPoolID(*userPoolID),
+				)
+			}
+		}},
+		{"github", func(flags *pflag.FlagSet) func() directory.Provider {
+			personalAccessToken, personalAccessTokenFile := secretFlags(flags, "personal-access-token", "personal access token")
+			username := requiredStringFlag(flags, "username", "username")
+			useNodeIDs := optionalBoolFlag(flags, "use-node-ids", "use node ids instead of logins for ids")
+			apiURL := optionalURLFlag(flags, "url", "github api url")
+			return func() directory.Provider {
+				options := []github.Option{
+					github.WithHTTPClient(upstreamHTTPClient),
+					github.WithLogger(logger),
+					github.WithPersonalAccessToken(*personalAccessToken),
+					github.WithPersonalAccessTokenFile(*personalAccessTokenFile),
+					github.WithUseNodeIDs(*useNodeIDs),
+					github.WithUsername(*username),
 				}
-			}),
-		directorySubCommand(logger, "gitlab",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				privateToken := requiredStringFlag(flags, "private-token", "private token")
-				return func() directory.Provider {
-					return gitlab.New(
-						gitlab.WithLogger(logger),
-						gitlab.WithPrivateToken(*privateToken),
-					)
+				if apiURL.URL != nil {
+					options = append(options, github.WithURL(apiURL.URL))
 				}
-			}),
-		directorySubCommand(logger, "google",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				impersonateUser := requiredStringFlag(flags, "impersonate-user", "impersonate user")
-				jsonKey := optionalBytesFlag(flags, "json-key", "json key (base64)")
-				jsonKeyFile := optionalStringFlag(flags, "json-key-file", "json key file")
-				return func() directory.Provider {
-					return google.New(
-						google.WithImpersonateUser(*impersonateUser),
-						google.WithJSONKey(*jsonKey),
-						google.WithJSONKeyFile(*jsonKeyFile),
-						google.WithLogger(logger),
-					)
+				return github.New(options...)
+			}
+		}},
+		{"gitlab", func(flags *pflag.FlagSet) func() directory.Provider {
+			privateToken, privateTokenFile := secretFlags(flags, "private-token", "private token")
+			flattenNestedGroups := optionalBoolFlag(flags, "flatten-nested-groups", "make members of nested groups members of the parent groups")
+			concurrency := concurrencyFlag(flags)
+			apiURL := optionalURLFlag(flags, "url", "gitlab url")
+			return func() directory.Provider {
+				options := []gitlab.Option{
+					gitlab.WithConcurrency(*concurrency),
+					gitlab.WithFlattenNestedGroups(*flattenNestedGroups),
+					gitlab.WithHTTPClient(upstreamHTTPClient),
+					gitlab.WithLogger(logger),
+					gitlab.WithPrivateToken(*privateToken),
+					gitlab.WithPrivateTokenFile(*privateTokenFile),
 				}
-			}),
-		directorySubCommand(logger, "keycloak",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				clientID := requiredStringFlag(flags, "client-id", "client id")
-				clientSecret := requiredStringFlag(flags, "client-secret", "client secret")
-				realm := requiredStringFlag(flags, "realm", "realm name")
-				url := requiredStringFlag(flags, "url", "url")
-				return func() directory.Provider {
-					return keycloak.New(
-						keycloak.WithClientID(*clientID),
-						keycloak.WithClientSecret(*clientSecret),
-						keycloak.WithRealm(*realm),
-						keycloak.WithLogger(logger),
-						keycloak.WithURL(*url),
-					)
+				if apiURL.URL != nil {
+					options = append(options, gitlab.WithURL(apiURL.URL))
 				}
-			}),
-		directorySubCommand(logger, "okta",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				apiKey := requiredStringFlag(flags, "api-key", "api key")
-				url := requiredStringFlag(flags, "url", "url")
-				return func() directory.Provider {
-					return okta.New(
-						okta.WithAPIKey(*apiKey),
-						okta.WithLogger(logger),
-						okta.WithURL(*url),
-					)
+				return gitlab.New(options...)
+			}
+		}},
+		{"google", func(flags *pflag.FlagSet) func() directory.Provider {
+			impersonateUser := requiredStringFlag(flags, "impersonate-user", "impersonate user")
+			jsonKey := optionalBytesFlag(flags, "json-key", "json key (base64)")
+			jsonKeyFile := optionalStringFlag(flags, "json-key-file", "json key file, re-read when it changes")
+			flattenNestedGroups := optionalBoolFlag(flags, "flatten-nested-groups", "make members of nested groups members of the parent groups")
+			userAttributes := optionalStringSliceFlag(flags, "user-attribute", "custom schema field (schema.field) to include in the directory, may be repeated")
+			concurrency := concurrencyFlag(flags)
+			apiURL := optionalURLFlag(flags, "url", "google apis url")
+			return func() directory.Provider {
+				options := []google.Option{
+					google.WithConcurrency(*concurrency),
+					google.WithFlattenNestedGroups(*flattenNestedGroups),
+					google.WithHTTPClient(upstreamHTTPClient),
+					google.WithImpersonateUser(*impersonateUser),
+					google.WithJSONKey(*jsonKey),
+					google.WithJSONKeyFile(*jsonKeyFile),
+					google.WithLogger(logger),
+					google.WithUserAttributes(*userAttributes),
 				}
-			}),
-		directorySubCommand(logger, "onelogin",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				clientID := requiredStringFlag(flags, "client-id", "client id")
-				clientSecret := requiredStringFlag(flags, "client-secret", "client secret")
-				return func() directory.Provider {
-					return onelogin.New(
-						onelogin.WithClientID(*clientID),
-						onelogin.WithClientSecret(*clientSecret),
-						onelogin.WithLogger(logger),
-					)
+				if apiURL.URL != nil {
+					options = append(options, google.WithURL(apiURL.String()))
 				}
-			}),
-		directorySubCommand(logger, "ping",
-			func(flags *pflag.FlagSet) func() directory.Provider {
-				return func() directory.Provider {
-					clientID := requiredStringFlag(flags, "client-id", "client id")
-					clientSecret := requiredStringFlag(flags, "client-secret", "client secret")
-					environmentID := requiredStringFlag(flags, "environment-id", "environment id")
-					return ping.New(
-						ping.WithClientID(*clientID),
-						ping.WithClientSecret(*clientSecret),
-						ping.WithEnvironmentID(*environmentID),
-						ping.WithLogger(logger),
-					)
+				return google.New(options...)
+			}
+		}},
+		{"keycloak", func(flags *pflag.FlagSet) func() directory.Provider {
+			clientID := requiredStringFlag(flags, "client-id", "client id")
+			clientSecret, clientSecretFile := secretFlags(flags, "client-secret", "client secret")
+			realm := requiredStringFlag(flags, "realm", "realm name")
+			url := requiredStringFlag(flags, "url", "url")
+			flattenNestedGroups := optionalBoolFlag(flags, "flatten-nested-groups", "make members of nested groups members of the parent groups")
+			concurrency := concurrencyFlag(flags)
+			return func() directory.Provider {
+				return keycloak.New(
+					keycloak.WithClientID(*clientID),
+					keycloak.WithClientSecret(*clientSecret),
+					keycloak.WithClientSecretFile(*clientSecretFile),
+					keycloak.WithConcurrency(*concurrency),
+					keycloak.WithFlattenNestedGroups(*flattenNestedGroups),
+					keycloak.WithHTTPClient(upstreamHTTPClient),
+					keycloak.WithRealm(*realm),
+					keycloak.WithLogger(logger),
+					keycloak.WithURL(*url),
+				)
+			}
+		}},
+		{"okta", func(flags *pflag.FlagSet) func() directory.Provider {
+			apiKey, apiKeyFile := secretFlags(flags, "api-key", "api key")
+			url := requiredStringFlag(flags, "url", "url")
+			userAttributes := optionalStringSliceFlag(flags, "user-attribute", "user profile attribute to include in the directory, may be repeated")
+			insecureAllowHTTP := optionalBoolFlag(flags, "insecure-allow-http",
+				"allow a plain http url, which sends the api key in cleartext, only for local development like with the fake-idp command")
+			return func() directory.Provider {
+				if *insecureAllowHTTP {
+					logger.Warn().Str("url", *url).Msg("okta: https check disabled, the api key may be sent in cleartext")
 				}
-			}),
-	)
-	return cmd
+				return okta.New(
+					okta.WithAPIKey(*apiKey),
+					okta.WithAPIKeyFile(*apiKeyFile),
+					okta.WithHTTPClient(upstreamHTTPClient),
+					okta.WithLogger(logger),
+					okta.WithOktaOptions(oktasdk.WithTestingDisableHttpsCheck(*insecureAllowHTTP)),
+					okta.WithURL(*url),
+					okta.WithUserAttributes(*userAttributes),
+				)
+			}
+		}},
+		{"onelogin", func(flags *pflag.FlagSet) func() directory.Provider {
+			clientID := requiredStringFlag(flags, "client-id", "client id")
+			clientSecret, clientSecretFile := secretFlags(flags, "client-secret", "client secret")
+			apiURL := optionalURLFlag(flags, "url", "onelogin api url")
+			return func() directory.Provider {
+				options := []onelogin.Option{
+					onelogin.WithClientID(*clientID),
+					onelogin.WithClientSecret(*clientSecret),
+					onelogin.WithClientSecretFile(*clientSecretFile),
+					onelogin.WithHTTPClient(upstreamHTTPClient),
+					onelogin.WithLogger(logger),
+				}
+				if apiURL.URL != nil {
+					options = append(options, onelogin.WithURL(apiURL.URL))
+				}
+				return onelogin.New(options...)
+			}
+		}},
+		{"ping", func(flags *pflag.FlagSet) func() directory.Provider {
+			clientID := requiredStringFlag(flags, "client-id", "client id")
+			clientSecret, clientSecretFile := secretFlags(flags, "client-secret", "client secret")
+			environmentID := requiredStringFlag(flags, "environment-id", "environment id")
+			flattenNestedGroups := optionalBoolFlag(flags, "flatten-nested-groups", "make members of nested groups members of the parent groups")
+			concurrency := concurrencyFlag(flags)
+			apiURL := optionalURLFlag(flags, "api-url", "pingone api url")
+			authURL := optionalURLFlag(flags, "auth-url", "pingone auth url")
+			return func() directory.Provider {
+				options := []ping.Option{
+					ping.WithClientID(*clientID),
+					ping.WithClientSecret(*clientSecret),
+					ping.WithClientSecretFile(*clientSecretFile),
+					ping.WithConcurrency(*concurrency),
+					ping.WithEnvironmentID(*environmentID),
+					ping.WithFlattenNestedGroups(*flattenNestedGroups),
+					ping.WithHTTPClient(upstreamHTTPClient),
+					ping.WithLogger(logger),
+				}
+				if apiURL.URL != nil {
+					options = append(options, ping.WithAPIURL(apiURL.URL))
+				}
+				if authURL.URL != nil {
+					options = append(options, ping.WithAuthURL(authURL.URL))
+				}
+				return ping.New(options...)
+			}
+		}},
+	}
 }
 
 func directorySubCommand(
@@ -184,20 +280,65 @@ func directorySubCommand(
 	}
 	addr := ":8080"
 	debug := false
+	refreshInterval := directory.DefaultRefreshInterval
+	maxStaleness := directory.DefaultMaxStaleness
 	cmd.Flags().StringVar(&addr, "address", ":8080", "tcp address to listen to")
 	cmd.Flags().BoolVar(&debug, "debug", false, "debug mode")
+	cmd.Flags().DurationVar(&refreshInterval, "refresh-interval", directory.DefaultRefreshInterval,
+		"how often to refresh the directory data, 0 to refresh on every request")
+	cmd.Flags().DurationVar(&maxStaleness, "max-staleness", directory.DefaultMaxStaleness,
+		"how long to keep serving the last good directory data when refreshing fails, 0 for no limit")
+	changeHistory := cmd.Flags().Int("change-history", directory.DefaultChangeHistory,
+		"how many directory change events to keep for the /changes endpoint")
+	webhookURLs := optionalStringArrayFlag(cmd.Flags(), "webhook-url",
+		"url to post directory change events to, may be repeated")
+	webhookSecret := optionalStringFlag(cmd.Flags(), "webhook-secret",
+		"secret used to sign webhook requests with HMAC-SHA256, required with --webhook-url")
+	newFilter := directoryFilterFlags(cmd.Flags())
 	newProvider := setupFlags(cmd.Flags())
+	cmd.PreRunE = func(cmd *cobra.Command, _ []string) error {
+		if len(*webhookURLs) > 0 && *webhookSecret == "" {
+			return fmt.Errorf("--webhook-secret is required with --webhook-url")
+		}
+		return validateSecretFlags(cmd.Flags())
+	}
 	cmd.Run = func(cmd *cobra.Command, _ []string) {
 		if debug {
 			zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		}
-		provider := newProvider()
-		err := server.RunHTTPServer(cmd.Context(), addr, directory.NewHandler(provider))
+		filter, err := newFilter()
+		if err != nil {
+			logger.Fatal().Err(err).Send()
+		}
+		provider := directory.NewSingleFlightProvider(cmd.Context(), directory.NewFilterProvider(newProvider(), filter))
+		options := []directory.HandlerOption{
+			directory.WithChangeHistory(*changeHistory),
+			directory.WithName(cmd.Name()),
+			directory.WithRefreshInterval(refreshInterval),
+			directory.WithMaxStaleness(maxStaleness),
+			directory.WithMetricsRecorder(metrics.Recorder{}),
+			// failed webhooks are retried by the handler
+			directory.WithWebhookClient(httputil.NewInstrumentedClient(upstreamHTTPClient)),
+		}
+		for _, u := range *webhookURLs {
+			options = append(options, directory.WithWebhook(u, []byte(*webhookSecret)))
+		}
+		h := directory.NewHandler(provider, options...)
+
+		eg, ctx := errgroup.WithContext(cmd.Context())
+		eg.Go(func() error {
+			return h.Run(ctx)
+		})
+		eg.Go(func() error {
+			return runHTTPServer(ctx, addr, cmd.Name(), h)
+		})
+		err = eg.Wait()
 		if err != nil {
 			logger.Fatal().Err(err).Send()
 		}
 	}
 
+	cmd.AddCommand(directoryDumpSubCommand(logger, setupFlags))
 	cmd.AddCommand(directoryUploadSubCommand(logger, setupFlags))
 
 	return cmd
@@ -214,14 +355,65 @@ func directoryUploadSubCommand(
 	debug := false
 	cmd.Flags().BoolVar(&debug, "debug", false, "debug mode")
 	destination := requiredStringFlag(cmd.Flags(), "destination", "blob url to upload files to")
+	maxDeletePercent := cmd.Flags().Float64("max-delete-percent", directory.DefaultMaxDeletePercent,
+		"refuse to upload if more than this percentage of the published users, groups or memberships would be removed, 0 for no limit")
+	maxDeleteCount := cmd.Flags().Int("max-delete-count", 0,
+		"refuse to upload if more than this number of the published users, groups or memberships would be removed, 0 for no limit")
+	force := optionalBoolFlag(cmd.Flags(), "force", "upload even if the deletion limits are exceeded")
+	historyRetention := cmd.Flags().Int("history-retention", blob.DefaultHistoryRetention,
+		"how many bundle versions to keep in the bundle history, 0 to disable the history")
+	signingKey := optionalStringFlag(cmd.Flags(), "signing-key",
+		"PEM file with an Ed25519 private key to sign the bundle with")
+	stateKeyFiles := optionalStringArrayFlag(cmd.Flags(), "state-key-file",
+		"file with a base64 encoded 256-bit key to encrypt the directory state with, "+
+			"may be repeated to keep previous keys for decryption, the first key is used for encryption")
+	allowUnencryptedState := optionalBoolFlag(cmd.Flags(), "allow-unencrypted-state",
+		"read the directory state even if it isn't encrypted, to encrypt existing state once after adding a --state-key-file")
+	newFilter := directoryFilterFlags(cmd.Flags())
 	newProvider := setupFlags(cmd.Flags())
+	cmd.PreRunE = func(cmd *cobra.Command, _ []string) error {
+		if *allowUnencryptedState && len(*stateKeyFiles) == 0 {
+			return fmt.Errorf("--allow-unencrypted-state requires --state-key-file")
+		}
+		return validateSecretFlags(cmd.Flags())
+	}
 	cmd.Run = func(cmd *cobra.Command, _ []string) {
 		if debug {
 			zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		}
-		provider := newProvider()
+		filter, err := newFilter()
+		if err != nil {
+			logger.Fatal().Err(err).Send()
+		}
+		provider := directory.NewFilterProvider(newProvider(), filter)
+
+		var guard *directory.DeletionGuard
+		if !*force {
+			guard = directory.NewDeletionGuard(
+				directory.WithMaxDeletePercent(*maxDeletePercent),
+				directory.WithMaxDeleteCount(*maxDeleteCount))
+		}
+
+		options := []blob.UploadOption{
+			blob.WithHistoryRetention(*historyRetention),
+			blob.WithSource(cmd.Parent().Name()),
+		}
+		if *signingKey != "" {
+			signer, err := blob.ReadSigningKeyFile(*signingKey)
+			if err != nil {
+				logger.Fatal().Err(err).Send()
+			}
+			options = append(options, blob.WithSigner(signer))
+		}
+
+		var stateOptions []blob.StateOption
+		if len(*stateKeyFiles) > 0 {
+			stateOptions = append(stateOptions,
+				blob.WithStateKeys(blob.KeyFiles(*stateKeyFiles...)),
+				blob.WithAllowUnencryptedState(*allowUnencryptedState))
+		}
 
-		err := uploadDirectoryBundleToBlob(cmd.Context(), provider, *destination)
+		err = uploadDirectoryBundleToBlob(cmd.Context(), provider, guard, *destination, stateOptions, options...)
 		if err != nil {
 			logger.Fatal().Err(err).Send()
 		}
@@ -230,6 +422,37 @@ func directoryUploadSubCommand(
 	return cmd
 }
 
+func directoryFilterFlags(flags *pflag.FlagSet) func() (*directory.Filter, error) {
+	includeGroups := optionalStringArrayFlag(flags, "include-group",
+		"only include groups with an id, name or email matching the glob (or re:regexp), may be repeated")
+	excludeGroups := optionalStringArrayFlag(flags, "exclude-group",
+		"exclude groups with an id, name or email matching the glob (or re:regexp), may be repeated")
+	includeUserDomains := optionalStringArrayFlag(flags, "include-user-domain",
+		"only include users with an email domain matching the glob (or re:regexp), may be repeated")
+	excludeUserDomains := optionalStringArrayFlag(flags, "exclude-user-domain",
+		"exclude users with an email domain matching the glob (or re:regexp), may be repeated")
+	dropUsersWithoutGroups := optionalBoolFlag(flags, "drop-users-without-groups",
+		"exclude users that aren't a member of any included group")
+	includeInactiveUsers := optionalBoolFlag(flags, "include-inactive-users",
+		"include suspended and deactivated users, marked with an inactive status")
+	return func() (*directory.Filter, error) {
+		return directory.NewFilter(
+			directory.WithIncludeGroups(*includeGroups...),
+			directory.WithExcludeGroups(*excludeGroups...),
+			directory.WithIncludeUserDomains(*includeUserDomains...),
+			directory.WithExcludeUserDomains(*excludeUserDomains...),
+			directory.WithDropUsersWithoutGroups(*dropUsersWithoutGroups),
+			directory.WithIncludeInactiveUsers(*includeInactiveUsers),
+		)
+	}
+}
+
+func concurrencyFlag(flags *pflag.FlagSet) *int {
+	ptr := new(int)
+	flags.IntVar(ptr, "concurrency", directory.DefaultConcurrency, "number of groups whose members are fetched concurrently")
+	return ptr
+}
+
 func optionalBoolFlag(flags *pflag.FlagSet, name, usage string) *bool {
 	ptr := new(bool)
 	flags.BoolVar(ptr, name, false, usage)
@@ -248,6 +471,65 @@ func optionalStringFlag(flags *pflag.FlagSet, name, usage string) *string {
 	return ptr
 }
 
+func optionalStringArrayFlag(flags *pflag.FlagSet, name, usage string) *[]string {
+	ptr := new([]string)
+	flags.StringArrayVar(ptr, name, nil, usage)
+	return ptr
+}
+
+func optionalStringSliceFlag(flags *pflag.FlagSet, name, usage string) *[]string {
+	ptr := new([]string)
+	flags.StringSliceVar(ptr, name, nil, usage)
+	return ptr
+}
+
+func optionalURLFlag(flags *pflag.FlagSet, name, usage string) *urlValue {
+	ptr := new(urlValue)
+	flags.Var(ptr, name, usage)
+	return ptr
+}
+
+// secretFileAnnotation is the flag annotation with the name of the file flag
+// of a secret.
+const secretFileAnnotation = "pomerium_secret_file"
+
+// secretFlags adds a flag for a secret and a flag for a file containing the
+// secret. The file is re-read when it changes, so the secret can be rotated
+// without a restart. Exactly one of them is required, which is checked by
+// validateSecretFlags.
+//
+// In the config file, the <flag>_file convention reads the secret from a file
+// once at startup, so use <flag>-file instead for secrets that are rotated.
+// Setting either flag on the command line overrides both in the config file.
+func secretFlags(flags *pflag.FlagSet, name, usage string) (value, file *string) {
+	value = optionalStringFlag(flags, name, usage+", or use --"+name+"-file")
+	file = optionalStringFlag(flags, name+"-file", "file containing the "+usage+", re-read when it changes")
+	if err := flags.SetAnnotation(name, secretFileAnnotation, []string{name + "-file"}); err != nil {
+		panic(err)
+	}
+	return value, file
+}
+
+// validateSecretFlags checks that exactly one of the flags added by
+// secretFlags is set for each secret.
+func validateSecretFlags(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		fileFlags := f.Annotations[secretFileAnnotation]
+		if err != nil || len(fileFlags) == 0 {
+			return
+		}
+		file := flags.Lookup(fileFlags[0])
+		switch {
+		case f.Changed && file.Changed:
+			err = fmt.Errorf("only one of --%s and --%s may be set", f.Name, file.Name)
+		case !f.Changed && !file.Changed:
+			err = fmt.Errorf("one of --%s or --%s is required", f.Name, file.Name)
+		}
+	})
+	return err
+}
+
 func requiredStringFlag(flags *pflag.FlagSet, name, usage string) *string {
 	ptr := new(string)
 	flags.StringVar(ptr, name, "", usage)
@@ -257,9 +539,47 @@ func requiredStringFlag(flags *pflag.FlagSet, name, usage string) *string {
 	return ptr
 }
 
-func uploadDirectoryBundleToBlob(ctx context.Context, provider directory.Provider, urlstr string) error {
+// A urlValue is a flag value for an absolute url. It's nil if the flag isn't set.
+type urlValue struct {
+	*url.URL
+}
+
+func (v *urlValue) Set(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() {
+		return fmt.Errorf("%q is not an absolute url", rawURL)
+	}
+	v.URL = u
+	return nil
+}
+
+func (v *urlValue) String() string {
+	if v.URL == nil {
+		return ""
+	}
+	return v.URL.String()
+}
+
+func (v *urlValue) Type() string {
+	return "url"
+}
+
+// uploadDirectoryBundleToBlob uploads the directory data to blob storage. If a
+// guard is given, the data is checked against the previously published bundle.
+// The state options are used for the state of persistent providers.
+func uploadDirectoryBundleToBlob(
+	ctx context.Context,
+	provider directory.Provider,
+	guard *directory.DeletionGuard,
+	urlstr string,
+	stateOptions []blob.StateOption,
+	options ...blob.UploadOption,
+) error {
 	if provider, ok := provider.(directory.PersistentProvider); ok {
-		err := downloadDirectoryStateFromBlob(ctx, provider, urlstr)
+		err := downloadDirectoryStateFromBlob(ctx, provider, urlstr, stateOptions...)
 		if err != nil {
 			return err
 		}
@@ -270,16 +590,23 @@ func uploadDirectoryBundleToBlob(ctx context.Context, provider directory.Provide
 		return fmt.Errorf("error retrieving directory data: %w", err)
 	}
 
+	if guard != nil {
+		err = checkDirectoryBundleDeletions(ctx, guard, urlstr, groups, users)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = blob.UploadBundle(ctx, urlstr, map[string]any{
 		directory.GroupRecordType: groups,
 		directory.UserRecordType:  users,
-	})
+	}, options...)
 	if err != nil {
 		return fmt.Errorf("error uploading directory data: %w", err)
 	}
 
 	if provider, ok := provider.(directory.PersistentProvider); ok {
-		err := uploadDirectoryStateToBlob(ctx, provider, urlstr)
+		err := uploadDirectoryStateToBlob(ctx, provider, urlstr, stateOptions...)
 		if err != nil {
 			return err
 		}
@@ -288,12 +615,48 @@ func uploadDirectoryBundleToBlob(ctx context.Context, provider directory.Provide
 	return nil
 }
 
-func downloadDirectoryStateFromBlob(ctx context.Context, provider directory.PersistentProvider, urlstr string) error {
+func checkDirectoryBundleDeletions(
+	ctx context.Context,
+	guard *directory.DeletionGuard,
+	urlstr string,
+	groups []directory.Group,
+	users []directory.User,
+) error {
+	var publishedGroups []directory.Group
+	var publishedUsers []directory.User
+	err := blob.DownloadBundle(ctx, urlstr, func(src io.Reader) error {
+		var err error
+		publishedGroups, publishedUsers, err = directory.DecodeBundle(src)
+		return err
+	})
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error downloading published directory bundle from blob: %w", err)
+	}
+
+	err = guard.Check(publishedGroups, publishedUsers, groups, users)
+	if err != nil {
+		return fmt.Errorf("refusing to upload directory data, use --force to override: %w", err)
+	}
+
+	return nil
+}
+
+func downloadDirectoryStateFromBlob(
+	ctx context.Context,
+	provider directory.PersistentProvider,
+	urlstr string,
+	options ...blob.StateOption,
+) error {
 	err := blob.DownloadState(ctx, urlstr, func(src io.Reader) error {
 		return provider.LoadDirectoryState(ctx, src)
-	})
+	}, options...)
 	if gcerrors.Code(err) == gcerrors.NotFound {
 		return nil
+	} else if errors.Is(err, blob.ErrUnencryptedState) {
+		return fmt.Errorf("error downloading directory state from blob: %w, "+
+			"run once with --allow-unencrypted-state to encrypt it", err)
 	} else if err != nil {
 		return fmt.Errorf("error downloading directory state from blob: %w", err)
 	}
@@ -301,10 +664,15 @@ func downloadDirectoryStateFromBlob(ctx context.Context, provider directory.Pers
 	return nil
 }
 
-func uploadDirectoryStateToBlob(ctx context.Context, provider directory.PersistentProvider, urlstr string) error {
+func uploadDirectoryStateToBlob(
+	ctx context.Context,
+	provider directory.PersistentProvider,
+	urlstr string,
+	options ...blob.StateOption,
+) error {
 	err := blob.UploadState(ctx, urlstr, func(dst io.Writer) error {
 		return provider.SaveDirectoryState(ctx, dst)
-	})
+	}, options...)
 	if err != nil {
 		return fmt.Errorf("error uploading directory state to blob: %w", err)
 	}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/pomerium/datasource/pkg/directory"
+)
+
+var dumpFormats = []string{"json", "ndjson", "csv", "table"}
+
+func directoryDumpSubCommand(
+	logger zerolog.Logger,
+	setupFlags func(flags *pflag.FlagSet) func() directory.Provider,
+) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "print directory data, or explain the group memberships of users and groups",
+	}
+	debug := false
+	format := "table"
+	cmd.Flags().BoolVar(&debug, "debug", false, "debug mode")
+	cmd.Flags().StringVar(&format, "format", "table", "output format, one of "+strings.Join(dumpFormats, ", "))
+	userQueries := optionalStringArrayFlag(cmd.Flags(), "user",
+		"explain the groups of the users with this id or email (or email prefix ending in @), may be repeated")
+	groupQueries := optionalStringArrayFlag(cmd.Flags(), "group",
+		"explain the members of the groups with this id, name or email, may be repeated")
+	newFilter := directoryFilterFlags(cmd.Flags())
+	newProvider := setupFlags(cmd.Flags())
+	cmd.PreRunE = func(cmd *cobra.Command, _ []string) error {
+		return validateSecretFlags(cmd.Flags())
+	}
+	cmd.Run = func(cmd *cobra.Command, _ []string) {
+		if debug {
+			zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		}
+		if !slices.Contains(dumpFormats, format) {
+			logger.Fatal().Msgf("invalid format %q, must be one of %s", format, strings.Join(dumpFormats, ", "))
+		}
+		filter, err := newFilter()
+		if err != nil {
+			logger.Fatal().Err(err).Send()
+		}
+
+		// record how nested groups are flattened to explain inherited memberships
+		nesting := new(directory.NestingRecorder)
+		ctx := directory.WithNestingRecorder(cmd.Context(), nesting)
+		groups, users, err := newProvider().GetDirectory(ctx)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("failed to get directory data")
+		}
+
+		if len(*userQueries) == 0 && len(*groupQueries) == 0 {
+			groups, users = filter.Apply(groups, users)
+			err = writeDirectory(cmd.OutOrStdout(), format, groups, users)
+		} else {
+			var memberships []membership
+			memberships, err = explainMemberships(filter, nesting, groups, users, *userQueries, *groupQueries)
+			if err == nil {
+				err = writeMemberships(cmd.OutOrStdout(), format, memberships)
+			}
+		}
+		if err != nil {
+			logger.Fatal().Err(err).Send()
+		}
+	}
+
+	return cmd
+}
+
+// A membership explains whether a user is part of a group after filtering.
+type membership struct {
+	UserID    string `json:"user_id"`
+	UserEmail string `json:"user_email,omitempty"`
+	GroupID   string `json:"group_id,omitempty"`
+	GroupName string `json:"group_name,omitempty"`
+	Included  bool   `json:"included"`
+	Reason    string `json:"reason"`
+}
+
+// explainMemberships returns the memberships of the users and groups matching
+// the queries, before filtering, along with the reason they are kept or dropped.
+// Memberships inherited through nested groups are explained with the path of
+// groups they're inherited through.
+func explainMemberships(
+	filter *directory.Filter,
+	nesting *directory.NestingRecorder,
+	groups []directory.Group,
+	users []directory.User,
+	userQueries, groupQueries []string,
+) ([]membership, error) {
+	groupsByID := make(map[string]directory.Group, len(groups))
+	for _, g := range groups {
+		groupsByID[g.ID] = g
+	}
+	_, filteredUsers := filter.Apply(groups, users)
+	filteredGroupIDs := make(map[string][]string, len(filteredUsers))
+	for _, u := range filteredUsers {
+		filteredGroupIDs[u.ID] = u.GroupIDs
+	}
+
+	explain := func(u directory.User, groupID string) membership {
+		m := membership{UserID: u.ID, UserEmail: u.Email, GroupID: groupID}
+		g, groupFound := groupsByID[groupID]
+		m.GroupName = g.Name
+
+		filteredIDs, userIncluded := filteredGroupIDs[u.ID]
+		switch {
+		case !userIncluded && filter.UserExclusionReason(u) != "":
+			m.Reason = filter.UserExclusionReason(u)
+		case groupID == "":
+			m.Included = userIncluded
+			m.Reason = "user is not a member of any group"
+		case !groupFound:
+			m.Reason = "group is not in the directory"
+		case filter.GroupExclusionReason(g) != "":
+			m.Reason = filter.GroupExclusionReason(g)
+		default:
+			m.Included = slices.Contains(filteredIDs, groupID)
+			m.Reason = membershipReason(nesting, groupsByID, u.ID, groupID)
+		}
+		return m
+	}
+
+	var memberships []membership
+	for _, q := range userQueries {
+		found := false
+		for _, u := range users {
+			if !matchUserQuery(u, q) {
+				continue
+			}
+			found = true
+			if len(u.GroupIDs) == 0 {
+				memberships = append(memberships, explain(u, ""))
+			}
+			for _, groupID := range u.GroupIDs {
+				memberships = append(memberships, explain(u, groupID))
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no user found matching %q", q)
+		}
+	}
+	for _, q := range groupQueries {
+		found := false
+		for _, g := range groups {
+			if !matchGroupQuery(g, q) {
+				continue
+			}
+			found = true
+			for _, u := range users {
+				if slices.Contains(u.GroupIDs, g.ID) {
+					memberships = append(memberships, explain(u, g.ID))
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no group found matching %q", q)
+		}
+	}
+	return memberships, nil
+}
+
+// membershipReason explains whether a user is a direct member of a group or
+// inherits the membership through nested groups. Without recorded nesting, as
+// when nested groups aren't flattened, it can't tell them apart.
+func membershipReason(
+	nesting *directory.NestingRecorder,
+	groupsByID map[string]directory.Group,
+	userID, groupID string,
+) string {
+	path, ok := nesting.Path(userID, groupID)
+	switch {
+	case !ok:
+		return "user is a member of the group"
+	case len(path) == 0:
+		return "user is a direct member of the group"
+	}
+
+	names := make([]string, len(path))
+	for i, id := range path {
+		names[i] = id
+		if g, ok := groupsByID[id]; ok && g.Name != "" {
+			names[i] = g.Name
+		}
+	}
+	return "user inherits the membership through nested groups: " + strings.Join(names, " > ")
+}
+
+func matchUserQuery(u directory.User, q string) bool {
+	if u.ID == q || strings.EqualFold(u.Email, q) {
+		return true
+	}
+	return strings.HasSuffix(q, "@") && len(u.Email) > len(q) && strings.EqualFold(u.Email[:len(q)], q)
+}
+
+func matchGroupQuery(g directory.Group, q string) bool {
+	return g.ID == q || strings.EqualFold(g.Name, q) || (g.Email != "" && strings.EqualFold(g.Email, q))
+}
+
+func writeDirectory(w io.Writer, format string, groups []directory.Group, users []directory.User) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Groups []directory.Group `json:"groups"`
+			Users  []directory.User  `json:"users"`
+		}{groups, users})
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, g := range groups {
+			err := enc.Encode(struct {
+				Type string `json:"type"`
+				directory.Group
+			}{directory.GroupRecordType, g})
+			if err != nil {
+				return err
+			}
+		}
+		for _, u := range users {
+			err := enc.Encode(struct {
+				Type string `json:"type"`
+				directory.User
+			}{directory.UserRecordType, u})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rows := [][]string{{"type", "id", "name", "email", "status", "group_ids"}}
+	for _, g := range groups {
+		rows = append(rows, []string{"group", g.ID, g.Name, g.Email, "", ""})
+	}
+	for _, u := range users {
+		rows = append(rows, []string{"user", u.ID, u.DisplayName, u.Email, string(u.Status), strings.Join(u.GroupIDs, ";")})
+	}
+	return writeRows(w, format, rows)
+}
+
+func writeMemberships(w io.Writer, format string, memberships []membership) error {
+	switch format {
+	case "json":
+		if memberships == nil {
+			memberships = make([]membership, 0)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(memberships)
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, m := range memberships {
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rows := [][]string{{"user_id", "user_email", "group_id", "group_name", "included", "reason"}}
+	for _, m := range memberships {
+		rows = append(rows, []string{m.UserID, m.UserEmail, m.GroupID, m.GroupName, fmt.Sprint(m.Included), m.Reason})
+	}
+	return writeRows(w, format, rows)
+}
+
+// writeRows writes the rows as csv, or as a table with upper case headers.
+func writeRows(w io.Writer, format string, rows [][]string) error {
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		err := cw.WriteAll(rows)
+		if err != nil {
+			return fmt.Errorf("failed to write csv: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, row := range rows {
+		if i == 0 {
+			row = slices.Clone(row)
+			for j := range row {
+				row[j] = strings.ToUpper(row[j])
+			}
+		}
+		_, _ = fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
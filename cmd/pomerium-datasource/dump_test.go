@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/datasource/pkg/directory"
+)
+
+func TestExplainMemberships(t *testing.T) {
+	t.Parallel()
+
+	groups := []directory.Group{
+		{ID: "g1", Name: "Engineering", Email: "eng@example.com"},
+		{ID: "g2", Name: "dl-announcements"},
+		{ID: "g3", Name: "Platform"},
+		{ID: "g4", Name: "On-call"},
+	}
+	users := []directory.User{
+		{ID: "u1", GroupIDs: []string{"g1", "g2"}, Email: "u1@example.com"},
+		{ID: "u7", GroupIDs: []string{"g4"}, Email: "u7@example.com"},
+		{ID: "u2", GroupIDs: []string{"g9"}, Email: "u2@example.com"},
+		{ID: "u3", GroupIDs: []string{"g1"}, Email: "u3@contractor.example.org"},
+		{ID: "u4", Email: "u4@example.com"},
+		{ID: "u5", GroupIDs: []string{"g1"}, Status: directory.UserStatusInactive},
+	}
+	filter, err := directory.NewFilter(
+		directory.WithExcludeGroups("dl-*"),
+		directory.WithExcludeUserDomains("contractor.example.org"),
+	)
+	require.NoError(t, err)
+
+	// On-call is nested in Platform, which is nested in Engineering
+	lookup := directory.NewGroupLookup()
+	lookup.AddGroup("g1", []string{"g3"}, nil)
+	lookup.AddGroup("g3", []string{"g4"}, nil)
+	nesting := new(directory.NestingRecorder)
+	lookup.FlattenUsers(directory.WithNestingRecorder(t.Context(), nesting), users)
+
+	for _, tc := range []struct {
+		name         string
+		userQueries  []string
+		groupQueries []string
+		expect       []membership
+		expectErr    string
+	}{
+		{
+			name:        "user id",
+			userQueries: []string{"u1"},
+			expect: []membership{
+				{UserID: "u1", UserEmail: "u1@example.com", GroupID: "g1", GroupName: "Engineering", Included: true, Reason: "user is a direct member of the group"},
+				{UserID: "u1", UserEmail: "u1@example.com", GroupID: "g2", GroupName: "dl-announcements", Reason: `group matches exclude pattern "dl-*"`},
+			},
+		},
+		{
+			name:        "nested groups",
+			userQueries: []string{"u7"},
+			expect: []membership{
+				{UserID: "u7", UserEmail: "u7@example.com", GroupID: "g1", GroupName: "Engineering", Included: true, Reason: "user inherits the membership through nested groups: On-call > Platform > Engineering"},
+				{UserID: "u7", UserEmail: "u7@example.com", GroupID: "g3", GroupName: "Platform", Included: true, Reason: "user inherits the membership through nested groups: On-call > Platform"},
+				{UserID: "u7", UserEmail: "u7@example.com", GroupID: "g4", GroupName: "On-call", Included: true, Reason: "user is a direct member of the group"},
+			},
+		},
+		{
+			name:        "email prefix",
+			userQueries: []string{"u2@"},
+			expect: []membership{
+				{UserID: "u2", UserEmail: "u2@example.com", GroupID: "g9", Reason: "group is not in the directory"},
+			},
+		},
+		{
+			name:        "user without groups",
+			userQueries: []string{"U4@example.com"},
+			expect: []membership{
+				{UserID: "u4", UserEmail: "u4@example.com", Included: true, Reason: "user is not a member of any group"},
+			},
+		},
+		{
+			name:         "group name",
+			groupQueries: []string{"engineering"},
+			expect: []membership{
+				{UserID: "u1", UserEmail: "u1@example.com", GroupID: "g1", GroupName: "Engineering", Included: true, Reason: "user is a direct member of the group"},
+				{UserID: "u7", UserEmail: "u7@example.com", GroupID: "g1", GroupName: "Engineering", Included: true, Reason: "user inherits the membership through nested groups: On-call > Platform > Engineering"},
+				{UserID: "u3", UserEmail: "u3@contractor.example.org", GroupID: "g1", GroupName: "Engineering", Reason: `email domain "contractor.example.org" matches exclude pattern "contractor.example.org"`},
+				{UserID: "u5", GroupID: "g1", GroupName: "Engineering", Reason: "user is inactive"},
+			},
+		},
+		{
+			name:        "user not found",
+			userQueries: []string{"u6@"},
+			expectErr:   `no user found matching "u6@"`,
+		},
+		{
+			name:         "group not found",
+			groupQueries: []string{"g9"},
+			expectErr:    `no group found matching "g9"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			memberships, err := explainMemberships(filter, nesting, groups, users, tc.userQueries, tc.groupQueries)
+			if tc.expectErr != "" {
+				assert.EqualError(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expect, memberships)
+		})
+	}
+}
+
+func TestMatchQuery(t *testing.T) {
+	t.Parallel()
+
+	u := directory.User{ID: "u1", Email: "Alice@Example.com"}
+	assert.True(t, matchUserQuery(u, "u1"))
+	assert.True(t, matchUserQuery(u, "alice@example.com"))
+	assert.True(t, matchUserQuery(u, "alice@"))
+	assert.False(t, matchUserQuery(u, "alice"), "prefixes must end in @")
+	assert.False(t, matchUserQuery(u, "alice@example.com@"))
+
+	g := directory.Group{ID: "g1", Name: "Engineering", Email: "eng@example.com"}
+	assert.True(t, matchGroupQuery(g, "g1"))
+	assert.True(t, matchGroupQuery(g, "engineering"))
+	assert.True(t, matchGroupQuery(g, "ENG@example.com"))
+	assert.False(t, matchGroupQuery(g, "G1"), "ids are case sensitive")
+}
+
+func TestWriteDirectory(t *testing.T) {
+	t.Parallel()
+
+	groups := []directory.Group{{ID: "g1", Name: "Engineering", Email: "eng@example.com"}}
+	users := []directory.User{
+		{ID: "u1", GroupIDs: []string{"g1", "g2"}, DisplayName: "User, One", Email: "u1@example.com", Status: directory.UserStatusActive},
+	}
+
+	for _, tc := range []struct {
+		format string
+		expect string
+	}{
+		{"json", `{
+  "groups": [
+    {
+      "id": "g1",
+      "name": "Engineering",
+      "email": "eng@example.com"
+    }
+  ],
+  "users": [
+    {
+      "id": "u1",
+      "group_ids": [
+        "g1",
+        "g2"
+      ],
+      "display_name": "User, One",
+      "email": "u1@example.com",
+      "status": "active"
+    }
+  ]
+}
+`},
+		{"ndjson", `{"type":"pomerium.io/DirectoryGroup","id":"g1","name":"Engineering","email":"eng@example.com"}
+{"type":"pomerium.io/DirectoryUser","id":"u1","group_ids":["g1","g2"],"display_name":"User, One","email":"u1@example.com","status":"active"}
+`},
+		{"csv", `type,id,name,email,status,group_ids
+group,g1,Engineering,eng@example.com,,
+user,u1,"User, One",u1@example.com,active,g1;g2
+`},
+		{"table", "" +
+			"TYPE   ID  NAME         EMAIL            STATUS  GROUP_IDS\n" +
+			"group  g1  Engineering  eng@example.com          \n" +
+			"user   u1  User, One    u1@example.com   active  g1;g2\n"},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			require.NoError(t, writeDirectory(&buf, tc.format, groups, users))
+			assert.Equal(t, tc.expect, buf.String())
+		})
+	}
+}
+
+func TestWriteMemberships(t *testing.T) {
+	t.Parallel()
+
+	memberships := []membership{
+		{UserID: "u1", UserEmail: "u1@example.com", GroupID: "g1", GroupName: "Engineering", Included: true, Reason: "user is a member of the group"},
+		{UserID: "u2", Reason: "user is inactive"},
+	}
+
+	for _, tc := range []struct {
+		format      string
+		memberships []membership
+		expect      string
+	}{
+		{"json", memberships, `[
+  {
+    "user_id": "u1",
+    "user_email": "u1@example.com",
+    "group_id": "g1",
+    "group_name": "Engineering",
+    "included": true,
+    "reason": "user is a member of the group"
+  },
+  {
+    "user_id": "u2",
+    "included": false,
+    "reason": "user is inactive"
+  }
+]
+`},
+		{"json", nil, "[]\n"},
+		{"ndjson", memberships, `{"user_id":"u1","user_email":"u1@example.com","group_id":"g1","group_name":"Engineering","included":true,"reason":"user is a member of the group"}
+{"user_id":"u2","included":false,"reason":"user is inactive"}
+`},
+		{"csv", memberships, `user_id,user_email,group_id,group_name,included,reason
+u1,u1@example.com,g1,Engineering,true,user is a member of the group
+u2,,,,false,user is inactive
+`},
+		{"table", memberships, `USER_ID  USER_EMAIL      GROUP_ID  GROUP_NAME   INCLUDED  REASON
+u1       u1@example.com  g1        Engineering  true      user is a member of the group
+u2                                              false     user is inactive
+`},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			require.NoError(t, writeMemberships(&buf, tc.format, tc.memberships))
+			assert.Equal(t, tc.expect, buf.String())
+		})
+	}
+}
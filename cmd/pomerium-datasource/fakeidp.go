@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/pomerium/datasource/internal/fakeidp"
+)
+
+func fakeIDPCommand(logger zerolog.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fake-idp",
+		Short: "runs a fake identity provider serving the users and groups from a seed file",
+		Long: `Runs a fake identity provider serving the users and groups from a seed file
+with fake versions of the provider APIs, for development and demos. Any
+credentials are accepted. Point a directory command at it with:
+
+  azure     --graph-url URL --login-url URL
+  github    --url URL
+  gitlab    --url URL
+  google    --url URL --json-key-file FILE, with the key from URL/google/json-key
+  keycloak  --url URL
+  okta      --url URL --insecure-allow-http
+  onelogin  --url URL
+  ping      --api-url URL --auth-url URL`,
+	}
+	address := cmd.Flags().String("address", ":8080", "tcp address to listen to")
+	seedFile := requiredStringFlag(cmd.Flags(), "seed-file", "yaml or json file with the users and groups to serve")
+	pageSize := cmd.Flags().Int("page-size", fakeidp.DefaultPageSize, "maximum number of items in a page of a list")
+	cmd.Run = func(cmd *cobra.Command, _ []string) {
+		seed, err := fakeidp.LoadSeed(*seedFile)
+		if err != nil {
+			logger.Fatal().Err(err).Send()
+		}
+
+		logger.Info().
+			Str("address", *address).
+			Int("groups", len(seed.Groups)).
+			Int("users", len(seed.Users)).
+			Msg("starting fake identity provider")
+		err = runHTTPServer(cmd.Context(), *address, "fakeidp",
+			fakeidp.NewServer(seed, fakeidp.WithPageSize(*pageSize)))
+		if err != nil {
+			logger.Fatal().Err(err).Send()
+		}
+	}
+	return cmd
+}
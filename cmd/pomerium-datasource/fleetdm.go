@@ -8,7 +8,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/pomerium/datasource/internal/fleetdm"
-	"github.com/pomerium/datasource/internal/server"
+	"github.com/pomerium/datasource/internal/httputil"
 )
 
 type fleetDMCmd struct {
@@ -53,11 +53,12 @@ func (cmd *fleetDMCmd) exec(c *cobra.Command, _ []string) error {
 		return err
 	}
 
-	return server.RunHTTPServer(c.Context(), cmd.Address, srv)
+	return runHTTPServer(c.Context(), cmd.Address, "fleetdm", srv)
 }
 
 func (cmd *fleetDMCmd) newServer() (http.Handler, error) {
 	srv, err := fleetdm.NewServer(
+		fleetdm.WithHTTPClient(httputil.NewRetryClient(httputil.NewInstrumentedClient(upstreamHTTPClient))),
 		fleetdm.WithAPIToken(cmd.APIToken),
 		fleetdm.WithAPIURL(cmd.APIURL),
 		fleetdm.WithCertificateQueryID(cmd.CertQueryID),
@@ -7,7 +7,6 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/pomerium/datasource/internal/ip2location"
-	"github.com/pomerium/datasource/internal/server"
 )
 
 var ip2LocationArgs struct {
@@ -16,14 +15,16 @@ var ip2LocationArgs struct {
 }
 
 var ip2LocationCmd = &cobra.Command{
-	Use:   "ip2location <file>",
+	Use:   "ip2location [file]",
 	Short: "runs the IP2Location server",
-	Args:  cobra.ExactArgs(1),
-	PersistentPreRunE: func(_ *cobra.Command, args []string) error {
-		if len(args) == 0 {
+	Args:  cobra.MaximumNArgs(1),
+	PreRunE: func(_ *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			ip2LocationArgs.file = args[0]
+		}
+		if ip2LocationArgs.file == "" {
 			return fmt.Errorf("file is required")
 		}
-		ip2LocationArgs.file = args[0]
 		return nil
 	},
 	Run: func(cmd *cobra.Command, _ []string) {
@@ -32,7 +33,7 @@ var ip2LocationCmd = &cobra.Command{
 			Str("file", ip2LocationArgs.file).
 			Msg("starting ip2location http server")
 		srv := ip2location.NewServer(ip2location.WithFile(ip2LocationArgs.file))
-		err := server.RunHTTPServer(cmd.Context(), ip2LocationArgs.address, srv)
+		err := runHTTPServer(cmd.Context(), ip2LocationArgs.address, "ip2location", srv)
 		if err != nil {
 			log.Fatal().Err(err).Send()
 		}
@@ -42,4 +43,6 @@ var ip2LocationCmd = &cobra.Command{
 func init() {
 	ip2LocationCmd.Flags().StringVar(&ip2LocationArgs.address, "address", ":8080",
 		"the tcp address to listen on")
+	ip2LocationCmd.Flags().StringVar(&ip2LocationArgs.file, "file", "",
+		"the IP2Location database file, instead of the file argument")
 }
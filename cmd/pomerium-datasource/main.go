@@ -11,32 +11,70 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 
+	"github.com/pomerium/datasource/internal/flagconfig"
 	"github.com/pomerium/datasource/internal/version"
 )
 
 func main() {
 	logger := makeLogger()
 
+	var configFile string
 	rootCmd := &cobra.Command{
 		Use:     "pomerium-datasource",
 		Version: version.FullVersion(),
+		// flags from the config file are applied before required flags are validated
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			if configFile != "" {
+				cfg, err := flagconfig.Load(configFile)
+				if err != nil {
+					return err
+				}
+				err = flagconfig.Apply(cmd, cfg)
+				if err != nil {
+					return err
+				}
+			}
+			err := setupUpstreamHTTPClient()
+			if err != nil {
+				return err
+			}
+			return setupTracing(cmd.Context())
+		},
 	}
+	rootCmd.PersistentFlags().StringVar(&configFile, "config-file", "",
+		"yaml or json file with the flags for each command, supporting ${ENV} and <flag>_file")
+	rootCmd.PersistentFlags().StringVar(&metricsAddress, "metrics-address", "",
+		"tcp address to serve prometheus metrics on, by default they are served at /metrics on the main address")
+	addTransportFlags(rootCmd.PersistentFlags())
+	addTracingFlags(rootCmd.PersistentFlags())
 	rootCmd.AddCommand(
 		bambooCommand(logger),
 		directoryCommand(logger),
+		fakeIDPCommand(logger),
 		zenefitsCommand(logger),
 		ip2LocationCmd,
 		wellKnownIPsCmd,
 		fleetDMCommand(logger),
 		blobCommand(logger),
 	)
-	if err := rootCmd.ExecuteContext(signalContext(logger)); err != nil {
+	err := rootCmd.ExecuteContext(signalContext(logger))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error().Err(err).Msg("error flushing spans")
+	}
+
+	if err != nil {
 		logger.Fatal().Err(err).Msg("exit")
 	}
 }
 
 func makeLogger() zerolog.Logger {
-	logger := zerolog.New(zerolog.NewConsoleWriter())
+	// log to stderr so commands like directory dump can write their output to stdout
+	logger := zerolog.New(zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) {
+		w.Out = os.Stderr
+	}))
 	log.Logger = logger
 	zerolog.DefaultContextLogger = &logger
 	return logger
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pomerium/datasource/internal/metrics"
+	"github.com/pomerium/datasource/internal/server"
+)
+
+// metricsAddress is the tcp address to serve metrics on, set by a root flag.
+var metricsAddress string
+
+// runHTTPServer runs an instrumented HTTP server for the named handler. Metrics
+// are served on their own listener when a metrics address is set, and at
+// /metrics on the same listener otherwise.
+func runHTTPServer(ctx context.Context, addr, name string, handler http.Handler) error {
+	handler = metrics.InstrumentHandler(name, handler)
+	if metricsAddress == "" {
+		metricsHandler := metrics.Handler()
+		return server.RunHTTPServer(ctx, addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/metrics" {
+				metricsHandler.ServeHTTP(w, r)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		}))
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return server.RunHTTPServer(ctx, metricsAddress, metrics.Handler())
+	})
+	eg.Go(func() error {
+		return server.RunHTTPServer(ctx, addr, handler)
+	})
+	return eg.Wait()
+}
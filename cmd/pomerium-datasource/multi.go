@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/multi"
+)
+
+// multiDirectoryConfig is the config file for the multi directory provider.
+//
+// Each source has a type, which is the name of one of the directory sub commands,
+// and options, which are the flags for that sub command:
+//
+//	failure_mode: last-good
+//	sources:
+//	  - name: employees
+//	    type: okta
+//	    merge_by_email: true
+//	    options:
+//	      api-key: ...
+//	      url: https://example.okta.com
+//	  - name: contractors
+//	    type: auth0
+//	    prefix: "contractor:"
+//	    options:
+//	      client-id: ...
+type multiDirectoryConfig struct {
+	FailureMode string                       `yaml:"failure_mode"`
+	Sources     []multiDirectorySourceConfig `yaml:"sources"`
+}
+
+type multiDirectorySourceConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	// Prefix defaults to the name followed by a slash.
+	Prefix       *string        `yaml:"prefix"`
+	MergeByEmail bool           `yaml:"merge_by_email"`
+	Options      map[string]any `yaml:"options"`
+}
+
+func multiDirectorySetupFlags(
+	logger zerolog.Logger,
+	providers []directoryProvider,
+) func(flags *pflag.FlagSet) func() directory.Provider {
+	return func(flags *pflag.FlagSet) func() directory.Provider {
+		configFile := requiredStringFlag(flags, "config", "config file (yaml or json) listing the directory sources")
+		return func() directory.Provider {
+			p, err := newMultiDirectoryProvider(logger, providers, *configFile)
+			if err != nil {
+				logger.Fatal().Err(err).Msg("invalid multi directory config")
+			}
+			return p
+		}
+	}
+}
+
+func newMultiDirectoryProvider(
+	logger zerolog.Logger,
+	providers []directoryProvider,
+	configFile string,
+) (*multi.Provider, error) {
+	f, err := os.Open(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config file: %w", err)
+	}
+	defer f.Close()
+
+	var cfg multiDirectoryConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	err = dec.Decode(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding config file: %w", err)
+	}
+
+	failureMode, err := multi.ParseFailureMode(cfg.FailureMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Sources) == 0 {
+		return nil, fmt.Errorf("at least one source is required")
+	}
+
+	var sources []multi.Source
+	for _, sc := range cfg.Sources {
+		if sc.Name == "" {
+			return nil, fmt.Errorf("source name is required")
+		}
+		if slices.ContainsFunc(sources, func(s multi.Source) bool { return s.Name == sc.Name }) {
+			return nil, fmt.Errorf("duplicate source name: %s", sc.Name)
+		}
+
+		idx := slices.IndexFunc(providers, func(p directoryProvider) bool { return p.name == sc.Type })
+		if idx < 0 {
+			return nil, fmt.Errorf("source %s: unknown type: %s", sc.Name, sc.Type)
+		}
+
+		provider, err := newDirectoryProviderFromOptions(providers[idx], sc.Options)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", sc.Name, err)
+		}
+
+		prefix := sc.Name + "/"
+		if sc.Prefix != nil {
+			prefix = *sc.Prefix
+		}
+
+		sources = append(sources, multi.Source{
+			Name:         sc.Name,
+			Prefix:       prefix,
+			MergeByEmail: sc.MergeByEmail,
+			Provider:     provider,
+		})
+	}
+
+	return multi.New(sources,
+		multi.WithFailureMode(failureMode),
+		multi.WithLogger(logger)), nil
+}
+
+// newDirectoryProviderFromOptions creates a directory provider by setting
+// its command line flags from the given options.
+func newDirectoryProviderFromOptions(p directoryProvider, options map[string]any) (directory.Provider, error) {
+	flags := pflag.NewFlagSet(p.name, pflag.ContinueOnError)
+	newProvider := p.setupFlags(flags)
+
+	for name, value := range options {
+		values, ok := value.([]any)
+		if !ok {
+			values = []any{value}
+		}
+		for _, v := range values {
+			err := flags.Set(name, fmt.Sprint(v))
+			if err != nil {
+				return nil, fmt.Errorf("invalid option %s: %w", name, err)
+			}
+		}
+	}
+
+	var missing []string
+	flags.VisitAll(func(f *pflag.Flag) {
+		if slices.Contains(f.Annotations[cobra.BashCompOneRequiredFlag], "true") && !f.Changed {
+			missing = append(missing, f.Name)
+		}
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required options: %v", missing)
+	}
+	err := validateSecretFlags(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return newProvider(), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/pflag"
+
+	"github.com/pomerium/datasource/internal/tracing"
+)
+
+// tracingArgs are the settings for exporting spans, set by root flags.
+var tracingArgs struct {
+	endpoint string
+	protocol string
+}
+
+// shutdownTracing flushes any pending spans. It's set when tracing is set up.
+var shutdownTracing = func(context.Context) error { return nil }
+
+func addTracingFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&tracingArgs.endpoint, "otlp-endpoint", "",
+		"url to export OpenTelemetry spans to, like http://localhost:4318/v1/traces, by default OTEL_EXPORTER_OTLP_ENDPOINT is used")
+	flags.StringVar(&tracingArgs.protocol, "otlp-protocol", "",
+		"otlp protocol (grpc or http/protobuf), by default OTEL_EXPORTER_OTLP_PROTOCOL is used")
+}
+
+// setupTracing sets up exporting spans from the tracing flags.
+func setupTracing(ctx context.Context) error {
+	var options []tracing.Option
+	if tracingArgs.endpoint != "" {
+		options = append(options, tracing.WithEndpoint(tracingArgs.endpoint))
+	}
+	if tracingArgs.protocol != "" {
+		options = append(options, tracing.WithProtocol(tracingArgs.protocol))
+	}
+
+	shutdown, err := tracing.Setup(ctx, options...)
+	if err != nil {
+		return err
+	}
+	shutdownTracing = shutdown
+	return nil
+}
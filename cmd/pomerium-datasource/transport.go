@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/pomerium/datasource/internal/httputil"
+)
+
+// transportArgs are the settings for requests to upstream APIs, set by root flags.
+var transportArgs struct {
+	caFile         string
+	clientCertFile string
+	clientKeyFile  string
+	proxyURL       string
+	tlsMinVersion  string
+	timeout        time.Duration
+}
+
+// upstreamHTTPClient is the http client for requests to upstream APIs. It's
+// built from the transport flags before a command runs.
+var upstreamHTTPClient = http.DefaultClient
+
+func addTransportFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&transportArgs.caFile, "ca-file", "",
+		"pem file of certificate authorities to trust for upstream requests, in addition to the system ones")
+	flags.StringVar(&transportArgs.clientCertFile, "client-cert-file", "",
+		"pem client certificate file for mutual tls with upstream apis")
+	flags.StringVar(&transportArgs.clientKeyFile, "client-key-file", "",
+		"pem client key file for mutual tls with upstream apis")
+	flags.StringVar(&transportArgs.proxyURL, "proxy-url", "",
+		"proxy url for upstream requests, by default HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used")
+	flags.StringVar(&transportArgs.tlsMinVersion, "tls-min-version", "",
+		"minimum tls version for upstream requests (1.0, 1.1, 1.2 or 1.3)")
+	flags.DurationVar(&transportArgs.timeout, "http-timeout", 0,
+		"time limit for each upstream request, 0 means no limit")
+}
+
+// setupUpstreamHTTPClient builds the upstream http client from the transport flags.
+func setupUpstreamHTTPClient() error {
+	options := []httputil.TransportOption{
+		httputil.WithCAFile(transportArgs.caFile),
+		httputil.WithClientCertificate(transportArgs.clientCertFile, transportArgs.clientKeyFile),
+		httputil.WithTimeout(transportArgs.timeout),
+	}
+	if transportArgs.proxyURL != "" {
+		u, err := url.Parse(transportArgs.proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy url: %w", err)
+		}
+		options = append(options, httputil.WithProxyURL(u))
+	}
+	tlsMinVersion, err := httputil.ParseTLSVersion(transportArgs.tlsMinVersion)
+	if err != nil {
+		return err
+	}
+	options = append(options, httputil.WithTLSMinVersion(tlsMinVersion))
+
+	client, err := httputil.NewClient(options...)
+	if err != nil {
+		return err
+	}
+	upstreamHTTPClient = client
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 
-	"github.com/pomerium/datasource/internal/server"
 	"github.com/pomerium/datasource/internal/wellknownips"
 )
 
@@ -21,8 +20,11 @@ var wellKnownIPsCmd = &cobra.Command{
 			Str("address", wellKnownIPsArgs.address).
 			Str("ip2asn-url", wellKnownIPsArgs.ip2asnURL).
 			Msg("starting well-known-ips http server")
-		srv := wellknownips.NewServer(wellknownips.WithIP2ASNURL(wellKnownIPsArgs.ip2asnURL))
-		err := server.RunHTTPServer(cmd.Context(), wellKnownIPsArgs.address, srv)
+		srv := wellknownips.NewServer(
+			wellknownips.WithHTTPClient(upstreamHTTPClient),
+			wellknownips.WithIP2ASNURL(wellKnownIPsArgs.ip2asnURL),
+		)
+		err := runHTTPServer(cmd.Context(), wellKnownIPsArgs.address, "wellknownips", srv)
 		if err != nil {
 			log.Fatal().Err(err).Send()
 		}
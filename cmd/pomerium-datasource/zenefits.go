@@ -10,6 +10,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 
+	"github.com/pomerium/datasource/internal/httputil"
 	"github.com/pomerium/datasource/internal/server"
 	"github.com/pomerium/datasource/internal/zenefits"
 )
@@ -62,11 +63,11 @@ func (cmd *zenefitsCmd) exec(c *cobra.Command, _ []string) error {
 	log := zerolog.New(os.Stdout)
 	log.Info().Str("address", cmd.Address).Msg("ready")
 
-	return server.RunHTTPServer(c.Context(), cmd.Address, srv)
+	return runHTTPServer(c.Context(), cmd.Address, "zenefits", srv)
 }
 
 func (cmd *zenefitsCmd) newServer() (http.Handler, error) {
-	client := server.NewBearerTokenClient(http.DefaultClient, cmd.APIKey)
+	client := server.NewBearerTokenClient(httputil.NewRetryClient(httputil.NewInstrumentedClient(upstreamHTTPClient)), cmd.APIKey)
 	if cmd.Debug {
 		client = server.NewDebugClient(client, cmd.Logger)
 	}
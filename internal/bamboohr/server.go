@@ -3,9 +3,20 @@ package bamboohr
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog"
+
+	"github.com/pomerium/datasource/internal/metrics"
+)
+
+const (
+	// the sources of the metrics of the endpoints
+	allEmployeesSource       = "bamboohr/all"
+	availableEmployeesSource = "bamboohr/available"
+
+	employeeRecordType = "bamboohr.com/Employee"
 )
 
 // NewServer implements new BambooHR limited data exporter
@@ -28,25 +39,31 @@ type apiServer struct {
 func (srv *apiServer) getAllEmployees(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	start := time.Now()
 	employees, err := GetAllEmployees(ctx, srv.Client, srv.EmployeeRequest)
+	metrics.RecordSync(allEmployeesSource, start, err)
 	if err != nil {
 		srv.serveError(w, err, "get employees")
 		return
 	}
 
-	srv.serveJSON(w, employees)
+	metrics.SetRecordCount(allEmployeesSource, employeeRecordType, len(employees))
+	srv.serveJSON(w, allEmployeesSource, employees)
 }
 
 func (srv *apiServer) getAvailableEmployees(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	start := time.Now()
 	employees, err := GetAvailableEmployees(ctx, srv.Client, srv.EmployeeRequest)
+	metrics.RecordSync(availableEmployeesSource, start, err)
 	if err != nil {
 		srv.serveError(w, err, "get employees")
 		return
 	}
 
-	srv.serveJSON(w, employees)
+	metrics.SetRecordCount(availableEmployeesSource, employeeRecordType, len(employees))
+	srv.serveJSON(w, availableEmployeesSource, employees)
 }
 
 func (srv *apiServer) serveError(w http.ResponseWriter, err error, msg string) {
@@ -57,9 +74,15 @@ func (srv *apiServer) serveError(w http.ResponseWriter, err error, msg string) {
 	_, _ = w.Write([]byte(err.Error()))
 }
 
-func (srv *apiServer) serveJSON(w http.ResponseWriter, src interface{}) {
-	w.Header().Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(src); err != nil {
+func (srv *apiServer) serveJSON(w http.ResponseWriter, source string, src interface{}) {
+	data, err := json.Marshal(src)
+	if err != nil {
 		srv.Err(err).Msg("json marshal")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	metrics.SetBundleSize(source, len(data))
+
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = w.Write(append(data, '\n'))
 }
@@ -0,0 +1,68 @@
+package fakeidp
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// mountAzure mounts a fake Microsoft Graph API, for the azure provider with
+// --graph-url and --login-url set to the server url.
+func (srv *server) mountAzure(r chi.Router) {
+	r.Post("/{directory_id}/oauth2/v2.0/token", writeToken)
+	r.Get("/v1.0/groups/delta", func(w http.ResponseWriter, r *http.Request) {
+		var groups []map[string]any
+		for _, g := range srv.seed.Groups {
+			var members []map[string]any
+			for _, u := range srv.seed.getGroupUsers(g.ID) {
+				members = append(members, map[string]any{"@odata.type": "#microsoft.graph.user", "id": u.ID})
+			}
+			for _, cg := range srv.seed.getChildGroups(g.ID) {
+				members = append(members, map[string]any{"@odata.type": "#microsoft.graph.group", "id": cg.ID})
+			}
+			groups = append(groups, map[string]any{
+				"id":            g.ID,
+				"displayName":   g.Name,
+				"members@delta": members,
+			})
+		}
+		srv.writeAzureDelta(w, r, groups)
+	})
+	r.Get("/v1.0/servicePrincipals/delta", func(w http.ResponseWriter, r *http.Request) {
+		srv.writeAzureDelta(w, r, nil)
+	})
+	r.Get("/v1.0/users/delta", func(w http.ResponseWriter, r *http.Request) {
+		var users []map[string]any
+		for _, u := range srv.seed.Users {
+			users = append(users, map[string]any{
+				"id":                u.ID,
+				"accountEnabled":    !u.Inactive,
+				"displayName":       u.Name,
+				"mail":              u.Email,
+				"userPrincipalName": u.Email,
+			})
+		}
+		srv.writeAzureDelta(w, r, users)
+	})
+}
+
+// writeAzureDelta writes a page of a delta query. The seed never changes, so
+// following the delta link at the end of the last page returns no changes.
+func (srv *server) writeAzureDelta(w http.ResponseWriter, r *http.Request, items []map[string]any) {
+	if r.URL.Query().Has("$deltatoken") {
+		items = nil
+	}
+
+	page, nextURL := paginate(r, items, srv.cfg.pageSize)
+	res := map[string]any{
+		"@odata.context": getURL(r, "/v1.0/$metadata", nil),
+		"value":          page,
+	}
+	if nextURL != "" {
+		res["@odata.nextLink"] = nextURL
+	} else {
+		res["@odata.deltaLink"] = getURL(r, r.URL.Path, url.Values{"$deltatoken": {"FAKE_DELTA_TOKEN"}})
+	}
+	writeJSON(w, res)
+}
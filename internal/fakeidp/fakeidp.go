@@ -0,0 +1,183 @@
+// Package fakeidp contains a fake identity provider server for development and
+// demos. It serves the users and groups from a seed file with fake versions of
+// the APIs used by the directory providers, so the directory commands can be
+// run without credentials for a real tenant.
+//
+// All the APIs are served from the same address, since the paths they use
+// don't overlap, and any credentials are accepted.
+package fakeidp
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Seed is the directory served by the fake identity provider.
+type Seed struct {
+	Groups []Group `yaml:"groups"`
+	Users  []User  `yaml:"users"`
+}
+
+// A Group is a group in a seed.
+type Group struct {
+	ID    string `yaml:"id"`
+	Name  string `yaml:"name"`
+	Email string `yaml:"email"`
+	// ParentID is the id of the group this group is nested in, if any.
+	ParentID string `yaml:"parent_id"`
+}
+
+// A User is a user in a seed.
+type User struct {
+	ID    string `yaml:"id"`
+	Name  string `yaml:"name"`
+	Email string `yaml:"email"`
+	// GroupIDs are the ids of the groups the user is a direct member of.
+	GroupIDs []string `yaml:"group_ids"`
+	Inactive bool     `yaml:"inactive"`
+}
+
+// LoadSeed loads a seed from a YAML or JSON file.
+func LoadSeed(name string) (*Seed, error) {
+	bs, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("fakeidp: error reading seed file: %w", err)
+	}
+
+	var seed Seed
+	err = yaml.Unmarshal(bs, &seed)
+	if err != nil {
+		return nil, fmt.Errorf("fakeidp: error parsing seed file: %w", err)
+	}
+
+	err = seed.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &seed, nil
+}
+
+func (seed *Seed) validate() error {
+	groupIDs := map[string]bool{}
+	for _, g := range seed.Groups {
+		if g.ID == "" {
+			return fmt.Errorf("fakeidp: group %q has no id", g.Name)
+		}
+		if groupIDs[g.ID] {
+			return fmt.Errorf("fakeidp: duplicate group id %q", g.ID)
+		}
+		groupIDs[g.ID] = true
+	}
+	for _, g := range seed.Groups {
+		if g.ParentID != "" && !groupIDs[g.ParentID] {
+			return fmt.Errorf("fakeidp: group %q has an unknown parent group %q", g.ID, g.ParentID)
+		}
+	}
+
+	userIDs := map[string]bool{}
+	for _, u := range seed.Users {
+		if u.ID == "" {
+			return fmt.Errorf("fakeidp: user %q has no id", u.Name)
+		}
+		if userIDs[u.ID] {
+			return fmt.Errorf("fakeidp: duplicate user id %q", u.ID)
+		}
+		userIDs[u.ID] = true
+		for _, groupID := range u.GroupIDs {
+			if !groupIDs[groupID] {
+				return fmt.Errorf("fakeidp: user %q is a member of an unknown group %q", u.ID, groupID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getGroup returns the group with the given id.
+func (seed *Seed) getGroup(id string) (Group, bool) {
+	idx, ok := seed.getGroupIndex(id)
+	if !ok {
+		return Group{}, false
+	}
+	return seed.Groups[idx], true
+}
+
+func (seed *Seed) getGroupIndex(id string) (int, bool) {
+	idx := slices.IndexFunc(seed.Groups, func(g Group) bool { return g.ID == id })
+	return idx, idx >= 0
+}
+
+func (seed *Seed) getUserIndex(id string) (int, bool) {
+	idx := slices.IndexFunc(seed.Users, func(u User) bool { return u.ID == id })
+	return idx, idx >= 0
+}
+
+// getChildGroups returns the groups nested directly in a group.
+func (seed *Seed) getChildGroups(id string) []Group {
+	var groups []Group
+	for _, g := range seed.Groups {
+		if g.ParentID == id {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// getGroupUsers returns the users that are direct members of a group.
+func (seed *Seed) getGroupUsers(id string) []User {
+	var users []User
+	for _, u := range seed.Users {
+		if slices.Contains(u.GroupIDs, id) {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// numericID returns the id as a number, for APIs that only have numeric ids.
+// Ids that aren't numbers are replaced by their position in the seed.
+func numericID(id string, idx int) int {
+	if n, err := strconv.Atoi(id); err == nil {
+		return n
+	}
+	return idx + 1
+}
+
+// findNumericID returns the index of the item with the numeric id n.
+func findNumericID[T any](items []T, getID func(T) string, n int) (int, bool) {
+	for i, item := range items {
+		if numericID(getID(item), i) == n {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func groupID(g Group) string { return g.ID }
+
+// splitName splits a name into a first name and a last name.
+func splitName(name string) (first, last string) {
+	first, last, _ = strings.Cut(name, " ")
+	return first, last
+}
+
+// getLogin returns a username for a user, from their email address.
+func getLogin(u User) string {
+	if login, _, ok := strings.Cut(u.Email, "@"); ok && login != "" {
+		return login
+	}
+	return u.ID
+}
+
+// getSlug returns a url-safe version of a name.
+func getSlug(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	}), "-")
+}
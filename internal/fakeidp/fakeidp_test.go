@@ -0,0 +1,177 @@
+package fakeidp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	oktasdk "github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/azure"
+	"github.com/pomerium/datasource/pkg/directory/github"
+	"github.com/pomerium/datasource/pkg/directory/gitlab"
+	"github.com/pomerium/datasource/pkg/directory/google"
+	"github.com/pomerium/datasource/pkg/directory/keycloak"
+	"github.com/pomerium/datasource/pkg/directory/okta"
+	"github.com/pomerium/datasource/pkg/directory/onelogin"
+	"github.com/pomerium/datasource/pkg/directory/ping"
+)
+
+func TestLoadSeed(t *testing.T) {
+	t.Parallel()
+
+	seed, err := LoadSeed(filepath.Join("testdata", "seed.yaml"))
+	require.NoError(t, err)
+	assert.Len(t, seed.Groups, 3)
+	assert.Len(t, seed.Users, 4)
+	assert.Equal(t, "1", seed.Groups[1].ParentID)
+	assert.Equal(t, []string{"1", "3"}, seed.Users[1].GroupIDs)
+
+	for _, tc := range []struct {
+		name string
+		seed string
+	}{
+		{"duplicate group", `{"groups": [{"id": "1"}, {"id": "1"}]}`},
+		{"unknown parent", `{"groups": [{"id": "1", "parent_id": "2"}]}`},
+		{"unknown group", `{"users": [{"id": "1", "group_ids": ["2"]}]}`},
+		{"missing id", `{"users": [{"name": "User"}]}`},
+	} {
+		fp := filepath.Join(t.TempDir(), "seed.json")
+		require.NoError(t, os.WriteFile(fp, []byte(tc.seed), 0o600))
+		_, err := LoadSeed(fp)
+		assert.Error(t, err, tc.name)
+	}
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	seed, err := LoadSeed(filepath.Join("testdata", "seed.yaml"))
+	require.NoError(t, err)
+
+	// a small page size so every list has more than one page
+	srv := httptest.NewServer(NewServer(seed, WithPageSize(2)))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	direct := map[string][]string{
+		"101": {"2"},
+		"102": {"1", "3"},
+		"103": {"3"},
+		"104": {"1"},
+	}
+	flattened := map[string][]string{
+		"101": {"1", "2"},
+		"102": {"1", "3"},
+		"103": {"3"},
+		"104": {"1"},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		provider func(t *testing.T) directory.Provider
+		expect   map[string][]string
+	}{
+		{"azure", func(_ *testing.T) directory.Provider {
+			return azure.New(
+				azure.WithClientID("CLIENT_ID"),
+				azure.WithClientSecret("CLIENT_SECRET"),
+				azure.WithDirectoryID("DIRECTORY_ID"),
+				azure.WithGraphURL(u),
+				azure.WithLoginURL(u))
+		}, flattened},
+		{"github", func(_ *testing.T) directory.Provider {
+			return github.New(
+				github.WithPersonalAccessToken("PERSONAL_ACCESS_TOKEN"),
+				github.WithURL(u),
+				github.WithUseNodeIDs(true),
+				github.WithUsername("USERNAME"))
+		}, direct},
+		{"gitlab", func(_ *testing.T) directory.Provider {
+			return gitlab.New(
+				gitlab.WithPrivateToken("PRIVATE_TOKEN"),
+				gitlab.WithURL(u))
+		}, direct},
+		{"google", func(t *testing.T) directory.Provider {
+			res, err := http.Get(srv.URL + "/google/json-key")
+			require.NoError(t, err)
+			defer res.Body.Close()
+			jsonKey, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+
+			return google.New(
+				google.WithImpersonateUser("admin@example.com"),
+				google.WithJSONKey(jsonKey),
+				google.WithURL(srv.URL+"/"))
+		}, direct},
+		{"keycloak", func(_ *testing.T) directory.Provider {
+			return keycloak.New(
+				keycloak.WithBatchSize(2),
+				keycloak.WithClientID("CLIENT_ID"),
+				keycloak.WithClientSecret("CLIENT_SECRET"),
+				keycloak.WithFlattenNestedGroups(true),
+				keycloak.WithRealm("REALM"),
+				keycloak.WithURL(srv.URL))
+		}, flattened},
+		{"okta", func(_ *testing.T) directory.Provider {
+			return okta.New(
+				okta.WithAPIKey("API_KEY"),
+				okta.WithOktaOptions(oktasdk.WithTestingDisableHttpsCheck(true)),
+				okta.WithURL(srv.URL))
+		}, direct},
+		{"onelogin", func(_ *testing.T) directory.Provider {
+			return onelogin.New(
+				onelogin.WithClientID("CLIENT_ID"),
+				onelogin.WithClientSecret("CLIENT_SECRET"),
+				onelogin.WithURL(u))
+		}, map[string][]string{
+			"101": {"2"},
+			"102": {"1"},
+			"103": {"3"},
+			"104": {"1"},
+		}},
+		{"ping", func(_ *testing.T) directory.Provider {
+			return ping.New(
+				ping.WithAPIURL(u),
+				ping.WithAuthURL(u),
+				ping.WithClientID("CLIENT_ID"),
+				ping.WithClientSecret("CLIENT_SECRET"),
+				ping.WithEnvironmentID("ENVIRONMENT_ID"))
+		}, direct},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			groups, users, err := tc.provider(t).GetDirectory(t.Context())
+			require.NoError(t, err)
+
+			var groupIDs []string
+			for _, g := range groups {
+				groupIDs = append(groupIDs, g.ID)
+			}
+			assert.Equal(t, []string{"1", "2", "3"}, groupIDs)
+
+			userGroupIDs := map[string][]string{}
+			emails := map[string]string{}
+			for _, u := range users {
+				userGroupIDs[u.ID] = u.GroupIDs
+				emails[u.ID] = u.Email
+			}
+			assert.Equal(t, tc.expect, userGroupIDs)
+			assert.Equal(t, map[string]string{
+				"101": "ada@example.com",
+				"102": "grace@example.com",
+				"103": "alan@example.com",
+				"104": "charles@example.com",
+			}, emails)
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package fakeidp
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// githubOrganization is the login of the only organization.
+const githubOrganization = "example"
+
+// mountGitHub mounts a fake GitHub API, for the github provider with --url set
+// to the server url. The seed users are the members of a single organization,
+// and the seed groups are its teams. The user and team ids are the node ids.
+func (srv *server) mountGitHub(r chi.Router) {
+	r.Get("/user/orgs", func(w http.ResponseWriter, r *http.Request) {
+		page, nextURL := paginate(r, []map[string]any{{
+			"login": githubOrganization,
+			"id":    1,
+		}}, srv.cfg.pageSize)
+		if nextURL != "" {
+			w.Header().Add("Link", "<"+nextURL+`>; rel="next"`)
+		}
+		writeJSON(w, page)
+	})
+	r.Post("/graphql", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query string `json:"query"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, `{"message":"Problems parsing JSON"}`, http.StatusBadRequest)
+			return
+		}
+
+		doc, err := parser.ParseQuery(&ast.Source{Input: body.Query})
+		if err != nil {
+			writeJSON(w, map[string]any{"errors": []map[string]any{{"message": err.Error()}}})
+			return
+		}
+
+		data := map[string]any{}
+		for _, op := range doc.Operations {
+			for _, field := range getFields(op.SelectionSet) {
+				switch field.Name {
+				case "organization":
+					data[field.Alias] = srv.resolveGitHubOrganization(field)
+				default:
+					data[field.Alias] = nil
+				}
+			}
+		}
+		writeJSON(w, map[string]any{"data": data})
+	})
+}
+
+func (srv *server) resolveGitHubOrganization(field *ast.Field) any {
+	if getStringArgument(field, "login") != githubOrganization {
+		return nil
+	}
+
+	org := map[string]any{}
+	for _, f := range getFields(field.SelectionSet) {
+		switch f.Name {
+		case "login":
+			org[f.Alias] = githubOrganization
+		case "membersWithRole":
+			org[f.Alias] = resolveGitHubConnection(f, srv.cfg.pageSize, srv.seed.Users, resolveGitHubUser)
+		case "teams":
+			org[f.Alias] = resolveGitHubConnection(f, srv.cfg.pageSize, srv.seed.Groups, srv.resolveGitHubTeam)
+		case "team":
+			org[f.Alias] = nil
+			for _, g := range srv.seed.Groups {
+				if getSlug(g.Name) == getStringArgument(f, "slug") {
+					org[f.Alias] = srv.resolveGitHubTeam(f, g)
+				}
+			}
+		}
+	}
+	return org
+}
+
+func (srv *server) resolveGitHubTeam(field *ast.Field, g Group) any {
+	team := map[string]any{}
+	for _, f := range getFields(field.SelectionSet) {
+		switch f.Name {
+		case "id":
+			team[f.Alias] = g.ID
+		case "name":
+			team[f.Alias] = g.Name
+		case "slug":
+			team[f.Alias] = getSlug(g.Name)
+		case "members":
+			team[f.Alias] = resolveGitHubConnection(f, srv.cfg.pageSize, srv.seed.getGroupUsers(g.ID), resolveGitHubUser)
+		}
+	}
+	return team
+}
+
+func resolveGitHubUser(field *ast.Field, u User) any {
+	user := map[string]any{}
+	for _, f := range getFields(field.SelectionSet) {
+		switch f.Name {
+		case "id":
+			user[f.Alias] = u.ID
+		case "login":
+			user[f.Alias] = getLogin(u)
+		case "name":
+			user[f.Alias] = u.Name
+		case "email":
+			user[f.Alias] = u.Email
+		}
+	}
+	return user
+}
+
+// resolveGitHubConnection resolves a page of a connection. The cursors are
+// offsets into the list.
+func resolveGitHubConnection[T any](field *ast.Field, pageSize int, items []T, resolve func(*ast.Field, T) any) any {
+	first, err := strconv.Atoi(getStringArgument(field, "first"))
+	if err != nil || first <= 0 {
+		first = pageSize
+	}
+	start, _ := strconv.Atoi(getStringArgument(field, "after"))
+	start = min(max(start, 0), len(items))
+	end := min(start+min(first, pageSize), len(items))
+
+	connection := map[string]any{}
+	for _, f := range getFields(field.SelectionSet) {
+		switch f.Name {
+		case "totalCount":
+			connection[f.Alias] = len(items)
+		case "pageInfo":
+			connection[f.Alias] = map[string]any{
+				"endCursor":   strconv.Itoa(end),
+				"hasNextPage": end < len(items),
+			}
+		case "nodes":
+			nodes := []any{}
+			for _, item := range items[start:end] {
+				nodes = append(nodes, resolve(f, item))
+			}
+			connection[f.Alias] = nodes
+		case "edges":
+			edges := []any{}
+			for _, item := range items[start:end] {
+				edge := map[string]any{}
+				for _, ef := range getFields(f.SelectionSet) {
+					if ef.Name == "node" {
+						edge[ef.Alias] = resolve(ef, item)
+					}
+				}
+				edges = append(edges, edge)
+			}
+			connection[f.Alias] = edges
+		}
+	}
+	return connection
+}
+
+func getFields(selectionSet ast.SelectionSet) []*ast.Field {
+	var fields []*ast.Field
+	for _, selection := range selectionSet {
+		if field, ok := selection.(*ast.Field); ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// getStringArgument returns the raw value of an argument, or "" if it's not set or null.
+func getStringArgument(field *ast.Field, name string) string {
+	arg := field.Arguments.ForName(name)
+	if arg == nil || arg.Value == nil || arg.Value.Kind == ast.NullValue {
+		return ""
+	}
+	return arg.Value.Raw
+}
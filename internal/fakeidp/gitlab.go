@@ -0,0 +1,69 @@
+package fakeidp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// mountGitLab mounts a fake GitLab API, for the gitlab provider with --url set
+// to the server url.
+func (srv *server) mountGitLab(r chi.Router) {
+	r.Get("/api/v4/groups", func(w http.ResponseWriter, r *http.Request) {
+		var groups []map[string]any
+		for i, g := range srv.seed.Groups {
+			group := map[string]any{
+				"id":        numericID(g.ID, i),
+				"name":      g.Name,
+				"path":      getSlug(g.Name),
+				"full_name": g.Name,
+				"parent_id": nil,
+			}
+			if j, ok := srv.seed.getGroupIndex(g.ParentID); ok {
+				group["parent_id"] = numericID(g.ParentID, j)
+			}
+			groups = append(groups, group)
+		}
+		srv.writeGitLabPage(w, r, groups)
+	})
+	r.Get("/api/v4/groups/{group_id}/members", func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(chi.URLParam(r, "group_id"))
+		if err != nil {
+			http.Error(w, `{"message":"404 Group Not Found"}`, http.StatusNotFound)
+			return
+		}
+		i, ok := findNumericID(srv.seed.Groups, groupID, n)
+		if !ok {
+			http.Error(w, `{"message":"404 Group Not Found"}`, http.StatusNotFound)
+			return
+		}
+
+		var members []map[string]any
+		for _, u := range srv.seed.getGroupUsers(srv.seed.Groups[i].ID) {
+			j, _ := srv.seed.getUserIndex(u.ID)
+			state := "active"
+			if u.Inactive {
+				state = "blocked"
+			}
+			members = append(members, map[string]any{
+				"id":       numericID(u.ID, j),
+				"username": getLogin(u),
+				"name":     u.Name,
+				"email":    u.Email,
+				"state":    state,
+			})
+		}
+		srv.writeGitLabPage(w, r, members)
+	})
+}
+
+// writeGitLabPage writes a page of a list, with a link to the next page.
+func (srv *server) writeGitLabPage(w http.ResponseWriter, r *http.Request, items []map[string]any) {
+	page, nextURL := paginate(r, items, srv.cfg.pageSize)
+	if nextURL != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL))
+	}
+	writeJSON(w, page)
+}
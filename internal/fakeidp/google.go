@@ -0,0 +1,133 @@
+package fakeidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const googleKeyID = "fake-key-id"
+
+// A googleKey is the private key of the fake service account. It's only used
+// to sign token requests, so it's generated when first needed.
+type googleKey struct {
+	once sync.Once
+	pem  []byte
+	err  error
+}
+
+func (k *googleKey) get() ([]byte, error) {
+	k.once.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			k.err = err
+			return
+		}
+		k.pem = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	})
+	return k.pem, k.err
+}
+
+// mountGoogle mounts a fake Google Admin SDK directory API, for the google
+// provider with --url set to the server url and --json-key-file set to a file
+// with the service account key from /google/json-key.
+func (srv *server) mountGoogle(r chi.Router) {
+	r.Get("/google/json-key", func(w http.ResponseWriter, r *http.Request) {
+		privateKey, err := srv.google.get()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{
+			"type":           "service_account",
+			"project_id":     "fake-project",
+			"private_key_id": googleKeyID,
+			"private_key":    string(privateKey),
+			"client_email":   "fake-service-account@fake-project.iam.gserviceaccount.com",
+			"client_id":      "fake-client-id",
+			"token_uri":      getURL(r, "/google/token", nil),
+		})
+	})
+	r.Post("/google/token", writeToken)
+	r.Route("/admin/directory/v1", func(r chi.Router) {
+		r.Get("/groups", func(w http.ResponseWriter, r *http.Request) {
+			var groups []map[string]any
+			for _, g := range srv.seed.Groups {
+				groups = append(groups, map[string]any{
+					"kind":               "admin#directory#group",
+					"id":                 g.ID,
+					"email":              g.Email,
+					"name":               g.Name,
+					"directMembersCount": strconv.Itoa(len(srv.seed.getGroupUsers(g.ID)) + len(srv.seed.getChildGroups(g.ID))),
+				})
+			}
+			srv.writeGooglePage(w, r, "admin#directory#groups", "groups", groups)
+		})
+		r.Get("/groups/{group_key}/members", func(w http.ResponseWriter, r *http.Request) {
+			g, ok := srv.seed.getGroup(chi.URLParam(r, "group_key"))
+			if !ok {
+				http.Error(w, "Resource Not Found: groupKey", http.StatusNotFound)
+				return
+			}
+
+			var members []map[string]any
+			for _, u := range srv.seed.getGroupUsers(g.ID) {
+				members = append(members, map[string]any{
+					"kind":  "admin#directory#member",
+					"id":    u.ID,
+					"email": u.Email,
+					"role":  "MEMBER",
+					"type":  "USER",
+				})
+			}
+			for _, cg := range srv.seed.getChildGroups(g.ID) {
+				members = append(members, map[string]any{
+					"kind":  "admin#directory#member",
+					"id":    cg.ID,
+					"email": cg.Email,
+					"role":  "MEMBER",
+					"type":  "GROUP",
+				})
+			}
+			srv.writeGooglePage(w, r, "admin#directory#members", "members", members)
+		})
+		r.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+			var users []map[string]any
+			for _, u := range srv.seed.Users {
+				firstName, lastName := splitName(u.Name)
+				users = append(users, map[string]any{
+					"kind":         "admin#directory#user",
+					"id":           u.ID,
+					"primaryEmail": u.Email,
+					"name": map[string]any{
+						"fullName":   u.Name,
+						"givenName":  firstName,
+						"familyName": lastName,
+					},
+					"suspended": u.Inactive,
+				})
+			}
+			srv.writeGooglePage(w, r, "admin#directory#users", "users", users)
+		})
+	})
+}
+
+// writeGooglePage writes a page of a list. The page token is the page number.
+func (srv *server) writeGooglePage(w http.ResponseWriter, r *http.Request, kind, field string, items []map[string]any) {
+	n, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	page, hasNext := getPage(items, srv.cfg.pageSize, n)
+	res := map[string]any{
+		"kind": kind,
+		field:  page,
+	}
+	if hasNext {
+		res["nextPageToken"] = strconv.Itoa(n + 1)
+	}
+	writeJSON(w, res)
+}
@@ -0,0 +1,98 @@
+package fakeidp
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// mountKeycloak mounts a fake Keycloak API, for the keycloak provider with --url
+// set to the server url. Any realm name works.
+func (srv *server) mountKeycloak(r chi.Router) {
+	r.Route("/realms/{realm}", func(r chi.Router) {
+		r.Get("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			issuer := getURL(r, "/realms/"+url.PathEscape(chi.URLParam(r, "realm")), nil)
+			writeJSON(w, map[string]any{
+				"issuer":                                issuer,
+				"authorization_endpoint":                issuer + "/protocol/openid-connect/auth",
+				"token_endpoint":                        issuer + "/protocol/openid-connect/token",
+				"jwks_uri":                              issuer + "/protocol/openid-connect/certs",
+				"grant_types_supported":                 []string{"client_credentials"},
+				"response_types_supported":              []string{"code"},
+				"subject_types_supported":               []string{"public"},
+				"id_token_signing_alg_values_supported": []string{"RS256"},
+			})
+		})
+		r.Post("/protocol/openid-connect/token", writeToken)
+	})
+	r.Route("/admin/realms/{realm}", func(r chi.Router) {
+		r.Get("/groups", func(w http.ResponseWriter, r *http.Request) {
+			// newer versions of Keycloak only return the top level groups,
+			// with subgroups listed separately
+			srv.writeKeycloakPage(w, r, srv.getKeycloakGroups(srv.seed.getChildGroups("")))
+		})
+		r.Get("/groups/{group_id}/children", func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := srv.seed.getGroup(chi.URLParam(r, "group_id")); !ok {
+				http.Error(w, `{"error":"Could not find group by id"}`, http.StatusNotFound)
+				return
+			}
+			srv.writeKeycloakPage(w, r, srv.getKeycloakGroups(srv.seed.getChildGroups(chi.URLParam(r, "group_id"))))
+		})
+		r.Get("/groups/{group_id}/members", func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := srv.seed.getGroup(chi.URLParam(r, "group_id")); !ok {
+				http.Error(w, `{"error":"Could not find group by id"}`, http.StatusNotFound)
+				return
+			}
+			srv.writeKeycloakPage(w, r, getKeycloakUsers(srv.seed.getGroupUsers(chi.URLParam(r, "group_id"))))
+		})
+		r.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+			srv.writeKeycloakPage(w, r, getKeycloakUsers(srv.seed.Users))
+		})
+	})
+}
+
+func (srv *server) getKeycloakGroups(groups []Group) []map[string]any {
+	var items []map[string]any
+	for _, g := range groups {
+		items = append(items, map[string]any{
+			"id":            g.ID,
+			"name":          g.Name,
+			"path":          "/" + g.Name,
+			"subGroupCount": len(srv.seed.getChildGroups(g.ID)),
+			"subGroups":     []any{},
+		})
+	}
+	return items
+}
+
+func getKeycloakUsers(users []User) []map[string]any {
+	var items []map[string]any
+	for _, u := range users {
+		firstName, lastName := splitName(u.Name)
+		items = append(items, map[string]any{
+			"id":            u.ID,
+			"username":      getLogin(u),
+			"email":         u.Email,
+			"emailVerified": u.Email != "",
+			"enabled":       !u.Inactive,
+			"firstName":     firstName,
+			"lastName":      lastName,
+		})
+	}
+	return items
+}
+
+// writeKeycloakPage writes the page of a list requested with the first and max
+// query parameters.
+func (srv *server) writeKeycloakPage(w http.ResponseWriter, r *http.Request, items []map[string]any) {
+	first, _ := strconv.Atoi(r.URL.Query().Get("first"))
+	first = min(max(first, 0), len(items))
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("max"))
+	if err != nil || pageSize <= 0 {
+		pageSize = srv.cfg.pageSize
+	}
+	end := min(first+pageSize, len(items))
+	writeJSON(w, append([]map[string]any{}, items[first:end]...))
+}
@@ -0,0 +1,76 @@
+package fakeidp
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// oktaTimeFormat is the ISO-8601 format used by Okta.
+const oktaTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// mountOkta mounts a fake Okta API, for the okta provider with --url set to the
+// server url.
+func (srv *server) mountOkta(r chi.Router) {
+	r.Get("/api/v1/groups", func(w http.ResponseWriter, r *http.Request) {
+		var groups []map[string]any
+		// a filter is only used to list the groups that changed since the last
+		// sync, and the seed never changes
+		if r.URL.Query().Get("filter") == "" {
+			for _, g := range srv.seed.Groups {
+				groups = append(groups, map[string]any{
+					"id":                    g.ID,
+					"type":                  "OKTA_GROUP",
+					"created":               srv.lastUpdated.Format(oktaTimeFormat),
+					"lastUpdated":           srv.lastUpdated.Format(oktaTimeFormat),
+					"lastMembershipUpdated": srv.lastUpdated.Format(oktaTimeFormat),
+					"profile": map[string]any{
+						"name": g.Name,
+					},
+				})
+			}
+		}
+		srv.writeOktaPage(w, r, groups)
+	})
+	r.Get("/api/v1/groups/{group_id}/users", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := srv.seed.getGroup(chi.URLParam(r, "group_id")); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			writeJSON(w, map[string]any{
+				"errorCode":    "E0000007",
+				"errorSummary": fmt.Sprintf("Not found: Resource not found: %s (UserGroup)", chi.URLParam(r, "group_id")),
+			})
+			return
+		}
+
+		var users []map[string]any
+		for _, u := range srv.seed.getGroupUsers(chi.URLParam(r, "group_id")) {
+			status := "ACTIVE"
+			if u.Inactive {
+				status = "SUSPENDED"
+			}
+			firstName, lastName := splitName(u.Name)
+			users = append(users, map[string]any{
+				"id":     u.ID,
+				"status": status,
+				"profile": map[string]any{
+					"email":     u.Email,
+					"firstName": firstName,
+					"lastName":  lastName,
+					"login":     u.Email,
+				},
+			})
+		}
+		srv.writeOktaPage(w, r, users)
+	})
+}
+
+// writeOktaPage writes a page of a list, with a link to the next page.
+func (srv *server) writeOktaPage(w http.ResponseWriter, r *http.Request, items []map[string]any) {
+	page, nextURL := paginate(r, items, srv.cfg.pageSize)
+	if nextURL != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL))
+	}
+	writeJSON(w, page)
+}
@@ -0,0 +1,75 @@
+package fakeidp
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// mountOneLogin mounts a fake OneLogin API, for the onelogin provider with --url
+// set to the server url. OneLogin users are only in a single group, so users
+// are only in the first of their groups.
+func (srv *server) mountOneLogin(r chi.Router) {
+	r.Post("/auth/oauth2/v2/token", writeToken)
+	r.Get("/api/1/groups", func(w http.ResponseWriter, r *http.Request) {
+		var groups []map[string]any
+		for i, g := range srv.seed.Groups {
+			groups = append(groups, map[string]any{
+				"id":        numericID(g.ID, i),
+				"name":      g.Name,
+				"reference": nil,
+			})
+		}
+		srv.writeOneLoginPage(w, r, groups)
+	})
+	r.Get("/api/1/users", func(w http.ResponseWriter, r *http.Request) {
+		var users []map[string]any
+		for i, u := range srv.seed.Users {
+			firstName, lastName := splitName(u.Name)
+			user := map[string]any{
+				"id":        numericID(u.ID, i),
+				"email":     u.Email,
+				"username":  getLogin(u),
+				"firstname": firstName,
+				"lastname":  lastName,
+				"group_id":  nil,
+				"status":    1,
+			}
+			if u.Inactive {
+				user["status"] = 2
+			}
+			if len(u.GroupIDs) > 0 {
+				j, _ := srv.seed.getGroupIndex(u.GroupIDs[0])
+				user["group_id"] = numericID(u.GroupIDs[0], j)
+			}
+			users = append(users, user)
+		}
+		srv.writeOneLoginPage(w, r, users)
+	})
+}
+
+// writeOneLoginPage writes a page of a list, with a link to the next page.
+func (srv *server) writeOneLoginPage(w http.ResponseWriter, r *http.Request, items []map[string]any) {
+	pageSize := srv.cfg.pageSize
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		pageSize = min(pageSize, limit)
+	}
+	page, nextURL := paginate(r, items, pageSize)
+	var nextLink any
+	if nextURL != "" {
+		nextLink = nextURL
+	}
+	writeJSON(w, map[string]any{
+		"status": map[string]any{
+			"error":   false,
+			"code":    http.StatusOK,
+			"type":    "success",
+			"message": "Success",
+		},
+		"pagination": map[string]any{
+			"next_link": nextLink,
+		},
+		"data": page,
+	})
+}
@@ -0,0 +1,92 @@
+package fakeidp
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// pingMemberOfGroupFilterRE matches the SCIM filter used to list the members of a group.
+var pingMemberOfGroupFilterRE = regexp.MustCompile(`^memberOfGroups\[id eq "([^"]*)"\]$`)
+
+// mountPing mounts a fake PingOne API, for the ping provider with --api-url
+// and --auth-url set to the server url.
+func (srv *server) mountPing(r chi.Router) {
+	r.Post("/{environment_id}/as/token", writeToken)
+	r.Route("/v1/environments/{environment_id}", func(r chi.Router) {
+		r.Get("/groups", func(w http.ResponseWriter, r *http.Request) {
+			var groups []map[string]any
+			for _, g := range srv.seed.Groups {
+				groups = append(groups, map[string]any{
+					"id":          g.ID,
+					"name":        g.Name,
+					"environment": map[string]any{"id": chi.URLParam(r, "environment_id")},
+				})
+			}
+			srv.writePingPage(w, r, "groups", groups)
+		})
+		r.Get("/groups/{group_id}/memberOfGroups", func(w http.ResponseWriter, r *http.Request) {
+			g, ok := srv.seed.getGroup(chi.URLParam(r, "group_id"))
+			if !ok {
+				http.Error(w, `{"code":"NOT_FOUND"}`, http.StatusNotFound)
+				return
+			}
+
+			var groupMemberships []map[string]any
+			if parent, ok := srv.seed.getGroup(g.ParentID); ok {
+				groupMemberships = append(groupMemberships, map[string]any{
+					"id":   parent.ID,
+					"name": parent.Name,
+					"type": "DIRECT",
+				})
+			}
+			srv.writePingPage(w, r, "groupMemberships", groupMemberships)
+		})
+		r.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+			users := srv.seed.Users
+			if filter := r.URL.Query().Get("filter"); filter != "" {
+				m := pingMemberOfGroupFilterRE.FindStringSubmatch(filter)
+				if m == nil {
+					http.Error(w, `{"code":"INVALID_FILTER"}`, http.StatusBadRequest)
+					return
+				}
+				users = srv.seed.getGroupUsers(m[1])
+			}
+
+			var items []map[string]any
+			for _, u := range users {
+				firstName, lastName := splitName(u.Name)
+				items = append(items, map[string]any{
+					"id":       u.ID,
+					"email":    u.Email,
+					"username": getLogin(u),
+					"enabled":  !u.Inactive,
+					"name": map[string]any{
+						"given":  firstName,
+						"family": lastName,
+					},
+				})
+			}
+			srv.writePingPage(w, r, "users", items)
+		})
+	})
+}
+
+// writePingPage writes a page of a list, embedded in a HAL response with a
+// link to the next page.
+func (srv *server) writePingPage(w http.ResponseWriter, r *http.Request, field string, items []map[string]any) {
+	page, nextURL := paginate(r, items, srv.cfg.pageSize)
+	links := map[string]any{
+		"self": map[string]any{"href": getURL(r, r.URL.Path, r.URL.Query())},
+	}
+	if nextURL != "" {
+		links["next"] = map[string]any{"href": nextURL}
+	}
+	writeJSON(w, map[string]any{
+		"_links":    links,
+		"_embedded": map[string]any{field: page},
+		"count":     len(items),
+		"size":      len(page),
+	})
+}
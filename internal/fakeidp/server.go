@@ -0,0 +1,127 @@
+package fakeidp
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DefaultPageSize is the default maximum number of items in a page of a list.
+const DefaultPageSize = 100
+
+const (
+	accessToken = "FAKE_ACCESS_TOKEN"
+	tokenExpiry = time.Hour
+)
+
+type config struct {
+	pageSize int
+}
+
+// An Option customizes the fake identity provider.
+type Option func(cfg *config)
+
+// WithPageSize sets the maximum number of items in a page of a list, so
+// pagination can be tried out with a small seed.
+func WithPageSize(pageSize int) Option {
+	return func(cfg *config) {
+		cfg.pageSize = pageSize
+	}
+}
+
+func getConfig(options ...Option) *config {
+	cfg := new(config)
+	WithPageSize(DefaultPageSize)(cfg)
+	for _, option := range options {
+		option(cfg)
+	}
+	cfg.pageSize = max(cfg.pageSize, 1)
+	return cfg
+}
+
+type server struct {
+	cfg  *config
+	seed *Seed
+	// groups and memberships are never updated, so every item has the same
+	// last updated time
+	lastUpdated time.Time
+
+	google *googleKey
+}
+
+// NewServer creates a new fake identity provider server for a seed.
+func NewServer(seed *Seed, options ...Option) http.Handler {
+	srv := &server{
+		cfg:         getConfig(options...),
+		seed:        seed,
+		lastUpdated: time.Now().UTC().Truncate(time.Second),
+		google:      new(googleKey),
+	}
+
+	r := chi.NewRouter()
+	srv.mountAzure(r)
+	srv.mountGitHub(r)
+	srv.mountGitLab(r)
+	srv.mountGoogle(r)
+	srv.mountKeycloak(r)
+	srv.mountOkta(r)
+	srv.mountOneLogin(r)
+	srv.mountPing(r)
+	return r
+}
+
+// paginate returns the page of items requested with the page query parameter,
+// and the url of the next page, or "" if it's the last page.
+func paginate[T any](r *http.Request, items []T, pageSize int) (page []T, nextURL string) {
+	n, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page, ok := getPage(items, pageSize, n)
+	if ok {
+		nextURL = getURL(r, r.URL.Path, withQuery(r.URL.Query(), "page", strconv.Itoa(n+1)))
+	}
+	return page, nextURL
+}
+
+// getPage returns page n of the items, and whether there's a next page. The
+// page is never nil, so it's encoded as an empty JSON array.
+func getPage[T any](items []T, pageSize, n int) (page []T, hasNext bool) {
+	start := min(max(n, 0)*pageSize, len(items))
+	end := min(start+pageSize, len(items))
+	return append([]T{}, items[start:end]...), end < len(items)
+}
+
+// getURL returns the absolute url of a path on the server.
+func getURL(r *http.Request, path string, query url.Values) string {
+	u := url.URL{Scheme: "http", Host: r.Host, Path: path, RawQuery: query.Encode()}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+func withQuery(query url.Values, name, value string) url.Values {
+	query = maps.Clone(query)
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set(name, value)
+	return query
+}
+
+// writeToken writes an oauth2 access token response.
+func writeToken(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenExpiry.Seconds()),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,246 @@
+// Package flagconfig sets command line flags from a YAML or JSON config file.
+//
+// The config file mirrors the command tree. Each command has a node keyed by its
+// name, and within that node the keys are flag names or sub command names:
+//
+//	directory:
+//	  okta:
+//	    api-key_file: /run/secrets/okta-api-key
+//	    url: ${OKTA_URL}
+//	    upload:
+//	      destination: s3://bucket/okta
+//	bamboohr:
+//	  bamboohr-api-key: ${BAMBOOHR_API_KEY}
+//
+// Options of a command also apply to its sub commands, so flags shared by
+// several commands, like the root ca-file flag, can be set once at the top.
+// Options in deeper nodes override the ones of their ancestors.
+//
+// String values may reference environment variables with ${NAME}. A flag name
+// with a _file suffix reads the flag value from the named file, which is useful
+// for secrets.
+//
+// Flags set on the command line take precedence over the config file. A flag
+// and its -file alternative, like api-key and api-key-file, count as one flag:
+// if either is set on the command line, the config file sets neither, so
+// --api-key-file wins over api-key_file. Unlike --api-key-file, which is re-read
+// when the file changes, api-key_file is only read once.
+//
+// The config file only sets flags, so values are validated the same way as the
+// command line: by cobra's required flags and by each command's own checks,
+// like the validator tags of the bamboohr, zenefits and fleetdm commands, which
+// all run before the command starts. Flags have no struct tags of their own to
+// validate here.
+package flagconfig
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const fileSuffix = "_file"
+
+var envRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// A Config is a parsed config file.
+type Config map[string]any
+
+// Load loads a config file. Since JSON is a subset of YAML, both are supported.
+func Load(name string) (Config, error) {
+	bs, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("flagconfig: error reading config file: %w", err)
+	}
+
+	// decode into a plain map so nested nodes are plain maps too
+	var cfg map[string]any
+	err = yaml.Unmarshal(bs, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("flagconfig: error parsing config file: %w", err)
+	}
+	return cfg, nil
+}
+
+// Apply validates the config against the command tree of cmd and sets the flags of cmd
+// from the nodes of cmd and its ancestors in the config. Flags set on the command line
+// take precedence.
+func Apply(cmd *cobra.Command, cfg Config) error {
+	err := validate(cmd.Root(), cfg, nil)
+	if err != nil {
+		return err
+	}
+
+	// the flags that are already set were set on the command line
+	cmdLine := map[string]bool{}
+	visit := func(f *pflag.Flag) { cmdLine[f.Name] = true }
+	cmd.Flags().Visit(visit)
+	cmd.InheritedFlags().Visit(visit)
+
+	// walk from the root to cmd, so deeper nodes override their ancestors
+	type option struct {
+		key   string
+		value any
+		path  []string
+	}
+	options := map[string]option{}
+	var path []string
+	node := map[string]any(cfg)
+	for _, c := range commandLineage(cmd) {
+		if c.HasParent() {
+			path = append(path, c.Name())
+			node, _ = node[c.Name()].(map[string]any)
+		}
+		for key, value := range node {
+			if isSubCommand(c, key, value) {
+				continue
+			}
+			// the local flags of an ancestor don't apply to cmd
+			name := strings.TrimSuffix(key, fileSuffix)
+			if cmd.Flags().Lookup(name) == nil {
+				continue
+			}
+			// a flag and its -file alternative override each other
+			options[strings.TrimSuffix(name, "-file")] = option{key: key, value: value, path: slices.Clone(path)}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(options)) {
+		o := options[name]
+		err = set(cmd, cmdLine, o.key, o.value)
+		if err != nil {
+			return fmt.Errorf("flagconfig: %s: %w", strings.Join(append(o.path, o.key), "."), err)
+		}
+	}
+
+	return nil
+}
+
+func validate(cmd *cobra.Command, node map[string]any, path []string) error {
+	for key, value := range node {
+		keyPath := strings.Join(append(path, key), ".")
+		if sub := findSubCommand(cmd, key); sub != nil {
+			m, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("flagconfig: %s: expected the options for the %s command", keyPath, key)
+			}
+			err := validate(sub, m, append(path, key))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := strings.TrimSuffix(key, fileSuffix)
+		if lookupFlag(cmd, name) == nil {
+			return fmt.Errorf("flagconfig: %s: unknown option", keyPath)
+		}
+		if name != key {
+			if _, ok := node[name]; ok {
+				return fmt.Errorf("flagconfig: %s: only one of %s and %s may be set", keyPath, name, key)
+			}
+		}
+	}
+	return nil
+}
+
+func set(cmd *cobra.Command, cmdLine map[string]bool, key string, value any) error {
+	name, isFile := strings.CutSuffix(key, fileSuffix)
+	if cmdLine[name] || cmdLine[alternativeFlag(name)] {
+		return nil
+	}
+
+	var values []any
+	if vs, ok := value.([]any); ok {
+		values = vs
+	} else {
+		values = []any{value}
+	}
+
+	for _, v := range values {
+		str := fmt.Sprint(v)
+		if s, ok := v.(string); ok {
+			var err error
+			str, err = interpolate(s)
+			if err != nil {
+				return err
+			}
+		}
+
+		if isFile {
+			bs, err := os.ReadFile(str)
+			if err != nil {
+				return fmt.Errorf("error reading file: %w", err)
+			}
+			str = strings.TrimRight(string(bs), "\r\n")
+		}
+
+		err := cmd.Flags().Set(name, str)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func interpolate(s string) (string, error) {
+	var err error
+	s = envRE.ReplaceAllStringFunc(s, func(m string) string {
+		name := envRE.FindStringSubmatch(m)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v
+	})
+	return s, err
+}
+
+// alternativeFlag returns the name of the -file alternative of a flag, or of
+// the flag a -file flag is an alternative to.
+func alternativeFlag(name string) string {
+	if base, ok := strings.CutSuffix(name, "-file"); ok {
+		return base
+	}
+	return name + "-file"
+}
+
+// commandLineage returns the root command, its sub commands down to cmd, and cmd.
+func commandLineage(cmd *cobra.Command) []*cobra.Command {
+	var cmds []*cobra.Command
+	for c := cmd; c != nil; c = c.Parent() {
+		cmds = append([]*cobra.Command{c}, cmds...)
+	}
+	return cmds
+}
+
+func findSubCommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+func isSubCommand(cmd *cobra.Command, key string, value any) bool {
+	_, ok := value.(map[string]any)
+	return ok && findSubCommand(cmd, key) != nil
+}
+
+func lookupFlag(cmd *cobra.Command, name string) *pflag.Flag {
+	if f := cmd.Flags().Lookup(name); f != nil {
+		return f
+	}
+	if f := cmd.PersistentFlags().Lookup(name); f != nil {
+		return f
+	}
+	return cmd.InheritedFlags().Lookup(name)
+}
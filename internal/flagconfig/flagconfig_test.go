@@ -0,0 +1,124 @@
+package flagconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testFlags struct {
+	apiKey     string
+	apiKeyFile string
+	url        string
+	scopes     []string
+	debug      bool
+	caFile     string
+	region     string
+	address    string
+}
+
+func newTestCommand(flags *testFlags) (root, leaf *cobra.Command) {
+	root = &cobra.Command{Use: "root"}
+	root.PersistentFlags().StringVar(&flags.caFile, "ca-file", "", "")
+	parent := &cobra.Command{Use: "parent"}
+	parent.PersistentFlags().StringVar(&flags.region, "region", "", "")
+	parent.Flags().StringVar(&flags.address, "address", "", "")
+	leaf = &cobra.Command{Use: "leaf", Run: func(_ *cobra.Command, _ []string) {}}
+	leaf.Flags().StringVar(&flags.apiKey, "api-key", "", "")
+	leaf.Flags().StringVar(&flags.apiKeyFile, "api-key-file", "", "")
+	leaf.Flags().StringVar(&flags.url, "url", "", "")
+	leaf.Flags().StringSliceVar(&flags.scopes, "scope", nil, "")
+	leaf.Flags().BoolVar(&flags.debug, "debug", false, "")
+	_ = leaf.MarkFlagRequired("api-key")
+	root.AddCommand(parent)
+	parent.AddCommand(leaf)
+	return root, leaf
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "api-key"), []byte("SECRET\n"), 0o600))
+	t.Setenv("FLAGCONFIG_TEST_HOST", "example.com")
+
+	cfgFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(cfgFile, []byte(`
+parent:
+  leaf:
+    api-key_file: `+filepath.Join(dir, "api-key")+`
+    url: https://${FLAGCONFIG_TEST_HOST}/api
+    scope: [a, b]
+    debug: true
+`), 0o600))
+
+	cfg, err := Load(cfgFile)
+	require.NoError(t, err)
+
+	t.Run("apply", func(t *testing.T) {
+		var flags testFlags
+		_, leaf := newTestCommand(&flags)
+		require.NoError(t, leaf.ParseFlags([]string{"--debug=false"}))
+		require.NoError(t, Apply(leaf, cfg))
+		require.NoError(t, leaf.ValidateRequiredFlags())
+		assert.Equal(t, testFlags{
+			apiKey: "SECRET",
+			url:    "https://example.com/api",
+			scopes: []string{"a", "b"},
+			debug:  false,
+		}, flags, "command line flags should take precedence")
+	})
+	t.Run("file alternative", func(t *testing.T) {
+		var flags testFlags
+		_, leaf := newTestCommand(&flags)
+		require.NoError(t, leaf.ParseFlags([]string{"--api-key-file=/run/secrets/api-key"}))
+		require.NoError(t, Apply(leaf, cfg))
+		assert.Empty(t, flags.apiKey, "the config file should not set a flag whose -file alternative is set on the command line")
+		assert.Equal(t, "/run/secrets/api-key", flags.apiKeyFile)
+	})
+	t.Run("ancestors", func(t *testing.T) {
+		var flags testFlags
+		_, leaf := newTestCommand(&flags)
+		require.NoError(t, leaf.ParseFlags(nil))
+		require.NoError(t, Apply(leaf, Config{
+			"ca-file": "/etc/ssl/ca.pem",
+			"parent": map[string]any{
+				"region":  "us",
+				"address": ":8080",
+				"leaf": map[string]any{
+					"region":  "eu",
+					"api-key": "SECRET",
+				},
+			},
+		}))
+		assert.Equal(t, testFlags{
+			apiKey: "SECRET",
+			caFile: "/etc/ssl/ca.pem",
+			region: "eu",
+		}, flags, "deeper nodes should override their ancestors, and ancestors' local flags should not apply")
+	})
+	t.Run("unknown option", func(t *testing.T) {
+		var flags testFlags
+		_, leaf := newTestCommand(&flags)
+		err := Apply(leaf, Config{"parent": map[string]any{"leaf": map[string]any{"bogus": "x"}}})
+		assert.ErrorContains(t, err, "parent.leaf.bogus: unknown option")
+	})
+	t.Run("duplicate option", func(t *testing.T) {
+		var flags testFlags
+		_, leaf := newTestCommand(&flags)
+		err := Apply(leaf, Config{"parent": map[string]any{"leaf": map[string]any{
+			"api-key": "x", "api-key_file": "y",
+		}}})
+		assert.ErrorContains(t, err, "only one of api-key and api-key_file may be set")
+	})
+	t.Run("missing environment variable", func(t *testing.T) {
+		var flags testFlags
+		_, leaf := newTestCommand(&flags)
+		err := Apply(leaf, Config{"parent": map[string]any{"leaf": map[string]any{
+			"url": "${FLAGCONFIG_TEST_MISSING}",
+		}}})
+		assert.ErrorContains(t, err, "environment variable FLAGCONFIG_TEST_MISSING is not set")
+	})
+}
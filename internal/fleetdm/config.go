@@ -1,6 +1,9 @@
 package fleetdm
 
+import "net/http"
+
 type config struct {
+	httpClient         *http.Client
 	apiToken           string
 	apiURL             string
 	certificateQueryID uint
@@ -8,7 +11,9 @@ type config struct {
 
 type Option func(*config)
 
-var defaults = []Option{}
+var defaults = []Option{
+	WithHTTPClient(http.DefaultClient),
+}
 
 func newConfig(opts ...Option) *config {
 	cfg := new(config)
@@ -21,6 +26,13 @@ func newConfig(opts ...Option) *config {
 	return cfg
 }
 
+// WithHTTPClient sets the HTTP client used to call the FleetDM API.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(cfg *config) {
+		cfg.httpClient = httpClient
+	}
+}
+
 // WithAPIToken sets the API token on the config.
 func WithAPIToken(token string) Option {
 	return func(cfg *config) {
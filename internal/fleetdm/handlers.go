@@ -2,14 +2,24 @@ package fleetdm
 
 import (
 	"net/http"
+	"time"
+
+	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/metrics"
 )
 
 func (srv *server) getIndexHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", "attachment; filename=fleetdm.zip")
 
-	err := srv.writeRecords(r.Context(), w)
+	start := time.Now()
+	size, err := httputil.ServeBundle(w, r, func(bw *httputil.BundleWriter) error {
+		return srv.writeRecords(r.Context(), bw)
+	}, httputil.WithBundleSource(source))
+	metrics.RecordSync(source, start, err)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	metrics.SetBundleSize(source, int(size))
 }
@@ -1,15 +1,17 @@
 package fleetdm
 
 import (
-	"archive/zip"
 	"context"
 	"fmt"
-	"io"
 
-	"github.com/pomerium/datasource/internal/jsonutil"
+	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/metrics"
 )
 
 const (
+	// source is the name of the connector in bundle manifests and metrics
+	source = "fleetdm"
+
 	typeCertificateSHA1Fingerprint = "fleetdm.com/CertificateSHA1Fingerprint"
 	typeHost                       = "fleetdm.com/Host"
 	typePolicy                     = "fleetdm.com/Policy"
@@ -17,51 +19,37 @@ const (
 
 func (srv *server) writeRecords(
 	ctx context.Context,
-	dst io.Writer,
+	bw *httputil.BundleWriter,
 ) error {
-	zw := zip.NewWriter(dst)
-
-	fw, err := zw.Create(typeCertificateSHA1Fingerprint + ".json")
-	if err != nil {
-		return fmt.Errorf("write header: %w", err)
-	}
-
 	certs, err := srv.client.QueryCertificates(ctx, srv.cfg.certificateQueryID)
 	if err != nil {
 		return fmt.Errorf("query certificates: %w", err)
 	}
 
-	err = jsonutil.StreamWriteArray(fw, certs)
+	err = httputil.WriteBundleRecords(bw, typeCertificateSHA1Fingerprint, certs)
 	if err != nil {
 		return fmt.Errorf("write certificates: %w", err)
 	}
 
-	fw, err = zw.Create(typeHost + ".json")
-	if err != nil {
-		return fmt.Errorf("write header: %w", err)
-	}
-
 	hosts := srv.client.ListHosts(ctx)
 
-	err = jsonutil.StreamWriteArray(fw, hosts)
+	err = httputil.WriteBundleRecords(bw, typeHost, hosts)
 	if err != nil {
 		return fmt.Errorf("write hosts: %w", err)
 	}
 
-	fw, err = zw.Create(typePolicy + ".json")
-	if err != nil {
-		return fmt.Errorf("write header: %w", err)
-	}
-
 	policies, err := srv.client.ListPolicies(ctx)
 	if err != nil {
 		return fmt.Errorf("list policies: %w", err)
 	}
 
-	err = jsonutil.StreamWriteArray(fw, policies)
+	err = httputil.WriteBundleRecords(bw, typePolicy, policies)
 	if err != nil {
 		return fmt.Errorf("write policies: %w", err)
 	}
 
-	return zw.Close()
+	for recordType, records := range bw.Manifest().Records {
+		metrics.SetRecordCount(source, recordType, records.Count)
+	}
+	return nil
 }
@@ -12,6 +12,7 @@ func NewServer(opts ...Option) (*mux.Router, error) {
 	cfg := newConfig(opts...)
 
 	client, err := client.New(
+		client.WithHTTPClient(cfg.httpClient),
 		client.WithToken(cfg.apiToken),
 		client.WithURL(cfg.apiURL),
 		client.WithPolicies(),
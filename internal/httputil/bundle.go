@@ -0,0 +1,216 @@
+package httputil
+
+import (
+	"archive/zip"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"reflect"
+	"time"
+
+	"github.com/pomerium/datasource/internal/jsonutil"
+	"github.com/pomerium/datasource/internal/version"
+)
+
+type bundleConfig struct {
+	now    func() time.Time
+	signer crypto.Signer
+	source string
+}
+
+// A BundleOption customizes how a bundle is written.
+type BundleOption func(cfg *bundleConfig)
+
+// WithBundleSigner sets the signer used to sign the bundle manifest. Only
+// signers with an Ed25519 public key are supported, like an ed25519.PrivateKey
+// or a client for a key management service.
+func WithBundleSigner(signer crypto.Signer) BundleOption {
+	return func(cfg *bundleConfig) {
+		cfg.signer = signer
+	}
+}
+
+// WithBundleSource sets the name of the connector that produced the bundle,
+// which is recorded in the bundle manifest.
+func WithBundleSource(source string) BundleOption {
+	return func(cfg *bundleConfig) {
+		cfg.source = source
+	}
+}
+
+// WithBundleTime sets the function used to get the generation time recorded
+// in the bundle manifest.
+func WithBundleTime(now func() time.Time) BundleOption {
+	return func(cfg *bundleConfig) {
+		cfg.now = now
+	}
+}
+
+func getBundleConfig(options ...BundleOption) *bundleConfig {
+	cfg := new(bundleConfig)
+	WithBundleTime(time.Now)(cfg)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
+
+// A BundleWriter writes a bundle of records as a zip file, with a JSON file for
+// each record type and a manifest describing them. The hash and size of the
+// bundle are computed as it's written, so it never has to be held in memory.
+type BundleWriter struct {
+	cfg      *bundleConfig
+	zw       *zip.Writer
+	size     int64
+	manifest *BundleManifest
+}
+
+// NewBundleWriter creates a new BundleWriter.
+func NewBundleWriter(w io.Writer, options ...BundleOption) *BundleWriter {
+	bw := &BundleWriter{
+		cfg: getBundleConfig(options...),
+	}
+	bw.zw = zip.NewWriter(io.MultiWriter(w, (*countingWriter)(&bw.size)))
+	bw.manifest = &BundleManifest{
+		SchemaVersion: BundleSchemaVersion,
+		Generator: BundleGenerator{
+			Name:    version.ProjectName,
+			Version: version.FullVersion(),
+		},
+		Source:      bw.cfg.source,
+		GeneratedAt: bw.cfg.now().UTC(),
+		Records:     map[string]BundleRecords{},
+	}
+	return bw
+}
+
+// WriteRecords adds the records of a record type to the bundle, encoded as a
+// single JSON value. If records is a slice, the manifest records its length as
+// the record count.
+func (bw *BundleWriter) WriteRecords(recordType string, records any) error {
+	return bw.write(recordType, func(w io.Writer) (int, error) {
+		count := 1
+		if v := reflect.ValueOf(records); v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			count = v.Len()
+		}
+		return count, json.NewEncoder(w).Encode(records)
+	})
+}
+
+// Close writes the manifest, and its signature if there's a signer, and
+// finishes writing the bundle. It doesn't close the underlying writer.
+func (bw *BundleWriter) Close() error {
+	manifest, err := json.Marshal(bw.manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	manifest = append(manifest, '\n')
+
+	err = bw.createFile(BundleManifestName, manifest)
+	if err != nil {
+		return err
+	}
+
+	if bw.cfg.signer != nil {
+		signature, err := signBundleManifest(bw.cfg.signer, manifest)
+		if err != nil {
+			return err
+		}
+		err = bw.createFile(BundleSignatureName, signature)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = bw.zw.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close zip file: %w", err)
+	}
+	return nil
+}
+
+// Hash returns the hash of the bundle used for ETags. It's computed from the
+// digests of the records, so it doesn't change when the same records are
+// written again at a different time.
+func (bw *BundleWriter) Hash() uint64 {
+	return bw.manifest.Hash()
+}
+
+// Manifest returns the manifest of the bundle.
+func (bw *BundleWriter) Manifest() *BundleManifest {
+	return bw.manifest
+}
+
+// Size returns the number of bytes written so far.
+func (bw *BundleWriter) Size() int64 {
+	return bw.size
+}
+
+func (bw *BundleWriter) createFile(name string, data []byte) error {
+	fw, err := bw.zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create %s file: %w", name, err)
+	}
+	_, err = fw.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to write %s file: %w", name, err)
+	}
+	return nil
+}
+
+// write adds the JSON file for a record type to the bundle, recording the
+// number of records and the digest of the file in the manifest.
+func (bw *BundleWriter) write(recordType string, write func(w io.Writer) (int, error)) error {
+	if recordType+".json" == BundleManifestName {
+		return fmt.Errorf("invalid record type %s", recordType)
+	} else if _, ok := bw.manifest.Records[recordType]; ok {
+		return fmt.Errorf("duplicate record type %s", recordType)
+	}
+
+	fw, err := bw.zw.Create(recordType + ".json")
+	if err != nil {
+		return fmt.Errorf("failed to create %s file: %w", recordType, err)
+	}
+
+	hasher := sha256.New()
+	count, err := write(io.MultiWriter(fw, hasher))
+	if err != nil {
+		return fmt.Errorf("failed to write %s data: %w", recordType, err)
+	}
+
+	bw.manifest.Records[recordType] = BundleRecords{
+		Count:  count,
+		SHA256: hex.EncodeToString(hasher.Sum(nil)),
+	}
+	return nil
+}
+
+// WriteBundleRecords adds the records of a record type to a bundle, encoding
+// them as they're produced.
+func WriteBundleRecords[T any](bw *BundleWriter, recordType string, records iter.Seq2[T, error]) error {
+	return bw.write(recordType, func(w io.Writer) (int, error) {
+		count := 0
+		err := jsonutil.StreamWriteArray(w, func(yield func(T, error) bool) {
+			for record, err := range records {
+				if err == nil {
+					count++
+				}
+				if !yield(record, err) {
+					return
+				}
+			}
+		})
+		return count, err
+	})
+}
+
+type countingWriter int64
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	*cw += countingWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,241 @@
+package httputil
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/datasource/internal/version"
+)
+
+func TestBundleWriter(t *testing.T) {
+	t.Parallel()
+
+	generatedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var buf bytes.Buffer
+	bw := NewBundleWriter(&buf,
+		WithBundleSource("example"),
+		WithBundleTime(func() time.Time { return generatedAt }))
+	require.NoError(t, WriteBundleRecords(bw, "example.com/Record", recordsSeq(3)))
+	require.NoError(t, WriteBundleRecords(bw, "example.com/Empty", recordsSeq(0)))
+	require.NoError(t, bw.Close())
+	assert.Equal(t, int64(buf.Len()), bw.Size())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 3)
+	assert.Equal(t, "example.com/Record.json", zr.File[0].Name)
+	assert.Equal(t, "example.com/Empty.json", zr.File[1].Name)
+	assert.Equal(t, BundleManifestName, zr.File[2].Name)
+	assert.JSONEq(t, `[{"id":"0"},{"id":"1"},{"id":"2"}]`, readZipFile(t, zr, "example.com/Record.json"))
+	assert.JSONEq(t, `[]`, readZipFile(t, zr, "example.com/Empty.json"))
+
+	manifest, err := ReadBundleManifest(zr)
+	require.NoError(t, err)
+	assert.Equal(t, bw.Manifest(), manifest)
+	assert.Equal(t, BundleSchemaVersion, manifest.SchemaVersion)
+	assert.Equal(t, version.ProjectName, manifest.Generator.Name)
+	assert.Equal(t, "example", manifest.Source)
+	assert.Equal(t, generatedAt, manifest.GeneratedAt)
+	assert.Equal(t, 3, manifest.Records["example.com/Record"].Count)
+	assert.Equal(t, 0, manifest.Records["example.com/Empty"].Count)
+	assert.Equal(t, manifest.Hash(), bw.Hash())
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+
+		bw := NewBundleWriter(io.Discard)
+		err := WriteBundleRecords(bw, "example.com/Record", func(yield func(map[string]string, error) bool) {
+			yield(nil, errors.New("ERROR"))
+		})
+		assert.ErrorContains(t, err, "ERROR")
+		assert.Error(t, bw.WriteRecords("manifest", nil), "should not overwrite the manifest")
+	})
+}
+
+func TestEncodeBundle(t *testing.T) {
+	t.Parallel()
+
+	encode := func(generatedAt time.Time, bundle map[string]any) ([]byte, uint64) {
+		var buf bytes.Buffer
+		hash, err := EncodeBundle(t.Context(), &buf, bundle,
+			WithBundleTime(func() time.Time { return generatedAt }))
+		require.NoError(t, err)
+		return buf.Bytes(), hash
+	}
+
+	bs1, hash1 := encode(time.Unix(1, 0), map[string]any{
+		"example.com/B": []string{"b1", "b2"},
+		"example.com/A": []string{"a"},
+	})
+	zr, err := zip.NewReader(bytes.NewReader(bs1), int64(len(bs1)))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 3)
+	assert.Equal(t, "example.com/A.json", zr.File[0].Name, "record types should be sorted")
+	assert.Equal(t, "example.com/B.json", zr.File[1].Name)
+	manifest, err := ReadBundleManifest(zr)
+	require.NoError(t, err)
+	assert.Equal(t, 1, manifest.Records["example.com/A"].Count)
+	assert.Equal(t, 2, manifest.Records["example.com/B"].Count)
+
+	bs2, hash2 := encode(time.Unix(2, 0), map[string]any{
+		"example.com/B": []string{"b1", "b2"},
+		"example.com/A": []string{"a"},
+	})
+	assert.NotEqual(t, bs1, bs2)
+	assert.Equal(t, hash1, hash2, "the hash should only depend on the records")
+
+	_, hash3 := encode(time.Unix(1, 0), map[string]any{
+		"example.com/B": []string{"b1"},
+		"example.com/A": []string{"a"},
+	})
+	assert.NotEqual(t, hash1, hash3)
+}
+
+func TestReadBundleManifest(t *testing.T) {
+	t.Parallel()
+
+	var manifest BundleManifest
+	for _, tc := range []struct {
+		name   string
+		files  [][2]string
+		expect string
+	}{
+		{"no manifest", [][2]string{
+			{"example.com/A.json", `[]`},
+		}, ""},
+		{"unsupported schema version", [][2]string{
+			{BundleManifestName, `{"schema_version": 2}`},
+		}, "unsupported bundle schema version: 2"},
+		{"unlisted file", [][2]string{
+			{BundleManifestName, `{"schema_version": 1, "records": {}}`},
+			{"example.com/A.json", `[]`},
+		}, "bundle file example.com/A.json is missing from the manifest"},
+		{"missing file", [][2]string{
+			{BundleManifestName, `{"schema_version": 1, "records": {"example.com/A": {"count": 0, "sha256": ""}}}`},
+		}, "failed to open bundle file example.com/A.json"},
+		{"digest mismatch", [][2]string{
+			{BundleManifestName, `{"schema_version": 1, "records": {"example.com/A": {"count": 0, "sha256": "4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945"}}}`},
+			{"example.com/A.json", `[{}]`},
+		}, "bundle file example.com/A.json doesn't match the manifest digest"},
+		{"unexpected file", [][2]string{
+			{BundleManifestName, `{"schema_version": 1, "records": {}}`},
+			{"README.txt", `hello`},
+		}, "unexpected bundle file README.txt"},
+		{"duplicate file", [][2]string{
+			{BundleManifestName, `{"schema_version": 1, "records": {"example.com/A": {"count": 0, "sha256": "4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945"}}}`},
+			{"example.com/A.json", `[]`},
+			{"example.com/A.json", `[{}]`},
+		}, "duplicate bundle file example.com/A.json"},
+		{"valid", [][2]string{
+			{BundleManifestName, `{"schema_version": 1, "records": {"example.com/A": {"count": 0, "sha256": "4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945"}}}`},
+			{"example.com/A.json", `[]`},
+		}, ""},
+	} {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, file := range tc.files {
+			fw, err := zw.Create(file[0])
+			require.NoError(t, err)
+			_, err = io.WriteString(fw, file[1])
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		m, err := ReadBundleManifest(zr)
+		if tc.expect != "" {
+			assert.ErrorContains(t, err, tc.expect, tc.name)
+		} else if assert.NoError(t, err, tc.name) && m != nil {
+			manifest = *m
+		}
+	}
+	assert.Equal(t, 1, manifest.SchemaVersion)
+}
+
+func TestServeBundle(t *testing.T) {
+	t.Parallel()
+
+	var size int64
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		size, err = ServeBundle(w, r, func(bw *BundleWriter) error {
+			return WriteBundleRecords(bw, "example.com/Record", recordsSeq(100))
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Equal(t, int64(w.Body.Len()), size)
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	manifest, err := ReadBundleManifest(zr)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(`"%x"`, manifest.Hash()), w.Header().Get("ETag"),
+		"should use the hash of the records, which doesn't depend on the generation time")
+	etag := w.Header().Get("ETag")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Range", "bytes=0-9")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, 10, w.Body.Len())
+}
+
+func TestServeEncodedError(t *testing.T) {
+	t.Parallel()
+
+	w := httptest.NewRecorder()
+	_, err := ServeEncoded(w, httptest.NewRequest(http.MethodGet, "/", nil), "data.json", func(dst io.Writer) error {
+		_, _ = io.WriteString(dst, "[")
+		return errors.New("ERROR")
+	})
+	assert.ErrorContains(t, err, "ERROR")
+	assert.Empty(t, w.Header().Get("ETag"), "nothing should be sent when encoding fails")
+	assert.Zero(t, w.Body.Len())
+}
+
+func recordsSeq(n int) iter.Seq2[map[string]string, error] {
+	return func(yield func(map[string]string, error) bool) {
+		for i := range n {
+			if !yield(map[string]string{"id": fmt.Sprint(i)}, nil) {
+				return
+			}
+		}
+	}
+}
+
+func readZipFile(t *testing.T, zr *zip.Reader, name string) string {
+	t.Helper()
+
+	rc, err := zr.Open(name)
+	require.NoError(t, err)
+	defer rc.Close()
+	bs, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(bs)
+}
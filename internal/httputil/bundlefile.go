@@ -0,0 +1,57 @@
+package httputil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// A BundleFile is an encoded bundle in a temporary file, so bundles aren't held
+// in memory while they're served. Requests may still be reading a bundle after
+// it's replaced, so the file is only closed and removed once the BundleFile is
+// garbage collected.
+type BundleFile struct {
+	f    *os.File
+	size int64
+}
+
+// NewBundleFile creates a BundleFile with the bundle written by write.
+func NewBundleFile(write func(w io.Writer) error) (*BundleFile, error) {
+	f, err := os.CreateTemp("", "pomerium-datasource-bundle-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	bf := &BundleFile{f: f}
+	runtime.AddCleanup(bf, func(f *os.File) {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}, f)
+
+	err = write(f)
+	if err != nil {
+		return nil, err
+	}
+
+	bf.size, err = f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seek temporary file: %w", err)
+	}
+	return bf, nil
+}
+
+// ReadAt implements io.ReaderAt, keeping the file open while it's read.
+func (bf *BundleFile) ReadAt(p []byte, off int64) (int, error) {
+	defer runtime.KeepAlive(bf)
+	return bf.f.ReadAt(p, off)
+}
+
+// Size returns the size of the bundle.
+func (bf *BundleFile) Size() int64 {
+	return bf.size
+}
+
+// Reader returns a reader for the bundle.
+func (bf *BundleFile) Reader() *io.SectionReader {
+	return io.NewSectionReader(bf, 0, bf.size)
+}
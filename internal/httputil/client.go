@@ -8,6 +8,9 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
+
+	"github.com/pomerium/datasource/internal/metrics"
+	"github.com/pomerium/datasource/internal/tracing"
 )
 
 type loggingRoundTripper struct {
@@ -53,12 +56,10 @@ func (l loggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error
 	return res, err
 }
 
-// NewLoggingRoundTripper creates a http.RoundTripper that will log requests.
+// NewLoggingRoundTripper creates a http.RoundTripper that will log requests and
+// record request metrics and spans.
 func NewLoggingRoundTripper(logger zerolog.Logger, base http.RoundTripper, customize ...func(event *zerolog.Event) *zerolog.Event) http.RoundTripper {
-	if base == nil {
-		base = http.DefaultTransport
-	}
-	return loggingRoundTripper{base: base, logger: logger, customize: customize}
+	return loggingRoundTripper{base: NewInstrumentedRoundTripper(base), logger: logger, customize: customize}
 }
 
 // NewLoggingClient creates a new http.Client that will log requests.
@@ -72,6 +73,24 @@ func NewLoggingClient(logger zerolog.Logger, base *http.Client, customize ...fun
 	return newClient
 }
 
+// NewInstrumentedRoundTripper creates a http.RoundTripper that records metrics
+// and a span for each request.
+func NewInstrumentedRoundTripper(base http.RoundTripper) http.RoundTripper {
+	return metrics.NewRoundTripper(tracing.NewRoundTripper(base))
+}
+
+// NewInstrumentedClient creates a new http.Client that records metrics and a
+// span for each request.
+func NewInstrumentedClient(base *http.Client) *http.Client {
+	if base == nil {
+		base = http.DefaultClient
+	}
+	newClient := new(http.Client)
+	*newClient = *base
+	newClient.Transport = NewInstrumentedRoundTripper(newClient.Transport)
+	return newClient
+}
+
 func peek(r io.Reader, dst []byte) (n int, newReader io.Reader, err error) {
 	var tmp bytes.Buffer
 	n, err = io.TeeReader(r, &tmp).Read(dst)
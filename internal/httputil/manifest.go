@@ -0,0 +1,146 @@
+package httputil
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+)
+
+const (
+	// BundleManifestName is the name of the manifest file in a bundle.
+	BundleManifestName = "manifest.json"
+	// BundleSchemaVersion is the version of the bundle format written by a
+	// BundleWriter. Bundles with a newer schema version are rejected.
+	BundleSchemaVersion = 1
+)
+
+// A BundleManifest describes the contents of a bundle and where it came from.
+type BundleManifest struct {
+	SchemaVersion int             `json:"schema_version"`
+	Generator     BundleGenerator `json:"generator"`
+	// Source is the name of the connector that produced the bundle.
+	Source      string    `json:"source,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Records are the records in the bundle by record type.
+	Records map[string]BundleRecords `json:"records"`
+}
+
+// A BundleGenerator is the program that generated a bundle.
+type BundleGenerator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// BundleRecords describes the records of a record type in a bundle.
+type BundleRecords struct {
+	Count int `json:"count"`
+	// SHA256 is the hex encoded SHA-256 digest of the record type's JSON file.
+	SHA256 string `json:"sha256"`
+}
+
+// Hash returns a hash of the records described by the manifest.
+func (m *BundleManifest) Hash() uint64 {
+	hasher := fnv.New64()
+	recordTypes := maps.Keys(m.Records)
+	slices.Sort(recordTypes)
+	for _, recordType := range recordTypes {
+		_, _ = fmt.Fprintf(hasher, "%s\x00%s\n", recordType, m.Records[recordType].SHA256)
+	}
+	return hasher.Sum64()
+}
+
+// ReadBundleManifest reads the manifest of a bundle and checks the bundle
+// against it, so truncated, modified or unsupported bundles are detected.
+// Bundles with duplicate file names, or with files other than the manifest,
+// its signature and the listed record files, are rejected. Bundles written
+// before manifests were added have no manifest, in which case nil is returned
+// without an error.
+func ReadBundleManifest(zr *zip.Reader) (*BundleManifest, error) {
+	err := checkBundleFileNames(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := zr.Open(BundleManifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open bundle manifest: %w", err)
+	}
+	defer f.Close()
+
+	var manifest BundleManifest
+	err = json.NewDecoder(f).Decode(&manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bundle manifest: %w", err)
+	}
+
+	if manifest.SchemaVersion < 1 || manifest.SchemaVersion > BundleSchemaVersion {
+		return nil, fmt.Errorf("unsupported bundle schema version: %d", manifest.SchemaVersion)
+	}
+
+	for _, zf := range zr.File {
+		if zf.Name == BundleManifestName || zf.Name == BundleSignatureName {
+			continue
+		}
+		recordType, ok := strings.CutSuffix(zf.Name, ".json")
+		if !ok {
+			return nil, fmt.Errorf("unexpected bundle file %s", zf.Name)
+		}
+		if _, ok := manifest.Records[recordType]; !ok {
+			return nil, fmt.Errorf("bundle file %s is missing from the manifest", zf.Name)
+		}
+	}
+
+	for recordType, records := range manifest.Records {
+		digest, err := digestBundleFile(zr, recordType+".json")
+		if err != nil {
+			return nil, err
+		}
+		if digest != records.SHA256 {
+			return nil, fmt.Errorf("bundle file %s.json doesn't match the manifest digest", recordType)
+		}
+	}
+
+	return &manifest, nil
+}
+
+// checkBundleFileNames rejects bundles with more than one file with the same
+// name. Readers may pick different copies of a file, so the copy that was
+// checked against the manifest might not be the one that's used.
+func checkBundleFileNames(zr *zip.Reader) error {
+	names := make(map[string]struct{}, len(zr.File))
+	for _, zf := range zr.File {
+		if _, ok := names[zf.Name]; ok {
+			return fmt.Errorf("duplicate bundle file %s", zf.Name)
+		}
+		names[zf.Name] = struct{}{}
+	}
+	return nil
+}
+
+func digestBundleFile(zr *zip.Reader, name string) (string, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to open bundle file %s: %w", name, err)
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bundle file %s: %w", name, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,282 @@
+package httputil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog/log"
+
+	"github.com/pomerium/datasource/internal/metrics"
+)
+
+const (
+	// DefaultMaxRetries is the default maximum number of retries for a single request.
+	DefaultMaxRetries = 5
+	// DefaultMaxRetryWait is the default maximum time to wait before a retry.
+	DefaultMaxRetryWait = 2 * time.Minute
+	// DefaultRetryBudget is the default number of retries that can be made in a burst.
+	DefaultRetryBudget = 20
+
+	// every request adds this fraction of a retry to the budget
+	retryBudgetRatio = 0.1
+	// unix timestamps are at least this large, smaller values are delays in seconds
+	minUnixTimestamp = 1_000_000_000
+)
+
+var defaultRetryableMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodPut,
+	http.MethodDelete,
+	http.MethodTrace,
+}
+
+type retryConfig struct {
+	maxRetries        int
+	maxRetryWait      time.Duration
+	retryBudget       int
+	retryableMethods  []string
+	unlimitedThrottle bool
+}
+
+// A RetryOption customizes the retry config.
+type RetryOption func(cfg *retryConfig)
+
+// WithMaxRetries sets the maximum number of retries for a single request.
+func WithMaxRetries(maxRetries int) RetryOption {
+	return func(cfg *retryConfig) {
+		cfg.maxRetries = maxRetries
+	}
+}
+
+// WithMaxRetryWait sets the maximum time to wait before a retry. If the server
+// asks to wait longer, the response is returned as is.
+func WithMaxRetryWait(maxRetryWait time.Duration) RetryOption {
+	return func(cfg *retryConfig) {
+		cfg.maxRetryWait = maxRetryWait
+	}
+}
+
+// WithRetryBudget sets the number of retries that can be made in a burst. The
+// budget is shared by all the requests to the same host made with the round
+// tripper and is refilled as requests are made, so a failing upstream isn't
+// flooded with retries. A value of 0 disables the budget.
+func WithRetryBudget(retryBudget int) RetryOption {
+	return func(cfg *retryConfig) {
+		cfg.retryBudget = retryBudget
+	}
+}
+
+// WithRetryableMethods adds request methods that are safe to retry. By default
+// only idempotent methods are retried.
+func WithRetryableMethods(methods ...string) RetryOption {
+	return func(cfg *retryConfig) {
+		cfg.retryableMethods = append(cfg.retryableMethods, methods...)
+	}
+}
+
+// WithUnlimitedThrottleRetries retries 429s with a Retry-After header for as
+// long as the server asks, until the request's context is canceled. These
+// retries don't count against the max retries, max retry wait or retry budget,
+// which still limit the retries of 5xxs and transport errors.
+func WithUnlimitedThrottleRetries(unlimited bool) RetryOption {
+	return func(cfg *retryConfig) {
+		cfg.unlimitedThrottle = unlimited
+	}
+}
+
+func getRetryConfig(options ...RetryOption) *retryConfig {
+	cfg := new(retryConfig)
+	WithMaxRetries(DefaultMaxRetries)(cfg)
+	WithMaxRetryWait(DefaultMaxRetryWait)(cfg)
+	WithRetryBudget(DefaultRetryBudget)(cfg)
+	WithRetryableMethods(defaultRetryableMethods...)(cfg)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
+
+type retryRoundTripper struct {
+	base    http.RoundTripper
+	cfg     *retryConfig
+	budgets *sync.Map // retry budgets by host
+}
+
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+}
+
+func (rt retryRoundTripper) getRetryBudget(host string) *retryBudget {
+	if rt.cfg.retryBudget <= 0 {
+		return nil
+	}
+	size := float64(rt.cfg.retryBudget)
+	b, _ := rt.budgets.LoadOrStore(host, &retryBudget{tokens: size, max: size})
+	return b.(*retryBudget)
+}
+
+func (b *retryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.tokens = min(b.tokens+retryBudgetRatio, b.max)
+	b.mu.Unlock()
+}
+
+func (b *retryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// NewRetryRoundTripper creates a http.RoundTripper that retries requests that
+// fail with a transport error, a 429 or a 5xx, using an exponential backoff
+// with jitter. Retry-After and X-RateLimit-Reset response headers are honored.
+func NewRetryRoundTripper(base http.RoundTripper, options ...RetryOption) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return retryRoundTripper{base: base, cfg: getRetryConfig(options...), budgets: new(sync.Map)}
+}
+
+// NewRetryClient creates a new http.Client that retries requests. The client
+// should be reused, since each one has its own retry budget.
+func NewRetryClient(base *http.Client, options ...RetryOption) *http.Client {
+	if base == nil {
+		base = http.DefaultClient
+	}
+	newClient := new(http.Client)
+	*newClient = *base
+	newClient.Transport = NewRetryRoundTripper(newClient.Transport, options...)
+	return newClient
+}
+
+func (rt retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	budget := rt.getRetryBudget(req.URL.Host)
+	retryable := slices.Contains(rt.cfg.retryableMethods, req.Method) &&
+		(req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+
+	bo := backoff.NewExponentialBackOff(backoff.WithMaxElapsedTime(0))
+	retries := 0
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		res, err := rt.base.RoundTrip(req)
+		if attempt == 0 {
+			budget.deposit()
+		}
+		if !retryable || !shouldRetry(ctx, res, err) {
+			return res, err
+		}
+
+		wait := bo.NextBackOff()
+		throttled := false
+		if res != nil {
+			if d, ok := getRetryAfter(res, time.Now()); ok {
+				wait = d
+				throttled = rt.cfg.unlimitedThrottle &&
+					res.StatusCode == http.StatusTooManyRequests && res.Header.Get("Retry-After") != ""
+			}
+		}
+		if !throttled {
+			if retries >= rt.cfg.maxRetries || wait > rt.cfg.maxRetryWait || !budget.withdraw() {
+				return res, err
+			}
+			retries++
+		}
+
+		log.Ctx(ctx).Debug().
+			Str("method", req.Method).
+			Str("authority", req.URL.Host).
+			Str("path", req.URL.Path).
+			Dur("wait", wait).
+			Int("attempt", attempt+1).
+			Msg("retrying http request")
+		metrics.RecordRetry(req.URL.Host)
+
+		if res != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+			_ = res.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-time.After(wait):
+		}
+	}
+}
+
+func shouldRetry(ctx context.Context, res *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil && !errors.Is(err, context.Canceled)
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	case http.StatusForbidden:
+		// github returns a 403 for secondary rate limits
+		return res.Header.Get("Retry-After") != "" || res.Header.Get("X-RateLimit-Remaining") == "0"
+	}
+	return false
+}
+
+// getRetryAfter returns how long to wait before retrying based on the
+// Retry-After header, either in seconds or as a date, or for rate limited
+// responses the X-RateLimit-Reset header, either a unix timestamp or seconds
+// until the reset.
+func getRetryAfter(res *http.Response, now time.Time) (time.Duration, bool) {
+	if v := res.Header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return max(time.Duration(seconds)*time.Second, 0), true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return max(t.Sub(now), 0), true
+		}
+	}
+
+	rateLimited := res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusForbidden
+	if v := res.Header.Get("X-RateLimit-Reset"); rateLimited && v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			if n >= minUnixTimestamp {
+				return max(time.Unix(n, 0).Sub(now), 0), true
+			}
+			return max(time.Duration(n)*time.Second, 0), true
+		}
+	}
+
+	return 0, false
+}
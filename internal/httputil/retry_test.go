@@ -0,0 +1,180 @@
+package httputil
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/429":
+			if n%3 != 0 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "TOO MANY REQUESTS", http.StatusTooManyRequests)
+				return
+			}
+		case "/503":
+			http.Error(w, "UNAVAILABLE", http.StatusServiceUnavailable)
+			return
+		case "/wait":
+			w.Header().Set("Retry-After", "3600")
+			http.Error(w, "TOO MANY REQUESTS", http.StatusTooManyRequests)
+			return
+		}
+		bs, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(bs)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewRetryClient(nil, WithMaxRetries(2), WithMaxRetryWait(2*time.Second))
+	do := func(t *testing.T, method, path, body string) (*http.Response, int64) {
+		t.Helper()
+
+		calls.Store(0)
+		req, err := http.NewRequestWithContext(ctx, method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = res.Body.Close() })
+		return res, calls.Load()
+	}
+
+	res, n := do(t, http.MethodPut, "/429", "BODY")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int64(3), n)
+	bs, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "BODY", string(bs), "should resend the body")
+
+	res, n = do(t, http.MethodPost, "/429", "")
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, int64(1), n, "should not retry non-idempotent requests")
+
+	res, n = do(t, http.MethodGet, "/wait", "")
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, int64(1), n, "should not wait longer than the max retry wait")
+
+	res, n = do(t, http.MethodGet, "/503", "")
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, int64(3), n, "should stop after the max retries")
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "TOO MANY REQUESTS", http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+
+	do := func(t *testing.T, client *http.Client) int64 {
+		t.Helper()
+
+		calls.Store(0)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return calls.Load()
+	}
+
+	options := []RetryOption{WithMaxRetries(3), WithMaxRetryWait(time.Second)}
+	client1 := NewRetryClient(nil, append(options, WithRetryBudget(2))...)
+	client2 := NewRetryClient(nil, append(options, WithRetryBudget(2))...)
+	assert.Equal(t, int64(3), do(t, client1), "should stop when the budget is spent")
+	assert.Equal(t, int64(1), do(t, client1), "should not retry without a budget")
+	assert.Equal(t, int64(3), do(t, client2), "should not share the budget with other clients")
+
+	client3 := NewRetryClient(nil, append(options, WithRetryBudget(0))...)
+	for range 3 {
+		assert.Equal(t, int64(4), do(t, client3), "should not limit retries with the budget disabled")
+	}
+}
+
+func TestUnlimitedThrottleRetries(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/429":
+			if n <= 5 {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, "TOO MANY REQUESTS", http.StatusTooManyRequests)
+				return
+			}
+		case "/503":
+			http.Error(w, "UNAVAILABLE", http.StatusServiceUnavailable)
+			return
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client := NewRetryClient(nil, WithMaxRetries(2), WithRetryBudget(1), WithUnlimitedThrottleRetries(true))
+	do := func(t *testing.T, path string) (*http.Response, int64) {
+		t.Helper()
+
+		calls.Store(0)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		_ = res.Body.Close()
+		return res, calls.Load()
+	}
+
+	res, n := do(t, "/429")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int64(6), n, "should retry throttled requests past the max retries and budget")
+
+	res, n = do(t, "/503")
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, int64(2), n, "should still limit other retries with the budget")
+}
+
+func TestGetRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		status int
+		header http.Header
+		expect time.Duration
+		ok     bool
+	}{
+		{http.StatusTooManyRequests, http.Header{"Retry-After": {"5"}}, 5 * time.Second, true},
+		{http.StatusServiceUnavailable, http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}}, time.Minute, true},
+		{http.StatusForbidden, http.Header{"X-Ratelimit-Reset": {"1767326645"}}, time.Hour, true},
+		{http.StatusTooManyRequests, http.Header{"X-Ratelimit-Reset": {"30"}}, 30 * time.Second, true},
+		{http.StatusInternalServerError, http.Header{"X-Ratelimit-Reset": {"30"}}, 0, false},
+		{http.StatusTooManyRequests, http.Header{}, 0, false},
+	} {
+		d, ok := getRetryAfter(&http.Response{StatusCode: tc.status, Header: tc.header}, now)
+		assert.Equal(t, tc.expect, d)
+		assert.Equal(t, tc.ok, ok)
+	}
+}
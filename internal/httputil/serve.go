@@ -1,56 +1,67 @@
 package httputil
 
 import (
-	"archive/zip"
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"hash/fnv"
 	"io"
 	"net/http"
+	"os"
 	"sort"
 	"time"
 
 	"golang.org/x/exp/maps"
+
+	"github.com/pomerium/datasource/internal/tracing"
 )
 
-// EncodeBundle encodes a bundle to a writer.
-func EncodeBundle(w io.Writer, bundle map[string]any) error {
-	zw := zip.NewWriter(w)
-	defer zw.Close()
+// EncodeBundle encodes a bundle to a writer, returning its hash.
+func EncodeBundle(ctx context.Context, w io.Writer, bundle map[string]any, options ...BundleOption) (_ uint64, err error) {
+	_, span := tracing.Start(ctx, "httputil.EncodeBundle")
+	defer func() { tracing.End(span, err) }()
+
+	bw := NewBundleWriter(w, options...)
 
 	recordTypes := maps.Keys(bundle)
 	sort.Strings(recordTypes)
 
 	for _, recordType := range recordTypes {
-		fw, err := zw.Create(recordType + ".json")
+		err = bw.WriteRecords(recordType, bundle[recordType])
 		if err != nil {
-			return fmt.Errorf("failed to create %s file: %w", recordType, err)
-		}
-		err = json.NewEncoder(fw).Encode(bundle[recordType])
-		if err != nil {
-			return fmt.Errorf("failed to write %s data: %w", recordType, err)
+			return 0, err
 		}
 	}
 
-	err := zw.Close()
+	err = bw.Close()
 	if err != nil {
-		return fmt.Errorf("failed to close zip file: %w", err)
+		return 0, err
 	}
 
-	return nil
+	return bw.Hash(), nil
 }
 
-// ServeBundle serves a bundle of data.
-func ServeBundle(w http.ResponseWriter, r *http.Request, bundle map[string]any) error {
-	var buf bytes.Buffer
-	err := EncodeBundle(&buf, bundle)
-	if err != nil {
-		return fmt.Errorf("failed to encode bundle: %w", err)
-	}
-
+// ServeBundle serves a bundle of records written by write. See ServeEncoded.
+// Unlike ServeEncoded, the ETag is the hash of the bundle's records, so it
+// doesn't change when the same records are written again at a different time.
+func ServeBundle(
+	w http.ResponseWriter,
+	r *http.Request,
+	write func(bw *BundleWriter) error,
+	options ...BundleOption,
+) (size int64, err error) {
 	w.Header().Set("Content-Type", "application/zip")
-	return ServeData(w, r, "bundle.zip", buf.Bytes())
+	return serveEncoded(w, r, "bundle.zip", func(dst io.Writer) (uint64, error) {
+		bw := NewBundleWriter(dst, options...)
+		err := write(bw)
+		if err != nil {
+			return 0, err
+		}
+		err = bw.Close()
+		if err != nil {
+			return 0, err
+		}
+		return bw.Hash(), nil
+	})
 }
 
 // ServeContent serves content over http.
@@ -66,15 +77,60 @@ func ServeContent(
 	return nil
 }
 
-// ServeData serves data over http.
-func ServeData(
+// ServeEncoded serves content encoded by write over http. The content is
+// written to a temporary file instead of memory, and hashed as it's written
+// for the ETag. Nothing is sent until write returns, so if it fails the error
+// can still be reported to the client. The size of the content is returned.
+func ServeEncoded(
 	w http.ResponseWriter,
 	r *http.Request,
 	name string,
-	data []byte,
-) error {
+	write func(dst io.Writer) error,
+) (size int64, err error) {
+	return serveEncoded(w, r, name, func(dst io.Writer) (uint64, error) {
+		hasher := fnv.New64()
+		err := write(io.MultiWriter(dst, hasher))
+		return hasher.Sum64(), err
+	})
+}
+
+// serveEncoded serves content encoded by write, which returns the hash of the
+// content for the ETag.
+func serveEncoded(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+	write func(dst io.Writer) (uint64, error),
+) (size int64, err error) {
+	f, err := os.CreateTemp("", "pomerium-datasource-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	hash, err := write(f)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err = f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seek temporary file: %w", err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seek temporary file: %w", err)
+	}
+
+	return size, ServeContent(w, r, name, hash, f)
+}
+
+// HashData returns the hash of data used for ETags.
+func HashData(data []byte) uint64 {
 	hasher := fnv.New64()
 	_, _ = hasher.Write(data)
-	h := hasher.Sum64()
-	return ServeContent(w, r, name, h, bytes.NewReader(data))
+	return hasher.Sum64()
 }
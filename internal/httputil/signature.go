@@ -0,0 +1,114 @@
+package httputil
+
+import (
+	"archive/zip"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+const (
+	// BundleSignatureName is the name of the manifest signature file in a bundle.
+	BundleSignatureName = "manifest.sig"
+	// BundleSignatureAlgorithm is the algorithm used to sign bundle manifests.
+	BundleSignatureAlgorithm = "Ed25519"
+)
+
+// A BundleSignature is a signature of the manifest file of a bundle. Since the
+// manifest has the digests of all the record files, it covers the whole bundle.
+type BundleSignature struct {
+	Algorithm string `json:"algorithm"`
+	// KeyID identifies the public key that verifies the signature.
+	KeyID     string `json:"key_id"`
+	Signature []byte `json:"signature"`
+}
+
+// BundleKeyID returns the id of a public key used in bundle signatures, the hex
+// encoded SHA-256 digest of the key.
+func BundleKeyID(publicKey ed25519.PublicKey) string {
+	digest := sha256.Sum256(publicKey)
+	return hex.EncodeToString(digest[:])
+}
+
+// VerifyBundle checks the signature of the manifest of a bundle with a public
+// key, and then checks the bundle against the manifest. Unlike
+// ReadBundleManifest, bundles without a manifest are rejected.
+func VerifyBundle(zr *zip.Reader, publicKey ed25519.PublicKey) (*BundleManifest, error) {
+	err := checkBundleFileNames(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := readBundleFile(zr, BundleManifestName)
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := readBundleFile(zr, BundleSignatureName)
+	if err != nil {
+		return nil, err
+	}
+	var signature BundleSignature
+	err = json.Unmarshal(bs, &signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bundle signature: %w", err)
+	}
+
+	if signature.Algorithm != BundleSignatureAlgorithm {
+		return nil, fmt.Errorf("unsupported bundle signature algorithm: %s", signature.Algorithm)
+	}
+	if signature.KeyID != BundleKeyID(publicKey) {
+		return nil, fmt.Errorf("bundle was signed with a different key: %s", signature.KeyID)
+	}
+	if !ed25519.Verify(publicKey, manifest, signature.Signature) {
+		return nil, fmt.Errorf("invalid bundle signature")
+	}
+
+	return ReadBundleManifest(zr)
+}
+
+func signBundleManifest(signer crypto.Signer, manifest []byte) ([]byte, error) {
+	publicKey, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported bundle signing key type: %T", signer.Public())
+	}
+
+	// Ed25519 signs the message itself rather than a digest
+	signature, err := signer.Sign(rand.Reader, manifest, crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign bundle manifest: %w", err)
+	}
+
+	bs, err := json.Marshal(BundleSignature{
+		Algorithm: BundleSignatureAlgorithm,
+		KeyID:     BundleKeyID(publicKey),
+		Signature: signature,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle signature: %w", err)
+	}
+	return append(bs, '\n'), nil
+}
+
+func readBundleFile(zr *zip.Reader, name string) ([]byte, error) {
+	f, err := zr.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("bundle has no %s file", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open bundle file %s: %w", name, err)
+	}
+	defer f.Close()
+
+	bs, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle file %s: %w", name, err)
+	}
+	return bs, nil
+}
@@ -0,0 +1,103 @@
+package httputil
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyBundle(t *testing.T) {
+	t.Parallel()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	encode := func(options ...BundleOption) []byte {
+		var buf bytes.Buffer
+		_, err := EncodeBundle(t.Context(), &buf, map[string]any{
+			"example.com/Record": []string{"a", "b"},
+		}, options...)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+	verify := func(bs []byte, publicKey ed25519.PublicKey) error {
+		zr, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
+		require.NoError(t, err)
+		_, err = VerifyBundle(zr, publicKey)
+		return err
+	}
+	// rewrite replaces the content of a file in a bundle
+	rewrite := func(bs []byte, name string, content []byte) []byte {
+		zr, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, f := range zr.File {
+			if f.Name != name {
+				require.NoError(t, zw.Copy(f))
+				continue
+			}
+			fw, err := zw.Create(f.Name)
+			require.NoError(t, err)
+			_, err = fw.Write(content)
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+	// add appends a file to a bundle, even if there's already a file with the name
+	add := func(bs []byte, name string, content []byte) []byte {
+		zr, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, f := range zr.File {
+			require.NoError(t, zw.Copy(f))
+		}
+		fw, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = fw.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	signed := encode(WithBundleSigner(privateKey))
+	assert.NoError(t, verify(signed, publicKey))
+	assert.ErrorContains(t, verify(signed, otherPublicKey), "bundle was signed with a different key")
+	assert.ErrorContains(t, verify(encode(), publicKey), "bundle has no manifest.sig file")
+	assert.ErrorContains(t, verify(rewrite(signed, "example.com/Record.json", []byte(`["a","c"]`)), publicKey),
+		"bundle file example.com/Record.json doesn't match the manifest digest")
+	assert.ErrorContains(t, verify(add(signed, "example.com/Record.json", []byte(`["a","c"]`)), publicKey),
+		"duplicate bundle file example.com/Record.json")
+	assert.ErrorContains(t, verify(add(signed, "README.txt", []byte("hello")), publicKey),
+		"unexpected bundle file README.txt")
+
+	zr, err := zip.NewReader(bytes.NewReader(signed), int64(len(signed)))
+	require.NoError(t, err)
+	f, err := zr.Open(BundleManifestName)
+	require.NoError(t, err)
+	manifest, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.ErrorContains(t, verify(rewrite(signed, BundleManifestName, bytes.Replace(manifest, []byte(`"count":2`), []byte(`"count":3`), 1)), publicKey),
+		"invalid bundle signature")
+
+	t.Run("unsupported key", func(t *testing.T) {
+		t.Parallel()
+
+		ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		_, err = EncodeBundle(t.Context(), io.Discard, map[string]any{}, WithBundleSigner(ecdsaKey))
+		assert.ErrorContains(t, err, "unsupported bundle signing key type")
+	})
+}
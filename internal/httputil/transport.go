@@ -0,0 +1,131 @@
+package httputil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version like "1.2". An empty string returns 0,
+// which leaves the default minimum version in place.
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("httputil: unsupported tls version %q, expected one of 1.0, 1.1, 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+type transportConfig struct {
+	caFile         string
+	clientCertFile string
+	clientKeyFile  string
+	proxyURL       *url.URL
+	tlsMinVersion  uint16
+	timeout        time.Duration
+}
+
+// A TransportOption customizes the transport config.
+type TransportOption func(cfg *transportConfig)
+
+// WithCAFile sets a file of PEM encoded certificate authorities that are
+// trusted in addition to the system ones.
+func WithCAFile(caFile string) TransportOption {
+	return func(cfg *transportConfig) {
+		cfg.caFile = caFile
+	}
+}
+
+// WithClientCertificate sets the PEM encoded certificate and key files used
+// for mutual TLS.
+func WithClientCertificate(certFile, keyFile string) TransportOption {
+	return func(cfg *transportConfig) {
+		cfg.clientCertFile = certFile
+		cfg.clientKeyFile = keyFile
+	}
+}
+
+// WithProxyURL sets the proxy for all requests. By default the proxy is taken
+// from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func WithProxyURL(proxyURL *url.URL) TransportOption {
+	return func(cfg *transportConfig) {
+		cfg.proxyURL = proxyURL
+	}
+}
+
+// WithTLSMinVersion sets the minimum TLS version.
+func WithTLSMinVersion(tlsMinVersion uint16) TransportOption {
+	return func(cfg *transportConfig) {
+		cfg.tlsMinVersion = tlsMinVersion
+	}
+}
+
+// WithTimeout sets the time limit for each request, including reading the
+// response body. A value of 0 means no limit.
+func WithTimeout(timeout time.Duration) TransportOption {
+	return func(cfg *transportConfig) {
+		cfg.timeout = timeout
+	}
+}
+
+func getTransportConfig(options ...TransportOption) *transportConfig {
+	cfg := new(transportConfig)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
+
+// NewClient creates a new http.Client for requests to upstream APIs, using a
+// copy of the default transport customized by the options.
+func NewClient(options ...TransportOption) (*http.Client, error) {
+	cfg := getTransportConfig(options...)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.proxyURL != nil {
+		transport.Proxy = http.ProxyURL(cfg.proxyURL)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: cfg.tlsMinVersion}
+	if cfg.caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(cfg.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("httputil: error reading ca file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("httputil: no certificates found in ca file %s", cfg.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.clientCertFile != "" || cfg.clientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.clientCertFile, cfg.clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("httputil: error loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.timeout,
+	}, nil
+}
@@ -0,0 +1,162 @@
+package httputil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	t.Parallel()
+
+	v, err := ParseTLSVersion("1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+
+	v, err = ParseTLSVersion("")
+	assert.NoError(t, err)
+	assert.Zero(t, v)
+
+	_, err = ParseTLSVersion("1.4")
+	assert.Error(t, err)
+}
+
+func TestNewClient(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ca file", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		t.Cleanup(srv.Close)
+
+		client, err := NewClient()
+		require.NoError(t, err)
+		_, err = client.Get(srv.URL)
+		assert.Error(t, err, "should not trust the test server by default")
+
+		caFile := writePEM(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+		client, err = NewClient(WithCAFile(caFile))
+		require.NoError(t, err)
+		res, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+		_, err = NewClient(WithCAFile(filepath.Join(t.TempDir(), "missing.pem")))
+		assert.Error(t, err)
+	})
+	t.Run("client certificate", func(t *testing.T) {
+		t.Parallel()
+
+		certFile, keyFile, cert := newClientCertificate(t)
+		pool := x509.NewCertPool()
+		pool.AddCert(cert)
+
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}))
+		srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+
+		caFile := writePEM(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+		client, err := NewClient(WithCAFile(caFile), WithClientCertificate(certFile, keyFile))
+		require.NoError(t, err)
+		res, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	})
+	t.Run("proxy", func(t *testing.T) {
+		t.Parallel()
+
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Proxied-Host", r.URL.Host)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		t.Cleanup(proxy.Close)
+
+		proxyURL, err := url.Parse(proxy.URL)
+		require.NoError(t, err)
+		client, err := NewClient(WithProxyURL(proxyURL))
+		require.NoError(t, err)
+		res, err := client.Get("http://upstream.example.com/path")
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, "upstream.example.com", res.Header.Get("X-Proxied-Host"))
+	})
+	t.Run("tls min version", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+		srv.StartTLS()
+		t.Cleanup(srv.Close)
+
+		caFile := writePEM(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+		client, err := NewClient(WithCAFile(caFile), WithTLSMinVersion(tls.VersionTLS13))
+		require.NoError(t, err)
+		_, err = client.Get(srv.URL)
+		assert.Error(t, err)
+	})
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		client, err := NewClient(WithTimeout(time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, time.Second, client.Timeout)
+	})
+}
+
+func newClientCertificate(t *testing.T) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return writePEM(t, "client.pem", "CERTIFICATE", der), writePEM(t, "client-key.pem", "PRIVATE KEY", keyDER), cert
+}
+
+func writePEM(t *testing.T, name, blockType string, data []byte) string {
+	t.Helper()
+
+	fp := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(fp, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600))
+	return fp
+}
@@ -1,13 +1,17 @@
 package ip2location
 
 import (
-	"bytes"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/pomerium/datasource/internal/httputil"
 	"github.com/pomerium/datasource/internal/jsonutil"
+	"github.com/pomerium/datasource/internal/metrics"
 )
 
+const metricsSource = "ip2location"
+
 type serverConfig struct {
 	file string
 }
@@ -45,18 +49,21 @@ func NewServer(options ...ServerOption) *Server {
 
 // ServeHTTP implements the http.Handler interface.
 func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	err := srv.serveHTTP(w, r)
+	metrics.RecordSync(metricsSource, start, err)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 func (srv *Server) serveHTTP(w http.ResponseWriter, r *http.Request) error {
-	var buf bytes.Buffer
-	dst := jsonutil.NewJSONArrayStream(&buf)
-	err := fileToJSON(dst, srv.cfg.file)
+	size, err := httputil.ServeEncoded(w, r, "ip2location.json", func(dst io.Writer) error {
+		return fileToJSON(jsonutil.NewJSONArrayStream(dst), srv.cfg.file)
+	})
 	if err != nil {
 		return err
 	}
-	return httputil.ServeData(w, r, "ip2location.json", buf.Bytes())
+	metrics.SetBundleSize(metricsSource, int(size))
+	return nil
 }
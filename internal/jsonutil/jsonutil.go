@@ -20,7 +20,8 @@ func NewJSONArrayStream(dst io.Writer) *JSONArrayStream {
 	}
 }
 
-// Close writes the ']' and flushes the stream.
+// Close writes the ']' and flushes the stream. An empty stream is written as
+// an empty array.
 func (stream *JSONArrayStream) Close() error {
 	if stream.closed {
 		return nil
@@ -32,6 +33,11 @@ func (stream *JSONArrayStream) Close() error {
 		if err != nil {
 			return err
 		}
+	} else {
+		_, err := stream.buf.Write([]byte{'[', ']'})
+		if err != nil {
+			return err
+		}
 	}
 	return stream.buf.Flush()
 }
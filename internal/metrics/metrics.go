@@ -0,0 +1,207 @@
+// Package metrics contains the prometheus metrics for the datasource servers.
+//
+// The metrics are registered with a registry of their own rather than the
+// global prometheus registry, so importing the package has no effect on other
+// metrics in the same process.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "datasource"
+
+var (
+	syncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of syncs with the upstream data source.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"source", "result"})
+	syncLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sync_last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful sync with the upstream data source.",
+	}, []string{"source"})
+	records = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "records",
+		Help:      "Number of records returned by the last successful sync, by record type.",
+	}, []string{"source", "type"})
+	bundleSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bundle_size_bytes",
+		Help:      "Size of the last bundle built from the upstream data source.",
+	}, []string{"source"})
+
+	upstreamRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_requests_total",
+		Help:      "Number of requests made to upstream APIs.",
+	}, []string{"host", "status"})
+	upstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Duration of requests made to upstream APIs.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"host"})
+	upstreamRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_rate_limited_total",
+		Help:      "Number of upstream API responses with a 429 status code.",
+	}, []string{"host"})
+	upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Number of retried upstream API requests.",
+	}, []string{"host"})
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests served.",
+	}, []string{"handler", "method", "code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests served.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "method", "code"})
+	httpResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_response_size_bytes",
+		Help:      "Size of HTTP responses served.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"handler", "method", "code"})
+)
+
+var registry = newRegistry()
+
+func newRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		syncDuration,
+		syncLastSuccess,
+		records,
+		bundleSize,
+		upstreamRequests,
+		upstreamRequestDuration,
+		upstreamRateLimited,
+		upstreamRetries,
+		httpRequests,
+		httpRequestDuration,
+		httpResponseSize,
+	)
+	return registry
+}
+
+// Handler returns an http.Handler that serves the metrics.
+func Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
+
+// A Recorder records the sync metrics of the datasource servers. It can be
+// passed to the WithMetricsRecorder options of the directory and blob packages.
+type Recorder struct{}
+
+// RecordSync calls RecordSync.
+func (Recorder) RecordSync(source string, start time.Time, err error) {
+	RecordSync(source, start, err)
+}
+
+// SetRecordCount calls SetRecordCount.
+func (Recorder) SetRecordCount(source, recordType string, count int) {
+	SetRecordCount(source, recordType, count)
+}
+
+// SetBundleSize calls SetBundleSize.
+func (Recorder) SetBundleSize(source string, size int) {
+	SetBundleSize(source, size)
+}
+
+// RecordSync records a sync with an upstream data source that started at start.
+func RecordSync(source string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	syncDuration.WithLabelValues(source, result).Observe(time.Since(start).Seconds())
+	if err == nil {
+		syncLastSuccess.WithLabelValues(source).SetToCurrentTime()
+	}
+}
+
+// SetRecordCount sets the number of records of a type returned by the last sync.
+func SetRecordCount(source, recordType string, count int) {
+	records.WithLabelValues(source, recordType).Set(float64(count))
+}
+
+// SetBundleSize sets the size of the last bundle built for a source.
+func SetBundleSize(source string, size int) {
+	bundleSize.WithLabelValues(source).Set(float64(size))
+}
+
+// RecordRetry records a retried request to an upstream API.
+func RecordRetry(host string) {
+	upstreamRetries.WithLabelValues(host).Inc()
+}
+
+// InstrumentHandler wraps an http.Handler to record request counts, durations
+// and response sizes, labelled with the handler name.
+func InstrumentHandler(name string, handler http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(httpRequestDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerResponseSize(httpResponseSize.MustCurryWith(labels), handler)))
+}
+
+// NewClient creates a new http.Client that records metrics for requests to
+// upstream APIs.
+func NewClient(base *http.Client) *http.Client {
+	if base == nil {
+		base = http.DefaultClient
+	}
+	newClient := new(http.Client)
+	*newClient = *base
+	newClient.Transport = NewRoundTripper(newClient.Transport)
+	return newClient
+}
+
+type roundTripper struct {
+	base http.RoundTripper
+}
+
+// NewRoundTripper creates a new http.RoundTripper that records metrics for
+// requests to upstream APIs, labelled by host and status code.
+func NewRoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripper{base: base}
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := rt.base.RoundTrip(req)
+
+	host := req.URL.Host
+	upstreamRequestDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+	status := "error"
+	if res != nil {
+		status = strconv.Itoa(res.StatusCode)
+		if res.StatusCode == http.StatusTooManyRequests {
+			upstreamRateLimited.WithLabelValues(host).Inc()
+		}
+	}
+	upstreamRequests.WithLabelValues(host, status).Inc()
+
+	return res, err
+}
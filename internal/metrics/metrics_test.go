@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	res, err := NewClient(nil).Do(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(upstreamRequests.WithLabelValues(u.Host, "429")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(upstreamRateLimited.WithLabelValues(u.Host)))
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	t.Parallel()
+
+	h := InstrumentHandler("TestInstrumentHandler", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "OK")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("TestInstrumentHandler", "get", "200")))
+}
+
+func TestRecordSync(t *testing.T) {
+	t.Parallel()
+
+	RecordSync("TestRecordSync", time.Now(), errors.New("ERROR"))
+	assert.Equal(t, 0.0, testutil.ToFloat64(syncLastSuccess.WithLabelValues("TestRecordSync")))
+	RecordSync("TestRecordSync", time.Now(), nil)
+	assert.Greater(t, testutil.ToFloat64(syncLastSuccess.WithLabelValues("TestRecordSync")), 0.0)
+
+	SetRecordCount("TestRecordSync", "user", 3)
+	assert.Equal(t, 3.0, testutil.ToFloat64(records.WithLabelValues("TestRecordSync", "user")))
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.True(t, strings.Contains(rec.Body.String(), `datasource_records{source="TestRecordSync",type="user"} 3`))
+}
@@ -0,0 +1,59 @@
+// Package secretfile reads secrets, like api keys and client secrets, from
+// files that may be rotated while the process is running.
+package secretfile
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A File is a secret stored in a file. The file is re-read whenever its
+// modification time or size changes, so secrets that are updated in place,
+// like Kubernetes secret volumes, take effect without a restart.
+type File struct {
+	name string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// New creates a new File for the file with the given name. The file isn't
+// read until Read is called.
+func New(name string) *File {
+	return &File{name: name}
+}
+
+// Name returns the name of the file.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Read returns the current secret, with any surrounding whitespace removed.
+func (f *File) Read() (string, error) {
+	fi, err := os.Stat(f.name)
+	if err != nil {
+		return "", fmt.Errorf("secretfile: error reading %s: %w", f.name, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return f.value, nil
+	}
+
+	bs, err := os.ReadFile(f.name)
+	if err != nil {
+		return "", fmt.Errorf("secretfile: error reading %s: %w", f.name, err)
+	}
+
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	f.value = strings.TrimSpace(string(bs))
+	return f.value, nil
+}
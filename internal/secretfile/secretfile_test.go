@@ -0,0 +1,43 @@
+package secretfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	name := filepath.Join(t.TempDir(), "secret")
+	f := New(name)
+
+	_, err := f.Read()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(name, []byte("SECRET1\n"), 0o600))
+	value, err := f.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET1", value)
+
+	// rotate the secret, making sure the modification time changes
+	require.NoError(t, os.WriteFile(name, []byte("SECRET2\n"), 0o600))
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(name, modTime, modTime))
+	value, err = f.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET2", value)
+
+	// symlinks are followed, like the ones used for Kubernetes secret volumes
+	target := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(target, []byte("SECRET3"), 0o600))
+	require.NoError(t, os.Remove(name))
+	require.NoError(t, os.Symlink(target, name))
+	value, err = f.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET3", value)
+}
@@ -0,0 +1,162 @@
+// Package tracing contains the OpenTelemetry tracing for the datasource servers.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pomerium/datasource/internal/version"
+)
+
+const (
+	tracerName  = "github.com/pomerium/datasource"
+	serviceName = "pomerium-datasource"
+)
+
+// OTLP protocols
+const (
+	ProtocolGRPC         = "grpc"
+	ProtocolHTTPProtobuf = "http/protobuf"
+)
+
+type config struct {
+	endpoint string
+	protocol string
+}
+
+// An Option customizes the tracing config.
+type Option func(cfg *config)
+
+// WithEndpoint sets the url spans are exported to, like
+// http://localhost:4318/v1/traces. By default the endpoint is taken from the
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT and OTEL_EXPORTER_OTLP_ENDPOINT
+// environment variables.
+func WithEndpoint(endpoint string) Option {
+	return func(cfg *config) {
+		cfg.endpoint = endpoint
+	}
+}
+
+// WithProtocol sets the OTLP protocol, either grpc or http/protobuf. By
+// default the protocol is taken from the OTEL_EXPORTER_OTLP_TRACES_PROTOCOL and
+// OTEL_EXPORTER_OTLP_PROTOCOL environment variables, falling back to
+// http/protobuf.
+func WithProtocol(protocol string) Option {
+	return func(cfg *config) {
+		cfg.protocol = protocol
+	}
+}
+
+func getConfig(options ...Option) *config {
+	cfg := new(config)
+	WithProtocol(firstEnv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"))(cfg)
+	for _, option := range options {
+		option(cfg)
+	}
+	if cfg.protocol == "" {
+		cfg.protocol = ProtocolHTTPProtobuf
+	}
+	return cfg
+}
+
+// Setup configures the global tracer provider to export spans with OTLP. If no
+// endpoint is configured, spans aren't exported.
+//
+// The returned shutdown function flushes any pending spans.
+func Setup(ctx context.Context, options ...Option) (shutdown func(context.Context) error, err error) {
+	cfg := getConfig(options...)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if cfg.endpoint == "" && firstEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// the exporters read the endpoint, headers and tls settings from the environment
+	var exporter *otlptrace.Exporter
+	switch cfg.protocol {
+	case ProtocolGRPC:
+		var opts []otlptracegrpc.Option
+		if cfg.endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.endpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	case ProtocolHTTPProtobuf:
+		var opts []otlptracehttp.Option
+		if cfg.endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("tracing: unsupported otlp protocol %q, expected %s or %s",
+			cfg.protocol, ProtocolGRPC, ProtocolHTTPProtobuf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: error creating otlp exporter: %w", err)
+	}
+
+	// attributes from the environment, like OTEL_SERVICE_NAME, take precedence
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName), semconv.ServiceVersion(version.FullVersion())),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: error creating resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Start starts a span.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// NewRoundTripper creates a new http.RoundTripper that records a span for
+// each request and propagates the trace context.
+func NewRoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Host
+		}))
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// the tests use the global tracer provider, so they don't run in parallel
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(sdktrace.NewTracerProvider()) })
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	shutdown, err := Setup(t.Context())
+	require.NoError(t, err)
+	require.NoError(t, shutdown(t.Context()))
+
+	t.Run("end", func(t *testing.T) {
+		_, span := Start(t.Context(), "ok")
+		End(span, nil)
+		_, span = Start(t.Context(), "error")
+		End(span, errors.New("ERROR"))
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 2)
+		assert.Equal(t, "ok", spans[0].Name())
+		assert.Equal(t, codes.Unset, spans[0].Status().Code)
+		assert.Equal(t, "error", spans[1].Name())
+		assert.Equal(t, codes.Error, spans[1].Status().Code)
+		assert.Equal(t, "ERROR", spans[1].Status().Description)
+	})
+	t.Run("round tripper", func(t *testing.T) {
+		var traceParent string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceParent = r.Header.Get("Traceparent")
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		t.Cleanup(srv.Close)
+
+		ctx, span := Start(t.Context(), "parent")
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		res, err := (&http.Client{Transport: NewRoundTripper(nil)}).Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		End(span, nil)
+
+		u, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		spans := recorder.Ended()
+		requestSpan := spans[len(spans)-2]
+		assert.Equal(t, "GET "+u.Host, requestSpan.Name())
+		assert.Equal(t, span.SpanContext().SpanID(), requestSpan.Parent().SpanID())
+		assert.Contains(t, traceParent, requestSpan.SpanContext().TraceID().String())
+	})
+	t.Run("invalid protocol", func(t *testing.T) {
+		_, err := Setup(t.Context(), WithEndpoint("http://localhost:4318/v1/traces"), WithProtocol("udp"))
+		assert.Error(t, err)
+	})
+}
@@ -1,13 +1,14 @@
 package wellknownips
 
 import (
-	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/gregjones/httpcache"
 	"github.com/gregjones/httpcache/diskcache"
@@ -16,17 +17,28 @@ import (
 
 	"github.com/pomerium/datasource/internal/httputil"
 	"github.com/pomerium/datasource/internal/jsonutil"
+	"github.com/pomerium/datasource/internal/metrics"
 )
 
+const metricsSource = "wellknownips"
+
 var DefaultIP2ASNURL = "https://iptoasn.com/data/ip2asn-v4.tsv.gz"
 
 type serverConfig struct {
-	ip2asnURL string
+	httpClient *http.Client
+	ip2asnURL  string
 }
 
 // A ServerOption customizes the server config.
 type ServerOption func(*serverConfig)
 
+// WithHTTPClient sets the http client used to fetch the upstream ip ranges.
+func WithHTTPClient(httpClient *http.Client) ServerOption {
+	return func(cfg *serverConfig) {
+		cfg.httpClient = httpClient
+	}
+}
+
 // WithIP2ASNURL sets the ip2asn url in the config.
 func WithIP2ASNURL(url string) ServerOption {
 	return func(cfg *serverConfig) {
@@ -36,6 +48,7 @@ func WithIP2ASNURL(url string) ServerOption {
 
 func getServerConfig(options ...ServerOption) *serverConfig {
 	cfg := new(serverConfig)
+	WithHTTPClient(http.DefaultClient)(cfg)
 	WithIP2ASNURL(DefaultIP2ASNURL)(cfg)
 	for _, option := range options {
 		option(cfg)
@@ -62,7 +75,9 @@ func NewServer(options ...ServerOption) *Server {
 
 // ServeHTTP implements the http.Handler interface.
 func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	err := srv.serveHTTP(w, r)
+	metrics.RecordSync(metricsSource, start, err)
 	if err != nil {
 		log.Error().Err(err).Send()
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -81,13 +96,15 @@ func (srv *Server) serveHTTP(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	transport := httpcache.NewTransport(cache)
+	transport.Transport = httputil.NewInstrumentedRoundTripper(srv.cfg.httpClient.Transport)
+	client := &http.Client{Transport: transport, Timeout: srv.cfg.httpClient.Timeout}
 
 	eg, ctx := errgroup.WithContext(r.Context())
 	recordLookup := map[string][]Record{}
 
 	recordLookup[AmazonASNumber] = nil
 	eg.Go(func() error {
-		amazonAWSIPRanges, err := FetchAmazonAWSIPRanges(ctx, transport.Client(), DefaultAmazonAWSIPRangesURL)
+		amazonAWSIPRanges, err := FetchAmazonAWSIPRanges(ctx, client, DefaultAmazonAWSIPRangesURL)
 		if err != nil {
 			return fmt.Errorf("error fetching amazon aws ip ranges: %w", err)
 		}
@@ -103,7 +120,7 @@ func (srv *Server) serveHTTP(w http.ResponseWriter, r *http.Request) error {
 
 	recordLookup[AtlassianASNumber] = nil
 	eg.Go(func() error {
-		atlassianRanges, err := FetchAtlassianIPRanges(ctx, transport.Client(), DefaultAtlassianIPRangesURL)
+		atlassianRanges, err := FetchAtlassianIPRanges(ctx, client, DefaultAtlassianIPRangesURL)
 		if err != nil {
 			return fmt.Errorf("error fetching atlassian ip ranges: %w", err)
 		}
@@ -123,7 +140,7 @@ func (srv *Server) serveHTTP(w http.ResponseWriter, r *http.Request) error {
 
 	recordLookup[GitHubASNumber] = nil
 	eg.Go(func() error {
-		githubMeta, err := FetchGitHubMeta(ctx, transport.Client(), DefaultGitHubMetaURL)
+		githubMeta, err := FetchGitHubMeta(ctx, client, DefaultGitHubMetaURL)
 		if err != nil {
 			return fmt.Errorf("error fetching github ip ranges: %w", err)
 		}
@@ -133,7 +150,7 @@ func (srv *Server) serveHTTP(w http.ResponseWriter, r *http.Request) error {
 
 	recordLookup[StripeASNumber] = nil
 	eg.Go(func() error {
-		stripeRanges, err := FetchStripeIPRanges(ctx, transport.Client(), DefaultStripeIPRangesURL)
+		stripeRanges, err := FetchStripeIPRanges(ctx, client, DefaultStripeIPRangesURL)
 		if err != nil {
 			return fmt.Errorf("error fetching stripe ip ranges: %w", err)
 		}
@@ -146,54 +163,61 @@ func (srv *Server) serveHTTP(w http.ResponseWriter, r *http.Request) error {
 		return fmt.Errorf("error fetching well known ip ranges: %w", err)
 	}
 
-	stream, err := FetchIP2ASNDatabase(r.Context(), transport.Client(), srv.cfg.ip2asnURL)
+	stream, err := FetchIP2ASNDatabase(r.Context(), client, srv.cfg.ip2asnURL)
 	if err != nil {
 		return fmt.Errorf("error fetching ip2asn database: %w", err)
 	}
 
-	var buf bytes.Buffer
-	dst := jsonutil.NewJSONArrayStream(&buf)
-	for stream.Next(r.Context()) {
-		_, ok := recordLookup[stream.Record().ASNumber]
-		if ok {
-			// skip well-defined ip ranges
-			continue
-		}
+	var count int
+	size, err := httputil.ServeEncoded(w, r, "well-known-ips.json", func(w io.Writer) error {
+		dst := jsonutil.NewJSONArrayStream(w)
+		for stream.Next(r.Context()) {
+			_, ok := recordLookup[stream.Record().ASNumber]
+			if ok {
+				// skip well-defined ip ranges
+				continue
+			}
 
-		for _, record := range RecordsFromIP2ASNRecord(stream.Record()) {
-			err := dst.Encode(record)
-			if err != nil {
-				return fmt.Errorf("failed to write record to destination: %w", err)
+			for _, record := range RecordsFromIP2ASNRecord(stream.Record()) {
+				err := dst.Encode(record)
+				if err != nil {
+					return fmt.Errorf("failed to write record to destination: %w", err)
+				}
+				count++
 			}
 		}
-	}
-
-	var keys []string
-	for key := range recordLookup {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
 
-	for _, key := range keys {
-		for _, record := range recordLookup[key] {
-			err := dst.Encode(record)
-			if err != nil {
-				return fmt.Errorf("failed to write record to destination: %w", err)
+		var keys []string
+		for key := range recordLookup {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			for _, record := range recordLookup[key] {
+				err := dst.Encode(record)
+				if err != nil {
+					return fmt.Errorf("failed to write record to destination: %w", err)
+				}
+				count++
 			}
 		}
-	}
 
-	err = stream.Err()
-	if err != nil {
-		return err
-	}
+		err := stream.Err()
+		if err != nil {
+			return err
+		}
 
-	err = dst.Close()
+		return dst.Close()
+	})
 	if err != nil {
 		return err
 	}
 
-	return httputil.ServeData(w, r, "well-known-ips.json", buf.Bytes())
+	metrics.SetRecordCount(metricsSource, "record", count)
+	metrics.SetBundleSize(metricsSource, int(size))
+
+	return nil
 }
 
 func (srv *Server) getCache() (httpcache.Cache, error) {
@@ -10,6 +10,15 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog"
+
+	"github.com/pomerium/datasource/internal/metrics"
+)
+
+const (
+	// source is the name of the connector in metrics
+	source = "zenefits"
+
+	employeeRecordType = "zenefits.com/Employee"
 )
 
 // NewServer implements new Zenefits limited data exporter
@@ -51,12 +60,16 @@ func WithRemoveOnVacation(location *time.Location) Option {
 }
 
 func (srv *apiServer) serveEmployees(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	data, err := srv.getEmployeesJSON(r.Context())
+	metrics.RecordSync(source, start, err)
 	if err != nil {
 		srv.serveError(w, err, "get employees")
 		return
 	}
 
+	metrics.SetRecordCount(source, employeeRecordType, len(data))
+
 	srv.serveJSON(w, data)
 }
 
@@ -108,8 +121,14 @@ func (srv *apiServer) serveError(w http.ResponseWriter, err error, msg string) {
 }
 
 func (srv *apiServer) serveJSON(w http.ResponseWriter, src interface{}) {
-	w.Header().Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(src); err != nil {
+	data, err := json.Marshal(src)
+	if err != nil {
 		srv.log.Err(err).Msg("json marshal")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	metrics.SetBundleSize(source, len(data))
+
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = w.Write(append(data, '\n'))
 }
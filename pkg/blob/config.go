@@ -0,0 +1,144 @@
+package blob
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"time"
+)
+
+const (
+	// DefaultHistoryRetention is the default number of bundle versions kept in the history.
+	DefaultHistoryRetention = 10
+	// DefaultCheckInterval is the default interval between checks for changes to a bundle.
+	DefaultCheckInterval = 5 * time.Second
+)
+
+type uploadConfig struct {
+	historyRetention int
+	signer           crypto.Signer
+	source           string
+}
+
+// An UploadOption customizes the upload config.
+type UploadOption func(cfg *uploadConfig)
+
+// WithHistoryRetention sets the number of bundle versions kept in the history.
+// Older versions are deleted after each upload. A value of 0 disables the history.
+func WithHistoryRetention(historyRetention int) UploadOption {
+	return func(cfg *uploadConfig) {
+		cfg.historyRetention = historyRetention
+	}
+}
+
+// WithSigner sets the signer used to sign the bundle manifest, so the bundle can
+// be verified before it's served. See httputil.WithBundleSigner.
+func WithSigner(signer crypto.Signer) UploadOption {
+	return func(cfg *uploadConfig) {
+		cfg.signer = signer
+	}
+}
+
+// WithSource sets the name of the connector that produced the bundle, which
+// is recorded in the bundle manifest.
+func WithSource(source string) UploadOption {
+	return func(cfg *uploadConfig) {
+		cfg.source = source
+	}
+}
+
+func getUploadConfig(options ...UploadOption) *uploadConfig {
+	cfg := new(uploadConfig)
+	WithHistoryRetention(DefaultHistoryRetention)(cfg)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
+
+type handlerConfig struct {
+	checkInterval time.Duration
+	metrics       MetricsRecorder
+	verifyKey     ed25519.PublicKey
+	version       string
+}
+
+// A HandlerOption customizes the handler config.
+type HandlerOption func(cfg *handlerConfig)
+
+// WithCheckInterval sets how long a cached bundle is served before the bucket
+// is checked for changes to it again. A value of 0 checks on every request.
+func WithCheckInterval(checkInterval time.Duration) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.checkInterval = checkInterval
+	}
+}
+
+// WithMetricsRecorder sets the recorder for the metrics of the bundles read by
+// the handler. By default no metrics are recorded.
+func WithMetricsRecorder(recorder MetricsRecorder) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.metrics = recorder
+	}
+}
+
+// WithVerifyKey sets the public key used to verify bundles before they're
+// served. Bundles that aren't signed with the matching private key, or whose
+// records don't match the signed manifest, are refused.
+func WithVerifyKey(verifyKey ed25519.PublicKey) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.verifyKey = verifyKey
+	}
+}
+
+// WithVersion pins the bundle served at the root of the handler to a version
+// from the bundle history instead of the latest bundle. Named bundles aren't
+// affected.
+func WithVersion(version string) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.version = version
+	}
+}
+
+func getHandlerConfig(options ...HandlerOption) *handlerConfig {
+	cfg := new(handlerConfig)
+	WithCheckInterval(DefaultCheckInterval)(cfg)
+	WithMetricsRecorder(nopMetricsRecorder{})(cfg)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
+
+type stateConfig struct {
+	keys             KeyProvider
+	allowUnencrypted bool
+}
+
+// A StateOption customizes the state config.
+type StateOption func(cfg *stateConfig)
+
+// WithAllowUnencryptedState allows reading unencrypted state when there are
+// state keys, to migrate state written before encryption was enabled. It's
+// encrypted when it's uploaded again, after which the option is no longer needed.
+func WithAllowUnencryptedState(allow bool) StateOption {
+	return func(cfg *stateConfig) {
+		cfg.allowUnencrypted = allow
+	}
+}
+
+// WithStateKeys sets the keys used to encrypt state at rest. State is encrypted
+// with the first key and can be decrypted with any of them. Unencrypted state
+// is rejected, unless it's allowed with WithAllowUnencryptedState.
+func WithStateKeys(keys KeyProvider) StateOption {
+	return func(cfg *stateConfig) {
+		cfg.keys = keys
+	}
+}
+
+func getStateConfig(options ...StateOption) *stateConfig {
+	cfg := new(stateConfig)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
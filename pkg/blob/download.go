@@ -7,11 +7,54 @@ import (
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/pomerium/datasource/internal/tracing"
 )
 
-// DownloadState downloads state data from blob storage.
-func DownloadState(ctx context.Context, urlstr string, callback func(src io.Reader) error) error {
+// DownloadBundle downloads the published bundle from blob storage.
+func DownloadBundle(ctx context.Context, urlstr string, callback func(src io.Reader) error) error {
+	log.Ctx(ctx).Debug().Msg("downloading bundle")
+	return download(ctx, urlstr, bundleKey, func(r io.Reader) error {
+		err := callback(r)
+		if err != nil {
+			return fmt.Errorf("error reading bundle: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// DownloadState downloads state data from blob storage. Encrypted state is
+// decrypted with the state keys.
+func DownloadState(ctx context.Context, urlstr string, callback func(src io.Reader) error, options ...StateOption) error {
+	cfg := getStateConfig(options...)
+
 	log.Ctx(ctx).Debug().Msg("downloading state")
+	return download(ctx, urlstr, "state.zst", func(r io.Reader) error {
+		r, err := decryptState(ctx, r, cfg.keys, cfg.allowUnencrypted)
+		if err != nil {
+			return fmt.Errorf("error decrypting state: %w", err)
+		}
+
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("error creating zstd reader: %w", err)
+		}
+
+		err = callback(zr)
+		zr.Close()
+		if err != nil {
+			return fmt.Errorf("error reading state: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func download(ctx context.Context, urlstr, fileName string, callback func(r io.Reader) error) (err error) {
+	ctx, span := tracing.Start(ctx, "blob.Read", attribute.String("key", fileName))
+	defer func() { tracing.End(span, err) }()
 
 	bucket, err := openBucket(ctx, urlstr)
 	if err != nil {
@@ -19,20 +62,15 @@ func DownloadState(ctx context.Context, urlstr string, callback func(src io.Read
 	}
 	defer bucket.Close()
 
-	file, err := bucket.NewReader(ctx, "state.zst", nil)
+	file, err := bucket.NewReader(ctx, fileName, nil)
 	if err != nil {
 		return fmt.Errorf("error opening bucket file: %w", err)
 	}
 
-	zr, err := zstd.NewReader(file)
-	if err != nil {
-		return fmt.Errorf("error creating zstd reader: %w", err)
-	}
-
-	err = callback(zr)
-	zr.Close()
+	err = callback(file)
 	if err != nil {
-		return fmt.Errorf("error reading state: %w", err)
+		_ = file.Close()
+		return err
 	}
 
 	err = file.Close()
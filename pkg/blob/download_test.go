@@ -28,3 +28,20 @@ func TestDownloadState(t *testing.T) {
 		return nil
 	}))
 }
+
+func TestDownloadBundle(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	urlstr := "file://" + dir
+
+	assert.Error(t, blob.DownloadBundle(t.Context(), urlstr, func(_ io.Reader) error {
+		return nil
+	}), "should return an error when no bundle was uploaded")
+
+	assert.NoError(t, blob.UploadBundle(t.Context(), urlstr, map[string]any{"a": "x"}))
+	assert.NoError(t, blob.DownloadBundle(t.Context(), urlstr, func(src io.Reader) error {
+		assert.Equal(t, map[string]any{"a": "x"}, decodeBundle(t, src))
+		return nil
+	}))
+}
@@ -0,0 +1,336 @@
+package blob
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/pomerium/datasource/internal/secretfile"
+)
+
+// Encrypted state starts with a magic number and a JSON header with the data
+// key, wrapped with one of the state keys. The data is split into segments
+// that are each sealed with AES-GCM, so it can be streamed without buffering
+// the whole state in memory. Each segment is framed as a flag byte, marking the
+// final segment, and the length of the sealed segment. The flag is
+// authenticated, so truncated state is detected.
+//
+// Zstd frames start with 28 b5 2f fd, so unencrypted state is never mistaken
+// for encrypted state.
+var stateMagic = []byte("PDSENC\x00\x01")
+
+// ErrUnencryptedState indicates that state keys are configured, but the state
+// isn't encrypted.
+var ErrUnencryptedState = errors.New("blob: state isn't encrypted, but state keys are configured")
+
+const (
+	// StateKeySize is the size of the keys used to encrypt state, for AES-256.
+	StateKeySize = 32
+
+	stateSegmentSize   = 64 * 1024
+	stateMaxHeaderSize = 4 * 1024
+	stateFinalFlag     = 1
+)
+
+type stateHeader struct {
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// A KeyProvider provides the keys used to encrypt directory state at rest.
+type KeyProvider interface {
+	// StateKeys returns the state keys. The first key is used to encrypt new
+	// state and all of the keys are tried when decrypting state, so a
+	// previous key can be kept around after a rotation until the state has
+	// been re-encrypted.
+	StateKeys(ctx context.Context) ([][]byte, error)
+}
+
+// KeyProviderFunc is a function that implements the KeyProvider interface.
+type KeyProviderFunc func(ctx context.Context) ([][]byte, error)
+
+// StateKeys returns the state keys.
+func (f KeyProviderFunc) StateKeys(ctx context.Context) ([][]byte, error) {
+	return f(ctx)
+}
+
+// StaticKeys returns a KeyProvider with fixed state keys.
+func StaticKeys(keys ...[]byte) KeyProvider {
+	return KeyProviderFunc(func(_ context.Context) ([][]byte, error) {
+		return keys, nil
+	})
+}
+
+// KeyFiles returns a KeyProvider that reads base64 encoded state keys from
+// files, like the ones created by `openssl rand -base64 32`. The files are
+// re-read when they change.
+func KeyFiles(names ...string) KeyProvider {
+	files := make([]*secretfile.File, len(names))
+	for i, name := range names {
+		files[i] = secretfile.New(name)
+	}
+	return KeyProviderFunc(func(_ context.Context) ([][]byte, error) {
+		keys := make([][]byte, 0, len(files))
+		for _, f := range files {
+			value, err := f.Read()
+			if err != nil {
+				return nil, fmt.Errorf("blob: error reading state key: %w", err)
+			}
+			key, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("blob: invalid state key in %s: %w", f.Name(), err)
+			}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	})
+}
+
+// StateKeyID returns the id of a state key that's recorded with encrypted
+// state, the first 8 bytes of the SHA-256 digest of the key, hex encoded.
+func StateKeyID(key []byte) string {
+	digest := sha256.Sum256(key)
+	return hex.EncodeToString(digest[:8])
+}
+
+func newStateAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != StateKeySize {
+		return nil, fmt.Errorf("blob: invalid state key size: %d, expected %d", len(key), StateKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("blob: error creating state cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+type stateEncrypter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+}
+
+// encryptState returns a writer that encrypts state with a new data key, wrapped
+// with the first of the state keys.
+func encryptState(ctx context.Context, w io.Writer, keys KeyProvider) (io.WriteCloser, error) {
+	stateKeys, err := keys.StateKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(stateKeys) == 0 {
+		return nil, fmt.Errorf("blob: no state keys")
+	}
+	kek, err := newStateAEAD(stateKeys[0])
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, StateKeySize)
+	_, _ = rand.Read(dataKey)
+	aead, err := newStateAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := StateKeyID(stateKeys[0])
+	nonce := make([]byte, kek.NonceSize())
+	_, _ = rand.Read(nonce)
+	header, err := json.Marshal(stateHeader{
+		KeyID:      keyID,
+		WrappedKey: kek.Seal(nonce, nonce, dataKey, []byte(keyID)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("blob: error encoding state header: %w", err)
+	}
+
+	bs := append([]byte{}, stateMagic...)
+	bs = binary.BigEndian.AppendUint32(bs, uint32(len(header)))
+	bs = append(bs, header...)
+	_, err = w.Write(bs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stateEncrypter{w: w, aead: aead}, nil
+}
+
+func (e *stateEncrypter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	for len(e.buf) > stateSegmentSize {
+		err := e.writeSegment(e.buf[:stateSegmentSize], 0)
+		if err != nil {
+			return 0, err
+		}
+		e.buf = append(e.buf[:0], e.buf[stateSegmentSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close writes the final segment. It doesn't close the underlying writer.
+func (e *stateEncrypter) Close() error {
+	return e.writeSegment(e.buf, stateFinalFlag)
+}
+
+func (e *stateEncrypter) writeSegment(plaintext []byte, flag byte) error {
+	sealed := e.aead.Seal(nil, stateNonce(e.aead, e.counter), plaintext, []byte{flag})
+	e.counter++
+
+	bs := binary.BigEndian.AppendUint32([]byte{flag}, uint32(len(sealed)))
+	_, err := e.w.Write(append(bs, sealed...))
+	return err
+}
+
+type stateDecrypter struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+	done    bool
+}
+
+// decryptState returns a reader for the state in r. Without state keys,
+// unencrypted state is returned as-is. With state keys, unencrypted state is
+// rejected, so state that was replaced by someone without the keys isn't
+// trusted, unless allowUnencrypted is set to migrate existing state.
+func decryptState(ctx context.Context, r io.Reader, keys KeyProvider, allowUnencrypted bool) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(stateMagic))
+	if !bytes.Equal(magic, stateMagic) {
+		if keys != nil {
+			if !allowUnencrypted {
+				return nil, ErrUnencryptedState
+			}
+			log.Ctx(ctx).Warn().Msg("state isn't encrypted, it will be encrypted when it's uploaded")
+		}
+		return br, nil
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("blob: state is encrypted, but no state keys are configured")
+	}
+	if allowUnencrypted {
+		log.Ctx(ctx).Info().Msg("state is already encrypted, allowing unencrypted state is no longer needed")
+	}
+	_, _ = br.Discard(len(stateMagic))
+
+	var headerLength uint32
+	err := binary.Read(br, binary.BigEndian, &headerLength)
+	if err != nil {
+		return nil, fmt.Errorf("blob: error reading state header: %w", err)
+	}
+	if headerLength > stateMaxHeaderSize {
+		return nil, fmt.Errorf("blob: invalid state header length: %d", headerLength)
+	}
+	bs := make([]byte, headerLength)
+	_, err = io.ReadFull(br, bs)
+	if err != nil {
+		return nil, fmt.Errorf("blob: error reading state header: %w", err)
+	}
+	var header stateHeader
+	err = json.Unmarshal(bs, &header)
+	if err != nil {
+		return nil, fmt.Errorf("blob: error decoding state header: %w", err)
+	}
+
+	stateKeys, err := keys.StateKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range stateKeys {
+		if StateKeyID(key) != header.KeyID {
+			continue
+		}
+		kek, err := newStateAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(header.WrappedKey) < kek.NonceSize() {
+			return nil, fmt.Errorf("blob: invalid state header")
+		}
+		nonce, wrapped := header.WrappedKey[:kek.NonceSize()], header.WrappedKey[kek.NonceSize():]
+		dataKey, err := kek.Open(nil, nonce, wrapped, []byte(header.KeyID))
+		if err != nil {
+			continue
+		}
+		aead, err := newStateAEAD(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		return &stateDecrypter{r: br, aead: aead}, nil
+	}
+
+	return nil, fmt.Errorf("blob: no state key can decrypt the state, it was encrypted with key %s", header.KeyID)
+}
+
+func (d *stateDecrypter) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		err := d.readSegment()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *stateDecrypter) readSegment() error {
+	var frame [5]byte
+	_, err := io.ReadFull(d.r, frame[:])
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("blob: encrypted state is truncated")
+	} else if err != nil {
+		return err
+	}
+
+	flag, length := frame[0], binary.BigEndian.Uint32(frame[1:])
+	if length > stateSegmentSize+uint32(d.aead.Overhead()) {
+		return fmt.Errorf("blob: invalid encrypted state segment length: %d", length)
+	}
+	sealed := make([]byte, length)
+	_, err = io.ReadFull(d.r, sealed)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("blob: encrypted state is truncated")
+	} else if err != nil {
+		return err
+	}
+
+	d.buf, err = d.aead.Open(sealed[:0], stateNonce(d.aead, d.counter), sealed, []byte{flag})
+	if err != nil {
+		return fmt.Errorf("blob: error decrypting state: %w", err)
+	}
+	d.counter++
+
+	if flag == stateFinalFlag {
+		d.done = true
+		n, _ := d.r.Read(frame[:1])
+		if n > 0 {
+			return fmt.Errorf("blob: unexpected data after the encrypted state")
+		}
+	}
+	return nil
+}
+
+// stateNonce returns the nonce for a segment. Every upload uses a new data key,
+// so the segment counter is enough to keep nonces unique.
+func stateNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
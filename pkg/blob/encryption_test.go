@@ -0,0 +1,110 @@
+package blob_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/datasource/pkg/blob"
+)
+
+func TestStateEncryption(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	urlstr := "file://" + dir
+
+	key1, key2 := newStateKey(t), newStateKey(t)
+	// random data doesn't compress, so it spans multiple segments
+	state := make([]byte, 200*1024)
+	_, _ = rand.Read(state)
+
+	upload := func(data []byte, options ...blob.StateOption) {
+		t.Helper()
+		require.NoError(t, blob.UploadState(ctx, urlstr, func(dst io.Writer) error {
+			_, err := dst.Write(data)
+			return err
+		}, options...))
+	}
+	download := func(options ...blob.StateOption) ([]byte, error) {
+		var data []byte
+		err := blob.DownloadState(ctx, urlstr, func(src io.Reader) error {
+			var err error
+			data, err = io.ReadAll(src)
+			return err
+		}, options...)
+		return data, err
+	}
+
+	// existing unencrypted state is only read when it's allowed
+	upload([]byte("STATE"))
+	_, err := download(blob.WithStateKeys(blob.StaticKeys(key1)))
+	assert.ErrorIs(t, err, blob.ErrUnencryptedState)
+	data, err := download(blob.WithStateKeys(blob.StaticKeys(key1)), blob.WithAllowUnencryptedState(true))
+	require.NoError(t, err)
+	assert.Equal(t, "STATE", string(data))
+	data, err = download()
+	require.NoError(t, err)
+	assert.Equal(t, "STATE", string(data))
+
+	upload(state, blob.WithStateKeys(blob.StaticKeys(key1)))
+	data, err = download(blob.WithStateKeys(blob.StaticKeys(key1)))
+	require.NoError(t, err)
+	assert.Equal(t, state, data)
+	data, err = download(blob.WithStateKeys(blob.StaticKeys(key1)), blob.WithAllowUnencryptedState(true))
+	require.NoError(t, err)
+	assert.Equal(t, state, data, "encrypted state should still be decrypted when unencrypted state is allowed")
+
+	_, err = download()
+	assert.ErrorContains(t, err, "state is encrypted, but no state keys are configured")
+	_, err = download(blob.WithStateKeys(blob.StaticKeys(key2)))
+	assert.ErrorContains(t, err, "no state key can decrypt the state")
+
+	// after a rotation the previous key still decrypts the state
+	data, err = download(blob.WithStateKeys(blob.StaticKeys(key2, key1)))
+	require.NoError(t, err)
+	assert.Equal(t, state, data)
+
+	upload(state, blob.WithStateKeys(blob.StaticKeys(key2, key1)))
+	_, err = download(blob.WithStateKeys(blob.StaticKeys(key1)))
+	assert.ErrorContains(t, err, "no state key can decrypt the state")
+
+	keyFile := filepath.Join(t.TempDir(), "state-key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key2)+"\n"), 0o600))
+	data, err = download(blob.WithStateKeys(blob.KeyFiles(keyFile)))
+	require.NoError(t, err)
+	assert.Equal(t, state, data)
+
+	bs, err := os.ReadFile(filepath.Join(dir, "state.zst"))
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		expect string
+	}{
+		{"truncated", bs[:len(bs)-100], "encrypted state is truncated"},
+		{"modified", append(bs[:len(bs)-1:len(bs)-1], bs[len(bs)-1]^1), "error decrypting state"},
+		{"trailing data", append(bytes.Clone(bs), 0), "unexpected data after the encrypted state"},
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "state.zst"), tc.data, 0o600))
+		_, err = download(blob.WithStateKeys(blob.StaticKeys(key2)))
+		assert.ErrorContains(t, err, tc.expect, tc.name)
+	}
+}
+
+func newStateKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, blob.StateKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
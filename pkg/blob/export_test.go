@@ -0,0 +1,23 @@
+package blob
+
+import (
+	"context"
+	"time"
+
+	"github.com/pomerium/datasource/internal/httputil"
+)
+
+// AddBundleVersion replaces the bundle and adds it to the history at a fixed time.
+func AddBundleVersion(ctx context.Context, urlstr string, now time.Time, data []byte, retention int) error {
+	bucket, err := openBucket(ctx, urlstr)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+
+	err = bucket.WriteAll(ctx, bundleKey, data, nil)
+	if err != nil {
+		return err
+	}
+	return addBundleVersion(ctx, bucket, now, httputil.HashData(data), retention)
+}
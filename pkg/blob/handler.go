@@ -1,30 +1,386 @@
 package blob
 
 import (
+	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/pomerium/datasource/internal/httputil"
 )
 
-// NewHandler creates a new HTTP handler for blob storage.
-func NewHandler(urlstr string) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		bucket, err := openBucket(r.Context(), urlstr)
-		if err != nil {
-			log.Ctx(r.Context()).Error().Err(err).Msg("error opening bucket")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+// notPublishedRetryAfter is sent in the Retry-After header when a bundle hasn't
+// been published yet.
+const notPublishedRetryAfter = 30 * time.Second
+
+var bundleNameRE = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// errNotPublished indicates that a bundle doesn't exist in the bucket yet.
+var errNotPublished = errors.New("blob: bundle has not been published")
+
+// A Handler serves bundles from blob storage over HTTP.
+//
+// The latest bundle, or a version from the bundle history if one is pinned, is
+// served at the root. Named bundles are served at /bundles/{name} from
+// {name}/bundle.zip in the bucket, so several connectors can upload to
+// sub-directories of the same bucket and be served by a single process.
+//
+// Bundles are kept in temporary files and are only downloaded again when the
+// blob's attributes change. The attributes are checked at most once per check
+// interval, and so are bundles that haven't been published yet. Bundles are
+// checked against their manifest before they're served, so truncated or
+// modified bundles result in an error instead. If there's a verify key, the
+// manifest signature is checked too.
+type Handler struct {
+	cfg    *handlerConfig
+	urlstr string
+	router *chi.Mux
+	group  singleflight.Group
+
+	// ctx is the lifetime of the handler. Refreshes are shared by every request
+	// waiting for them, so they're canceled when the handler is closed rather
+	// than when the request that started them is.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	bucketMu sync.Mutex
+	bucket   *blob.Bucket
+
+	cacheMu sync.RWMutex
+	cache   map[string]*cachedBundle
+	// notPublished has when each key that wasn't in the bucket was checked
+	notPublished map[string]time.Time
+}
+
+type cachedBundle struct {
+	attributes bundleAttributes
+	etag       string
+	bundle     *httputil.BundleFile
+	// checkedAt is when the blob's attributes were last checked.
+	checkedAt time.Time
+}
+
+// bundleAttributes are the blob attributes used to detect changes to a bundle.
+type bundleAttributes struct {
+	etag    string
+	md5     string
+	modTime time.Time
+	size    int64
+}
+
+// NewHandler creates a new Handler. The bucket is opened on the first request
+// and kept open until the handler is closed.
+func NewHandler(urlstr string, options ...HandlerOption) *Handler {
+	h := &Handler{
+		cfg:          getHandlerConfig(options...),
+		urlstr:       urlstr,
+		cache:        make(map[string]*cachedBundle),
+		notPublished: make(map[string]time.Time),
+	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+
+	key := bundleKey
+	if h.cfg.version != "" {
+		key = versionKey(h.cfg.version)
+	}
+
+	h.router = chi.NewMux()
+	h.router.Get("/bundles/{name}", h.serveNamedBundle)
+	h.router.Head("/bundles/{name}", h.serveNamedBundle)
+	h.router.Get("/*", func(w http.ResponseWriter, r *http.Request) {
+		h.serveBundle(w, r, key)
+	})
+	h.router.Head("/*", func(w http.ResponseWriter, r *http.Request) {
+		h.serveBundle(w, r, key)
+	})
+	return h
+}
+
+// ServeHTTP serves an HTTP request with a bundle.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.router.ServeHTTP(w, r)
+}
+
+// Close cancels any refreshes in progress and closes the bucket.
+func (h *Handler) Close() error {
+	h.cancel()
+
+	h.bucketMu.Lock()
+	defer h.bucketMu.Unlock()
+
+	if h.bucket == nil {
+		return nil
+	}
+	err := h.bucket.Close()
+	h.bucket = nil
+	return err
+}
+
+func (h *Handler) serveNamedBundle(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !bundleNameRE.MatchString(name) {
+		http.Error(w, "invalid bundle name", http.StatusBadRequest)
+		return
+	}
+	h.serveBundle(w, r, name+"/"+bundleKey)
+}
+
+func (h *Handler) serveBundle(w http.ResponseWriter, r *http.Request, key string) {
+	b, err := h.getBundle(r.Context(), key)
+	if errors.Is(err, errNotPublished) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(notPublishedRetryAfter.Seconds())))
+		http.Error(w, "bundle has not been published yet", http.StatusServiceUnavailable)
+		return
+	} else if err != nil && r.Context().Err() != nil {
+		// the client went away
+		return
+	} else if err != nil {
+		log.Ctx(r.Context()).Error().Err(err).Str("key", key).Msg("error serving bundle from bucket")
+		http.Error(w, "error reading bundle", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("ETag", b.etag)
+	http.ServeContent(w, r, "bundle.zip", b.attributes.modTime, b.bundle.Reader())
+}
+
+// getBundle returns the bundle for a key, downloading it again if the blob has
+// changed since it was cached. The blob is only checked for changes once the
+// check interval has passed. If the bucket can't be read, the cached bundle is
+// returned instead.
+func (h *Handler) getBundle(ctx context.Context, key string) (*cachedBundle, error) {
+	h.cacheMu.RLock()
+	cached := h.cache[key]
+	notPublishedAt, notPublished := h.notPublished[key]
+	h.cacheMu.RUnlock()
+
+	if notPublished && time.Since(notPublishedAt) < h.cfg.checkInterval {
+		return nil, errNotPublished
+	}
+	if cached != nil && time.Since(cached.checkedAt) < h.cfg.checkInterval {
+		return cached, nil
+	}
+
+	ch := h.group.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+		defer cancel(nil)
+		stop := context.AfterFunc(h.ctx, func() { cancel(context.Cause(h.ctx)) })
+		defer stop()
+
+		return h.refreshBundle(ctx, key, cached)
+	})
+
+	var res singleflight.Result
+	select {
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	case res = <-ch:
+	}
+
+	err := res.Err
+	if errors.Is(err, errNotPublished) {
+		return nil, err
+	} else if err != nil {
+		var invalid invalidBundleError
+		if cached == nil || errors.As(err, &invalid) {
+			return nil, err
 		}
-		defer bucket.Close()
+		log.Ctx(ctx).Warn().Err(err).Str("key", key).Msg("error refreshing bundle, serving the cached bundle")
+		return cached, nil
+	}
+	return res.Val.(*cachedBundle), nil
+}
 
-		file, err := bucket.NewReader(r.Context(), "bundle.zip", nil)
-		if err != nil {
-			log.Ctx(r.Context()).Error().Err(err).Msg("error serving file from bucket")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+func (h *Handler) refreshBundle(ctx context.Context, key string, cached *cachedBundle) (_ *cachedBundle, err error) {
+	source := metricsSource(key)
+	start := time.Now()
+	defer func() {
+		if errors.Is(err, errNotPublished) {
+			h.setNotPublished(key, start)
+		} else {
+			h.cfg.metrics.RecordSync(source, start, err)
 		}
-		defer file.Close()
+	}()
+
+	bucket, err := h.getBucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := bucket.Attributes(ctx, key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, errNotPublished
+	} else if err != nil {
+		return nil, fmt.Errorf("blob: error reading bundle attributes: %w", err)
+	}
+
+	attributes := bundleAttributes{
+		etag:    attrs.ETag,
+		md5:     hex.EncodeToString(attrs.MD5),
+		modTime: attrs.ModTime,
+		size:    attrs.Size,
+	}
+	if cached != nil && cached.attributes == attributes {
+		b := *cached
+		b.checkedAt = start
+		h.setCached(key, &b)
+		return &b, nil
+	}
 
-		http.ServeContent(w, r, "bundle.zip", file.ModTime(), file)
+	log.Ctx(ctx).Debug().Str("key", key).Msg("downloading bundle")
+	file, err := bucket.NewReader(ctx, key, nil)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, errNotPublished
+	} else if err != nil {
+		return nil, fmt.Errorf("blob: error opening bundle: %w", err)
+	}
+	defer file.Close()
+
+	hasher := fnv.New64()
+	bundle, err := httputil.NewBundleFile(func(w io.Writer) error {
+		_, err := io.Copy(io.MultiWriter(w, hasher), file)
+		return err
 	})
+	if err != nil {
+		return nil, fmt.Errorf("blob: error reading bundle: %w", err)
+	}
+	manifest, err := checkBundle(bundle, h.cfg.verifyKey)
+	if err != nil {
+		return nil, err
+	}
+	// bundles uploaded before manifests were added have no record counts
+	if manifest != nil {
+		for recordType, records := range manifest.Records {
+			h.cfg.metrics.SetRecordCount(source, recordType, records.Count)
+		}
+	}
+	h.cfg.metrics.SetBundleSize(source, int(bundle.Size()))
+
+	b := &cachedBundle{
+		attributes: attributes,
+		etag:       bundleETag(attributes, hasher.Sum64()),
+		bundle:     bundle,
+		checkedAt:  start,
+	}
+	h.setCached(key, b)
+	return b, nil
+}
+
+// setCached caches a bundle that was found in the bucket.
+func (h *Handler) setCached(key string, b *cachedBundle) {
+	h.cacheMu.Lock()
+	defer h.cacheMu.Unlock()
+
+	h.cache[key] = b
+	delete(h.notPublished, key)
+}
+
+// setNotPublished records that a bundle isn't in the bucket, so it's not
+// checked again until the check interval has passed. Any valid name can be
+// requested, so expired entries for other keys are dropped as well.
+func (h *Handler) setNotPublished(key string, checkedAt time.Time) {
+	h.cacheMu.Lock()
+	defer h.cacheMu.Unlock()
+
+	delete(h.cache, key)
+	for k, t := range h.notPublished {
+		if time.Since(t) >= h.cfg.checkInterval {
+			delete(h.notPublished, k)
+		}
+	}
+	if h.cfg.checkInterval > 0 {
+		h.notPublished[key] = checkedAt
+	}
+}
+
+// metricsSource returns the source of the metrics for a bundle key.
+func metricsSource(key string) string {
+	if name, ok := strings.CutSuffix(key, "/"+bundleKey); ok {
+		return "blob/" + name
+	}
+	return "blob"
+}
+
+func (h *Handler) getBucket(ctx context.Context) (*blob.Bucket, error) {
+	h.bucketMu.Lock()
+	defer h.bucketMu.Unlock()
+
+	if h.bucket != nil {
+		return h.bucket, nil
+	}
+
+	bucket, err := openBucket(context.WithoutCancel(ctx), h.urlstr)
+	if err != nil {
+		return nil, fmt.Errorf("blob: error opening bucket: %w", err)
+	}
+	h.bucket = bucket
+	return bucket, nil
+}
+
+// bundleETag returns the ETag for a bundle, based on the MD5 digest or the
+// ETag of the blob. If the bucket has neither, the hash of the data is used.
+func bundleETag(attributes bundleAttributes, hash uint64) string {
+	switch {
+	case attributes.md5 != "":
+		return strconv.Quote(attributes.md5)
+	case attributes.etag != "":
+		if len(attributes.etag) > 1 && attributes.etag[0] == '"' {
+			return attributes.etag
+		}
+		return strconv.Quote(attributes.etag)
+	default:
+		return fmt.Sprintf(`"%x"`, hash)
+	}
+}
+
+// invalidBundleError indicates that a bundle failed validation, as opposed to
+// an error reading it.
+type invalidBundleError struct {
+	err error
+}
+
+func (err invalidBundleError) Error() string {
+	return fmt.Sprintf("blob: invalid bundle: %v", err.err)
+}
+
+func (err invalidBundleError) Unwrap() error {
+	return err.err
+}
+
+// checkBundle checks a bundle against its manifest, and the manifest signature
+// if there's a verify key.
+func checkBundle(bundle *httputil.BundleFile, verifyKey ed25519.PublicKey) (*httputil.BundleManifest, error) {
+	zr, err := zip.NewReader(bundle, bundle.Size())
+	if err != nil {
+		return nil, invalidBundleError{err}
+	}
+
+	var manifest *httputil.BundleManifest
+	if verifyKey != nil {
+		manifest, err = httputil.VerifyBundle(zr, verifyKey)
+	} else {
+		manifest, err = httputil.ReadBundleManifest(zr)
+	}
+	if err != nil {
+		return nil, invalidBundleError{err}
+	}
+
+	return manifest, nil
 }
@@ -0,0 +1,367 @@
+package blob_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gocloudblob "gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+
+	"github.com/pomerium/datasource/pkg/blob"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	urlstr := "file://" + dir
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "x"}))
+
+	get := func() *http.Response {
+		srv := httptest.NewServer(blob.NewHandler(urlstr))
+		t.Cleanup(srv.Close)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = res.Body.Close() })
+		return res
+	}
+
+	res := get()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, map[string]any{"a": "x"}, decodeBundle(t, res.Body))
+
+	// replace a record file without updating the manifest
+	bs, err := os.ReadFile(filepath.Join(dir, "bundle.zip"))
+	require.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		if f.Name == "a.json" {
+			fw, err := zw.Create(f.Name)
+			require.NoError(t, err)
+			_, err = fw.Write([]byte(`"y"`))
+			require.NoError(t, err)
+		} else {
+			require.NoError(t, zw.Copy(f))
+		}
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bundle.zip"), buf.Bytes(), 0o600))
+
+	res = get()
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
+
+func TestHandlerCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	urlstr := "file://" + dir
+
+	h := blob.NewHandler(urlstr, blob.WithCheckInterval(0))
+	t.Cleanup(func() { _ = h.Close() })
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+		for k, vs := range header {
+			r.Header[k] = vs
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "should be unavailable until a bundle is published")
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "x"}))
+	w = get("/", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]any{"a": "x"}, decodeBundle(t, w.Body))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	w = get("/", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "y"}))
+	w = get("/", http.Header{"If-None-Match": {etag}})
+	require.Equal(t, http.StatusOK, w.Code, "should refresh the cache when the bundle changes")
+	assert.Equal(t, map[string]any{"a": "y"}, decodeBundle(t, w.Body))
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// remove the bundle, the cached bundle is not served
+	require.NoError(t, os.Remove(filepath.Join(dir, "bundle.zip")))
+	w = get("/", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestHandlerWithoutManifest(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// bundles uploaded before manifests were added only have record files
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, err := zw.Create("a.json")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte(`"x"`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bundle.zip"), buf.Bytes(), 0o600))
+
+	h := blob.NewHandler("file://" + dir)
+	t.Cleanup(func() { _ = h.Close() })
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequestWithContext(t.Context(), http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]any{"a": "x"}, decodeBundle(t, w.Body))
+}
+
+func TestHandlerCheckInterval(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	urlstr := "file://" + t.TempDir()
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "x"}))
+
+	h := blob.NewHandler(urlstr, blob.WithCheckInterval(time.Hour))
+	t.Cleanup(func() { _ = h.Close() })
+	get := func() map[string]any {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		return decodeBundle(t, w.Body)
+	}
+
+	assert.Equal(t, map[string]any{"a": "x"}, get())
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "y"}))
+	assert.Equal(t, map[string]any{"a": "x"}, get(),
+		"should serve the cached bundle until the check interval has passed")
+}
+
+func TestHandlerNotPublishedCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "okta"), 0o700))
+
+	h := blob.NewHandler("file://"+dir, blob.WithCheckInterval(time.Hour))
+	t.Cleanup(func() { _ = h.Close() })
+	get := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, "/bundles/okta", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, get())
+	require.NoError(t, blob.UploadBundle(ctx, "file://"+filepath.Join(dir, "okta"), map[string]any{"a": "x"}))
+	assert.Equal(t, http.StatusServiceUnavailable, get(),
+		"should not check the bucket for an unpublished bundle until the check interval has passed")
+}
+
+func TestHandlerCanceledRequest(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	require.NoError(t, blob.UploadBundle(ctx, "file://"+dir, map[string]any{"a": "x"}))
+
+	recorder := &testMetricsRecorder{
+		syncs:       map[string]int{},
+		counts:      map[string]int{},
+		bundleSizes: map[string]int{},
+	}
+	h := blob.NewHandler(ctxBucketScheme+"://"+dir, blob.WithMetricsRecorder(recorder))
+	t.Cleanup(func() { _ = h.Close() })
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequestWithContext(canceled, http.MethodGet, "/", nil))
+
+	// the refresh isn't tied to the request that started it, so it still completes
+	assert.Eventually(t, func() bool {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		return recorder.syncs["blob"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHandlerNamedBundles(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	for _, name := range []string{"okta", "azure"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0o700))
+		require.NoError(t, blob.UploadBundle(ctx, "file://"+filepath.Join(dir, name), map[string]any{"name": name}))
+	}
+
+	h := blob.NewHandler("file://" + dir)
+	t.Cleanup(func() { _ = h.Close() })
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil))
+		return w
+	}
+
+	for _, name := range []string{"okta", "azure"} {
+		w := get("/bundles/" + name)
+		require.Equal(t, http.StatusOK, w.Code, name)
+		assert.Equal(t, map[string]any{"name": name}, decodeBundle(t, w.Body), name)
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, get("/bundles/github").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/bundles/..").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, get("/").Code,
+		"the root bundle should be separate from the named bundles")
+}
+
+type testMetricsRecorder struct {
+	mu          sync.Mutex
+	syncs       map[string]int
+	counts      map[string]int
+	bundleSizes map[string]int
+}
+
+func (r *testMetricsRecorder) RecordSync(source string, _ time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.syncs[source]++
+	}
+}
+
+func (r *testMetricsRecorder) SetRecordCount(source, recordType string, count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[source+" "+recordType] = count
+}
+
+func (r *testMetricsRecorder) SetBundleSize(source string, size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bundleSizes[source] = size
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	require.NoError(t, blob.UploadBundle(ctx, "file://"+dir, map[string]any{"a": []int{1, 2, 3}}))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "okta"), 0o700))
+	require.NoError(t, blob.UploadBundle(ctx, "file://"+filepath.Join(dir, "okta"), map[string]any{"b": []int{1}}))
+
+	recorder := &testMetricsRecorder{
+		syncs:       map[string]int{},
+		counts:      map[string]int{},
+		bundleSizes: map[string]int{},
+	}
+	h := blob.NewHandler("file://"+dir, blob.WithMetricsRecorder(recorder))
+	t.Cleanup(func() { _ = h.Close() })
+	for _, path := range []string{"/", "/", "/bundles/okta"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequestWithContext(ctx, http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, w.Code, path)
+	}
+
+	// the second request is served from the cache without checking the bucket
+	assert.Equal(t, map[string]int{"blob": 1, "blob/okta": 1}, recorder.syncs)
+	assert.Equal(t, map[string]int{"blob a": 3, "blob/okta b": 1}, recorder.counts)
+	bs, err := os.ReadFile(filepath.Join(dir, "bundle.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, len(bs), recorder.bundleSizes["blob"])
+	assert.Contains(t, recorder.bundleSizes, "blob/okta")
+}
+
+// ctxBucketScheme is the url scheme of a bucket that reads files from a
+// directory like fileblob, but that fails when the context is canceled like
+// the cloud storage drivers do.
+const ctxBucketScheme = "ctxfile"
+
+func init() {
+	gocloudblob.DefaultURLMux().RegisterBucket(ctxBucketScheme, ctxBucketOpener{})
+}
+
+type ctxBucketOpener struct{}
+
+func (ctxBucketOpener) OpenBucketURL(_ context.Context, u *url.URL) (*gocloudblob.Bucket, error) {
+	return gocloudblob.NewBucket(ctxBucket{dir: u.Path}), nil
+}
+
+// ctxBucket only implements the driver methods used by the handler.
+type ctxBucket struct {
+	driver.Bucket
+	dir string
+}
+
+func (b ctxBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(filepath.Join(b.dir, key))
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Attributes{ModTime: fi.ModTime(), Size: fi.Size()}, nil
+}
+
+func (b ctxBucket) NewRangeReader(ctx context.Context, key string, _, _ int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	bs, err := os.ReadFile(filepath.Join(b.dir, key))
+	if err != nil {
+		return nil, err
+	}
+	if opts.BeforeRead != nil {
+		err = opts.BeforeRead(func(any) bool { return false })
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ctxReader{Reader: bytes.NewReader(bs), size: int64(len(bs))}, nil
+}
+
+func (ctxBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if errors.Is(err, fs.ErrNotExist) {
+		return gcerrors.NotFound
+	}
+	return gcerrors.Unknown
+}
+
+func (ctxBucket) Close() error { return nil }
+
+type ctxReader struct {
+	*bytes.Reader
+	size int64
+}
+
+func (r *ctxReader) Attributes() *driver.ReaderAttributes {
+	return &driver.ReaderAttributes{Size: r.size}
+}
+
+func (*ctxReader) As(any) bool { return false }
+
+func (*ctxReader) Close() error { return nil }
@@ -0,0 +1,150 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+const (
+	bundleKey         = "bundle.zip"
+	historyPrefix     = "history/"
+	historySuffix     = ".zip"
+	versionTimeFormat = "20060102T150405.000Z"
+)
+
+// ErrVersionNotFound indicates that a bundle version doesn't exist in the history.
+var ErrVersionNotFound = errors.New("blob: bundle version not found")
+
+var versionIDRE = regexp.MustCompile(`^\d{8}T\d{6}\.\d{3}Z-[0-9a-f]{16}$`)
+
+// A BundleVersion is a version of the bundle in the bundle history.
+//
+// Version ids are the upload time followed by the hash of the bundle, so they
+// sort chronologically and identical bundles share the same hash.
+type BundleVersion struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+}
+
+// ValidateVersion returns an error if the version id is malformed.
+func ValidateVersion(version string) error {
+	if !versionIDRE.MatchString(version) {
+		return fmt.Errorf("blob: invalid bundle version %q", version)
+	}
+	return nil
+}
+
+// ListBundleVersions lists the versions in the bundle history, newest first.
+func ListBundleVersions(ctx context.Context, urlstr string) ([]BundleVersion, error) {
+	bucket, err := openBucket(ctx, urlstr)
+	if err != nil {
+		return nil, fmt.Errorf("error opening bucket: %w", err)
+	}
+	defer bucket.Close()
+
+	return listBundleVersions(ctx, bucket)
+}
+
+// RollbackBundle replaces the bundle with a version from the bundle history.
+// The directory state is left as is.
+func RollbackBundle(ctx context.Context, urlstr, version string) error {
+	err := ValidateVersion(version)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := openBucket(ctx, urlstr)
+	if err != nil {
+		return fmt.Errorf("error opening bucket: %w", err)
+	}
+	defer bucket.Close()
+
+	log.Ctx(ctx).Info().Str("version", version).Msg("rolling back bundle")
+	err = bucket.Copy(ctx, bundleKey, versionKey(version), nil)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return fmt.Errorf("%w: %s", ErrVersionNotFound, version)
+	} else if err != nil {
+		return fmt.Errorf("error copying bundle version: %w", err)
+	}
+
+	return nil
+}
+
+func versionKey(version string) string {
+	return historyPrefix + version + historySuffix
+}
+
+func listBundleVersions(ctx context.Context, bucket *blob.Bucket) ([]BundleVersion, error) {
+	var versions []BundleVersion
+	it := bucket.List(&blob.ListOptions{Prefix: historyPrefix})
+	for {
+		obj, err := it.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error listing bundle history: %w", err)
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(obj.Key, historyPrefix), historySuffix)
+		if ValidateVersion(id) != nil {
+			continue
+		}
+		createdAt, _ := time.Parse(versionTimeFormat, id[:len(versionTimeFormat)])
+		versions = append(versions, BundleVersion{ID: id, CreatedAt: createdAt, Size: obj.Size})
+	}
+
+	slices.SortFunc(versions, func(a, b BundleVersion) int {
+		return strings.Compare(b.ID, a.ID)
+	})
+	return versions, nil
+}
+
+// addBundleVersion adds a copy of the current bundle to the history, unless it
+// has the same records as the latest version, and deletes the versions beyond
+// the retention. The hash is the bundle hash, which only depends on the records.
+func addBundleVersion(ctx context.Context, bucket *blob.Bucket, now time.Time, hash uint64, retention int) error {
+	versions, err := listBundleVersions(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
+	hashstr := fmt.Sprintf("%016x", hash)
+	if len(versions) == 0 || !strings.HasSuffix(versions[0].ID, "-"+hashstr) {
+		createdAt := now.UTC().Truncate(time.Millisecond)
+		// version ids only have millisecond precision, so make sure uploads in
+		// quick succession still sort after the latest version
+		if len(versions) > 0 && !createdAt.After(versions[0].CreatedAt) {
+			createdAt = versions[0].CreatedAt.Add(time.Millisecond)
+		}
+		version := BundleVersion{
+			ID:        createdAt.Format(versionTimeFormat) + "-" + hashstr,
+			CreatedAt: createdAt,
+		}
+		err = bucket.Copy(ctx, versionKey(version.ID), bundleKey, nil)
+		if err != nil {
+			return fmt.Errorf("error copying bundle to version %s: %w", version.ID, err)
+		}
+		versions = slices.Insert(versions, 0, version)
+	}
+
+	for _, version := range versions[min(retention, len(versions)):] {
+		log.Ctx(ctx).Debug().Str("version", version.ID).Msg("deleting bundle version")
+		err = bucket.Delete(ctx, versionKey(version.ID))
+		if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return fmt.Errorf("error deleting bundle version %s: %w", version.ID, err)
+		}
+	}
+
+	return nil
+}
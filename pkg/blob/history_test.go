@@ -0,0 +1,85 @@
+package blob_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/datasource/pkg/blob"
+)
+
+func TestBundleHistory(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	urlstr := "file://" + dir
+
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "1"}, blob.WithHistoryRetention(2)))
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "1"}, blob.WithHistoryRetention(2)))
+	versions, err := blob.ListBundleVersions(ctx, urlstr)
+	require.NoError(t, err)
+	require.Len(t, versions, 1, "identical bundles should share a version")
+	first := versions[0]
+	assert.NoError(t, blob.ValidateVersion(first.ID))
+
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "2"}, blob.WithHistoryRetention(2)))
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "3"}, blob.WithHistoryRetention(2)))
+	versions, err = blob.ListBundleVersions(ctx, urlstr)
+	require.NoError(t, err)
+	require.Len(t, versions, 2, "should only keep the retained versions")
+	assert.NotContains(t, versions, first)
+	assert.Less(t, versions[1].ID, versions[0].ID, "should list the newest version first")
+
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "4"}, blob.WithHistoryRetention(0)))
+	versions2, err := blob.ListBundleVersions(ctx, urlstr)
+	require.NoError(t, err)
+	assert.Equal(t, versions, versions2, "should not add versions when the history is disabled")
+
+	assert.ErrorIs(t, blob.RollbackBundle(ctx, urlstr, first.ID), blob.ErrVersionNotFound)
+	assert.Error(t, blob.RollbackBundle(ctx, urlstr, "../bundle"))
+	require.NoError(t, blob.RollbackBundle(ctx, urlstr, versions[1].ID))
+	bs, err := os.ReadFile(filepath.Join(dir, "bundle.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"a": "2"}, decodeBundle(t, bytes.NewReader(bs)))
+
+	srv := httptest.NewServer(blob.NewHandler(urlstr, blob.WithVersion(versions[0].ID)))
+	t.Cleanup(srv.Close)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, map[string]any{"a": "3"}, decodeBundle(t, res.Body))
+}
+
+func TestBundleHistorySameTime(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	urlstr := "file://" + dir
+
+	now := time.Now()
+	for _, data := range []string{"1", "2", "3", "4"} {
+		require.NoError(t, blob.AddBundleVersion(ctx, urlstr, now, []byte(data), 4))
+	}
+
+	versions, err := blob.ListBundleVersions(ctx, urlstr)
+	require.NoError(t, err)
+	var contents []string
+	for _, version := range versions {
+		bs, err := os.ReadFile(filepath.Join(dir, "history", version.ID+".zip"))
+		require.NoError(t, err)
+		contents = append(contents, string(bs))
+	}
+	assert.Equal(t, []string{"4", "3", "2", "1"}, contents,
+		"versions uploaded at the same time should still be listed newest first")
+}
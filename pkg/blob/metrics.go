@@ -0,0 +1,20 @@
+package blob
+
+import "time"
+
+// A MetricsRecorder records metrics about the bundles read by a handler. The
+// source of the latest bundle is "blob", and "blob/{name}" for named bundles.
+type MetricsRecorder interface {
+	// RecordSync records a check of the bucket for a new bundle that started at start.
+	RecordSync(source string, start time.Time, err error)
+	// SetRecordCount sets the number of records of a type in the last bundle.
+	SetRecordCount(source, recordType string, count int)
+	// SetBundleSize sets the size of the last bundle.
+	SetBundleSize(source string, size int)
+}
+
+type nopMetricsRecorder struct{}
+
+func (nopMetricsRecorder) RecordSync(string, time.Time, error) {}
+func (nopMetricsRecorder) SetRecordCount(string, string, int)  {}
+func (nopMetricsRecorder) SetBundleSize(string, int)           {}
@@ -0,0 +1,62 @@
+package blob
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// ReadSigningKeyFile reads an Ed25519 private key for signing bundles from a
+// PEM encoded PKCS #8 file, like the ones created by
+// `openssl genpkey -algorithm ed25519`.
+func ReadSigningKeyFile(name string) (crypto.Signer, error) {
+	der, err := readPEMFile(name, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("blob: error parsing signing key: %w", err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("blob: signing key is not an Ed25519 key: %T", key)
+	}
+	return privateKey, nil
+}
+
+// ReadVerifyKeyFile reads an Ed25519 public key for verifying bundles from a
+// PEM encoded PKIX file, like the ones created by `openssl pkey -pubout`.
+func ReadVerifyKeyFile(name string) (ed25519.PublicKey, error) {
+	der, err := readPEMFile(name, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("blob: error parsing verify key: %w", err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("blob: verify key is not an Ed25519 key: %T", key)
+	}
+	return publicKey, nil
+}
+
+func readPEMFile(name, blockType string) ([]byte, error) {
+	bs, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("blob: error reading key file: %w", err)
+	}
+
+	block, _ := pem.Decode(bs)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("blob: key file %s has no %s PEM block", name, blockType)
+	}
+	return block.Bytes, nil
+}
@@ -0,0 +1,74 @@
+package blob_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/datasource/pkg/blob"
+)
+
+func TestSignedBundles(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyDir := t.TempDir()
+	signingKeyFile := writePEMFile(t, filepath.Join(keyDir, "signing.pem"), "PRIVATE KEY",
+		must(x509.MarshalPKCS8PrivateKey(privateKey)))
+	verifyKeyFile := writePEMFile(t, filepath.Join(keyDir, "verify.pem"), "PUBLIC KEY",
+		must(x509.MarshalPKIXPublicKey(publicKey)))
+
+	signingKey, err := blob.ReadSigningKeyFile(signingKeyFile)
+	require.NoError(t, err)
+	verifyKey, err := blob.ReadVerifyKeyFile(verifyKeyFile)
+	require.NoError(t, err)
+	otherVerifyKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	_, err = blob.ReadSigningKeyFile(verifyKeyFile)
+	assert.ErrorContains(t, err, "has no PRIVATE KEY PEM block")
+	_, err = blob.ReadVerifyKeyFile(signingKeyFile)
+	assert.ErrorContains(t, err, "has no PUBLIC KEY PEM block")
+
+	signedURL := "file://" + t.TempDir()
+	require.NoError(t, blob.UploadBundle(ctx, signedURL, map[string]any{"a": "x"}, blob.WithSigner(signingKey)))
+	unsignedURL := "file://" + t.TempDir()
+	require.NoError(t, blob.UploadBundle(ctx, unsignedURL, map[string]any{"a": "x"}))
+
+	get := func(urlstr string, options ...blob.HandlerOption) int {
+		w := httptest.NewRecorder()
+		blob.NewHandler(urlstr, options...).ServeHTTP(w,
+			httptest.NewRequestWithContext(ctx, http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get(signedURL, blob.WithVerifyKey(verifyKey)))
+	assert.Equal(t, http.StatusOK, get(signedURL), "signatures should be ignored without a verify key")
+	assert.Equal(t, http.StatusInternalServerError, get(signedURL, blob.WithVerifyKey(otherVerifyKey)))
+	assert.Equal(t, http.StatusInternalServerError, get(unsignedURL, blob.WithVerifyKey(verifyKey)))
+}
+
+func writePEMFile(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return name
+}
+
+func must[T any](value T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
@@ -4,30 +4,79 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"gocloud.dev/blob"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/tracing"
 )
 
-// UploadBundle uploads a bundle of data to blob storage.
-func UploadBundle(ctx context.Context, urlstr string, bundle map[string]any) error {
+// UploadBundle uploads a bundle of data to blob storage. Unless disabled, a copy
+// of the bundle is also added to the bundle history.
+func UploadBundle(ctx context.Context, urlstr string, bundle map[string]any, options ...UploadOption) (err error) {
+	cfg := getUploadConfig(options...)
+
+	ctx, span := tracing.Start(ctx, "blob.UploadBundle")
+	defer func() { tracing.End(span, err) }()
+
 	log.Ctx(ctx).Debug().Msg("uploading bundle")
-	return upload(ctx, urlstr, "bundle.zip", func(w io.Writer) error {
-		err := httputil.EncodeBundle(w, bundle)
+
+	bundleOptions := []httputil.BundleOption{httputil.WithBundleSource(cfg.source)}
+	if cfg.signer != nil {
+		bundleOptions = append(bundleOptions, httputil.WithBundleSigner(cfg.signer))
+	}
+
+	bucket, err := openBucket(ctx, urlstr)
+	if err != nil {
+		return fmt.Errorf("error opening bucket: %w", err)
+	}
+	defer bucket.Close()
+
+	// the bundle is encoded straight into the bucket file, so it's never held in memory
+	var hash uint64
+	err = writeBucketFile(ctx, bucket, bundleKey, func(w io.Writer) error {
+		var err error
+		hash, err = httputil.EncodeBundle(ctx, w, bundle, bundleOptions...)
 		if err != nil {
-			return fmt.Errorf("error writing bundle to bucket file: %w", err)
+			return fmt.Errorf("error encoding bundle: %w", err)
 		}
-
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	if cfg.historyRetention > 0 {
+		err = addBundleVersion(ctx, bucket, time.Now(), hash, cfg.historyRetention)
+		if err != nil {
+			return fmt.Errorf("error adding bundle to history: %w", err)
+		}
+	}
+
+	return nil
 }
 
-// UploadState uploads state data to blob storage.
-func UploadState(ctx context.Context, urlstr string, callback func(dst io.Writer) error) error {
+// UploadState uploads state data to blob storage. If there are state keys,
+// the state is encrypted.
+func UploadState(ctx context.Context, urlstr string, callback func(dst io.Writer) error, options ...StateOption) error {
+	cfg := getStateConfig(options...)
+
 	log.Ctx(ctx).Debug().Msg("uploading state")
 	return upload(ctx, urlstr, "state.zst", func(w io.Writer) error {
+		var ew io.WriteCloser
+		if cfg.keys != nil {
+			var err error
+			ew, err = encryptState(ctx, w, cfg.keys)
+			if err != nil {
+				return fmt.Errorf("error encrypting state: %w", err)
+			}
+			w = ew
+		}
+
 		zw, err := zstd.NewWriter(w)
 		if err != nil {
 			return fmt.Errorf("error creating zstd writer for bucket file: %w", err)
@@ -43,6 +92,13 @@ func UploadState(ctx context.Context, urlstr string, callback func(dst io.Writer
 			return fmt.Errorf("error closing zstd writer: %w", err)
 		}
 
+		if ew != nil {
+			err = ew.Close()
+			if err != nil {
+				return fmt.Errorf("error encrypting state: %w", err)
+			}
+		}
+
 		return nil
 	})
 }
@@ -54,6 +110,16 @@ func upload(ctx context.Context, urlstr, fileName string, callback func(w io.Wri
 	}
 	defer bucket.Close()
 
+	return writeBucketFile(ctx, bucket, fileName, callback)
+}
+
+func writeBucketFile(ctx context.Context, bucket *blob.Bucket, fileName string, callback func(w io.Writer) error) (err error) {
+	ctx, span := tracing.Start(ctx, "blob.Write", attribute.String("key", fileName))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	file, err := bucket.NewWriter(ctx, fileName, nil)
 	if err != nil {
 		return fmt.Errorf("error opening bucket file: %w", err)
@@ -61,6 +127,8 @@ func upload(ctx context.Context, urlstr, fileName string, callback func(w io.Wri
 
 	err = callback(file)
 	if err != nil {
+		// canceling the context aborts the write, so a partial file isn't committed
+		cancel()
 		_ = file.Close()
 		return fmt.Errorf("error writing bucket file: %w", err)
 	}
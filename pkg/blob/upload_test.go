@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/pomerium/datasource/internal/httputil"
 	"github.com/pomerium/datasource/pkg/blob"
 )
 
@@ -21,13 +22,39 @@ func TestUploadBundle(t *testing.T) {
 
 	ctx := t.Context()
 	dir := t.TempDir()
-	err := blob.UploadBundle(ctx, "file://"+dir, map[string]any{"a": "x", "b": "y", "c": "z"})
+	err := blob.UploadBundle(ctx, "file://"+dir, map[string]any{"a": "x", "b": "y", "c": "z"},
+		blob.WithSource("example"))
 	assert.NoError(t, err)
 
 	bs, err := os.ReadFile(filepath.Join(dir, "bundle.zip"))
 	assert.NoError(t, err)
 
 	assert.Equal(t, map[string]any{"a": "x", "b": "y", "c": "z"}, decodeBundle(t, bytes.NewReader(bs)))
+
+	zr, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
+	require.NoError(t, err)
+	manifest, err := httputil.ReadBundleManifest(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "example", manifest.Source)
+	assert.Len(t, manifest.Records, 3)
+}
+
+func TestUploadBundleError(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	dir := t.TempDir()
+	urlstr := "file://" + dir
+	require.NoError(t, blob.UploadBundle(ctx, urlstr, map[string]any{"a": "x"}))
+
+	// the bundle is encoded as it's uploaded, so this fails halfway through
+	err := blob.UploadBundle(ctx, urlstr, map[string]any{"a": "y", "b": make(chan int)})
+	assert.ErrorContains(t, err, "error encoding bundle")
+
+	bs, err := os.ReadFile(filepath.Join(dir, "bundle.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"a": "x"}, decodeBundle(t, bytes.NewReader(bs)),
+		"a failed upload should not replace the bundle")
 }
 
 func decodeBundle(tb testing.TB, r io.Reader) map[string]any {
@@ -41,6 +68,9 @@ func decodeBundle(tb testing.TB, r io.Reader) map[string]any {
 
 	m := map[string]any{}
 	for _, f := range zr.File {
+		if f.Name == "manifest.json" || f.Name == "manifest.sig" {
+			continue
+		}
 		r, err := f.Open()
 		require.NoError(tb, err)
 		var obj any
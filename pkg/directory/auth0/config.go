@@ -2,20 +2,25 @@ package auth0
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/secretfile"
 )
 
 type config struct {
-	clientID     string
-	clientSecret string
-	domain       string
-	httpClient   *http.Client
-	insecure     bool
-	logger       zerolog.Logger
+	clientID         string
+	clientSecret     string
+	clientSecretFile *secretfile.File
+	domain           string
+	httpClient       *http.Client
+	httpClientOnce   sync.Once
+	insecure         bool
+	logger           zerolog.Logger
+	retryHTTPClient  *http.Client
 }
 
 // Option provides config for the Auth0 Provider.
@@ -35,6 +40,18 @@ func WithClientSecret(clientSecret string) Option {
 	}
 }
 
+// WithClientSecretFile sets the file to read the client secret from in the
+// config. The file is re-read when it changes, and takes precedence over the
+// client secret.
+func WithClientSecretFile(name string) Option {
+	return func(cfg *config) {
+		cfg.clientSecretFile = nil
+		if name != "" {
+			cfg.clientSecretFile = secretfile.New(name)
+		}
+	}
+}
+
 // WithDomain sets the domain in the config.
 func WithDomain(domain string) Option {
 	return func(cfg *config) {
@@ -73,8 +90,18 @@ func getConfig(options ...Option) *config {
 	return cfg
 }
 
+func (cfg *config) getClientSecret() (string, error) {
+	if cfg.clientSecretFile != nil {
+		return cfg.clientSecretFile.Read()
+	}
+	return cfg.clientSecret, nil
+}
+
 func (cfg *config) getHTTPClient() *http.Client {
-	return httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
-		return event.Str("idp", "auth0")
+	cfg.httpClientOnce.Do(func() {
+		cfg.retryHTTPClient = httputil.NewRetryClient(httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "auth0")
+		}))
 	})
+	return cfg.retryHTTPClient
 }
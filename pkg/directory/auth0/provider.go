@@ -10,6 +10,7 @@ import (
 
 	"github.com/auth0/go-auth0/management"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -26,7 +27,10 @@ func New(options ...Option) *Provider {
 }
 
 // UserGroups fetches a slice of groups and users.
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "auth0.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
 	m, err := p.getManagement()
 	if err != nil {
 		return nil, nil, fmt.Errorf("auth0: error creating management client: %w", err)
@@ -81,8 +85,13 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 }
 
 func (p *Provider) getManagement() (*management.Management, error) {
+	clientSecret, err := p.cfg.getClientSecret()
+	if err != nil {
+		return nil, fmt.Errorf("auth0: error reading client secret: %w", err)
+	}
+
 	options := []management.Option{
-		management.WithClientCredentials(context.Background(), p.cfg.clientID, p.cfg.clientSecret),
+		management.WithClientCredentials(context.Background(), p.cfg.clientID, clientSecret),
 		management.WithClient(p.cfg.getHTTPClient()),
 	}
 	if p.cfg.insecure {
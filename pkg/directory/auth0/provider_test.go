@@ -4,12 +4,16 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"testing"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/pomerium/datasource/pkg/directory"
 	"github.com/pomerium/datasource/pkg/directory/auth0"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 )
 
 type (
@@ -152,3 +156,63 @@ func TestProvider_GetDirectory(t *testing.T) {
 		{ID: "user4", GroupIDs: []string{"team4"}, DisplayName: "User 4", Email: "user4@example.com"},
 	}, users)
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: func(t *testing.T, fixture *directorytest.Fixture, pageSize int) http.Handler {
+			t.Helper()
+
+			paginate := func(w http.ResponseWriter, r *http.Request, key string, items []M) {
+				page, _ := directorytest.Paginate(r, items, pageSize)
+				if page == nil {
+					page = []M{}
+				}
+				n, _ := strconv.Atoi(r.URL.Query().Get(directorytest.PageParam))
+				_ = json.NewEncoder(w).Encode(M{
+					"start": n * pageSize,
+					"limit": pageSize,
+					"total": len(items),
+					key:     page,
+				})
+			}
+
+			r := chi.NewRouter()
+			r.Get("/api/v2/users", func(w http.ResponseWriter, r *http.Request) {
+				var users []M
+				for _, u := range fixture.Users {
+					users = append(users, M{"user_id": u.ID, "name": u.Name, "email": u.Email})
+				}
+				paginate(w, r, "users", users)
+			})
+			r.Get("/api/v2/roles", func(w http.ResponseWriter, r *http.Request) {
+				var roles []M
+				for _, g := range fixture.Groups {
+					roles = append(roles, M{"id": g.ID, "name": g.Name})
+				}
+				paginate(w, r, "roles", roles)
+			})
+			r.Get("/api/v2/roles/{role_id}/users", func(w http.ResponseWriter, r *http.Request) {
+				var users []M
+				for _, u := range fixture.GetGroupUsers(chi.URLParam(r, "role_id")) {
+					users = append(users, M{"user_id": u.ID, "name": u.Name, "email": u.Email})
+				}
+				paginate(w, r, "users", users)
+			})
+			return r
+		},
+		NewProvider: func(_ *testing.T, rawURL string) directory.Provider {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				panic(err)
+			}
+			return auth0.New(
+				auth0.WithClientID("CLIENT_ID"),
+				auth0.WithClientSecret("CLIENT_SECRET"),
+				auth0.WithDomain(u.Host),
+				auth0.WithInsecure(true),
+			)
+		},
+	})
+}
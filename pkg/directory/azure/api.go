@@ -1,6 +1,10 @@
 package azure
 
-import "strings"
+import (
+	"strings"
+
+	"github.com/pomerium/datasource/pkg/directory"
+)
 
 type (
 	apiGroup struct {
@@ -12,13 +16,42 @@ type (
 		DisplayName string `json:"displayName"`
 	}
 	apiUser struct {
-		ID                string `json:"id"`
-		DisplayName       string `json:"displayName"`
-		Mail              string `json:"mail"`
-		UserPrincipalName string `json:"userPrincipalName"`
+		ID                            string             `json:"id"`
+		AccountEnabled                *bool              `json:"accountEnabled"`
+		DisplayName                   string             `json:"displayName"`
+		Mail                          string             `json:"mail"`
+		OnPremisesExtensionAttributes map[string]*string `json:"onPremisesExtensionAttributes"`
+		UserPrincipalName             string             `json:"userPrincipalName"`
 	}
 )
 
+func (obj apiUser) getStatus() directory.UserStatus {
+	switch {
+	case obj.AccountEnabled == nil:
+		return ""
+	case *obj.AccountEnabled:
+		return directory.UserStatusActive
+	default:
+		return directory.UserStatusInactive
+	}
+}
+
+// getAttributes returns the extension attributes that are set,
+// keyed by name (extensionAttribute1 to extensionAttribute15).
+func (obj apiUser) getAttributes() map[string]any {
+	var attributes map[string]any
+	for k, v := range obj.OnPremisesExtensionAttributes {
+		if v == nil || *v == "" {
+			continue
+		}
+		if attributes == nil {
+			attributes = make(map[string]any)
+		}
+		attributes[k] = *v
+	}
+	return attributes
+}
+
 func (obj apiUser) getEmail() string {
 	if obj.Mail != "" {
 		return obj.Mail
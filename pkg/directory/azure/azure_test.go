@@ -1,20 +1,28 @@
 package azure
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/pomerium/datasource/internal/httputil"
 	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 )
 
 type M = map[string]any
@@ -90,17 +98,21 @@ func newMockAPI(t *testing.T, _ *httptest.Server) http.Handler {
 		})
 		var userCallCount atomic.Int64
 		r.Get("/users/delta", func(w http.ResponseWriter, _ *http.Request) {
-			if userCallCount.Add(1) < 3 {
-				w.Header().Set("Retry-After", "1")
+			// more 429s than the default max retries, which azure doesn't limit
+			if userCallCount.Add(1) <= httputil.DefaultMaxRetries+2 {
+				w.Header().Set("Retry-After", "0")
 				http.Error(w, "too many requests", http.StatusTooManyRequests)
 				return
 			}
 			_ = json.NewEncoder(w).Encode(M{
 				"value": []M{
-					{"id": "user-1", "displayName": "User 1", "mail": "user1@example.com"},
+					{"id": "user-1", "displayName": "User 1", "mail": "user1@example.com", "onPremisesExtensionAttributes": M{
+						"extensionAttribute1": "engineering",
+						"extensionAttribute2": nil,
+					}},
 					{"id": "user-2", "displayName": "User 2", "mail": "user2@example.com"},
 					{"id": "user-3", "displayName": "User 3", "userPrincipalName": "user3_example.com#EXT#@user3example.onmicrosoft.com"},
-					{"id": "user-4", "displayName": "User 4", "userPrincipalName": "user4@example.com"},
+					{"id": "user-4", "displayName": "User 4", "userPrincipalName": "user4@example.com", "accountEnabled": false},
 				},
 			})
 		})
@@ -162,6 +174,7 @@ func TestProvider_GetDirectory(t *testing.T) {
 		WithDirectoryID("DIRECTORY_ID"),
 		WithGraphURL(mustParseURL(srv.URL)),
 		WithLoginURL(mustParseURL(srv.URL)),
+		WithUserAttributes([]string{"extensionAttribute1", "extensionAttribute2"}),
 	)
 	groups, users, err := p.GetDirectory(t.Context())
 	assert.NoError(t, err)
@@ -185,6 +198,7 @@ func TestProvider_GetDirectory(t *testing.T) {
 			GroupIDs:    []string{"admin"},
 			DisplayName: "User 1",
 			Email:       "user1@example.com",
+			Attributes:  map[string]any{"extensionAttribute1": "engineering"},
 		},
 		{
 			ID:          "user-2",
@@ -203,6 +217,7 @@ func TestProvider_GetDirectory(t *testing.T) {
 			GroupIDs:    []string{"test"},
 			DisplayName: "User 4",
 			Email:       "user4@example.com",
+			Status:      directory.UserStatusInactive,
 		},
 	}, users)
 }
@@ -230,3 +245,200 @@ func getPageURL(r *http.Request, page int) string {
 	u.RawQuery = q.Encode()
 	return u.String()
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: func(t *testing.T, fixture *directorytest.Fixture, pageSize int) http.Handler {
+			t.Helper()
+
+			// every list ends with a delta link that returns no changes
+			paginate := func(w http.ResponseWriter, r *http.Request, items []M) {
+				if r.URL.Query().Has("$deltatoken") {
+					items = nil
+				}
+				page, nextURL := directorytest.Paginate(r, items, pageSize)
+				res := M{"value": page}
+				if page == nil {
+					res["value"] = []M{}
+				}
+				if nextURL != "" {
+					res["@odata.nextLink"] = nextURL
+				} else {
+					u := *r.URL
+					u.Scheme, u.Host, u.RawQuery = "http", r.Host, "$deltatoken=DELTA_TOKEN"
+					res["@odata.deltaLink"] = u.String()
+				}
+				_ = json.NewEncoder(w).Encode(res)
+			}
+
+			r := chi.NewRouter()
+			r.Post("/DIRECTORY_ID/oauth2/v2.0/token", func(w http.ResponseWriter, _ *http.Request) {
+				_ = json.NewEncoder(w).Encode(M{
+					"access_token": "ACCESS_TOKEN",
+					"token_type":   "Bearer",
+					"expires_in":   3600,
+				})
+			})
+			r.Get("/v1.0/groups/delta", func(w http.ResponseWriter, r *http.Request) {
+				var groups []M
+				for _, g := range fixture.Groups {
+					var members []M
+					for _, id := range g.UserIDs {
+						members = append(members, M{"@odata.type": "#microsoft.graph.user", "id": id})
+					}
+					for _, id := range g.GroupIDs {
+						members = append(members, M{"@odata.type": "#microsoft.graph.group", "id": id})
+					}
+					for _, id := range g.OtherIDs {
+						members = append(members, M{"@odata.type": "#microsoft.graph.device", "id": id})
+					}
+					groups = append(groups, M{"id": g.ID, "displayName": g.Name, "members@delta": members})
+				}
+				paginate(w, r, groups)
+			})
+			r.Get("/v1.0/servicePrincipals/delta", func(w http.ResponseWriter, r *http.Request) {
+				paginate(w, r, nil)
+			})
+			r.Get("/v1.0/users/delta", func(w http.ResponseWriter, r *http.Request) {
+				var users []M
+				for _, u := range fixture.Users {
+					users = append(users, M{"id": u.ID, "displayName": u.Name, "mail": u.Email})
+				}
+				paginate(w, r, users)
+			})
+			return r
+		},
+		NewProvider: func(_ *testing.T, url string) directory.Provider {
+			return New(
+				WithClientID("CLIENT_ID"),
+				WithClientSecret("CLIENT_SECRET"),
+				WithDirectoryID("DIRECTORY_ID"),
+				WithGraphURL(mustParseURL(url)),
+				WithLoginURL(mustParseURL(url)),
+			)
+		},
+		IsTokenRequest: func(r *http.Request) bool {
+			return r.URL.Path == "/DIRECTORY_ID/oauth2/v2.0/token"
+		},
+		NestedGroups:   directorytest.NestedGroupsFlattened,
+		UnknownMembers: true,
+	})
+}
+
+func TestClientSecretFile(t *testing.T) {
+	t.Parallel()
+
+	var clientSecrets []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientSecrets = append(clientSecrets, r.FormValue("client_secret"))
+		_ = json.NewEncoder(w).Encode(M{
+			"access_token": fmt.Sprintf("ACCESSTOKEN%d", len(clientSecrets)),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	clientSecretFile := filepath.Join(t.TempDir(), "client-secret")
+	require.NoError(t, os.WriteFile(clientSecretFile, []byte("CLIENT_SECRET1\n"), 0o600))
+
+	p := New(
+		WithClientID("CLIENT_ID"),
+		WithClientSecretFile(clientSecretFile),
+		WithDirectoryID("DIRECTORY_ID"),
+		WithLoginURL(mustParseURL(srv.URL)),
+	)
+
+	token, err := p.getToken(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "ACCESSTOKEN1", token.AccessToken)
+
+	token, err = p.getToken(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "ACCESSTOKEN1", token.AccessToken, "should use the cached token")
+
+	// rotating the client secret should drop the cached token
+	require.NoError(t, os.WriteFile(clientSecretFile, []byte("CLIENT_SECRET2\n"), 0o600))
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(clientSecretFile, modTime, modTime))
+
+	token, err = p.getToken(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "ACCESSTOKEN2", token.AccessToken)
+	assert.Equal(t, []string{"CLIENT_SECRET1", "CLIENT_SECRET2"}, clientSecrets)
+}
+
+func TestLoadDirectoryStateMigration(t *testing.T) {
+	t.Parallel()
+
+	var userDeltaQueries []string
+	r := chi.NewRouter()
+	r.Post("/DIRECTORY_ID/oauth2/v2.0/token", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(M{"access_token": "ACCESSTOKEN", "token_type": "Bearer", "expires_in": 3600})
+	})
+	deltaLink := func(r *http.Request) string {
+		return "http://" + r.Host + r.URL.Path + "?$deltatoken=NEW"
+	}
+	r.Get("/v1.0/groups/delta", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(M{"value": []M{}, "@odata.deltaLink": deltaLink(r)})
+	})
+	r.Get("/v1.0/servicePrincipals/delta", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(M{"value": []M{}, "@odata.deltaLink": deltaLink(r)})
+	})
+	r.Get("/v1.0/users/delta", func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("$deltatoken"); token != "" {
+			userDeltaQueries = append(userDeltaQueries, "$deltatoken="+token)
+			_ = json.NewEncoder(w).Encode(M{"value": []M{}, "@odata.deltaLink": deltaLink(r)})
+			return
+		}
+		userDeltaQueries = append(userDeltaQueries, "$select="+r.URL.Query().Get("$select"))
+		_ = json.NewEncoder(w).Encode(M{
+			"value": []M{
+				{"id": "user-1", "displayName": "User 1", "mail": "user1@example.com", "accountEnabled": false, "onPremisesExtensionAttributes": M{
+					"extensionAttribute1": "engineering",
+				}},
+			},
+			"@odata.deltaLink": deltaLink(r),
+		})
+	})
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	newProvider := func() *Provider {
+		return New(
+			WithClientID("CLIENT_ID"),
+			WithClientSecret("CLIENT_SECRET"),
+			WithDirectoryID("DIRECTORY_ID"),
+			WithGraphURL(mustParseURL(srv.URL)),
+			WithLoginURL(mustParseURL(srv.URL)),
+			WithUserAttributes([]string{"extensionAttribute1"}),
+		)
+	}
+
+	// state saved before the account status and the extension attributes were
+	// synced, the saved delta link would never return them for unchanged users
+	p := newProvider()
+	require.NoError(t, p.LoadDirectoryState(t.Context(), strings.NewReader(`{
+		"userDeltaLink": "`+srv.URL+`/v1.0/users/delta?$deltatoken=OLD",
+		"users": {"user-1": {"id": "user-1", "displayName": "User 1", "email": "user1@example.com"}}
+	}`)))
+	_, users, err := p.GetDirectory(t.Context())
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, directory.UserStatusInactive, users[0].Status, "the users should be fully synced again")
+	assert.Equal(t, map[string]any{"extensionAttribute1": "engineering"}, users[0].Attributes)
+	assert.Equal(t, []string{"$select=" + usersDeltaSelect}, userDeltaQueries)
+
+	// state saved with the current properties keeps the delta link
+	var buf bytes.Buffer
+	require.NoError(t, p.SaveDirectoryState(t.Context(), &buf))
+	p = newProvider()
+	require.NoError(t, p.LoadDirectoryState(t.Context(), &buf))
+	_, users, err = p.GetDirectory(t.Context())
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, directory.UserStatusInactive, users[0].Status)
+	assert.Equal(t, []string{"$select=" + usersDeltaSelect, "$deltatoken=NEW"}, userDeltaQueries)
+}
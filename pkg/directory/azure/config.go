@@ -3,11 +3,13 @@ package azure
 import (
 	"net/http"
 	"net/url"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/secretfile"
 )
 
 const (
@@ -19,13 +21,17 @@ const (
 )
 
 type config struct {
-	clientID     string
-	clientSecret string
-	directoryID  string
-	graphURL     *url.URL
-	httpClient   *http.Client
-	logger       zerolog.Logger
-	loginURL     *url.URL
+	clientID         string
+	clientSecret     string
+	clientSecretFile *secretfile.File
+	directoryID      string
+	graphURL         *url.URL
+	httpClient       *http.Client
+	httpClientOnce   sync.Once
+	logger           zerolog.Logger
+	loginURL         *url.URL
+	retryHTTPClient  *http.Client
+	userAttributes   []string
 }
 
 // An Option updates the provider configuration.
@@ -45,6 +51,18 @@ func WithClientSecret(clientSecret string) Option {
 	}
 }
 
+// WithClientSecretFile sets the file to read the client secret from in the
+// config. The file is re-read when it changes, and takes precedence over the
+// client secret.
+func WithClientSecretFile(name string) Option {
+	return func(cfg *config) {
+		cfg.clientSecretFile = nil
+		if name != "" {
+			cfg.clientSecretFile = secretfile.New(name)
+		}
+	}
+}
+
 // WithDirectoryID sets the directory in the config.
 func WithDirectoryID(directoryID string) Option {
 	return func(cfg *config) {
@@ -80,6 +98,13 @@ func WithLoginURL(loginURL *url.URL) Option {
 	}
 }
 
+// WithUserAttributes sets the names of the user attributes to include in the directory.
+func WithUserAttributes(userAttributes []string) Option {
+	return func(cfg *config) {
+		cfg.userAttributes = userAttributes
+	}
+}
+
 func getConfig(options ...Option) *config {
 	cfg := new(config)
 	WithGraphURL(&url.URL{
@@ -98,8 +123,23 @@ func getConfig(options ...Option) *config {
 	return cfg
 }
 
+func (cfg *config) getClientSecret() (string, error) {
+	if cfg.clientSecretFile != nil {
+		return cfg.clientSecretFile.Read()
+	}
+	return cfg.clientSecret, nil
+}
+
+// getHTTPClient returns the http client for the graph api. The graph api
+// throttles with 429s and a Retry-After header, which have always been waited
+// out for as long as the api asks, so those are retried without a limit until
+// the context is canceled. 5xxs and transport errors use the default limits.
 func (cfg *config) getHTTPClient() *http.Client {
-	return httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
-		return event.Str("idp", "azure")
+	cfg.httpClientOnce.Do(func() {
+		client := httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "azure")
+		})
+		cfg.retryHTTPClient = httputil.NewRetryClient(client, httputil.WithUnlimitedThrottleRetries(true))
 	})
+	return cfg.retryHTTPClient
 }
@@ -3,9 +3,12 @@ package azure
 import (
 	"context"
 	"errors"
+	"maps"
 	"net/url"
 	"sort"
+	"sync"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -13,11 +16,24 @@ const (
 	groupsDeltaPath            = "/v1.0/groups/delta"
 	servicePrincipalsDeltaPath = "/v1.0/servicePrincipals/delta"
 	usersDeltaPath             = "/v1.0/users/delta"
+
+	// usersDeltaSelect is the list of user properties that are synced. It's
+	// saved with the user delta link, because a delta link only returns the
+	// users that changed, so changing the list requires a full resync.
+	usersDeltaSelect = "accountEnabled,displayName,mail,onPremisesExtensionAttributes,userPrincipalName"
 )
 
 type (
 	deltaCollection struct {
-		provider                  *Provider
+		provider *Provider
+
+		// syncMu serializes syncs, mu guards the current state
+		syncMu sync.Mutex
+		mu     sync.RWMutex
+		state  *deltaState
+	}
+	// a deltaState is never modified once it has been committed to the delta collection
+	deltaState struct {
 		groups                    map[string]deltaGroup
 		groupDeltaLink            string
 		servicePrincipals         map[string]deltaServicePrincipal
@@ -35,9 +51,11 @@ type (
 		ID         string `json:"id"`
 	}
 	deltaUser struct {
-		ID          string `json:"id"`
-		DisplayName string `json:"displayName"`
-		Email       string `json:"email"`
+		ID          string               `json:"id"`
+		DisplayName string               `json:"displayName"`
+		Email       string               `json:"email"`
+		Status      directory.UserStatus `json:"status,omitempty"`
+		Attributes  map[string]any       `json:"attributes,omitempty"`
 	}
 	deltaServicePrincipal struct {
 		ID          string `json:"id"`
@@ -47,13 +65,44 @@ type (
 
 func newDeltaCollection(p *Provider) *deltaCollection {
 	return &deltaCollection{
-		provider:          p,
+		provider: p,
+		state:    newDeltaState(),
+	}
+}
+
+func newDeltaState() *deltaState {
+	return &deltaState{
 		groups:            make(map[string]deltaGroup),
 		users:             make(map[string]deltaUser),
 		servicePrincipals: make(map[string]deltaServicePrincipal),
 	}
 }
 
+// clone returns a shallow copy of the state. Group members are copied on write by syncGroups.
+func (ds *deltaState) clone() *deltaState {
+	return &deltaState{
+		groups:                    maps.Clone(ds.groups),
+		groupDeltaLink:            ds.groupDeltaLink,
+		servicePrincipals:         maps.Clone(ds.servicePrincipals),
+		servicePrincipalDeltaLink: ds.servicePrincipalDeltaLink,
+		users:                     maps.Clone(ds.users),
+		userDeltaLink:             ds.userDeltaLink,
+	}
+}
+
+func (dc *deltaCollection) getState() *deltaState {
+	dc.mu.RLock()
+	ds := dc.state
+	dc.mu.RUnlock()
+	return ds
+}
+
+func (dc *deltaCollection) setState(ds *deltaState) {
+	dc.mu.Lock()
+	dc.state = ds
+	dc.mu.Unlock()
+}
+
 // Sync syncs the latest changes from the microsoft graph API.
 //
 // Synchronization is based on https://docs.microsoft.com/en-us/graph/delta-query-groups
@@ -66,16 +115,32 @@ func newDeltaCollection(p *Provider) *deltaCollection {
 // 4. on the next call to sync, starting at @odata.deltaLink
 //
 // Only the changed groups/members are returned. Removed groups/members have an @removed property.
+//
+// Changes are applied to a copy of the current state, which is only committed once all the
+// delta queries have succeeded, so readers never see a partially applied delta.
 func (dc *deltaCollection) Sync(ctx context.Context) error {
-	return errors.Join(
-		dc.syncGroups(ctx),
-		dc.syncServicePrincipals(ctx),
-		dc.syncUsers(ctx),
+	dc.syncMu.Lock()
+	defer dc.syncMu.Unlock()
+
+	next := dc.getState().clone()
+	err := errors.Join(
+		dc.syncGroups(ctx, next),
+		dc.syncServicePrincipals(ctx, next),
+		dc.syncUsers(ctx, next),
 	)
+	if err != nil {
+		return err
+	}
+
+	dc.setState(next)
+	return nil
 }
 
-func (dc *deltaCollection) syncGroups(ctx context.Context) error {
-	apiURL := dc.groupDeltaLink
+func (dc *deltaCollection) syncGroups(ctx context.Context, ds *deltaState) (err error) {
+	ctx, span := tracing.Start(ctx, "azure.syncGroups")
+	defer func() { tracing.End(span, err) }()
+
+	apiURL := ds.groupDeltaLink
 
 	// if no delta link is set yet, start the initial fill
 	if apiURL == "" {
@@ -97,13 +162,15 @@ func (dc *deltaCollection) syncGroups(ctx context.Context) error {
 		for _, g := range res.Value {
 			// if removed exists, the group was deleted
 			if g.Removed != nil {
-				delete(dc.groups, g.ID)
+				delete(ds.groups, g.ID)
 				continue
 			}
 
-			gdg := dc.groups[g.ID]
+			gdg := ds.groups[g.ID]
 			gdg.ID = g.ID
 			gdg.DisplayName = g.DisplayName
+			// copy the members so the committed state isn't modified
+			gdg.Members = maps.Clone(gdg.Members)
 			if gdg.Members == nil {
 				gdg.Members = make(map[string]deltaGroupMember)
 			}
@@ -119,7 +186,7 @@ func (dc *deltaCollection) syncGroups(ctx context.Context) error {
 					ID:         m.ID,
 				}
 			}
-			dc.groups[g.ID] = gdg
+			ds.groups[g.ID] = gdg
 		}
 
 		switch {
@@ -128,14 +195,17 @@ func (dc *deltaCollection) syncGroups(ctx context.Context) error {
 			apiURL = res.NextLink
 		default:
 			// once no next link is set anymore, we save the delta link and return
-			dc.groupDeltaLink = res.DeltaLink
+			ds.groupDeltaLink = res.DeltaLink
 			return nil
 		}
 	}
 }
 
-func (dc *deltaCollection) syncServicePrincipals(ctx context.Context) error {
-	apiURL := dc.servicePrincipalDeltaLink
+func (dc *deltaCollection) syncServicePrincipals(ctx context.Context, ds *deltaState) (err error) {
+	ctx, span := tracing.Start(ctx, "azure.syncServicePrincipals")
+	defer func() { tracing.End(span, err) }()
+
+	apiURL := ds.servicePrincipalDeltaLink
 
 	// if no delta link is set yet, start the initial fill
 	if apiURL == "" {
@@ -157,10 +227,10 @@ func (dc *deltaCollection) syncServicePrincipals(ctx context.Context) error {
 		for _, sp := range res.Value {
 			// if removed exists, the service principal was deleted
 			if sp.Removed != nil {
-				delete(dc.servicePrincipals, sp.ID)
+				delete(ds.servicePrincipals, sp.ID)
 				continue
 			}
-			dc.servicePrincipals[sp.ID] = deltaServicePrincipal{
+			ds.servicePrincipals[sp.ID] = deltaServicePrincipal{
 				ID:          sp.ID,
 				DisplayName: sp.DisplayName,
 			}
@@ -172,21 +242,24 @@ func (dc *deltaCollection) syncServicePrincipals(ctx context.Context) error {
 			apiURL = res.NextLink
 		default:
 			// once no next link is set anymore, we save the delta link and return
-			dc.servicePrincipalDeltaLink = res.DeltaLink
+			ds.servicePrincipalDeltaLink = res.DeltaLink
 			return nil
 		}
 	}
 }
 
-func (dc *deltaCollection) syncUsers(ctx context.Context) error {
-	apiURL := dc.userDeltaLink
+func (dc *deltaCollection) syncUsers(ctx context.Context, ds *deltaState) (err error) {
+	ctx, span := tracing.Start(ctx, "azure.syncUsers")
+	defer func() { tracing.End(span, err) }()
+
+	apiURL := ds.userDeltaLink
 
 	// if no delta link is set yet, start the initial fill
 	if apiURL == "" {
 		apiURL = dc.provider.cfg.graphURL.ResolveReference(&url.URL{
 			Path: usersDeltaPath,
 			RawQuery: url.Values{
-				"$select": {"displayName,mail,userPrincipalName"},
+				"$select": {usersDeltaSelect},
 			}.Encode(),
 		}).String()
 	}
@@ -201,13 +274,15 @@ func (dc *deltaCollection) syncUsers(ctx context.Context) error {
 		for _, u := range res.Value {
 			// if removed exists, the user was deleted
 			if u.Removed != nil {
-				delete(dc.users, u.ID)
+				delete(ds.users, u.ID)
 				continue
 			}
-			dc.users[u.ID] = deltaUser{
+			ds.users[u.ID] = deltaUser{
 				ID:          u.ID,
 				DisplayName: u.DisplayName,
 				Email:       u.getEmail(),
+				Status:      u.getStatus(),
+				Attributes:  u.getAttributes(),
 			}
 		}
 
@@ -217,7 +292,7 @@ func (dc *deltaCollection) syncUsers(ctx context.Context) error {
 			apiURL = res.NextLink
 		default:
 			// once no next link is set anymore, we save the delta link and return
-			dc.userDeltaLink = res.DeltaLink
+			ds.userDeltaLink = res.DeltaLink
 			return nil
 		}
 	}
@@ -225,10 +300,12 @@ func (dc *deltaCollection) syncUsers(ctx context.Context) error {
 
 // CurrentUserGroups returns the directory groups and users based on the current state.
 func (dc *deltaCollection) CurrentUserGroups() ([]directory.Group, []directory.User) {
+	ds := dc.getState()
+
 	var groups []directory.Group
 
-	groupLookup := newGroupLookup()
-	for _, g := range dc.groups {
+	groupLookup := directory.NewGroupLookup()
+	for _, g := range ds.groups {
 		groups = append(groups, directory.Group{
 			ID:   g.ID,
 			Name: g.DisplayName,
@@ -243,26 +320,28 @@ func (dc *deltaCollection) CurrentUserGroups() ([]directory.Group, []directory.U
 				userIDs = append(userIDs, m.ID)
 			}
 		}
-		groupLookup.addGroup(g.ID, groupIDs, userIDs)
+		groupLookup.AddGroup(g.ID, groupIDs, userIDs)
 	}
 	sort.Slice(groups, func(i, j int) bool {
 		return groups[i].ID < groups[j].ID
 	})
 
 	var users []directory.User
-	for _, sp := range dc.servicePrincipals {
+	for _, sp := range ds.servicePrincipals {
 		users = append(users, directory.User{
 			ID:          sp.ID,
-			GroupIDs:    groupLookup.getGroupIDsForUser(sp.ID),
+			GroupIDs:    groupLookup.GetGroupIDsForUser(sp.ID),
 			DisplayName: sp.DisplayName,
 		})
 	}
-	for _, u := range dc.users {
+	for _, u := range ds.users {
 		users = append(users, directory.User{
 			ID:          u.ID,
-			GroupIDs:    groupLookup.getGroupIDsForUser(u.ID),
+			GroupIDs:    groupLookup.GetGroupIDsForUser(u.ID),
 			DisplayName: u.DisplayName,
 			Email:       u.Email,
+			Status:      u.Status,
+			Attributes:  directory.SelectAttributes(u.Attributes, dc.provider.cfg.userAttributes),
 		})
 	}
 	sort.Slice(users, func(i, j int) bool {
@@ -7,14 +7,12 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/cenkalti/backoff/v4"
 	"golang.org/x/oauth2"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -25,6 +23,8 @@ type Provider struct {
 
 	mu    sync.RWMutex
 	token *oauth2.Token
+	// tokenClientSecret is the client secret the token was retrieved with
+	tokenClientSecret string
 }
 
 // New creates a new Provider.
@@ -37,8 +37,11 @@ func New(options ...Option) *Provider {
 }
 
 // GetDirectory returns the directory users in azure active directory.
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
-	err := p.dc.Sync(ctx)
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "azure.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
+	err = p.dc.Sync(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -68,58 +71,42 @@ func (p *Provider) api(ctx context.Context, url string, out any) error {
 		return res, nil
 	}
 
-	bo := backoff.NewExponentialBackOff(backoff.WithMaxElapsedTime(0))
-	for {
-		res, err := call()
-		if err != nil {
-			return err
-		}
-		defer res.Body.Close()
-
-		// if we get unauthorized, invalidate the token
-		if res.StatusCode == http.StatusUnauthorized {
-			_, _ = io.ReadAll(res.Body)
-			_ = res.Body.Close()
-
-			p.mu.Lock()
-			p.token = nil
-			p.mu.Unlock()
-
-			// try again
-			res, err = call()
-			if err != nil {
-				return err
-			}
-			defer res.Body.Close()
-		}
+	// the http client retries 429s for as long as the api asks, and 5xxs and
+	// transport errors up to httputil.DefaultMaxRetries times
+	res, err := call()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
 
-		// for a 429, backoff and try again
-		if res.StatusCode == http.StatusTooManyRequests {
-			wait := bo.NextBackOff()
-			if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
-				wait = time.Duration(seconds) * time.Second
-			}
-			p.cfg.logger.Error().Dur("wait", wait).Msg("azure: received 429 error from microsoft graph api, backing off")
-			select {
-			case <-ctx.Done():
-				return context.Cause(ctx)
-			case <-time.After(wait):
-			}
-			continue
-		}
+	// if we get unauthorized, invalidate the token
+	if res.StatusCode == http.StatusUnauthorized {
+		_, _ = io.ReadAll(res.Body)
+		_ = res.Body.Close()
 
-		// any non-200, we exit
-		if res.StatusCode/100 != 2 {
-			return fmt.Errorf("azure: error querying api (%s): %s", url, res.Status)
-		}
+		p.mu.Lock()
+		p.token = nil
+		p.mu.Unlock()
 
-		err = json.NewDecoder(res.Body).Decode(out)
+		// try again
+		res, err = call()
 		if err != nil {
-			return fmt.Errorf("azure: error decoding api response: %w", err)
+			return err
 		}
+		defer res.Body.Close()
+	}
+
+	// any non-200, we exit
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("azure: error querying api (%s): %s", url, res.Status)
+	}
 
-		return nil
+	err = json.NewDecoder(res.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("azure: error decoding api response: %w", err)
 	}
+
+	return nil
 }
 
 func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
@@ -131,16 +118,19 @@ func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
 	if clientID == "" {
 		return nil, ErrClientIDRequired
 	}
-	clientSecret := p.cfg.clientSecret
-	if clientSecret == "" {
+	clientSecret, err := p.cfg.getClientSecret()
+	if err != nil {
+		return nil, fmt.Errorf("azure: error reading client secret: %w", err)
+	} else if clientSecret == "" {
 		return nil, ErrClientSecretRequired
 	}
 
 	p.mu.RLock()
 	token := p.token
+	tokenClientSecret := p.tokenClientSecret
 	p.mu.RUnlock()
 
-	if token != nil && token.Valid() {
+	if token != nil && token.Valid() && tokenClientSecret == clientSecret {
 		return token, nil
 	}
 
@@ -149,7 +139,11 @@ func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
 
 	token = p.token
 	if token != nil && token.Valid() {
-		return token, nil
+		if p.tokenClientSecret == clientSecret {
+			return token, nil
+		}
+		// the client secret was rotated, so get a new token with it
+		p.cfg.logger.Info().Str("idp", "azure").Msg("client secret changed, refreshing token")
 	}
 
 	tokenURL := p.cfg.loginURL.ResolveReference(&url.URL{
@@ -182,6 +176,7 @@ func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
 		return nil, fmt.Errorf("azure: error decoding oauth2 token: %w", err)
 	}
 	p.token = token
+	p.tokenClientSecret = clientSecret
 
 	return p.token, nil
 }
@@ -16,18 +16,21 @@ type directoryState struct {
 	ServicePrincipalDeltaLink string                           `json:"servicePrincipalDeltaLink,omitempty"`
 	ServicePrincipals         map[string]deltaServicePrincipal `json:"servicePrincipals,omitempty"`
 	UserDeltaLink             string                           `json:"userDeltaLink,omitempty"`
+	UserDeltaSelect           string                           `json:"userDeltaSelect,omitempty"`
 	Users                     map[string]deltaUser             `json:"users,omitempty"`
 }
 
 // SaveDirectoryState saves the directory state to a writer.
 func (p *Provider) SaveDirectoryState(_ context.Context, dst io.Writer) error {
+	ds := p.dc.getState()
 	return json.NewEncoder(dst).Encode(directoryState{
-		GroupDeltaLink:            p.dc.groupDeltaLink,
-		Groups:                    p.dc.groups,
-		ServicePrincipalDeltaLink: p.dc.servicePrincipalDeltaLink,
-		ServicePrincipals:         p.dc.servicePrincipals,
-		UserDeltaLink:             p.dc.userDeltaLink,
-		Users:                     p.dc.users,
+		GroupDeltaLink:            ds.groupDeltaLink,
+		Groups:                    ds.groups,
+		ServicePrincipalDeltaLink: ds.servicePrincipalDeltaLink,
+		ServicePrincipals:         ds.servicePrincipals,
+		UserDeltaLink:             ds.userDeltaLink,
+		UserDeltaSelect:           usersDeltaSelect,
+		Users:                     ds.users,
 	})
 }
 
@@ -39,23 +42,31 @@ func (p *Provider) LoadDirectoryState(_ context.Context, src io.Reader) error {
 		return err
 	}
 
-	p.dc.groupDeltaLink = ds.GroupDeltaLink
-	p.dc.groups = ds.Groups
-	if p.dc.groups == nil {
-		p.dc.groups = make(map[string]deltaGroup)
+	next := newDeltaState()
+	next.groupDeltaLink = ds.GroupDeltaLink
+	if ds.Groups != nil {
+		next.groups = ds.Groups
 	}
 
-	p.dc.servicePrincipalDeltaLink = ds.ServicePrincipalDeltaLink
-	p.dc.servicePrincipals = ds.ServicePrincipals
-	if p.dc.servicePrincipals == nil {
-		p.dc.servicePrincipals = make(map[string]deltaServicePrincipal)
+	next.servicePrincipalDeltaLink = ds.ServicePrincipalDeltaLink
+	if ds.ServicePrincipals != nil {
+		next.servicePrincipals = ds.ServicePrincipals
 	}
 
-	p.dc.userDeltaLink = ds.UserDeltaLink
-	p.dc.users = ds.Users
-	if p.dc.users == nil {
-		p.dc.users = make(map[string]deltaUser)
+	// state saved with a different list of user properties is dropped, so the
+	// users are fully synced again instead of only picking up the new
+	// properties for users that change
+	if ds.UserDeltaSelect == usersDeltaSelect {
+		next.userDeltaLink = ds.UserDeltaLink
+		if ds.Users != nil {
+			next.users = ds.Users
+		}
 	}
 
+	// wait for any in-flight sync so it doesn't overwrite the loaded state
+	p.dc.syncMu.Lock()
+	p.dc.setState(next)
+	p.dc.syncMu.Unlock()
+
 	return nil
 }
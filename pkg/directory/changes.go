@@ -0,0 +1,109 @@
+package directory
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultChangeHistory is the default number of change events kept for the changes endpoint.
+const DefaultChangeHistory = 100
+
+// A ChangeEvent is the set of changes detected by a single directory refresh.
+//
+// Cursors increase by one for every event, starting at 1 when the handler is
+// created. They are not persisted, so clients should expect a restarted server
+// to reject their cursor and resynchronize from the full directory.
+type ChangeEvent struct {
+	Cursor  uint64    `json:"cursor"`
+	Time    time.Time `json:"time"`
+	Changes []Change  `json:"changes"`
+}
+
+// changesResponse is the body returned by the changes endpoint.
+type changesResponse struct {
+	Cursor uint64        `json:"cursor"`
+	Events []ChangeEvent `json:"events"`
+}
+
+// changeFeed keeps the most recent change events in memory.
+type changeFeed struct {
+	mu     sync.Mutex
+	size   int
+	cursor uint64
+	events []ChangeEvent
+}
+
+func newChangeFeed(size int) *changeFeed {
+	return &changeFeed{size: max(size, 1)}
+}
+
+func (f *changeFeed) add(now time.Time, changes []Change) ChangeEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cursor++
+	evt := ChangeEvent{Cursor: f.cursor, Time: now, Changes: changes}
+	f.events = append(f.events, evt)
+	if n := len(f.events) - f.size; n > 0 {
+		f.events = append(f.events[:0], f.events[n:]...)
+	}
+	return evt
+}
+
+// all returns all the retained events along with the latest cursor.
+func (f *changeFeed) all() (events []ChangeEvent, latest uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]ChangeEvent{}, f.events...), f.cursor
+}
+
+// since returns the events after the given cursor along with the latest cursor.
+// If events after the cursor have already been discarded, or the cursor is from
+// the future, ok is false.
+func (f *changeFeed) since(cursor uint64) (events []ChangeEvent, latest uint64, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cursor > f.cursor {
+		return nil, f.cursor, false
+	}
+	if cursor < f.cursor && f.events[0].Cursor > cursor+1 {
+		return nil, f.cursor, false
+	}
+
+	events = make([]ChangeEvent, 0, f.cursor-cursor)
+	for _, evt := range f.events {
+		if evt.Cursor > cursor {
+			events = append(events, evt)
+		}
+	}
+	return events, f.cursor, true
+}
+
+// serveChanges serves the change events after the cursor query parameter. If no
+// cursor is given, all the retained events are returned.
+func (h *Handler) serveChanges(w http.ResponseWriter, r *http.Request) {
+	events, latest := h.changes.all()
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		cursor, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+
+		var ok bool
+		events, latest, ok = h.changes.since(cursor)
+		if !ok {
+			http.Error(w, "cursor is no longer available, resynchronize from the full directory", http.StatusGone)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(changesResponse{Cursor: latest, Events: events})
+}
@@ -65,6 +65,7 @@ func listUsers(
 	ctx context.Context,
 	client cognitoidentityprovider.ListUsersAPIClient,
 	userPoolID string,
+	userAttributes []string,
 ) ([]directory.User, error) {
 	var users []directory.User
 
@@ -82,6 +83,8 @@ func listUsers(
 				ID:          getUserID(u),
 				DisplayName: getUserDisplayName(u),
 				Email:       getUserEmail(u),
+				Status:      getUserStatus(u),
+				Attributes:  getUserAttributes(u, userAttributes),
 			})
 		}
 	}
@@ -158,6 +161,23 @@ func getUserEmail(u types.UserType) string {
 	return ""
 }
 
+func getUserStatus(u types.UserType) directory.UserStatus {
+	if !u.Enabled {
+		return directory.UserStatusInactive
+	}
+	return directory.UserStatusActive
+}
+
+func getUserAttributes(u types.UserType, names []string) map[string]any {
+	attributes := make(map[string]any, len(u.Attributes))
+	for _, attr := range u.Attributes {
+		if attr.Name != nil && attr.Value != nil {
+			attributes[*attr.Name] = *attr.Value
+		}
+	}
+	return directory.SelectAttributes(attributes, names)
+}
+
 func getUserAttribute(attributes []types.AttributeType, name string) (value string, ok bool) {
 	for _, attr := range attributes {
 		if attr.Name != nil && attr.Value != nil &&
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -14,6 +16,7 @@ import (
 
 	"github.com/pomerium/datasource/pkg/directory"
 	"github.com/pomerium/datasource/pkg/directory/cognito"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 )
 
 type roundTripperFunc func(r *http.Request) (*http.Response, error)
@@ -73,14 +76,17 @@ func TestCognito(t *testing.T) {
 					Users: []types.UserType{
 						{
 							Username: aws.String("USERx1"),
+							Enabled:  true,
 							Attributes: []types.AttributeType{
 								{Name: aws.String("sub"), Value: aws.String("USER1")},
 								{Name: aws.String("name"), Value: aws.String("user-1")},
 								{Name: aws.String("email"), Value: aws.String("user1@example.com")},
+								{Name: aws.String("custom:department"), Value: aws.String("engineering")},
 							},
 						},
 						{
 							Username: aws.String("USER2"),
+							Enabled:  true,
 							Attributes: []types.AttributeType{
 								{Name: aws.String("name"), Value: aws.String("user-2")},
 								{Name: aws.String("email"), Value: aws.String("user2@example.com")},
@@ -137,7 +143,8 @@ func TestCognito(t *testing.T) {
 		cognito.WithLogger(zerolog.New(zerolog.NewTestWriter(t))),
 		cognito.WithRegion("us-east-1"),
 		cognito.WithSecretAccessKey("SECRET_ACCESS_KEY"),
-		cognito.WithSessionToken("SESSION_TOKEN"))
+		cognito.WithSessionToken("SESSION_TOKEN"),
+		cognito.WithUserAttributes([]string{"custom:department"}))
 
 	groups, users, err := c.GetDirectory(t.Context())
 	assert.NoError(t, err)
@@ -151,18 +158,116 @@ func TestCognito(t *testing.T) {
 			DisplayName: "user-1",
 			Email:       "user1@example.com",
 			GroupIDs:    []string{"GROUP1"},
+			Status:      directory.UserStatusActive,
+			Attributes:  map[string]any{"custom:department": "engineering"},
 		},
 		{
 			ID:          "USER2",
 			DisplayName: "user-2",
 			Email:       "user2@example.com",
 			GroupIDs:    []string{"GROUP1"},
+			Status:      directory.UserStatusActive,
 		},
 		{
 			ID:          "USER3",
 			DisplayName: "user-3",
 			Email:       "user3@example.com",
 			GroupIDs:    []string{"GROUP1", "GROUP2"},
+			Status:      directory.UserStatusInactive,
 		},
 	}, users)
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: func(t *testing.T, fixture *directorytest.Fixture, pageSize int) http.Handler {
+			t.Helper()
+
+			toUsers := func(users []directorytest.User) []types.UserType {
+				var out []types.UserType
+				for _, u := range users {
+					out = append(out, types.UserType{
+						Username: aws.String(u.ID),
+						Enabled:  true,
+						Attributes: []types.AttributeType{
+							{Name: aws.String("sub"), Value: aws.String(u.ID)},
+							{Name: aws.String("name"), Value: aws.String(u.Name)},
+							{Name: aws.String("email"), Value: aws.String(u.Email)},
+						},
+					})
+				}
+				return out
+			}
+
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var out any
+				switch r.Header.Get("x-amz-target") {
+				case "AWSCognitoIdentityProviderService.ListGroups":
+					var in cognitoidentityprovider.ListGroupsInput
+					_ = json.NewDecoder(r.Body).Decode(&in)
+					var groups []types.GroupType
+					for _, g := range fixture.Groups {
+						groups = append(groups, types.GroupType{GroupName: aws.String(g.ID)})
+					}
+					res := new(cognitoidentityprovider.ListGroupsOutput)
+					res.Groups, res.NextToken = paginate(groups, in.NextToken, pageSize)
+					out = res
+				case "AWSCognitoIdentityProviderService.ListUsers":
+					var in cognitoidentityprovider.ListUsersInput
+					_ = json.NewDecoder(r.Body).Decode(&in)
+					res := new(cognitoidentityprovider.ListUsersOutput)
+					res.Users, res.PaginationToken = paginate(toUsers(fixture.Users), in.PaginationToken, pageSize)
+					out = res
+				case "AWSCognitoIdentityProviderService.ListUsersInGroup":
+					var in cognitoidentityprovider.ListUsersInGroupInput
+					_ = json.NewDecoder(r.Body).Decode(&in)
+					res := new(cognitoidentityprovider.ListUsersInGroupOutput)
+					res.Users, res.NextToken = paginate(toUsers(fixture.GetGroupUsers(*in.GroupName)), in.NextToken, pageSize)
+					out = res
+				default:
+					http.Error(w, "not found", http.StatusNotFound)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(out)
+			})
+		},
+		NewProvider: func(t *testing.T, rawURL string) directory.Provider {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				panic(err)
+			}
+			// send the aws api requests to the fake upstream
+			httpClient := &http.Client{
+				Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					r = r.Clone(r.Context())
+					r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+					return http.DefaultTransport.RoundTrip(r)
+				}),
+			}
+			return cognito.New(
+				cognito.WithAccessKeyID("ACCESS_KEY_ID"),
+				cognito.WithHTTPClient(httpClient),
+				cognito.WithLogger(zerolog.New(zerolog.NewTestWriter(t))),
+				cognito.WithRegion("us-east-1"),
+				cognito.WithSecretAccessKey("SECRET_ACCESS_KEY"),
+				cognito.WithUserPoolID("USER-POOL-1"),
+			)
+		},
+	})
+}
+
+// paginate returns the page of items starting at the index in token, and the
+// token for the next page, if any.
+func paginate[T any](items []T, token *string, pageSize int) ([]T, *string) {
+	var start int
+	if token != nil {
+		start, _ = strconv.Atoi(*token)
+	}
+	end := min(start+pageSize, len(items))
+	if end < len(items) {
+		return items[start:end], aws.String(strconv.Itoa(end))
+	}
+	return items[start:end], nil
+}
@@ -2,21 +2,27 @@ package cognito
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/pkg/directory"
 )
 
 type config struct {
 	accessKeyID     string
+	concurrency     int
 	httpClient      *http.Client
+	httpClientOnce  sync.Once
 	logger          zerolog.Logger
 	region          string
+	retryHTTPClient *http.Client
 	secretAccessKey string
 	sessionToken    string
 	userPoolID      string
+	userAttributes  []string
 }
 
 type Option func(cfg *config)
@@ -28,6 +34,13 @@ func WithAccessKeyID(accessKeyID string) Option {
 	}
 }
 
+// WithConcurrency sets the number of groups whose members are fetched concurrently.
+func WithConcurrency(concurrency int) Option {
+	return func(cfg *config) {
+		cfg.concurrency = concurrency
+	}
+}
+
 // WithHTTPClient sets the http client config option.
 func WithHTTPClient(httpClient *http.Client) Option {
 	return func(cfg *config) {
@@ -63,6 +76,13 @@ func WithSessionToken(sessionToken string) Option {
 	}
 }
 
+// WithUserAttributes sets the names of the user attributes to include in the directory.
+func WithUserAttributes(userAttributes []string) Option {
+	return func(cfg *config) {
+		cfg.userAttributes = userAttributes
+	}
+}
+
 // WithUserPoolID sets the user pool ID config option.
 func WithUserPoolID(userPoolID string) Option {
 	return func(cfg *config) {
@@ -72,6 +92,7 @@ func WithUserPoolID(userPoolID string) Option {
 
 func getConfig(options ...Option) *config {
 	cfg := new(config)
+	WithConcurrency(directory.DefaultConcurrency)(cfg)
 	WithHTTPClient(http.DefaultClient)(cfg)
 	WithLogger(log.Logger)(cfg)
 	for _, option := range options {
@@ -81,7 +102,10 @@ func getConfig(options ...Option) *config {
 }
 
 func (cfg *config) getHTTPClient() *http.Client {
-	return httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
-		return event.Str("idp", "cognito")
+	cfg.httpClientOnce.Do(func() {
+		cfg.retryHTTPClient = httputil.NewRetryClient(httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "cognito")
+		}))
 	})
+	return cfg.retryHTTPClient
 }
@@ -3,14 +3,18 @@ package cognito
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"sort"
 	"sync"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"golang.org/x/exp/maps"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -26,7 +30,10 @@ func New(options ...Option) *Provider {
 	return &Provider{cfg: getConfig(options...)}
 }
 
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "cognito.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
 	client, err := p.getClient(ctx)
 	if err != nil {
 		return nil, nil, fmt.Errorf("cognito: error getting aws cognito client %w", err)
@@ -45,7 +52,7 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 	groupLookup := map[string]directory.Group{}
 	userLookup := map[string]directory.User{}
 	for _, userPoolID := range userPoolIDs {
-		users, err := listUsers(ctx, client, userPoolID)
+		users, err := listUsers(ctx, client, userPoolID, p.cfg.userAttributes)
 		if err != nil {
 			return nil, nil, fmt.Errorf("cognito: error listing users in user pool: %w", err)
 		}
@@ -63,14 +70,18 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 			groupLookup[g.ID] = g
 		}
 
-		for groupID := range groupLookup {
-			userIDs, err := listUserIDsInGroup(ctx, client, userPoolID, groupID)
-			if err != nil {
-				return nil, nil, fmt.Errorf("cognito: error listing user ids in group in user pool: %w", err)
-			}
-			for _, userID := range userIDs {
+		groupUserIDs, err := directory.MapConcurrently(ctx, p.cfg.concurrency, groups,
+			func(ctx context.Context, g directory.Group) ([]string, error) {
+				return listUserIDsInGroup(ctx, client, userPoolID, g.ID)
+			})
+		if err != nil {
+			return nil, nil, fmt.Errorf("cognito: error listing user ids in group in user pool: %w", err)
+		}
+
+		for i, g := range groups {
+			for _, userID := range groupUserIDs[i] {
 				if u, ok := userLookup[userID]; ok {
-					u.GroupIDs = append(u.GroupIDs, groupID)
+					u.GroupIDs = append(u.GroupIDs, g.ID)
 					userLookup[userID] = u
 				}
 			}
@@ -107,6 +118,15 @@ func (p *Provider) getClient(ctx context.Context) (*cognitoidentityprovider.Clie
 			credentials.NewStaticCredentialsProvider(p.cfg.accessKeyID, p.cfg.secretAccessKey, p.cfg.sessionToken)))
 	}
 	options = append(options, awsconfig.WithHTTPClient(p.cfg.getHTTPClient()))
+	// the sdk's requests can't be sent again by the http client, so the sdk
+	// retries them, including 429s that don't have an aws error code
+	options = append(options, awsconfig.WithRetryer(func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.Retryables = append(o.Retryables, retry.RetryableHTTPStatusCode{
+				Codes: map[int]struct{}{http.StatusTooManyRequests: {}},
+			})
+		})
+	}))
 
 	if p.cfg.region != "" {
 		options = append(options, awsconfig.WithRegion(p.cfg.region))
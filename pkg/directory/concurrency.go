@@ -0,0 +1,52 @@
+package directory
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultConcurrency is the default number of groups whose members are fetched
+// concurrently by the providers that fetch members one group at a time.
+const DefaultConcurrency = 4
+
+// MapConcurrently calls fn for each of the items, running at most concurrency
+// calls at once, and returns the results in the same order as the items so that
+// the output doesn't depend on the order the calls complete in.
+//
+// The first error cancels the context passed to the other calls and is returned.
+// If ctx is canceled before every item was started, the cancellation error is
+// returned, so callers never get results that are missing items.
+func MapConcurrently[T, R any](
+	ctx context.Context,
+	concurrency int,
+	items []T,
+	fn func(ctx context.Context, item T) (R, error),
+) ([]R, error) {
+	results := make([]R, len(items))
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.SetLimit(max(concurrency, 1))
+	started := 0
+	for i, item := range items {
+		if ectx.Err() != nil {
+			break
+		}
+		started++
+		eg.Go(func() error {
+			result, err := fn(ectx, item)
+			if err != nil {
+				return err
+			}
+			results[i] = result
+			return nil
+		})
+	}
+	err := eg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if started < len(items) {
+		return nil, context.Cause(ctx)
+	}
+	return results, nil
+}
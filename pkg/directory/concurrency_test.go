@@ -0,0 +1,73 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapConcurrently(t *testing.T) {
+	t.Parallel()
+
+	t.Run("order", func(t *testing.T) {
+		t.Parallel()
+
+		items := []int{5, 4, 3, 2, 1}
+		results, err := MapConcurrently(t.Context(), 3, items, func(_ context.Context, item int) (int, error) {
+			// complete the items in reverse order
+			time.Sleep(time.Duration(item) * time.Millisecond)
+			return item * 10, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{50, 40, 30, 20, 10}, results)
+	})
+	t.Run("limit", func(t *testing.T) {
+		t.Parallel()
+
+		var running, maxRunning atomic.Int32
+		_, err := MapConcurrently(t.Context(), 2, make([]struct{}, 10), func(_ context.Context, _ struct{}) (struct{}, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			return struct{}{}, nil
+		})
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, maxRunning.Load(), int32(2))
+	})
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+
+		errTest := errors.New("test")
+		results, err := MapConcurrently(t.Context(), 2, []int{1, 2, 3}, func(_ context.Context, item int) (int, error) {
+			if item == 2 {
+				return 0, errTest
+			}
+			return item, nil
+		})
+		assert.ErrorIs(t, err, errTest)
+		assert.Nil(t, results)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		// cancel between items, with calls that don't check the context
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		results, err := MapConcurrently(ctx, 1, []int{1, 2, 3}, func(_ context.Context, item int) (int, error) {
+			cancel()
+			return item, nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, results)
+	})
+}
@@ -0,0 +1,98 @@
+package directory
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultRefreshInterval is the default interval between directory refreshes.
+	DefaultRefreshInterval = 5 * time.Minute
+	// DefaultMaxStaleness is the default maximum age of a snapshot that will be served when refreshes fail.
+	DefaultMaxStaleness = time.Hour
+
+	refreshJitter     = 0.1
+	minRefreshBackoff = 5 * time.Second
+	maxRefreshBackoff = 5 * time.Minute
+)
+
+type handlerConfig struct {
+	changeHistory   int
+	maxStaleness    time.Duration
+	metrics         MetricsRecorder
+	name            string
+	refreshInterval time.Duration
+	webhooks        []webhook
+	webhookClient   *http.Client
+}
+
+// A HandlerOption customizes the handler config.
+type HandlerOption func(cfg *handlerConfig)
+
+// WithChangeHistory sets the number of change events kept for the changes endpoint.
+func WithChangeHistory(changeHistory int) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.changeHistory = changeHistory
+	}
+}
+
+// WithMaxStaleness sets the maximum age of a snapshot that will be served
+// when refreshing the directory fails. A value of 0 means no limit.
+func WithMaxStaleness(maxStaleness time.Duration) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.maxStaleness = maxStaleness
+	}
+}
+
+// WithMetricsRecorder sets the recorder for the sync metrics of the handler.
+// By default no metrics are recorded.
+func WithMetricsRecorder(recorder MetricsRecorder) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.metrics = recorder
+	}
+}
+
+// WithName sets the name of the handler, used as the source of its metrics.
+func WithName(name string) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.name = name
+	}
+}
+
+// WithRefreshInterval sets the interval between directory refreshes.
+func WithRefreshInterval(refreshInterval time.Duration) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.refreshInterval = refreshInterval
+	}
+}
+
+// WithWebhook adds a URL that change events are posted to. If the secret is
+// not empty, requests are signed with it. See SignWebhook for the format.
+func WithWebhook(url string, secret []byte) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.webhooks = append(cfg.webhooks, webhook{url: url, secret: secret})
+	}
+}
+
+// WithWebhookClient sets the http client used to send webhooks. Failed
+// deliveries are retried with a backoff by the handler, so the client
+// shouldn't retry them as well.
+func WithWebhookClient(client *http.Client) HandlerOption {
+	return func(cfg *handlerConfig) {
+		cfg.webhookClient = client
+	}
+}
+
+func getHandlerConfig(options ...HandlerOption) *handlerConfig {
+	cfg := new(handlerConfig)
+	WithChangeHistory(DefaultChangeHistory)(cfg)
+	WithMaxStaleness(DefaultMaxStaleness)(cfg)
+	WithMetricsRecorder(nopMetricsRecorder{})(cfg)
+	WithName("directory")(cfg)
+	WithRefreshInterval(DefaultRefreshInterval)(cfg)
+	WithWebhookClient(http.DefaultClient)(cfg)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
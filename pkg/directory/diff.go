@@ -0,0 +1,93 @@
+package directory
+
+import (
+	"cmp"
+	"slices"
+)
+
+// A ChangeType describes what changed between two directory snapshots.
+type ChangeType string
+
+// Change types.
+const (
+	ChangeTypeGroupAdded        ChangeType = "group.added"
+	ChangeTypeGroupRemoved      ChangeType = "group.removed"
+	ChangeTypeUserAdded         ChangeType = "user.added"
+	ChangeTypeUserRemoved       ChangeType = "user.removed"
+	ChangeTypeMembershipAdded   ChangeType = "membership.added"
+	ChangeTypeMembershipRemoved ChangeType = "membership.removed"
+)
+
+// A Change is a single difference between two directory snapshots. Group
+// changes set GroupID, user changes set UserID and membership changes set both.
+type Change struct {
+	Type    ChangeType `json:"type"`
+	GroupID string     `json:"group_id,omitempty"`
+	UserID  string     `json:"user_id,omitempty"`
+}
+
+// Diff computes the changes needed to go from the old directory snapshot to the
+// new one. Changes are returned sorted by type, group id and user id.
+//
+// Memberships of added or removed users are reported as well, so consumers
+// watching a group see every user that joined or left it.
+func Diff(oldGroups []Group, oldUsers []User, newGroups []Group, newUsers []User) []Change {
+	var changes []Change
+
+	oldGroupIDs := make(map[string]struct{}, len(oldGroups))
+	for _, g := range oldGroups {
+		oldGroupIDs[g.ID] = struct{}{}
+	}
+	newGroupIDs := make(map[string]struct{}, len(newGroups))
+	for _, g := range newGroups {
+		newGroupIDs[g.ID] = struct{}{}
+		if _, ok := oldGroupIDs[g.ID]; !ok {
+			changes = append(changes, Change{Type: ChangeTypeGroupAdded, GroupID: g.ID})
+		}
+	}
+	for id := range oldGroupIDs {
+		if _, ok := newGroupIDs[id]; !ok {
+			changes = append(changes, Change{Type: ChangeTypeGroupRemoved, GroupID: id})
+		}
+	}
+
+	oldUserGroupIDs := make(map[string][]string, len(oldUsers))
+	for _, u := range oldUsers {
+		oldUserGroupIDs[u.ID] = u.GroupIDs
+	}
+	newUserGroupIDs := make(map[string][]string, len(newUsers))
+	for _, u := range newUsers {
+		newUserGroupIDs[u.ID] = u.GroupIDs
+	}
+	for id, groupIDs := range newUserGroupIDs {
+		oldGroupIDs, ok := oldUserGroupIDs[id]
+		if !ok {
+			changes = append(changes, Change{Type: ChangeTypeUserAdded, UserID: id})
+		}
+		for _, groupID := range groupIDs {
+			if !slices.Contains(oldGroupIDs, groupID) {
+				changes = append(changes, Change{Type: ChangeTypeMembershipAdded, GroupID: groupID, UserID: id})
+			}
+		}
+	}
+	for id, groupIDs := range oldUserGroupIDs {
+		newGroupIDs, ok := newUserGroupIDs[id]
+		if !ok {
+			changes = append(changes, Change{Type: ChangeTypeUserRemoved, UserID: id})
+		}
+		for _, groupID := range groupIDs {
+			if !slices.Contains(newGroupIDs, groupID) {
+				changes = append(changes, Change{Type: ChangeTypeMembershipRemoved, GroupID: groupID, UserID: id})
+			}
+		}
+	}
+
+	slices.SortFunc(changes, func(a, b Change) int {
+		return cmp.Or(
+			cmp.Compare(a.Type, b.Type),
+			cmp.Compare(a.GroupID, b.GroupID),
+			cmp.Compare(a.UserID, b.UserID),
+		)
+	})
+	return changes
+}
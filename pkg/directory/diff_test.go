@@ -0,0 +1,32 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	oldGroups := []Group{{ID: "g1"}, {ID: "g2"}}
+	oldUsers := []User{
+		{ID: "u1", GroupIDs: []string{"g1"}},
+		{ID: "u2", GroupIDs: []string{"g1", "g2"}},
+	}
+
+	assert.Empty(t, Diff(oldGroups, oldUsers, oldGroups, oldUsers))
+	assert.Equal(t, []Change{
+		{Type: ChangeTypeGroupAdded, GroupID: "g3"},
+		{Type: ChangeTypeGroupRemoved, GroupID: "g2"},
+		{Type: ChangeTypeMembershipAdded, GroupID: "g3", UserID: "u1"},
+		{Type: ChangeTypeMembershipAdded, GroupID: "g3", UserID: "u3"},
+		{Type: ChangeTypeMembershipRemoved, GroupID: "g1", UserID: "u2"},
+		{Type: ChangeTypeMembershipRemoved, GroupID: "g2", UserID: "u2"},
+		{Type: ChangeTypeUserAdded, UserID: "u3"},
+		{Type: ChangeTypeUserRemoved, UserID: "u2"},
+	}, Diff(oldGroups, oldUsers, []Group{{ID: "g1"}, {ID: "g3"}}, []User{
+		{ID: "u1", GroupIDs: []string{"g1", "g3"}},
+		{ID: "u3", GroupIDs: []string{"g3"}},
+	}))
+}
@@ -5,17 +5,47 @@ const (
 	UserRecordType  = "pomerium.io/DirectoryUser"
 )
 
+// A UserStatus is the status of a user account.
+type UserStatus string
+
+// User statuses. Providers that don't know the status of a user leave it unset,
+// which is treated the same as active.
+const (
+	UserStatusActive   UserStatus = "active"
+	UserStatusInactive UserStatus = "inactive"
+)
+
 // A User represents a user in a directory.
 type User struct {
-	ID          string   `json:"id,omitempty"`
-	GroupIDs    []string `json:"group_ids,omitempty"`
-	DisplayName string   `json:"display_name,omitempty"`
-	Email       string   `json:"email,omitempty"`
+	ID          string         `json:"id,omitempty"`
+	GroupIDs    []string       `json:"group_ids,omitempty"`
+	DisplayName string         `json:"display_name,omitempty"`
+	Email       string         `json:"email,omitempty"`
+	Status      UserStatus     `json:"status,omitempty"`
+	Attributes  map[string]any `json:"attributes,omitempty"`
 }
 
 // A Group represents a group in a directory.
 type Group struct {
-	ID    string `json:"id,omitempty"`
-	Name  string `json:"name,omitempty"`
-	Email string `json:"email,omitempty"`
+	ID         string         `json:"id,omitempty"`
+	Name       string         `json:"name,omitempty"`
+	Email      string         `json:"email,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// SelectAttributes returns the attributes in src with the given names.
+// If none of the attributes are found, nil is returned.
+func SelectAttributes(src map[string]any, names []string) map[string]any {
+	var dst map[string]any
+	for _, name := range names {
+		v, ok := src[name]
+		if !ok || v == nil {
+			continue
+		}
+		if dst == nil {
+			dst = make(map[string]any, len(names))
+		}
+		dst[name] = v
+	}
+	return dst
 }
@@ -0,0 +1,22 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectAttributes(t *testing.T) {
+	t.Parallel()
+
+	src := map[string]any{
+		"department": "Engineering",
+		"manager":    nil,
+		"title":      "Engineer",
+	}
+	assert.Nil(t, SelectAttributes(src, nil))
+	assert.Nil(t, SelectAttributes(src, []string{"manager", "missing"}))
+	assert.Equal(t, map[string]any{
+		"department": "Engineering",
+	}, SelectAttributes(src, []string{"department", "manager"}))
+}
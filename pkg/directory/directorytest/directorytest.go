@@ -0,0 +1,328 @@
+// Package directorytest contains a conformance test suite for directory providers.
+//
+// Each provider package implements a fake of its upstream API that serves a
+// Fixture, or uses the fake identity provider with NewFakeIDPUpstream, and Run
+// checks the provider against it:
+//
+//   - groups, users and group ids are sorted, whatever order the upstream uses
+//   - an empty directory is not an error
+//   - lists that end at, just before and just after a page boundary are complete
+//   - nested groups are ignored or flattened, and unknown member types are ignored
+//   - a 401 results in a new access token, for providers that use one
+//   - a 429 is retried
+//   - a canceled context stops the sync
+//   - directory state survives a save and load, for persistent providers
+//
+// Every provider package runs the suite in a TestConformance test, so a new
+// provider should add one too.
+package directorytest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/datasource/pkg/directory"
+)
+
+// DefaultPageSize is the page size used by the fake upstreams.
+const DefaultPageSize = 3
+
+// NestedGroups is how a provider handles groups that are members of other groups.
+type NestedGroups int
+
+// NestedGroups values
+const (
+	// NestedGroupsUnsupported means the upstream has no nested groups.
+	NestedGroupsUnsupported NestedGroups = iota
+	// NestedGroupsIgnored means users are only members of the groups they are
+	// direct members of.
+	NestedGroupsIgnored
+	// NestedGroupsFlattened means users are also members of all the ancestors of
+	// their groups.
+	NestedGroupsFlattened
+)
+
+// A Harness creates providers backed by a fake upstream.
+type Harness struct {
+	// NewUpstream returns a handler implementing the upstream API, serving the
+	// fixture with at most pageSize items in every page of a list.
+	NewUpstream func(t *testing.T, fixture *Fixture, pageSize int) http.Handler
+	// NewProvider creates a provider that uses the upstream API at url.
+	NewProvider func(t *testing.T, url string) directory.Provider
+
+	// IsTokenRequest reports whether a request is for an access token, or for
+	// discovering where to get one. It's only set for providers that get a new
+	// access token after a 401.
+	IsTokenRequest func(r *http.Request) bool
+	// NestedGroups is how the provider handles nested groups.
+	NestedGroups NestedGroups
+	// UnknownMembers is whether the upstream has group members that are
+	// neither users nor groups.
+	UnknownMembers bool
+	// SingleGroup is whether users can only be a member of a single group. If
+	// it's set, users are only kept in the group with the highest id.
+	SingleGroup bool
+}
+
+// Run runs the conformance tests.
+func Run(t *testing.T, h Harness) {
+	t.Helper()
+
+	t.Run("sorted", func(t *testing.T) {
+		t.Parallel()
+
+		rnd := rand.New(rand.NewPCG(1, 2))
+		var results []result
+		for range 2 {
+			f := newFixture(7)
+			f.Groups[1].UserIDs = append(f.Groups[1].UserIDs, userID(4), userID(6), userID(2))
+			f.Groups[3].UserIDs = append(f.Groups[3].UserIDs, userID(5), userID(1))
+			f.shuffle(rnd)
+
+			r := h.getDirectory(t, t.Context(), f, nil)
+			require.NoError(t, r.err)
+			assert.True(t, slices.IsSortedFunc(r.groups, func(a, b directory.Group) int {
+				return strings.Compare(a.ID, b.ID)
+			}), "groups should be sorted by id")
+			assert.True(t, slices.IsSortedFunc(r.users, func(a, b directory.User) int {
+				return strings.Compare(a.ID, b.ID)
+			}), "users should be sorted by id")
+			for _, u := range r.users {
+				assert.True(t, slices.IsSorted(u.GroupIDs), "group ids of %s should be sorted", u.ID)
+			}
+			h.assertDirectory(t, f, r)
+			results = append(results, r)
+		}
+		assert.Equal(t, results[0].groups, results[1].groups, "groups should be the same for the same directory")
+		assert.Equal(t, results[0].users, results[1].users, "users should be the same for the same directory")
+	})
+	t.Run("empty", func(t *testing.T) {
+		t.Parallel()
+
+		r := h.getDirectory(t, t.Context(), new(Fixture), nil)
+		require.NoError(t, r.err)
+		assert.Empty(t, r.groups)
+		assert.Empty(t, r.users)
+	})
+	t.Run("pagination", func(t *testing.T) {
+		t.Parallel()
+
+		for _, n := range []int{DefaultPageSize - 1, DefaultPageSize, DefaultPageSize + 1, 2*DefaultPageSize + 1} {
+			f := newFixture(n)
+			r := h.getDirectory(t, t.Context(), f, nil)
+			require.NoError(t, r.err, "%d items", n)
+			h.assertDirectory(t, f, r)
+		}
+	})
+	if h.NestedGroups != NestedGroupsUnsupported {
+		t.Run("nested groups", func(t *testing.T) {
+			t.Parallel()
+
+			f := newFixture(3)
+			f.Groups[0].UserIDs = []string{userID(0)}
+			f.Groups[0].GroupIDs = []string{groupID(1)}
+			f.Groups[1].GroupIDs = []string{groupID(2)}
+			r := h.getDirectory(t, t.Context(), f, nil)
+			require.NoError(t, r.err)
+			h.assertDirectory(t, f, r)
+		})
+	}
+	if h.UnknownMembers {
+		t.Run("unknown members", func(t *testing.T) {
+			t.Parallel()
+
+			f := newFixture(2)
+			f.Groups[1].OtherIDs = []string{"device-1"}
+			r := h.getDirectory(t, t.Context(), f, nil)
+			require.NoError(t, r.err)
+			h.assertDirectory(t, f, r)
+		})
+	}
+	if h.IsTokenRequest != nil {
+		t.Run("token refresh", func(t *testing.T) {
+			t.Parallel()
+
+			var tokenRequests, unauthorized atomic.Int64
+			f := newFixture(2)
+			r := h.getDirectory(t, t.Context(), f, func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if h.IsTokenRequest(r) {
+						tokenRequests.Add(1)
+					} else if unauthorized.CompareAndSwap(0, 1) {
+						http.Error(w, "expired", http.StatusUnauthorized)
+						return
+					}
+					next.ServeHTTP(w, r)
+				})
+			})
+			require.NoError(t, r.err)
+			h.assertDirectory(t, f, r)
+			assert.GreaterOrEqual(t, tokenRequests.Load(), int64(2), "should get a new access token after a 401")
+		})
+	}
+	t.Run("rate limited", func(t *testing.T) {
+		t.Parallel()
+
+		var rateLimited atomic.Int64
+		f := newFixture(2)
+		r := h.getDirectory(t, t.Context(), f, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !h.isTokenRequest(r) && rateLimited.Add(1) <= 2 {
+					w.Header().Set("Retry-After", "0")
+					http.Error(w, "too many requests", http.StatusTooManyRequests)
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+		require.NoError(t, r.err)
+		h.assertDirectory(t, f, r)
+	})
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+
+		errc := make(chan error, 1)
+		go func() {
+			r := h.getDirectory(t, ctx, newFixture(2), func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if h.isTokenRequest(r) {
+						next.ServeHTTP(w, r)
+						return
+					}
+					// hang until the sync is canceled, reading the body first so
+					// the server notices when the client goes away
+					_, _ = io.Copy(io.Discard, r.Body)
+					cancel()
+					<-r.Context().Done()
+				})
+			})
+			errc <- r.err
+		}()
+
+		select {
+		case err := <-errc:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(10 * time.Second):
+			t.Fatal("sync wasn't canceled")
+		}
+	})
+	t.Run("state", func(t *testing.T) {
+		t.Parallel()
+
+		f := newFixture(DefaultPageSize + 1)
+		srv := h.newServer(t, f, nil)
+		p1, ok := h.NewProvider(t, srv.URL).(directory.PersistentProvider)
+		if !ok {
+			t.Skip("not a persistent provider")
+		}
+
+		groups1, users1, err := p1.GetDirectory(t.Context())
+		require.NoError(t, err)
+		var state1 bytes.Buffer
+		require.NoError(t, p1.SaveDirectoryState(t.Context(), &state1))
+
+		p2 := h.NewProvider(t, srv.URL).(directory.PersistentProvider)
+		require.NoError(t, p2.LoadDirectoryState(t.Context(), bytes.NewReader(state1.Bytes())))
+		var state2 bytes.Buffer
+		require.NoError(t, p2.SaveDirectoryState(t.Context(), &state2))
+		assert.Equal(t, state1.String(), state2.String(), "saved state should be the same after a load")
+
+		groups2, users2, err := p2.GetDirectory(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, groups1, groups2)
+		assert.Equal(t, users1, users2)
+	})
+}
+
+type result struct {
+	groups []directory.Group
+	users  []directory.User
+	err    error
+}
+
+func (h Harness) isTokenRequest(r *http.Request) bool {
+	return h.IsTokenRequest != nil && h.IsTokenRequest(r)
+}
+
+func (h Harness) newServer(t *testing.T, f *Fixture, middleware func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+
+	if h.SingleGroup {
+		f.keepSingleGroup()
+	}
+	handler := h.NewUpstream(t, f, DefaultPageSize)
+	if middleware != nil {
+		handler = middleware(handler)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (h Harness) getDirectory(
+	t *testing.T,
+	ctx context.Context,
+	f *Fixture,
+	middleware func(http.Handler) http.Handler,
+) result {
+	t.Helper()
+
+	srv := h.newServer(t, f, middleware)
+	groups, users, err := h.NewProvider(t, srv.URL).GetDirectory(ctx)
+	return result{groups: groups, users: users, err: err}
+}
+
+// assertDirectory checks that the groups and memberships match the fixture.
+func (h Harness) assertDirectory(t *testing.T, f *Fixture, r result) {
+	t.Helper()
+
+	var expectGroupIDs []string
+	for _, g := range f.Groups {
+		expectGroupIDs = append(expectGroupIDs, g.ID)
+	}
+	slices.Sort(expectGroupIDs)
+	var actualGroupIDs []string
+	for _, g := range r.groups {
+		actualGroupIDs = append(actualGroupIDs, g.ID)
+	}
+	assert.Equal(t, expectGroupIDs, actualGroupIDs, "group ids")
+
+	expectMemberships := map[string][]string{}
+	for _, g := range f.Groups {
+		for _, userID := range g.UserIDs {
+			groupIDs := append(expectMemberships[userID], g.ID)
+			if h.NestedGroups == NestedGroupsFlattened {
+				for id, ok := f.GetParentGroupID(g.ID); ok; id, ok = f.GetParentGroupID(id) {
+					groupIDs = append(groupIDs, id)
+				}
+			}
+			expectMemberships[userID] = groupIDs
+		}
+	}
+	for userID, groupIDs := range expectMemberships {
+		slices.Sort(groupIDs)
+		expectMemberships[userID] = slices.Compact(groupIDs)
+	}
+	actualMemberships := map[string][]string{}
+	for _, u := range r.users {
+		if len(u.GroupIDs) > 0 {
+			actualMemberships[u.ID] = u.GroupIDs
+		}
+	}
+	assert.Equal(t, expectMemberships, actualMemberships, "user group ids")
+}
@@ -0,0 +1,33 @@
+package directorytest
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/pomerium/datasource/internal/fakeidp"
+)
+
+// NewFakeIDPUpstream returns the fake identity provider serving the fixture.
+// It can be used as the NewUpstream of a harness for the providers that the
+// fake identity provider supports. Members that are neither users nor groups
+// aren't supported.
+func NewFakeIDPUpstream(t *testing.T, fixture *Fixture, pageSize int) http.Handler {
+	t.Helper()
+
+	seed := new(fakeidp.Seed)
+	for _, g := range fixture.Groups {
+		parentID, _ := fixture.GetParentGroupID(g.ID)
+		seed.Groups = append(seed.Groups, fakeidp.Group{ID: g.ID, Name: g.Name, ParentID: parentID})
+	}
+	for _, u := range fixture.Users {
+		user := fakeidp.User{ID: u.ID, Name: u.Name, Email: u.Email}
+		for _, g := range fixture.Groups {
+			if slices.Contains(g.UserIDs, u.ID) {
+				user.GroupIDs = append(user.GroupIDs, g.ID)
+			}
+		}
+		seed.Users = append(seed.Users, user)
+	}
+	return fakeidp.NewServer(seed, fakeidp.WithPageSize(pageSize))
+}
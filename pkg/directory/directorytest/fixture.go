@@ -0,0 +1,150 @@
+package directorytest
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// A Fixture is the directory served by a fake upstream.
+type Fixture struct {
+	Groups []Group
+	Users  []User
+}
+
+// A Group is a group in a fixture.
+type Group struct {
+	ID   string
+	Name string
+	// UserIDs are the ids of the users that are direct members of the group.
+	UserIDs []string
+	// GroupIDs are the ids of the groups that are members of the group.
+	GroupIDs []string
+	// OtherIDs are the ids of members that are neither users nor groups, like devices.
+	OtherIDs []string
+}
+
+// A User is a user in a fixture.
+type User struct {
+	ID    string
+	Name  string
+	Email string
+}
+
+// GetGroup returns the group with the given id.
+func (f *Fixture) GetGroup(id string) (Group, bool) {
+	idx := slices.IndexFunc(f.Groups, func(g Group) bool { return g.ID == id })
+	if idx < 0 {
+		return Group{}, false
+	}
+	return f.Groups[idx], true
+}
+
+// GetUser returns the user with the given id.
+func (f *Fixture) GetUser(id string) (User, bool) {
+	idx := slices.IndexFunc(f.Users, func(u User) bool { return u.ID == id })
+	if idx < 0 {
+		return User{}, false
+	}
+	return f.Users[idx], true
+}
+
+// GetParentGroupID returns the id of the group the group is a member of, if any.
+func (f *Fixture) GetParentGroupID(id string) (string, bool) {
+	for _, g := range f.Groups {
+		if slices.Contains(g.GroupIDs, id) {
+			return g.ID, true
+		}
+	}
+	return "", false
+}
+
+// GetGroupUsers returns the users that are direct members of a group.
+func (f *Fixture) GetGroupUsers(id string) []User {
+	g, _ := f.GetGroup(id)
+	var users []User
+	for _, userID := range g.UserIDs {
+		if u, ok := f.GetUser(userID); ok {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// newFixture creates a fixture of numbered groups and users. Every user is a
+// member of the group with the same number, and all the users are members of
+// the first group, so both lists of groups and lists of members have n items.
+func newFixture(n int) *Fixture {
+	f := new(Fixture)
+	for i := range n {
+		f.Groups = append(f.Groups, Group{
+			ID:   groupID(i),
+			Name: fmt.Sprintf("Group %d", i),
+		})
+		f.Users = append(f.Users, User{
+			ID:    userID(i),
+			Name:  fmt.Sprintf("User %d", i),
+			Email: fmt.Sprintf("user%d@example.com", i),
+		})
+	}
+	for i := range n {
+		f.Groups[0].UserIDs = append(f.Groups[0].UserIDs, userID(i))
+		if i > 0 {
+			f.Groups[i].UserIDs = append(f.Groups[i].UserIDs, userID(i))
+		}
+	}
+	return f
+}
+
+// shuffle randomizes the order of the groups, users and members.
+func (f *Fixture) shuffle(rnd *rand.Rand) {
+	rnd.Shuffle(len(f.Groups), func(i, j int) { f.Groups[i], f.Groups[j] = f.Groups[j], f.Groups[i] })
+	rnd.Shuffle(len(f.Users), func(i, j int) { f.Users[i], f.Users[j] = f.Users[j], f.Users[i] })
+	for _, g := range f.Groups {
+		rnd.Shuffle(len(g.UserIDs), func(i, j int) { g.UserIDs[i], g.UserIDs[j] = g.UserIDs[j], g.UserIDs[i] })
+	}
+}
+
+// keepSingleGroup removes users from all their groups except the one with the
+// highest id, for upstreams where users are only in a single group.
+func (f *Fixture) keepSingleGroup() {
+	lastGroupIDs := map[string]string{}
+	for _, g := range f.Groups {
+		for _, userID := range g.UserIDs {
+			if id, ok := lastGroupIDs[userID]; !ok || g.ID > id {
+				lastGroupIDs[userID] = g.ID
+			}
+		}
+	}
+	for i, g := range f.Groups {
+		f.Groups[i].UserIDs = slices.DeleteFunc(g.UserIDs, func(userID string) bool {
+			return lastGroupIDs[userID] != g.ID
+		})
+	}
+}
+
+// numeric ids work for upstreams that only support numeric ids
+func groupID(i int) string { return strconv.Itoa(1000 + i) }
+func userID(i int) string  { return strconv.Itoa(2000 + i) }
+
+// PageParam is the query parameter used by Paginate for the page number.
+const PageParam = "page"
+
+// Paginate returns the page of items requested by r, using the page query
+// parameter, and the url of the next page, or "" if it's the last page.
+func Paginate[T any](r *http.Request, items []T, pageSize int) (page []T, nextURL string) {
+	n, _ := strconv.Atoi(r.URL.Query().Get(PageParam))
+	start := min(n*pageSize, len(items))
+	end := min(start+pageSize, len(items))
+	if end < len(items) {
+		u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		q := u.Query()
+		q.Set(PageParam, strconv.Itoa(n+1))
+		u.RawQuery = q.Encode()
+		nextURL = u.String()
+	}
+	return items[start:end], nextURL
+}
@@ -0,0 +1,290 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// regexpPatternPrefix marks a filter pattern as a regular expression instead of a glob.
+const regexpPatternPrefix = "re:"
+
+type filterConfig struct {
+	includeGroups          []string
+	excludeGroups          []string
+	includeUserDomains     []string
+	excludeUserDomains     []string
+	dropUsersWithoutGroups bool
+	includeInactiveUsers   bool
+}
+
+// A FilterOption customizes the filter config.
+type FilterOption func(cfg *filterConfig)
+
+// WithIncludeGroups sets the patterns for the groups to include. If any patterns
+// are set, only groups with an id, name or email matching one of them are included.
+//
+// Patterns are globs, or regular expressions when prefixed with "re:". Both
+// match the whole value, and * in a glob also matches /, so "eng/*" matches
+// "eng/platform/oncall" and "re:admin" doesn't match "not-admin".
+func WithIncludeGroups(patterns ...string) FilterOption {
+	return func(cfg *filterConfig) {
+		cfg.includeGroups = patterns
+	}
+}
+
+// WithExcludeGroups sets the patterns for the groups to exclude. Groups with an
+// id, name or email matching one of them are excluded.
+//
+// Patterns are globs, or regular expressions when prefixed with "re:". Both
+// match the whole value, and * in a glob also matches /, so "eng/*" matches
+// "eng/platform/oncall" and "re:admin" doesn't match "not-admin".
+func WithExcludeGroups(patterns ...string) FilterOption {
+	return func(cfg *filterConfig) {
+		cfg.excludeGroups = patterns
+	}
+}
+
+// WithIncludeUserDomains sets the patterns for the email domains of the users to
+// include. If any patterns are set, only users with a matching email domain are
+// included.
+func WithIncludeUserDomains(patterns ...string) FilterOption {
+	return func(cfg *filterConfig) {
+		cfg.includeUserDomains = patterns
+	}
+}
+
+// WithExcludeUserDomains sets the patterns for the email domains of the users to exclude.
+func WithExcludeUserDomains(patterns ...string) FilterOption {
+	return func(cfg *filterConfig) {
+		cfg.excludeUserDomains = patterns
+	}
+}
+
+// WithDropUsersWithoutGroups sets whether users that aren't a member of any
+// of the included groups are dropped.
+func WithDropUsersWithoutGroups(dropUsersWithoutGroups bool) FilterOption {
+	return func(cfg *filterConfig) {
+		cfg.dropUsersWithoutGroups = dropUsersWithoutGroups
+	}
+}
+
+// WithIncludeInactiveUsers sets whether inactive users are included. By default they
+// are dropped. When they are included their status marks them as inactive.
+func WithIncludeInactiveUsers(includeInactiveUsers bool) FilterOption {
+	return func(cfg *filterConfig) {
+		cfg.includeInactiveUsers = includeInactiveUsers
+	}
+}
+
+func getFilterConfig(options ...FilterOption) *filterConfig {
+	cfg := new(filterConfig)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
+
+// A Filter removes groups and users from a directory.
+type Filter struct {
+	includeGroups          []pattern
+	excludeGroups          []pattern
+	includeUserDomains     []pattern
+	excludeUserDomains     []pattern
+	dropUsersWithoutGroups bool
+	includeInactiveUsers   bool
+}
+
+// NewFilter creates a new Filter. An error is returned if any of the patterns are invalid.
+func NewFilter(options ...FilterOption) (*Filter, error) {
+	cfg := getFilterConfig(options...)
+
+	f := &Filter{
+		dropUsersWithoutGroups: cfg.dropUsersWithoutGroups,
+		includeInactiveUsers:   cfg.includeInactiveUsers,
+	}
+	var err error
+	if f.includeGroups, err = parsePatterns(cfg.includeGroups, false); err != nil {
+		return nil, err
+	}
+	if f.excludeGroups, err = parsePatterns(cfg.excludeGroups, false); err != nil {
+		return nil, err
+	}
+	if f.includeUserDomains, err = parsePatterns(cfg.includeUserDomains, true); err != nil {
+		return nil, err
+	}
+	if f.excludeUserDomains, err = parsePatterns(cfg.excludeUserDomains, true); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Apply returns the groups and users that pass the filter. Group ids of
+// excluded groups are removed from the users.
+func (f *Filter) Apply(groups []Group, users []User) ([]Group, []User) {
+	included := make(map[string]struct{}, len(groups))
+	filteredGroups := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		if !f.IncludeGroup(g) {
+			continue
+		}
+		included[g.ID] = struct{}{}
+		filteredGroups = append(filteredGroups, g)
+	}
+
+	filteredUsers := make([]User, 0, len(users))
+	for _, u := range users {
+		if !f.IncludeUser(u) {
+			continue
+		}
+
+		var groupIDs []string
+		for _, groupID := range u.GroupIDs {
+			if _, ok := included[groupID]; ok {
+				groupIDs = append(groupIDs, groupID)
+			}
+		}
+		if f.dropUsersWithoutGroups && len(groupIDs) == 0 {
+			continue
+		}
+		u.GroupIDs = groupIDs
+		filteredUsers = append(filteredUsers, u)
+	}
+
+	return filteredGroups, filteredUsers
+}
+
+// IncludeGroup returns true if the group passes the filter.
+func (f *Filter) IncludeGroup(g Group) bool {
+	return f.GroupExclusionReason(g) == ""
+}
+
+// GroupExclusionReason returns why the group doesn't pass the filter, or an
+// empty string if it does.
+func (f *Filter) GroupExclusionReason(g Group) string {
+	values := []string{g.ID, g.Name, g.Email}
+	if len(f.includeGroups) > 0 {
+		if _, ok := firstMatch(f.includeGroups, values...); !ok {
+			return "group does not match any include pattern"
+		}
+	}
+	if p, ok := firstMatch(f.excludeGroups, values...); ok {
+		return fmt.Sprintf("group matches exclude pattern %q", p.raw)
+	}
+	return ""
+}
+
+// IncludeUser returns true if the user passes the status and email domain filters.
+// It doesn't consider the user's groups.
+func (f *Filter) IncludeUser(u User) bool {
+	return f.UserExclusionReason(u) == ""
+}
+
+// UserExclusionReason returns why the user doesn't pass the status and email
+// domain filters, or an empty string if it does.
+func (f *Filter) UserExclusionReason(u User) string {
+	if !f.includeInactiveUsers && u.Status == UserStatusInactive {
+		return "user is inactive"
+	}
+
+	domain := ""
+	if idx := strings.LastIndexByte(u.Email, '@'); idx >= 0 {
+		domain = strings.ToLower(u.Email[idx+1:])
+	}
+	if domain == "" {
+		if len(f.includeUserDomains) > 0 {
+			return "user has no email domain to match the include patterns"
+		}
+		return ""
+	}
+	if len(f.includeUserDomains) > 0 {
+		if _, ok := firstMatch(f.includeUserDomains, domain); !ok {
+			return fmt.Sprintf("email domain %q does not match any include pattern", domain)
+		}
+	}
+	if p, ok := firstMatch(f.excludeUserDomains, domain); ok {
+		return fmt.Sprintf("email domain %q matches exclude pattern %q", domain, p.raw)
+	}
+	return ""
+}
+
+type filterProvider struct {
+	provider Provider
+	filter   *Filter
+}
+
+// NewFilterProvider creates a new Provider that applies the filter to the groups
+// and users returned by the provider.
+//
+// If the provider is a PersistentProvider, so is the returned provider.
+func NewFilterProvider(provider Provider, filter *Filter) Provider {
+	return withPersistence(&filterProvider{provider: provider, filter: filter}, provider)
+}
+
+// GetDirectory gets all the groups and users in a directory.
+func (p *filterProvider) GetDirectory(ctx context.Context) ([]Group, []User, error) {
+	groups, users, err := p.provider.GetDirectory(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups, users = p.filter.Apply(groups, users)
+	return groups, users, nil
+}
+
+type pattern struct {
+	raw   string
+	match func(value string) bool
+}
+
+// parsePatterns parses globs and regular expressions that match whole values.
+// Names aren't paths, so unlike with path.Match, * and ? in a glob also match /.
+func parsePatterns(raw []string, caseInsensitive bool) ([]pattern, error) {
+	patterns := make([]pattern, 0, len(raw))
+	for _, r := range raw {
+		if expr, ok := strings.CutPrefix(r, regexpPatternPrefix); ok {
+			expr = "^(?:" + expr + ")$"
+			if caseInsensitive {
+				expr = "(?i)" + expr
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("directory: invalid filter pattern %q: %w", r, err)
+			}
+			patterns = append(patterns, pattern{raw: r, match: re.MatchString})
+			continue
+		}
+
+		glob := withoutSeparators(r)
+		if caseInsensitive {
+			glob = strings.ToLower(glob)
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("directory: invalid filter pattern %q: %w", r, err)
+		}
+		patterns = append(patterns, pattern{raw: r, match: func(value string) bool {
+			ok, _ := path.Match(glob, withoutSeparators(value))
+			return ok
+		}})
+	}
+	return patterns, nil
+}
+
+// withoutSeparators replaces the / in a glob or value with a character that
+// path.Match doesn't treat as a separator.
+func withoutSeparators(s string) string {
+	return strings.ReplaceAll(s, "/", "\x00")
+}
+
+func firstMatch(patterns []pattern, values ...string) (pattern, bool) {
+	for _, p := range patterns {
+		for _, v := range values {
+			if v != "" && p.match(v) {
+				return p, true
+			}
+		}
+	}
+	return pattern{}, false
+}
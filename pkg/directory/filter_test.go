@@ -0,0 +1,163 @@
+package directory
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	groups := []Group{
+		{ID: "g1", Name: "Engineering", Email: "eng@example.com"},
+		{ID: "g2", Name: "dl-announcements", Email: "announce@example.com"},
+		{ID: "g3", Name: "svc-backups"},
+	}
+	users := []User{
+		{ID: "u1", GroupIDs: []string{"g1", "g2"}, Email: "u1@example.com"},
+		{ID: "u2", GroupIDs: []string{"g2"}, Email: "u2@Example.com"},
+		{ID: "u3", GroupIDs: []string{"g3"}, Email: "u3@contractor.example.org"},
+		{ID: "u4"},
+		{ID: "u5", GroupIDs: []string{"g1"}, Status: UserStatusInactive},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		options        []FilterOption
+		expectGroupIDs []string
+		expectUsers    []User
+	}{
+		{
+			"none", nil,
+			[]string{"g1", "g2", "g3"},
+			users[:4],
+		},
+		{
+			"include inactive users",
+			[]FilterOption{WithIncludeInactiveUsers(true)},
+			[]string{"g1", "g2", "g3"},
+			users,
+		},
+		{
+			"exclude groups",
+			[]FilterOption{WithExcludeGroups("dl-*", "re:svc-.*")},
+			[]string{"g1"},
+			[]User{
+				{ID: "u1", GroupIDs: []string{"g1"}, Email: "u1@example.com"},
+				{ID: "u2", Email: "u2@Example.com"},
+				{ID: "u3", Email: "u3@contractor.example.org"},
+				{ID: "u4"},
+			},
+		},
+		{
+			"include groups by email",
+			[]FilterOption{WithIncludeGroups("*@example.com"), WithDropUsersWithoutGroups(true)},
+			[]string{"g1", "g2"},
+			[]User{
+				{ID: "u1", GroupIDs: []string{"g1", "g2"}, Email: "u1@example.com"},
+				{ID: "u2", GroupIDs: []string{"g2"}, Email: "u2@Example.com"},
+			},
+		},
+		{
+			"include user domains",
+			[]FilterOption{WithIncludeUserDomains("EXAMPLE.COM")},
+			[]string{"g1", "g2", "g3"},
+			[]User{
+				{ID: "u1", GroupIDs: []string{"g1", "g2"}, Email: "u1@example.com"},
+				{ID: "u2", GroupIDs: []string{"g2"}, Email: "u2@Example.com"},
+			},
+		},
+		{
+			"exclude user domains",
+			[]FilterOption{WithExcludeUserDomains("*.example.org")},
+			[]string{"g1", "g2", "g3"},
+			[]User{
+				{ID: "u1", GroupIDs: []string{"g1", "g2"}, Email: "u1@example.com"},
+				{ID: "u2", GroupIDs: []string{"g2"}, Email: "u2@Example.com"},
+				{ID: "u4"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := NewFilter(tc.options...)
+			require.NoError(t, err)
+
+			actualGroups, actualUsers := f.Apply(groups, users)
+			var actualGroupIDs []string
+			for _, g := range actualGroups {
+				actualGroupIDs = append(actualGroupIDs, g.ID)
+			}
+			assert.Equal(t, tc.expectGroupIDs, actualGroupIDs)
+			assert.Equal(t, tc.expectUsers, actualUsers)
+		})
+	}
+
+	t.Run("whole values", func(t *testing.T) {
+		t.Parallel()
+
+		patterns, err := parsePatterns([]string{"eng/*", "re:admin", "?/oncall"}, false)
+		require.NoError(t, err)
+		match := func(value string) string {
+			p, _ := firstMatch(patterns, value)
+			return p.raw
+		}
+		assert.Equal(t, "eng/*", match("eng/platform/oncall"), "* should match /")
+		assert.Equal(t, "re:admin", match("admin"))
+		assert.Empty(t, match("not-admin-ish"), "regular expressions should match the whole value")
+		assert.Equal(t, "?/oncall", match("a/oncall"))
+		assert.Empty(t, match("engineering"))
+	})
+	t.Run("invalid pattern", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := NewFilter(WithExcludeGroups("re:("))
+		assert.Error(t, err)
+		_, err = NewFilter(WithIncludeGroups("["))
+		assert.Error(t, err)
+	})
+	t.Run("reasons", func(t *testing.T) {
+		t.Parallel()
+
+		f, err := NewFilter(
+			WithIncludeGroups("g1", "dl-*"),
+			WithExcludeGroups("*announce*"),
+			WithIncludeUserDomains("*example.com", "*.example.org"),
+			WithExcludeUserDomains("contractor.*"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "", f.GroupExclusionReason(groups[0]))
+		assert.Equal(t, `group matches exclude pattern "*announce*"`, f.GroupExclusionReason(groups[1]))
+		assert.Equal(t, "group does not match any include pattern", f.GroupExclusionReason(groups[2]))
+
+		assert.Equal(t, "", f.UserExclusionReason(users[1]))
+		assert.Equal(t, `email domain "contractor.example.org" matches exclude pattern "contractor.*"`,
+			f.UserExclusionReason(users[2]))
+		assert.Equal(t, "user has no email domain to match the include patterns", f.UserExclusionReason(users[3]))
+		assert.Equal(t, "user is inactive", f.UserExclusionReason(users[4]))
+	})
+	t.Run("provider", func(t *testing.T) {
+		t.Parallel()
+
+		f, err := NewFilter(WithExcludeGroups("g2", "g3"), WithDropUsersWithoutGroups(true))
+		require.NoError(t, err)
+
+		p := NewFilterProvider(&mockPersistentProvider{Provider: ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+			return groups, users, nil
+		})}, f)
+		actualGroups, actualUsers, err := p.GetDirectory(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, groups[:1], actualGroups)
+		assert.Equal(t, []User{{ID: "u1", GroupIDs: []string{"g1"}, Email: "u1@example.com"}}, actualUsers)
+
+		pp, ok := p.(PersistentProvider)
+		if assert.True(t, ok) {
+			assert.NoError(t, pp.SaveDirectoryState(t.Context(), new(bytes.Buffer)))
+		}
+	})
+}
@@ -3,11 +3,13 @@ package github
 import (
 	"net/http"
 	"net/url"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/secretfile"
 )
 
 var defaultURL = &url.URL{
@@ -16,12 +18,15 @@ var defaultURL = &url.URL{
 }
 
 type config struct {
-	httpClient          *http.Client
-	logger              zerolog.Logger
-	personalAccessToken string
-	url                 *url.URL
-	useNodeIDs          bool
-	username            string
+	httpClient              *http.Client
+	httpClientOnce          sync.Once
+	logger                  zerolog.Logger
+	personalAccessToken     string
+	personalAccessTokenFile *secretfile.File
+	retryHTTPClient         *http.Client
+	url                     *url.URL
+	useNodeIDs              bool
+	username                string
 }
 
 // An Option updates the github configuration.
@@ -48,6 +53,18 @@ func WithPersonalAccessToken(personalAccessToken string) Option {
 	}
 }
 
+// WithPersonalAccessTokenFile sets the file to read the personal access token
+// from in the config. The file is re-read when it changes, and takes precedence
+// over the personal access token.
+func WithPersonalAccessTokenFile(name string) Option {
+	return func(cfg *config) {
+		cfg.personalAccessTokenFile = nil
+		if name != "" {
+			cfg.personalAccessTokenFile = secretfile.New(name)
+		}
+	}
+}
+
 // WithURL sets the api url in the config.
 func WithURL(u *url.URL) Option {
 	return func(cfg *config) {
@@ -81,8 +98,21 @@ func getConfig(options ...Option) *config {
 	return cfg
 }
 
+func (cfg *config) getPersonalAccessToken() (string, error) {
+	if cfg.personalAccessTokenFile != nil {
+		return cfg.personalAccessTokenFile.Read()
+	}
+	return cfg.personalAccessToken, nil
+}
+
+// getHTTPClient returns the http client for the github api. Since the graphql
+// queries are read-only, POST requests are retried as well. The client is
+// created once, so the requests share a retry budget.
 func (cfg *config) getHTTPClient() *http.Client {
-	return httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
-		return event.Str("idp", "github")
+	cfg.httpClientOnce.Do(func() {
+		cfg.retryHTTPClient = httputil.NewRetryClient(httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "github")
+		}), httputil.WithRetryableMethods(http.MethodPost))
 	})
+	return cfg.retryHTTPClient
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -14,6 +15,7 @@ import (
 	"github.com/vektah/gqlparser/v2/parser"
 
 	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 )
 
 type M = map[string]interface{}
@@ -446,3 +448,95 @@ func mustParseURL(rawurl string) *url.URL {
 	}
 	return u
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: func(t *testing.T, fixture *directorytest.Fixture, pageSize int) http.Handler {
+			t.Helper()
+
+			// cursors are the index of the first item of the page, and null is the first page
+			after := func(field *ast.Field) int {
+				start, _ := strconv.Atoi(field.Arguments.ForName("after").Value.Raw)
+				return start
+			}
+			paginate := func(start, n int) (end int, pageInfo qlPageInfo) {
+				end = min(start+pageSize, n)
+				if end < n {
+					pageInfo = qlPageInfo{EndCursor: strconv.Itoa(end), HasNextPage: true}
+				}
+				return end, pageInfo
+			}
+			teamMembers := func(teamSlug string, start int) *qlTeamMemberConnection {
+				users := fixture.GetGroupUsers(teamSlug)
+				end, pageInfo := paginate(start, len(users))
+				members := &qlTeamMemberConnection{PageInfo: pageInfo}
+				for _, u := range users[start:end] {
+					members.Edges = append(members.Edges, qlTeamMemberEdge{Node: qlUser{NodeID: "node-" + u.ID}})
+				}
+				return members
+			}
+
+			r := chi.NewRouter()
+			r.Get("/user/orgs", func(w http.ResponseWriter, _ *http.Request) {
+				_ = json.NewEncoder(w).Encode([]M{{"login": "org"}})
+			})
+			r.Post("/graphql", func(w http.ResponseWriter, r *http.Request) {
+				var body struct {
+					Query string `json:"query"`
+				}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				q, err := parser.ParseQuery(&ast.Source{Input: body.Query})
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				org := &qlOrganization{}
+				for _, field := range q.Operations[0].SelectionSet[0].(*ast.Field).SelectionSet {
+					field := field.(*ast.Field)
+					switch field.Name {
+					case "membersWithRole":
+						start := after(field)
+						end, pageInfo := paginate(start, len(fixture.Users))
+						org.MembersWithRole = &qlMembersWithRoleConnection{PageInfo: pageInfo}
+						for _, u := range fixture.Users[start:end] {
+							org.MembersWithRole.Nodes = append(org.MembersWithRole.Nodes, qlUser{
+								NodeID: "node-" + u.ID,
+								Login:  u.ID,
+								Name:   u.Name,
+								Email:  u.Email,
+							})
+						}
+					case "teams":
+						start := after(field)
+						end, pageInfo := paginate(start, len(fixture.Groups))
+						org.Teams = &qlTeamConnection{PageInfo: pageInfo, Edges: []qlTeamEdge{}}
+						for _, g := range fixture.Groups[start:end] {
+							org.Teams.Edges = append(org.Teams.Edges, qlTeamEdge{Node: qlTeam{
+								NodeID:  "node-" + g.ID,
+								Name:    g.Name,
+								Slug:    g.ID,
+								Members: teamMembers(g.ID, 0),
+							}})
+						}
+					case "team":
+						teamSlug := field.Arguments.ForName("slug").Value.Raw
+						membersField := field.SelectionSet[0].(*ast.Field)
+						org.Team = &qlTeam{Members: teamMembers(teamSlug, after(membersField))}
+					}
+				}
+				_ = json.NewEncoder(w).Encode(qlResult{Data: &qlData{Organization: org}})
+			})
+			return r
+		},
+		NewProvider: func(_ *testing.T, rawURL string) directory.Provider {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				panic(err)
+			}
+			return New(WithURL(u), WithUsername("abc"), WithPersonalAccessToken("xyz"))
+		},
+	})
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/pomerium/datasource/internal/tracing"
 )
 
 const maxPageCount = 100
@@ -62,7 +66,10 @@ type (
 	}
 )
 
-func (p *Provider) listOrganizationMembers(ctx context.Context, orgSlug string) ([]qlUser, error) {
+func (p *Provider) listOrganizationMembers(ctx context.Context, orgSlug string) (_ []qlUser, err error) {
+	ctx, span := tracing.Start(ctx, "github.listOrganizationMembers", attribute.String("org", orgSlug))
+	defer func() { tracing.End(span, err) }()
+
 	var results []qlUser
 	var cursor *string
 	for {
@@ -103,7 +110,10 @@ func (p *Provider) listOrganizationMembers(ctx context.Context, orgSlug string)
 	return results, nil
 }
 
-func (p *Provider) listOrganizationTeamsWithMemberIDs(ctx context.Context, orgSlug string) ([]teamWithMemberIDs, error) {
+func (p *Provider) listOrganizationTeamsWithMemberIDs(ctx context.Context, orgSlug string) (_ []teamWithMemberIDs, err error) {
+	ctx, span := tracing.Start(ctx, "github.listOrganizationTeamsWithMemberIDs", attribute.String("org", orgSlug))
+	defer func() { tracing.End(span, err) }()
+
 	var results []teamWithMemberIDs
 	var pageInfos []qlPageInfo
 
@@ -14,6 +14,7 @@ import (
 
 	"github.com/tomnomnom/linkheader"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -30,7 +31,10 @@ func New(options ...Option) *Provider {
 }
 
 // GetDirectory gets the directory user groups for github.
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "github.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
 	orgSlugs, err := p.listOrgs(ctx)
 	if err != nil {
 		return nil, nil, err
@@ -95,6 +99,9 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 }
 
 func (p *Provider) listOrgs(ctx context.Context) (orgSlugs []string, err error) {
+	ctx, span := tracing.Start(ctx, "github.listOrgs")
+	defer func() { tracing.End(span, err) }()
+
 	nextURL := p.cfg.url.ResolveReference(&url.URL{
 		Path: "/user/orgs",
 	}).String()
@@ -123,8 +130,10 @@ func (p *Provider) api(ctx context.Context, apiURL string, out any) (http.Header
 	if username == "" {
 		return nil, ErrUsernameRequired
 	}
-	personalAccessToken := p.cfg.personalAccessToken
-	if personalAccessToken == "" {
+	personalAccessToken, err := p.cfg.getPersonalAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("github: error reading personal access token: %w", err)
+	} else if personalAccessToken == "" {
 		return nil, ErrPersonalAccessTokenRequired
 	}
 
@@ -159,8 +168,10 @@ func (p *Provider) graphql(ctx context.Context, query string, out any) (http.Hea
 	if username == "" {
 		return nil, ErrUsernameRequired
 	}
-	personalAccessToken := p.cfg.personalAccessToken
-	if personalAccessToken == "" {
+	personalAccessToken, err := p.cfg.getPersonalAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("github: error reading personal access token: %w", err)
+	} else if personalAccessToken == "" {
 		return nil, ErrPersonalAccessTokenRequired
 	}
 
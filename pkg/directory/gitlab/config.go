@@ -3,11 +3,14 @@ package gitlab
 import (
 	"net/http"
 	"net/url"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/secretfile"
+	"github.com/pomerium/datasource/pkg/directory"
 )
 
 var defaultURL = &url.URL{
@@ -16,15 +19,35 @@ var defaultURL = &url.URL{
 }
 
 type config struct {
-	httpClient   *http.Client
-	logger       zerolog.Logger
-	privateToken string
-	url          *url.URL
+	concurrency         int
+	flattenNestedGroups bool
+	httpClient          *http.Client
+	httpClientOnce      sync.Once
+	logger              zerolog.Logger
+	privateToken        string
+	privateTokenFile    *secretfile.File
+	retryHTTPClient     *http.Client
+	url                 *url.URL
 }
 
 // An Option updates the gitlab configuration.
 type Option func(cfg *config)
 
+// WithConcurrency sets the number of groups whose members are fetched concurrently.
+func WithConcurrency(concurrency int) Option {
+	return func(cfg *config) {
+		cfg.concurrency = concurrency
+	}
+}
+
+// WithFlattenNestedGroups sets whether members of nested groups are also
+// made members of all the ancestor groups.
+func WithFlattenNestedGroups(flattenNestedGroups bool) Option {
+	return func(cfg *config) {
+		cfg.flattenNestedGroups = flattenNestedGroups
+	}
+}
+
 // WithHTTPClient sets the http client option.
 func WithHTTPClient(httpClient *http.Client) Option {
 	return func(cfg *config) {
@@ -46,6 +69,18 @@ func WithPrivateToken(privateToken string) Option {
 	}
 }
 
+// WithPrivateTokenFile sets the file to read the private token from in the
+// config. The file is re-read when it changes, and takes precedence over the
+// private token.
+func WithPrivateTokenFile(name string) Option {
+	return func(cfg *config) {
+		cfg.privateTokenFile = nil
+		if name != "" {
+			cfg.privateTokenFile = secretfile.New(name)
+		}
+	}
+}
+
 // WithURL sets the api url in the config.
 func WithURL(u *url.URL) Option {
 	return func(cfg *config) {
@@ -55,6 +90,7 @@ func WithURL(u *url.URL) Option {
 
 func getConfig(options ...Option) *config {
 	cfg := new(config)
+	WithConcurrency(directory.DefaultConcurrency)(cfg)
 	WithHTTPClient(http.DefaultClient)(cfg)
 	WithLogger(log.Logger)(cfg)
 	WithURL(defaultURL)(cfg)
@@ -64,8 +100,18 @@ func getConfig(options ...Option) *config {
 	return cfg
 }
 
+func (cfg *config) getPrivateToken() (string, error) {
+	if cfg.privateTokenFile != nil {
+		return cfg.privateTokenFile.Read()
+	}
+	return cfg.privateToken, nil
+}
+
 func (cfg *config) getHTTPClient() *http.Client {
-	return httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
-		return event.Str("idp", "gitlab")
+	cfg.httpClientOnce.Do(func() {
+		cfg.retryHTTPClient = httputil.NewRetryClient(httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "gitlab")
+		}))
 	})
+	return cfg.retryHTTPClient
 }
@@ -2,9 +2,11 @@ package gitlab
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -12,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 )
 
 type M = map[string]interface{}
@@ -35,6 +38,7 @@ func newMockAPI(t *testing.T, _ *httptest.Server) http.Handler {
 			_ = json.NewEncoder(w).Encode([]M{
 				{"id": 1, "name": "Group 1"},
 				{"id": 2, "name": "Group 2"},
+				{"id": 3, "name": "Group 3", "parent_id": 2},
 			})
 		})
 		r.Get("/groups/{group_name}/members", func(w http.ResponseWriter, r *http.Request) {
@@ -46,6 +50,9 @@ func newMockAPI(t *testing.T, _ *httptest.Server) http.Handler {
 					{"id": 12, "name": "User 2", "email": "user2@example.com"},
 					{"id": 13, "name": "User 3", "email": "user3@example.com"},
 				},
+				"3": {
+					{"id": 14, "name": "User 4", "email": "user4@example.com"},
+				},
 			}
 			_ = json.NewEncoder(w).Encode(members[chi.URLParam(r, "group_name")])
 		})
@@ -60,24 +67,42 @@ func Test(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		mockAPI.ServeHTTP(w, r)
 	}))
-	defer srv.Close()
+	t.Cleanup(srv.Close)
 	mockAPI = newMockAPI(t, srv)
 
-	p := New(
-		WithURL(mustParseURL(srv.URL)),
-		WithPrivateToken("PRIVATE_TOKEN"),
-	)
-	groups, users, err := p.GetDirectory(t.Context())
+	newProvider := func(options ...Option) *Provider {
+		return New(append([]Option{
+			WithURL(mustParseURL(srv.URL)),
+			WithPrivateToken("PRIVATE_TOKEN"),
+		}, options...)...)
+	}
+
+	groups, users, err := newProvider().GetDirectory(t.Context())
 	assert.NoError(t, err)
 	assert.Equal(t, []directory.Group{
 		{ID: "1", Name: "Group 1"},
 		{ID: "2", Name: "Group 2"},
+		{ID: "3", Name: "Group 3"},
 	}, groups)
 	assert.Equal(t, []directory.User{
 		{ID: "11", GroupIDs: []string{"1"}, DisplayName: "User 1", Email: "user1@example.com"},
 		{ID: "12", GroupIDs: []string{"2"}, DisplayName: "User 2", Email: "user2@example.com"},
 		{ID: "13", GroupIDs: []string{"2"}, DisplayName: "User 3", Email: "user3@example.com"},
+		{ID: "14", GroupIDs: []string{"3"}, DisplayName: "User 4", Email: "user4@example.com"},
 	}, users)
+
+	t.Run("flatten nested groups", func(t *testing.T) {
+		t.Parallel()
+
+		_, users, err := newProvider(WithFlattenNestedGroups(true)).GetDirectory(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, []directory.User{
+			{ID: "11", GroupIDs: []string{"1"}, DisplayName: "User 1", Email: "user1@example.com"},
+			{ID: "12", GroupIDs: []string{"2"}, DisplayName: "User 2", Email: "user2@example.com"},
+			{ID: "13", GroupIDs: []string{"2"}, DisplayName: "User 3", Email: "user3@example.com"},
+			{ID: "14", GroupIDs: []string{"2", "3"}, DisplayName: "User 4", Email: "user4@example.com"},
+		}, users)
+	})
 }
 
 func mustParseURL(rawurl string) *url.URL {
@@ -87,3 +112,57 @@ func mustParseURL(rawurl string) *url.URL {
 	}
 	return u
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: func(t *testing.T, fixture *directorytest.Fixture, pageSize int) http.Handler {
+			t.Helper()
+
+			paginate := func(w http.ResponseWriter, r *http.Request, items []M) {
+				page, nextURL := directorytest.Paginate(r, items, pageSize)
+				if nextURL != "" {
+					w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL))
+				}
+				if page == nil {
+					page = []M{}
+				}
+				_ = json.NewEncoder(w).Encode(page)
+			}
+
+			r := chi.NewRouter()
+			r.Get("/api/v4/groups", func(w http.ResponseWriter, r *http.Request) {
+				var groups []M
+				for _, g := range fixture.Groups {
+					group := M{"id": mustAtoi(g.ID), "name": g.Name}
+					if parentID, ok := fixture.GetParentGroupID(g.ID); ok {
+						group["parent_id"] = mustAtoi(parentID)
+					}
+					groups = append(groups, group)
+				}
+				paginate(w, r, groups)
+			})
+			r.Get("/api/v4/groups/{group_id}/members", func(w http.ResponseWriter, r *http.Request) {
+				var members []M
+				for _, u := range fixture.GetGroupUsers(chi.URLParam(r, "group_id")) {
+					members = append(members, M{"id": mustAtoi(u.ID), "name": u.Name, "email": u.Email})
+				}
+				paginate(w, r, members)
+			})
+			return r
+		},
+		NewProvider: func(_ *testing.T, url string) directory.Provider {
+			return New(WithURL(mustParseURL(url)), WithPrivateToken("PRIVATE_TOKEN"))
+		},
+		NestedGroups: directorytest.NestedGroupsIgnored,
+	})
+}
+
+func mustAtoi(s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/tomnomnom/linkheader"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -27,21 +28,27 @@ func New(options ...Option) *Provider {
 }
 
 // UserGroups gets the directory user groups for gitlab.
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
-	groups, err := p.listGroups(ctx)
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "gitlab.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
+	groups, groupLookup, err := p.listGroups(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groupMembers, err := directory.MapConcurrently(ctx, p.cfg.concurrency, groups,
+		func(ctx context.Context, group directory.Group) ([]apiUserObject, error) {
+			return p.listGroupMembers(ctx, group.ID)
+		})
 	if err != nil {
 		return nil, nil, err
 	}
 
 	userLookup := map[int]apiUserObject{}
 	userIDToGroupIDs := map[int][]string{}
-	for _, group := range groups {
-		users, err := p.listGroupMembers(ctx, group.ID)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		for _, u := range users {
+	for i, group := range groups {
+		for _, u := range groupMembers[i] {
 			userIDToGroupIDs[u.ID] = append(userIDToGroupIDs[u.ID], group.ID)
 			userLookup[u.ID] = u
 		}
@@ -63,23 +70,28 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 	sort.Slice(users, func(i, j int) bool {
 		return users[i].ID < users[j].ID
 	})
+	if p.cfg.flattenNestedGroups {
+		groupLookup.FlattenUsers(ctx, users)
+	}
 	return groups, users, nil
 }
 
-// listGroups returns a map, with key is group ID, element is group name.
-func (p *Provider) listGroups(ctx context.Context) ([]directory.Group, error) {
+// listGroups returns all the groups, along with a lookup of subgroups to their parent groups.
+func (p *Provider) listGroups(ctx context.Context) ([]directory.Group, *directory.GroupLookup, error) {
 	nextURL := p.cfg.url.ResolveReference(&url.URL{
 		Path: "/api/v4/groups",
 	}).String()
 	var groups []directory.Group
+	groupLookup := directory.NewGroupLookup()
 	for nextURL != "" {
 		var result []struct {
-			ID   int    `json:"id"`
-			Name string `json:"name"`
+			ID       int    `json:"id"`
+			Name     string `json:"name"`
+			ParentID *int   `json:"parent_id"`
 		}
 		hdrs, err := p.api(ctx, nextURL, &result)
 		if err != nil {
-			return nil, fmt.Errorf("gitlab: error querying groups: %w", err)
+			return nil, nil, fmt.Errorf("gitlab: error querying groups: %w", err)
 		}
 
 		for _, r := range result {
@@ -87,11 +99,17 @@ func (p *Provider) listGroups(ctx context.Context) ([]directory.Group, error) {
 				ID:   strconv.Itoa(r.ID),
 				Name: r.Name,
 			})
+			if r.ParentID != nil {
+				groupLookup.AddGroup(strconv.Itoa(*r.ParentID), []string{strconv.Itoa(r.ID)}, nil)
+			}
 		}
 
 		nextURL = getNextLink(hdrs)
 	}
-	return groups, nil
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID < groups[j].ID
+	})
+	return groups, groupLookup, nil
 }
 
 func (p *Provider) listGroupMembers(ctx context.Context, groupID string) (users []apiUserObject, err error) {
@@ -112,8 +130,10 @@ func (p *Provider) listGroupMembers(ctx context.Context, groupID string) (users
 }
 
 func (p *Provider) api(ctx context.Context, uri string, out interface{}) (http.Header, error) {
-	privateToken := p.cfg.privateToken
-	if privateToken == "" {
+	privateToken, err := p.cfg.getPrivateToken()
+	if err != nil {
+		return nil, fmt.Errorf("gitlab: error reading private token: %w", err)
+	} else if privateToken == "" {
 		return nil, ErrPrivateTokenRequired
 	}
 
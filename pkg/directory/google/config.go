@@ -1,10 +1,15 @@
 package google
 
 import (
+	"net/http"
 	"os"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+
+	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/pkg/directory"
 )
 
 const (
@@ -12,16 +17,44 @@ const (
 )
 
 type config struct {
-	logger          zerolog.Logger
-	impersonateUser string
-	jsonKey         []byte
-	jsonKeyFile     string
-	url             string
+	concurrency         int
+	flattenNestedGroups bool
+	httpClient          *http.Client
+	httpClientOnce      sync.Once
+	logger              zerolog.Logger
+	impersonateUser     string
+	jsonKey             []byte
+	jsonKeyFile         string
+	retryHTTPClient     *http.Client
+	url                 string
+	userAttributes      []string
 }
 
 // An Option changes the configuration for the Google directory provider.
 type Option func(cfg *config)
 
+// WithConcurrency sets the number of groups whose members are fetched concurrently.
+func WithConcurrency(concurrency int) Option {
+	return func(cfg *config) {
+		cfg.concurrency = concurrency
+	}
+}
+
+// WithFlattenNestedGroups sets whether members of nested groups are also
+// made members of all the ancestor groups.
+func WithFlattenNestedGroups(flattenNestedGroups bool) Option {
+	return func(cfg *config) {
+		cfg.flattenNestedGroups = flattenNestedGroups
+	}
+}
+
+// WithHTTPClient sets the http client in the config.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(cfg *config) {
+		cfg.httpClient = httpClient
+	}
+}
+
 // WithImpersonateUser sets the impersonate user in the config.
 func WithImpersonateUser(impersonateUser string) Option {
 	return func(cfg *config) {
@@ -50,6 +83,13 @@ func WithLogger(logger zerolog.Logger) Option {
 	}
 }
 
+// WithUserAttributes sets the names of the user attributes to include in the directory.
+func WithUserAttributes(userAttributes []string) Option {
+	return func(cfg *config) {
+		cfg.userAttributes = userAttributes
+	}
+}
+
 // WithURL sets the provider url to use.
 func WithURL(url string) Option {
 	return func(cfg *config) {
@@ -59,6 +99,8 @@ func WithURL(url string) Option {
 
 func getConfig(opts ...Option) *config {
 	cfg := new(config)
+	WithConcurrency(directory.DefaultConcurrency)(cfg)
+	WithHTTPClient(http.DefaultClient)(cfg)
 	WithLogger(log.Logger)(cfg)
 	WithURL(defaultProviderURL)(cfg)
 	for _, opt := range opts {
@@ -73,3 +115,12 @@ func (cfg *config) getJSONKey() ([]byte, error) {
 	}
 	return cfg.jsonKey, nil
 }
+
+func (cfg *config) getHTTPClient() *http.Client {
+	cfg.httpClientOnce.Do(func() {
+		cfg.retryHTTPClient = httputil.NewRetryClient(httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "google")
+		}))
+	})
+	return cfg.retryHTTPClient
+}
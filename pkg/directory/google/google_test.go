@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 )
 
 var privateKey = `
@@ -87,6 +89,7 @@ func newMockAPI(t *testing.T, _ *httptest.Server) http.Handler {
 						"groups": []M{
 							{"id": "group1", "directMembersCount": "2"},
 							{"id": "group2"},
+							{"id": "group3", "directMembersCount": "1"},
 						},
 					})
 				}
@@ -110,6 +113,16 @@ func newMockAPI(t *testing.T, _ *httptest.Server) http.Handler {
 							},
 						},
 					})
+				case "group3":
+					_ = json.NewEncoder(w).Encode(M{
+						"members": []M{
+							{
+								"kind": "admin#directory#member",
+								"id":   "group1",
+								"type": "GROUP",
+							},
+						},
+					})
 				}
 			})
 		})
@@ -122,6 +135,18 @@ func newMockAPI(t *testing.T, _ *httptest.Server) http.Handler {
 							"kind":         "admin#directory#user",
 							"id":           "inside-user1",
 							"primaryEmail": "user1@inside.test",
+							"customSchemas": M{
+								"Employment": M{
+									"department": "engineering",
+									"title":      "engineer",
+								},
+							},
+						},
+						{
+							"kind":         "admin#directory#user",
+							"id":           "inside-user2",
+							"primaryEmail": "user2@inside.test",
+							"suspended":    true,
 						},
 					},
 				})
@@ -158,34 +183,150 @@ func TestProvider_GetDirectory(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		mockAPI.ServeHTTP(w, r)
 	}))
-	defer srv.Close()
+	t.Cleanup(srv.Close)
 	mockAPI = newMockAPI(t, srv)
 
-	p := New(
-		WithImpersonateUser("IMPERSONATE_USER"),
-		WithJSONKey(encodeJSON(map[string]any{
-			"type":        "service_account",
-			"private_key": privateKey,
-			"token_uri":   srv.URL + "/token",
-		})),
-		WithURL(srv.URL),
-	)
-
-	dgs, dus, err := p.GetDirectory(ctx)
+	newProvider := func(options ...Option) *Provider {
+		return New(append([]Option{
+			WithImpersonateUser("IMPERSONATE_USER"),
+			WithJSONKey(encodeJSON(map[string]any{
+				"type":        "service_account",
+				"private_key": privateKey,
+				"token_uri":   srv.URL + "/token",
+			})),
+			WithURL(srv.URL),
+		}, options...)...)
+	}
+
+	dgs, dus, err := newProvider().GetDirectory(ctx)
 	if !assert.NoError(t, err) {
 		return
 	}
 
 	assert.Equal(t, []directory.Group{
 		{ID: "group1"},
+		{ID: "group3"},
 	}, dgs)
 	assert.Equal(t, []directory.User{
-		{ID: "inside-user1", Email: "user1@inside.test", GroupIDs: []string{"group1"}},
+		{ID: "inside-user1", Email: "user1@inside.test", GroupIDs: []string{"group1"}, Status: directory.UserStatusActive},
+		{ID: "inside-user2", Email: "user2@inside.test", Status: directory.UserStatusInactive},
 		{ID: "outside-user1", Email: "user1@outside.test", GroupIDs: []string{"group1"}},
 	}, dus)
+
+	t.Run("flatten nested groups", func(t *testing.T) {
+		t.Parallel()
+
+		_, dus, err := newProvider(WithFlattenNestedGroups(true)).GetDirectory(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, []directory.User{
+			{ID: "inside-user1", Email: "user1@inside.test", GroupIDs: []string{"group1", "group3"}, Status: directory.UserStatusActive},
+			{ID: "inside-user2", Email: "user2@inside.test", Status: directory.UserStatusInactive},
+			{ID: "outside-user1", Email: "user1@outside.test", GroupIDs: []string{"group1", "group3"}},
+		}, dus)
+	})
+	t.Run("user attributes", func(t *testing.T) {
+		t.Parallel()
+
+		_, dus, err := newProvider(WithUserAttributes([]string{"Employment.department"})).GetDirectory(ctx)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, []directory.User{
+			{
+				ID: "inside-user1", Email: "user1@inside.test", GroupIDs: []string{"group1"},
+				Status: directory.UserStatusActive, Attributes: map[string]any{"Employment.department": "engineering"},
+			},
+			{ID: "inside-user2", Email: "user2@inside.test", Status: directory.UserStatusInactive},
+			{ID: "outside-user1", Email: "user1@outside.test", GroupIDs: []string{"group1"}},
+		}, dus)
+	})
 }
 
 func encodeJSON(data any) []byte {
 	bs, _ := json.Marshal(data)
 	return bs
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: func(t *testing.T, fixture *directorytest.Fixture, pageSize int) http.Handler {
+			t.Helper()
+
+			// page tokens are the index of the first item of the page
+			paginate := func(w http.ResponseWriter, r *http.Request, key string, items []M) {
+				start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+				end := min(start+pageSize, len(items))
+				res := M{key: items[start:end]}
+				if end < len(items) {
+					res["nextPageToken"] = strconv.Itoa(end)
+				}
+				_ = json.NewEncoder(w).Encode(res)
+			}
+
+			r := chi.NewRouter()
+			r.Post("/token", func(w http.ResponseWriter, _ *http.Request) {
+				_ = json.NewEncoder(w).Encode(M{
+					"access_token": "ACCESSTOKEN",
+					"token_type":   "Bearer",
+					"expires_in":   3600,
+				})
+			})
+			r.Get("/admin/directory/v1/groups", func(w http.ResponseWriter, r *http.Request) {
+				var groups []M
+				for _, g := range fixture.Groups {
+					groups = append(groups, M{
+						"id":                 g.ID,
+						"email":              g.ID + "@example.com",
+						"name":               g.Name,
+						"directMembersCount": strconv.Itoa(len(g.UserIDs) + len(g.GroupIDs) + len(g.OtherIDs)),
+					})
+				}
+				paginate(w, r, "groups", groups)
+			})
+			r.Get("/admin/directory/v1/groups/{group_key}/members", func(w http.ResponseWriter, r *http.Request) {
+				g, _ := fixture.GetGroup(chi.URLParam(r, "group_key"))
+				var members []M
+				for _, u := range fixture.GetGroupUsers(g.ID) {
+					members = append(members, M{"id": u.ID, "email": u.Email, "type": "USER"})
+				}
+				for _, id := range g.GroupIDs {
+					members = append(members, M{"id": id, "type": "GROUP"})
+				}
+				for _, id := range g.OtherIDs {
+					members = append(members, M{"id": id, "type": "CUSTOMER"})
+				}
+				paginate(w, r, "members", members)
+			})
+			r.Get("/admin/directory/v1/users", func(w http.ResponseWriter, r *http.Request) {
+				var users []M
+				for _, u := range fixture.Users {
+					users = append(users, M{"id": u.ID, "primaryEmail": u.Email, "name": M{"fullName": u.Name}})
+				}
+				paginate(w, r, "users", users)
+			})
+			return r
+		},
+		NewProvider: func(_ *testing.T, url string) directory.Provider {
+			return New(
+				WithImpersonateUser("IMPERSONATE_USER"),
+				WithJSONKey(encodeJSON(map[string]any{
+					"type":        "service_account",
+					"private_key": privateKey,
+					"token_uri":   url + "/token",
+				})),
+				WithURL(url),
+			)
+		},
+		IsTokenRequest: func(r *http.Request) bool {
+			return r.URL.Path == "/token"
+		},
+		NestedGroups:   directorytest.NestedGroupsIgnored,
+		UnknownMembers: true,
+	})
+}
@@ -1,16 +1,23 @@
 package google
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"sort"
 	"sync"
 
 	"github.com/hashicorp/go-multierror"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
 	admin "google.golang.org/api/admin/directory/v1"
 	"google.golang.org/api/option"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -26,6 +33,12 @@ type Provider struct {
 
 	mu        sync.RWMutex
 	apiClient *admin.Service
+	// apiClientJSONKey is the json key the api client was created with
+	apiClientJSONKey []byte
+
+	tokenSourceMu sync.Mutex
+	tokenConfig   *jwt.Config
+	tokenSource   oauth2.TokenSource
 }
 
 // New creates a new Google directory provider.
@@ -39,7 +52,10 @@ func New(options ...Option) *Provider {
 // NOTE: groups via Directory API is limited to 1 QPS!
 // https://developers.google.com/admin-sdk/directory/v1/reference/groups/list
 // https://developers.google.com/admin-sdk/directory/v1/limits
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "google.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
 	apiClient, err := p.getAPIClient(ctx)
 	if err != nil {
 		return nil, nil, fmt.Errorf("google: error getting API client: %w", err)
@@ -67,50 +83,76 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 	if err != nil {
 		return nil, nil, fmt.Errorf("google: error getting groups: %w", err)
 	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID < groups[j].ID
+	})
 
 	// query all the user members for each group
 	// - create a lookup table for the user (storing id and name)
 	//   (this includes users who aren't necessarily members of the same organization)
 	// - create a lookup table for the user's groups
+	groupMembers, err := directory.MapConcurrently(ctx, p.cfg.concurrency, groups,
+		func(ctx context.Context, group directory.Group) ([]*admin.Member, error) {
+			var members []*admin.Member
+			err := apiClient.Members.List(group.ID).
+				Context(ctx).
+				Pages(ctx, func(res *admin.Members) error {
+					members = append(members, res.Members...)
+					return nil
+				})
+			return members, err
+		})
+	if err != nil {
+		return nil, nil, fmt.Errorf("google: error getting group members: %w", err)
+	}
+
 	userLookup := map[string]apiUserObject{}
 	userIDToGroups := map[string][]string{}
-	for _, group := range groups {
-		group := group
-		err = apiClient.Members.List(group.ID).
-			Context(ctx).
-			Pages(ctx, func(res *admin.Members) error {
-				for _, member := range res.Members {
-					// only include user objects
-					if member.Type != "USER" {
-						continue
-					}
-
-					userLookup[member.Id] = apiUserObject{
-						ID:    member.Id,
-						Email: member.Email,
-					}
-					userIDToGroups[member.Id] = append(userIDToGroups[member.Id], group.ID)
-				}
-				return nil
-			})
-		if err != nil {
-			return nil, nil, fmt.Errorf("google: error getting group members: %w", err)
+	groupLookup := directory.NewGroupLookup()
+	for i, group := range groups {
+		for _, member := range groupMembers[i] {
+			// nested groups are only used for flattening
+			if member.Type == "GROUP" {
+				groupLookup.AddGroup(group.ID, []string{member.Id}, nil)
+				continue
+			}
+
+			// only include user objects
+			if member.Type != "USER" {
+				continue
+			}
+
+			userLookup[member.Id] = apiUserObject{
+				ID:    member.Id,
+				Email: member.Email,
+			}
+			userIDToGroups[member.Id] = append(userIDToGroups[member.Id], group.ID)
 		}
 	}
 
 	// query all the users in the organization
-	err = apiClient.Users.List().
+	usersCall := apiClient.Users.List().
 		Context(ctx).
-		Customer(currentAccountCustomerID).
+		Customer(currentAccountCustomerID)
+	if len(p.cfg.userAttributes) > 0 {
+		// custom schemas are only returned with the full projection
+		usersCall = usersCall.Projection("full")
+	}
+	err = usersCall.
 		Pages(ctx, func(res *admin.Users) error {
 			for _, u := range res.Users {
 				auo := apiUserObject{
-					ID:    u.Id,
-					Email: u.PrimaryEmail,
+					ID:     u.Id,
+					Email:  u.PrimaryEmail,
+					Status: directory.UserStatusActive,
+				}
+				if u.Suspended || u.Archived {
+					auo.Status = directory.UserStatusInactive
 				}
 				if u.Name != nil {
 					auo.DisplayName = u.Name.FullName
 				}
+				auo.Attributes = directory.SelectAttributes(getCustomSchemaAttributes(u), p.cfg.userAttributes)
 				userLookup[u.Id] = auo
 			}
 			return nil
@@ -128,11 +170,16 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 			GroupIDs:    groups,
 			DisplayName: u.DisplayName,
 			Email:       u.Email,
+			Status:      u.Status,
+			Attributes:  u.Attributes,
 		})
 	}
 	sort.Slice(users, func(i, j int) bool {
 		return users[i].ID < users[j].ID
 	})
+	if p.cfg.flattenNestedGroups {
+		groupLookup.FlattenUsers(ctx, users)
+	}
 	return groups, users, nil
 }
 
@@ -150,15 +197,20 @@ func (p *Provider) getAPIClient(ctx context.Context) (*admin.Service, error) {
 
 	p.mu.RLock()
 	apiClient := p.apiClient
+	apiClientJSONKey := p.apiClientJSONKey
 	p.mu.RUnlock()
-	if apiClient != nil {
+	if apiClient != nil && bytes.Equal(apiClientJSONKey, jsonKey) {
 		return apiClient, nil
 	}
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if p.apiClient != nil {
-		return p.apiClient, nil
+		if bytes.Equal(p.apiClientJSONKey, jsonKey) {
+			return p.apiClient, nil
+		}
+		// the json key was rotated, so create a new api client with it
+		p.cfg.logger.Info().Str("idp", "google").Msg("json key changed, refreshing token")
 	}
 
 	config, err := google.JWTConfigFromJSON(jsonKey, apiScopes...)
@@ -167,19 +219,104 @@ func (p *Provider) getAPIClient(ctx context.Context) (*admin.Service, error) {
 	}
 	config.Subject = impersonateUser
 
-	ts := config.TokenSource(ctx)
+	p.tokenSourceMu.Lock()
+	p.tokenConfig = config
+	p.tokenSource = p.newTokenSource(config)
+	p.tokenSourceMu.Unlock()
 
+	// the http client is shared, so wrap its transport in a new client
+	client := p.cfg.getHTTPClient()
 	p.apiClient, err = admin.NewService(ctx,
-		option.WithTokenSource(ts),
+		option.WithHTTPClient(&http.Client{
+			Transport: &unauthorizedTransport{
+				p: p,
+				base: &oauth2.Transport{
+					Source: currentTokenSource{p: p},
+					Base:   client.Transport,
+				},
+			},
+			Timeout: client.Timeout,
+		}),
 		option.WithEndpoint(p.cfg.url))
 	if err != nil {
 		return nil, fmt.Errorf("google: failed creating admin service %w", err)
 	}
+	p.apiClientJSONKey = jsonKey
 	return p.apiClient, nil
 }
 
+// newTokenSource returns a token source for the jwt config. Token requests use
+// the shared http client, so they're retried and recorded in the metrics too.
+func (p *Provider) newTokenSource(config *jwt.Config) oauth2.TokenSource {
+	return config.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, p.cfg.getHTTPClient()))
+}
+
+// invalidateToken replaces the token source, so the next request gets a new access token.
+func (p *Provider) invalidateToken() {
+	p.tokenSourceMu.Lock()
+	defer p.tokenSourceMu.Unlock()
+
+	if p.tokenConfig != nil {
+		p.tokenSource = p.newTokenSource(p.tokenConfig)
+	}
+}
+
+// A currentTokenSource returns tokens from the provider's current token source.
+type currentTokenSource struct {
+	p *Provider
+}
+
+func (ts currentTokenSource) Token() (*oauth2.Token, error) {
+	ts.p.tokenSourceMu.Lock()
+	tokenSource := ts.p.tokenSource
+	ts.p.tokenSourceMu.Unlock()
+
+	if tokenSource == nil {
+		return nil, fmt.Errorf("no token source")
+	}
+	return tokenSource.Token()
+}
+
+// An unauthorizedTransport retries a request with a new access token when the
+// api responds with a 401, like when the token was revoked before it expired.
+type unauthorizedTransport struct {
+	p    *Provider
+	base http.RoundTripper
+}
+
+func (t *unauthorizedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	// only requests without a body can be sent again
+	if err != nil || res.StatusCode != http.StatusUnauthorized || req.Body != nil {
+		return res, err
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+
+	t.p.invalidateToken()
+	return t.base.RoundTrip(req)
+}
+
 type apiUserObject struct {
 	ID          string
 	DisplayName string
 	Email       string
+	Status      directory.UserStatus
+	Attributes  map[string]any
+}
+
+// getCustomSchemaAttributes returns the custom schema fields of a user,
+// keyed by "{schemaName}.{fieldName}".
+func getCustomSchemaAttributes(u *admin.User) map[string]any {
+	attributes := map[string]any{}
+	for schemaName, raw := range u.CustomSchemas {
+		var fields map[string]any
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			continue
+		}
+		for fieldName, value := range fields {
+			attributes[schemaName+"."+fieldName] = value
+		}
+	}
+	return attributes
 }
@@ -0,0 +1,218 @@
+package directory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+type stringSet map[string]struct{}
+
+func newStringSet() stringSet {
+	return make(stringSet)
+}
+
+func (ss stringSet) add(value string) {
+	ss[value] = struct{}{}
+}
+
+func (ss stringSet) has(value string) bool {
+	if ss == nil {
+		return false
+	}
+
+	_, ok := ss[value]
+	return ok
+}
+
+func (ss stringSet) sorted() []string {
+	if ss == nil {
+		return nil
+	}
+
+	s := make([]string, 0, len(ss))
+	for v := range ss {
+		s = append(s, v)
+	}
+	sort.Strings(s)
+	return s
+}
+
+type stringSetSet map[string]stringSet
+
+func newStringSetSet() stringSetSet {
+	return make(stringSetSet)
+}
+
+func (sss stringSetSet) add(v1, v2 string) {
+	ss, ok := sss[v1]
+	if !ok {
+		ss = newStringSet()
+		sss[v1] = ss
+	}
+	ss.add(v2)
+}
+
+func (sss stringSetSet) get(v1 string) stringSet {
+	return sss[v1]
+}
+
+// A GroupLookup resolves transitive group membership. Providers feed it the
+// direct members of each group, both users and other groups, and it returns
+// every group a user belongs to, directly or through nested groups.
+//
+// Cycles in the group graph are detected and each group is only visited once.
+type GroupLookup struct {
+	childUserIDToParentGroupID  stringSetSet
+	childGroupIDToParentGroupID stringSetSet
+}
+
+// NewGroupLookup creates a new GroupLookup.
+func NewGroupLookup() *GroupLookup {
+	return &GroupLookup{
+		childUserIDToParentGroupID:  newStringSetSet(),
+		childGroupIDToParentGroupID: newStringSetSet(),
+	}
+}
+
+// AddGroup adds the direct child groups and users of a group.
+func (l *GroupLookup) AddGroup(parentGroupID string, childGroupIDs, childUserIDs []string) {
+	for _, childGroupID := range childGroupIDs {
+		l.childGroupIDToParentGroupID.add(childGroupID, parentGroupID)
+	}
+	for _, childUserID := range childUserIDs {
+		l.childUserIDToParentGroupID.add(childUserID, parentGroupID)
+	}
+}
+
+// GetUserIDs returns the sorted ids of all the users that are a member of a group.
+func (l *GroupLookup) GetUserIDs() []string {
+	s := make([]string, 0, len(l.childUserIDToParentGroupID))
+	for userID := range l.childUserIDToParentGroupID {
+		s = append(s, userID)
+	}
+	sort.Strings(s)
+	return s
+}
+
+// GetGroupIDsForUser returns the sorted ids of all the groups a user is a member of,
+// including the ancestors of the groups the user is a direct member of.
+func (l *GroupLookup) GetGroupIDsForUser(userID string) []string {
+	var groupIDs []string
+	for groupID := range l.childUserIDToParentGroupID.get(userID) {
+		groupIDs = append(groupIDs, groupID)
+	}
+	return l.ExpandGroupIDs(groupIDs)
+}
+
+// ExpandGroupIDs returns the sorted ids of the given groups and all of their ancestors.
+func (l *GroupLookup) ExpandGroupIDs(groupIDs []string) []string {
+	expanded := newStringSet()
+	todo := append([]string(nil), groupIDs...)
+	for len(todo) > 0 {
+		groupID := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if expanded.has(groupID) {
+			continue
+		}
+
+		expanded.add(groupID)
+		for parentGroupID := range l.childGroupIDToParentGroupID.get(groupID) {
+			todo = append(todo, parentGroupID)
+		}
+	}
+
+	return expanded.sorted()
+}
+
+// FlattenUsers replaces the group ids of each user with the groups they are
+// a direct member of and all of their ancestors. If ctx has a NestingRecorder,
+// the direct groups of the users and the nesting of the groups are recorded.
+func (l *GroupLookup) FlattenUsers(ctx context.Context, users []User) {
+	if r, ok := ctx.Value(nestingRecorderKey{}).(*NestingRecorder); ok {
+		r.record(l, users)
+	}
+	for i := range users {
+		if len(users[i].GroupIDs) == 0 {
+			continue
+		}
+		users[i].GroupIDs = l.ExpandGroupIDs(users[i].GroupIDs)
+	}
+}
+
+type nestingRecorderKey struct{}
+
+// A NestingRecorder records how providers flatten nested groups, so that
+// memberships users inherit through nested groups can be explained.
+type NestingRecorder struct {
+	mu      sync.Mutex
+	nesting []nesting
+}
+
+type nesting struct {
+	lookup         *GroupLookup
+	directGroupIDs map[string][]string
+}
+
+// WithNestingRecorder returns a context that makes providers record how they
+// flatten nested groups in r.
+func WithNestingRecorder(ctx context.Context, r *NestingRecorder) context.Context {
+	return context.WithValue(ctx, nestingRecorderKey{}, r)
+}
+
+func (r *NestingRecorder) record(l *GroupLookup, users []User) {
+	directGroupIDs := make(map[string][]string, len(users))
+	for _, u := range users {
+		directGroupIDs[u.ID] = slices.Clone(u.GroupIDs)
+	}
+
+	r.mu.Lock()
+	r.nesting = append(r.nesting, nesting{lookup: l, directGroupIDs: directGroupIDs})
+	r.mu.Unlock()
+}
+
+// Path returns the groups through which a user is a member of a group, from a
+// group the user is a direct member of to the group itself. It returns an
+// empty path if the user is a direct member of the group, and false if the
+// membership wasn't recorded.
+func (r *NestingRecorder) Path(userID, groupID string) ([]string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, n := range r.nesting {
+		directGroupIDs, ok := n.directGroupIDs[userID]
+		if !ok {
+			continue
+		}
+		if slices.Contains(directGroupIDs, groupID) {
+			return nil, true
+		}
+
+		// search breadth first from the direct groups, so the shortest path is found
+		child := map[string]string{}
+		todo := slices.Sorted(slices.Values(directGroupIDs))
+		for _, id := range todo {
+			child[id] = ""
+		}
+		for len(todo) > 0 {
+			id := todo[0]
+			todo = todo[1:]
+			if id == groupID {
+				var path []string
+				for ; id != ""; id = child[id] {
+					path = append(path, id)
+				}
+				slices.Reverse(path)
+				return path, true
+			}
+			for _, parentID := range n.lookup.childGroupIDToParentGroupID.get(id).sorted() {
+				if _, seen := child[parentID]; !seen {
+					child[parentID] = id
+					todo = append(todo, parentID)
+				}
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,65 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupLookup(t *testing.T) {
+	t.Parallel()
+
+	gl := NewGroupLookup()
+
+	gl.AddGroup("g1", []string{"g11", "g12", "g13"}, []string{"u1"})
+	gl.AddGroup("g11", []string{"g111"}, nil)
+	gl.AddGroup("g111", nil, []string{"u2"})
+
+	assert.Equal(t, []string{"u1", "u2"}, gl.GetUserIDs())
+	assert.Equal(t, []string{"g1", "g11", "g111"}, gl.GetGroupIDsForUser("u2"))
+	assert.Equal(t, []string{"g1", "g13", "g2"}, gl.ExpandGroupIDs([]string{"g2", "g13"}))
+
+	users := []User{
+		{ID: "u3", GroupIDs: []string{"g111"}},
+		{ID: "u4"},
+	}
+	gl.FlattenUsers(t.Context(), users)
+	assert.Equal(t, []User{
+		{ID: "u3", GroupIDs: []string{"g1", "g11", "g111"}},
+		{ID: "u4"},
+	}, users)
+
+	t.Run("nesting recorder", func(t *testing.T) {
+		t.Parallel()
+
+		gl := NewGroupLookup()
+		gl.AddGroup("g1", []string{"g11"}, nil)
+		gl.AddGroup("g11", []string{"g111"}, nil)
+		gl.AddGroup("g2", []string{"g111"}, nil)
+
+		var r NestingRecorder
+		gl.FlattenUsers(WithNestingRecorder(t.Context(), &r), []User{
+			{ID: "u1", GroupIDs: []string{"g111"}},
+		})
+
+		path, ok := r.Path("u1", "g111")
+		assert.True(t, ok)
+		assert.Empty(t, path, "should be a direct membership")
+		path, ok = r.Path("u1", "g1")
+		assert.True(t, ok)
+		assert.Equal(t, []string{"g111", "g11", "g1"}, path)
+		path, ok = r.Path("u1", "g2")
+		assert.True(t, ok)
+		assert.Equal(t, []string{"g111", "g2"}, path)
+		_, ok = r.Path("u2", "g1")
+		assert.False(t, ok)
+	})
+	t.Run("cycle protection", func(t *testing.T) {
+		t.Parallel()
+
+		gl.AddGroup("g12", []string{"g1"}, nil)
+
+		assert.Equal(t, []string{"u1", "u2"}, gl.GetUserIDs())
+		assert.Equal(t, []string{"g1", "g11", "g111", "g12"}, gl.GetGroupIDsForUser("u2"))
+	})
+}
@@ -0,0 +1,100 @@
+package directory
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMaxDeletePercent is the default maximum percentage of users, groups or
+// memberships that may be removed between two snapshots. It's 0, so deletions
+// aren't limited unless a limit is set.
+const DefaultMaxDeletePercent = 0
+
+// ErrTooManyDeletions indicates that a snapshot removes more of the directory
+// than the deletion guard allows.
+var ErrTooManyDeletions = errors.New("directory: too many deletions")
+
+type deletionGuardConfig struct {
+	maxDeleteCount   int
+	maxDeletePercent float64
+}
+
+// A DeletionGuardOption customizes the deletion guard config.
+type DeletionGuardOption func(cfg *deletionGuardConfig)
+
+// WithMaxDeleteCount sets the maximum number of users, groups or memberships
+// that may be removed. A value of 0 means no limit.
+func WithMaxDeleteCount(maxDeleteCount int) DeletionGuardOption {
+	return func(cfg *deletionGuardConfig) {
+		cfg.maxDeleteCount = maxDeleteCount
+	}
+}
+
+// WithMaxDeletePercent sets the maximum percentage of users, groups or
+// memberships that may be removed. A value of 0 means no limit.
+func WithMaxDeletePercent(maxDeletePercent float64) DeletionGuardOption {
+	return func(cfg *deletionGuardConfig) {
+		cfg.maxDeletePercent = maxDeletePercent
+	}
+}
+
+func getDeletionGuardConfig(options ...DeletionGuardOption) *deletionGuardConfig {
+	cfg := new(deletionGuardConfig)
+	WithMaxDeletePercent(DefaultMaxDeletePercent)(cfg)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
+
+// A DeletionGuard protects against publishing a snapshot that drops a large
+// part of the directory, as happens when an identity provider returns a
+// partial result.
+type DeletionGuard struct {
+	cfg *deletionGuardConfig
+}
+
+// NewDeletionGuard creates a new DeletionGuard.
+func NewDeletionGuard(options ...DeletionGuardOption) *DeletionGuard {
+	return &DeletionGuard{cfg: getDeletionGuardConfig(options...)}
+}
+
+// Check compares the new snapshot with the old one and returns an error
+// wrapping ErrTooManyDeletions if more users, groups or memberships were
+// removed than allowed.
+func (g *DeletionGuard) Check(oldGroups []Group, oldUsers []User, newGroups []Group, newUsers []User) error {
+	var oldMemberships int
+	for _, u := range oldUsers {
+		oldMemberships += len(u.GroupIDs)
+	}
+
+	removed := map[ChangeType]int{}
+	for _, change := range Diff(oldGroups, oldUsers, newGroups, newUsers) {
+		removed[change.Type]++
+	}
+
+	var errs []error
+	for _, check := range []struct {
+		name    string
+		total   int
+		removed int
+	}{
+		{"groups", len(oldGroups), removed[ChangeTypeGroupRemoved]},
+		{"users", len(oldUsers), removed[ChangeTypeUserRemoved]},
+		{"memberships", oldMemberships, removed[ChangeTypeMembershipRemoved]},
+	} {
+		if check.removed == 0 {
+			continue
+		}
+
+		percent := float64(check.removed) * 100 / float64(check.total)
+		if g.cfg.maxDeleteCount > 0 && check.removed > g.cfg.maxDeleteCount {
+			errs = append(errs, fmt.Errorf("%w: %d of %d %s would be removed, more than the limit of %d",
+				ErrTooManyDeletions, check.removed, check.total, check.name, g.cfg.maxDeleteCount))
+		} else if g.cfg.maxDeletePercent > 0 && percent > g.cfg.maxDeletePercent {
+			errs = append(errs, fmt.Errorf("%w: %d of %d %s (%.1f%%) would be removed, more than the limit of %g%%",
+				ErrTooManyDeletions, check.removed, check.total, check.name, percent, g.cfg.maxDeletePercent))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,36 @@
+package directory
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletionGuard(t *testing.T) {
+	t.Parallel()
+
+	var groups []Group
+	var users []User
+	for i := range 10 {
+		groups = append(groups, Group{ID: fmt.Sprint("g", i)})
+		users = append(users, User{ID: fmt.Sprint("u", i), GroupIDs: []string{"g0"}})
+	}
+
+	assert.NoError(t, NewDeletionGuard().Check(groups, users, groups[:1], nil),
+		"should not limit deletions by default")
+
+	guard := NewDeletionGuard(WithMaxDeletePercent(20))
+	assert.NoError(t, guard.Check(groups, users, groups, users))
+	assert.NoError(t, guard.Check(groups, users, groups[:8], users[:8]),
+		"should allow removing up to the percentage")
+	assert.NoError(t, guard.Check(nil, nil, groups, users))
+
+	err := guard.Check(groups, users, groups, users[:7])
+	assert.ErrorIs(t, err, ErrTooManyDeletions)
+	assert.EqualError(t, err, "directory: too many deletions: 3 of 10 users (30.0%) would be removed, more than the limit of 20%\n"+
+		"directory: too many deletions: 3 of 10 memberships (30.0%) would be removed, more than the limit of 20%")
+
+	err = NewDeletionGuard(WithMaxDeleteCount(1)).Check(groups, users, groups[:8], users)
+	assert.EqualError(t, err, "directory: too many deletions: 2 of 10 groups would be removed, more than the limit of 1")
+}
@@ -7,22 +7,64 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/tracing"
 )
 
-type handler struct {
+// A Handler serves directory users and groups over HTTP.
+//
+// The handler keeps the last successfully retrieved snapshot of the directory in
+// memory and serves it to every request. Snapshots are refreshed in the background
+// by Run, or on demand when a request sees a snapshot that is due for a refresh.
+//
+// Every refresh that changes the directory records a ChangeEvent, which is served
+// by the /changes endpoint and posted to the configured webhooks.
+type Handler struct {
+	cfg      *handlerConfig
 	router   *chi.Mux
 	provider Provider
+	changes  *changeFeed
+
+	refreshMu sync.Mutex
+	current   atomic.Pointer[snapshot]
+	failure   atomic.Pointer[refreshFailure]
+	running   atomic.Bool
+}
+
+// refreshFailure records a failed refresh, so refreshes back off until retryAt.
+type refreshFailure struct {
+	err      error
+	attempts int
+	retryAt  time.Time
+}
+
+type snapshot struct {
+	bundle    *httputil.BundleFile
+	hash      uint64
+	createdAt time.Time
+	refreshAt time.Time
 }
 
 // NewHandler creates a new Handler.
-func NewHandler(provider Provider) http.Handler {
-	h := &handler{provider: provider}
+func NewHandler(provider Provider, options ...HandlerOption) *Handler {
+	cfg := getHandlerConfig(options...)
+	h := &Handler{
+		cfg:      cfg,
+		provider: provider,
+		changes:  newChangeFeed(cfg.changeHistory),
+	}
 	h.router = chi.NewMux()
+	h.router.Get("/changes", h.serveChanges)
 	h.router.Get("/*", func(w http.ResponseWriter, r *http.Request) {
 		err := h.serve(r.Context(), w, r)
 		if err != nil {
@@ -33,14 +75,133 @@ func NewHandler(provider Provider) http.Handler {
 }
 
 // ServeHTTP serves an HTTP request with directory users and groups.
-func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.router.ServeHTTP(w, r)
 }
 
-func (h *handler) serve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+// Run refreshes the directory snapshot on the configured refresh interval until
+// the context is canceled. If the refresh interval is not positive, snapshots are
+// refreshed on every request instead and Run just waits for the context to finish.
+func (h *Handler) Run(ctx context.Context) error {
+	if h.cfg.refreshInterval <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	h.running.Store(true)
+	defer h.running.Store(false)
+
+	for {
+		wait := jitter(h.cfg.refreshInterval)
+		if s := h.current.Load(); s == nil || !time.Now().Before(s.refreshAt) {
+			_, err := h.refresh(ctx, s)
+			if err != nil && ctx.Err() == nil {
+				log.Ctx(ctx).Error().Err(err).Msg("directory: error refreshing directory data")
+			}
+		}
+		if f := h.failure.Load(); f != nil {
+			wait = time.Until(f.retryAt)
+		} else if s := h.current.Load(); s != nil && time.Until(s.refreshAt) > 0 {
+			wait = time.Until(s.refreshAt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (h *Handler) serve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	s, err := h.getSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	return httputil.ServeContent(w, r, "bundle.zip", s.hash, s.bundle.Reader())
+}
+
+// getSnapshot returns the current snapshot, refreshing it if it's due. If the
+// refresh fails, the previous snapshot is returned as long as it isn't stale.
+//
+// The snapshot isn't refreshed inline while Run refreshes it in the background,
+// or while refreshes are backing off after a failure, so an upstream outage or
+// rate limit doesn't make every request wait for a sync.
+func (h *Handler) getSnapshot(ctx context.Context) (*snapshot, error) {
+	s := h.current.Load()
+	if s != nil && time.Now().Before(s.refreshAt) {
+		return s, nil
+	}
+
+	var err error
+	if f := h.failure.Load(); f != nil && time.Now().Before(f.retryAt) {
+		err = f.err
+	} else if s == nil || !h.running.Load() {
+		var next *snapshot
+		next, err = h.refresh(ctx, s)
+		if err == nil {
+			return next, nil
+		}
+		if s != nil {
+			log.Ctx(ctx).Error().Err(err).
+				Time("snapshot-created-at", s.createdAt).
+				Msg("directory: error refreshing directory data, serving previous snapshot")
+		}
+	}
+
+	if s == nil {
+		return nil, err
+	}
+	if h.cfg.maxStaleness > 0 && time.Since(s.createdAt) > h.cfg.maxStaleness {
+		if err == nil {
+			err = fmt.Errorf("directory data is older than %s", h.cfg.maxStaleness)
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// refresh retrieves the directory data from the provider and stores a new
+// snapshot. If another caller replaced prev while waiting for the lock, that
+// snapshot is returned instead.
+func (h *Handler) refresh(ctx context.Context, prev *snapshot) (s *snapshot, err error) {
+	h.refreshMu.Lock()
+	defer h.refreshMu.Unlock()
+
+	if s := h.current.Load(); s != prev {
+		return s, nil
+	}
+	// another caller may have failed while waiting for the lock
+	if f := h.failure.Load(); f != nil && time.Now().Before(f.retryAt) {
+		return nil, f.err
+	}
+	defer func() {
+		if err == nil {
+			h.failure.Store(nil)
+			return
+		}
+		attempts := 1
+		if f := h.failure.Load(); f != nil {
+			attempts = f.attempts + 1
+		}
+		h.failure.Store(&refreshFailure{
+			err:      err,
+			attempts: attempts,
+			retryAt:  time.Now().Add(refreshBackoff(attempts)),
+		})
+	}()
+
+	start := time.Now()
+	defer func() { h.cfg.metrics.RecordSync(h.cfg.name, start, err) }()
+
+	ctx, span := tracing.Start(ctx, "directory.Sync", attribute.String("source", h.cfg.name))
+	defer func() { tracing.End(span, err) }()
+
 	groups, users, err := h.provider.GetDirectory(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get directory data: %w", err)
+		return nil, fmt.Errorf("failed to get directory data: %w", err)
 	}
 
 	// don't serve null, but an empty array instead
@@ -51,23 +212,93 @@ func (h *handler) serve(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		users = make([]User, 0)
 	}
 
-	return httputil.ServeBundle(w, r, map[string]any{
-		GroupRecordType: groups,
-		UserRecordType:  users,
+	var hash uint64
+	bundle, err := httputil.NewBundleFile(func(w io.Writer) error {
+		var err error
+		hash, err = httputil.EncodeBundle(ctx, w, map[string]any{
+			GroupRecordType: groups,
+			UserRecordType:  users,
+		}, httputil.WithBundleSource(h.cfg.name))
+		return err
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	// keep serving the same bytes while the records are unchanged, so the
+	// manifest generation time doesn't change the content behind the ETag
+	if prev != nil && prev.hash == hash {
+		bundle = prev.bundle
+	}
+
+	h.cfg.metrics.SetRecordCount(h.cfg.name, GroupRecordType, len(groups))
+	h.cfg.metrics.SetRecordCount(h.cfg.name, UserRecordType, len(users))
+	h.cfg.metrics.SetBundleSize(h.cfg.name, int(bundle.Size()))
+
+	now := time.Now()
+	s = &snapshot{
+		bundle:    bundle,
+		hash:      hash,
+		createdAt: now,
+		refreshAt: now.Add(jitter(h.cfg.refreshInterval)),
+	}
+	h.current.Store(s)
+
+	// the first snapshot has nothing to compare against, and snapshots only
+	// keep the encoded bundle, so the previous records are decoded from it
+	if prev != nil && prev.hash != s.hash {
+		prevGroups, prevUsers, err := decodeBundle(prev.bundle.Reader(), prev.bundle.Size())
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("directory: error decoding previous snapshot, skipping change event")
+		} else if changes := Diff(prevGroups, prevUsers, groups, users); len(changes) > 0 {
+			h.notify(ctx, h.changes.add(now, changes))
+		}
+	}
+
+	return s, nil
+}
+
+// refreshBackoff returns how long to wait before refreshing again after a
+// number of failed attempts.
+func refreshBackoff(attempts int) time.Duration {
+	backoff := minRefreshBackoff
+	for range attempts - 1 {
+		backoff *= 2
+		if backoff >= maxRefreshBackoff {
+			return jitter(maxRefreshBackoff)
+		}
+	}
+	return jitter(backoff)
+}
+
+// jitter randomly adjusts a duration by up to the refresh jitter fraction in either direction.
+func jitter(d time.Duration) time.Duration {
+	delta := time.Duration(float64(d) * refreshJitter)
+	if delta <= 0 {
+		return d
+	}
+	return d - delta + rand.N(2*delta)
 }
 
-func decodeBundle(r io.Reader) (groups []Group, users []User, err error) {
+// DecodeBundle decodes the groups and users from a directory bundle.
+func DecodeBundle(r io.Reader) (groups []Group, users []User, err error) {
 	bs, err := io.ReadAll(r)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read zip file: %w", err)
 	}
+	return decodeBundle(bytes.NewReader(bs), int64(len(bs)))
+}
 
-	zr, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
+func decodeBundle(r io.ReaderAt, size int64) (groups []Group, users []User, err error) {
+	zr, err := zip.NewReader(r, size)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to open zip file for reading: %w", err)
 	}
 
+	manifest, err := httputil.ReadBundleManifest(zr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+
 	for _, file := range []struct {
 		name string
 		ptr  any
@@ -90,5 +321,14 @@ func decodeBundle(r io.Reader) (groups []Group, users []User, err error) {
 			return nil, nil, fmt.Errorf("failed to close file %s: %w", file.name, err)
 		}
 	}
+
+	if manifest != nil {
+		if n := manifest.Records[GroupRecordType].Count; n != len(groups) {
+			return nil, nil, fmt.Errorf("invalid bundle: expected %d groups, got %d", n, len(groups))
+		}
+		if n := manifest.Records[UserRecordType].Count; n != len(users) {
+			return nil, nil, fmt.Errorf("invalid bundle: expected %d users, got %d", n, len(users))
+		}
+	}
 	return groups, users, nil
 }
@@ -1,15 +1,24 @@
 package directory
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pomerium/datasource/internal/httputil"
 )
 
 func TestHandler(t *testing.T) {
@@ -55,13 +64,22 @@ func TestHandler(t *testing.T) {
 		defer res.Body.Close()
 
 		assert.Equal(t, 200, res.StatusCode)
-		assert.Equal(t, `"d3b7677a8420759f"`, res.Header.Get("ETag"))
-		groups, users, err := decodeBundle(res.Body)
+		bs, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		zr, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
+		require.NoError(t, err)
+		manifest, err := httputil.ReadBundleManifest(zr)
+		require.NoError(t, err)
+		assert.Equal(t, 3, manifest.Records[GroupRecordType].Count)
+		assert.Equal(t, 3, manifest.Records[UserRecordType].Count)
+		etag := fmt.Sprintf(`"%x"`, manifest.Hash())
+		assert.Equal(t, etag, res.Header.Get("ETag"))
+		groups, users, err := DecodeBundle(bytes.NewReader(bs))
 		assert.NoError(t, err)
 		assert.Equal(t, expect.groups, groups)
 		assert.Equal(t, expect.users, users)
 
-		req.Header.Set("If-None-Match", `"d3b7677a8420759f"`)
+		req.Header.Set("If-None-Match", etag)
 		res, err = http.DefaultClient.Do(req)
 		if !assert.NoError(t, err) {
 			return
@@ -125,10 +143,307 @@ func TestHandler(t *testing.T) {
 		defer res.Body.Close()
 
 		assert.Equal(t, 200, res.StatusCode)
-		groups, users, err := decodeBundle(res.Body)
+		groups, users, err := DecodeBundle(res.Body)
 		assert.NoError(t, err)
 
 		assert.NotNil(t, groups)
 		assert.NotNil(t, users)
 	})
 }
+
+func TestHandlerSnapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	get := func(t *testing.T, srv *httptest.Server) (*http.Response, []Group, error) {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			bs, _ := io.ReadAll(res.Body)
+			return res, nil, errors.New(strings.TrimSpace(string(bs)))
+		}
+		groups, _, err := DecodeBundle(res.Body)
+		return res, groups, err
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+		h := NewHandler(ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+			n := calls.Add(1)
+			return []Group{{ID: fmt.Sprint("group", n)}}, nil, nil
+		}), WithRefreshInterval(time.Hour))
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+
+		res1, groups1, err := get(t, srv)
+		require.NoError(t, err)
+		res2, groups2, err := get(t, srv)
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(1), calls.Load())
+		assert.Equal(t, []Group{{ID: "group1"}}, groups1)
+		assert.Equal(t, groups1, groups2)
+		assert.Equal(t, res1.Header.Get("ETag"), res2.Header.Get("ETag"))
+	})
+	t.Run("stale", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+		h := NewHandler(ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+			if calls.Add(1) > 1 {
+				return nil, nil, errors.New("ERROR")
+			}
+			return []Group{{ID: "group1"}}, nil, nil
+		}), WithRefreshInterval(time.Nanosecond), WithMaxStaleness(time.Hour))
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+
+		_, groups, err := get(t, srv)
+		require.NoError(t, err)
+		assert.Equal(t, []Group{{ID: "group1"}}, groups)
+
+		_, groups, err = get(t, srv)
+		require.NoError(t, err)
+		assert.Equal(t, []Group{{ID: "group1"}}, groups, "should serve the previous snapshot")
+		assert.Equal(t, int64(2), calls.Load())
+
+		_, groups, err = get(t, srv)
+		require.NoError(t, err)
+		assert.Equal(t, []Group{{ID: "group1"}}, groups)
+		assert.Equal(t, int64(2), calls.Load(), "should back off after a failed refresh")
+	})
+	t.Run("backoff", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+		h := NewHandler(ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+			calls.Add(1)
+			return nil, nil, errors.New("ERROR")
+		}))
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+
+		for range 3 {
+			res, _, err := get(t, srv)
+			assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+			assert.EqualError(t, err, "failed to get directory data: ERROR")
+		}
+		assert.Equal(t, int64(1), calls.Load(), "should not retry until the backoff expires")
+	})
+	t.Run("too stale", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+		h := NewHandler(ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+			if calls.Add(1) > 1 {
+				return nil, nil, errors.New("ERROR")
+			}
+			return []Group{{ID: "group1"}}, nil, nil
+		}), WithRefreshInterval(time.Nanosecond), WithMaxStaleness(time.Nanosecond))
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+
+		_, _, err := get(t, srv)
+		require.NoError(t, err)
+
+		time.Sleep(time.Millisecond)
+		res, _, err := get(t, srv)
+		assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+		assert.EqualError(t, err, "failed to get directory data: ERROR")
+	})
+	t.Run("run", func(t *testing.T) {
+		t.Parallel()
+
+		var calls atomic.Int64
+		h := NewHandler(ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+			calls.Add(1)
+			return nil, nil, nil
+		}), WithRefreshInterval(10*time.Millisecond))
+
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- h.Run(ctx) }()
+
+		assert.Eventually(t, func() bool {
+			return calls.Load() >= 3
+		}, 5*time.Second, time.Millisecond)
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	t.Run("run in background", func(t *testing.T) {
+		t.Parallel()
+
+		block := make(chan struct{})
+		var calls atomic.Int64
+		h := NewHandler(ProviderFunc(func(ctx context.Context) ([]Group, []User, error) {
+			if calls.Add(1) > 1 {
+				select {
+				case <-block:
+				case <-ctx.Done():
+				}
+				return nil, nil, ctx.Err()
+			}
+			return []Group{{ID: "group1"}}, nil, nil
+		}), WithRefreshInterval(10*time.Millisecond), WithMaxStaleness(0))
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+		t.Cleanup(func() { close(block) })
+
+		ctx, cancel := context.WithCancel(ctx)
+		t.Cleanup(cancel)
+		go func() { _ = h.Run(ctx) }()
+
+		// wait for Run to start a refresh that never finishes
+		assert.Eventually(t, func() bool {
+			return calls.Load() >= 2
+		}, 5*time.Second, time.Millisecond)
+
+		_, groups, err := get(t, srv)
+		require.NoError(t, err)
+		assert.Equal(t, []Group{{ID: "group1"}}, groups,
+			"should serve the snapshot without waiting for the background refresh")
+	})
+}
+
+func TestHandlerChanges(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+
+	secret := []byte("SECRET")
+	webhooks := make(chan ChangeEvent, 10)
+	webhookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if !assert.NoError(t, VerifyWebhook(secret, r.Header.Get(WebhookSignatureHeader), bs, time.Minute)) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var evt ChangeEvent
+		require.NoError(t, json.Unmarshal(bs, &evt))
+		webhooks <- evt
+	}))
+	t.Cleanup(webhookSrv.Close)
+
+	var users atomic.Pointer[[]User]
+	users.Store(&[]User{{ID: "u1", GroupIDs: []string{"g1"}}})
+	h := NewHandler(ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+		return []Group{{ID: "g1"}}, *users.Load(), nil
+	}),
+		WithRefreshInterval(time.Nanosecond),
+		WithChangeHistory(1),
+		WithWebhook(webhookSrv.URL, secret))
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	get := func(t *testing.T, path string) (int, changesResponse) {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		var body changesResponse
+		if res.StatusCode == http.StatusOK && strings.HasPrefix(path, "/changes") {
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		}
+		return res.StatusCode, body
+	}
+
+	get(t, "/")
+	status, body := get(t, "/changes")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, changesResponse{Cursor: 0, Events: []ChangeEvent{}}, body)
+
+	users.Store(&[]User{})
+	get(t, "/")
+	status, body = get(t, "/changes?cursor=0")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, uint64(1), body.Cursor)
+	if assert.Len(t, body.Events, 1) {
+		assert.Equal(t, []Change{
+			{Type: ChangeTypeMembershipRemoved, GroupID: "g1", UserID: "u1"},
+			{Type: ChangeTypeUserRemoved, UserID: "u1"},
+		}, body.Events[0].Changes)
+	}
+
+	select {
+	case evt := <-webhooks:
+		assert.Equal(t, uint64(1), evt.Cursor)
+		assert.Equal(t, body.Events[0].Changes, evt.Changes)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook")
+	}
+
+	users.Store(&[]User{{ID: "u2", GroupIDs: []string{"g1"}}})
+	get(t, "/")
+	status, body = get(t, "/changes?cursor=1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, uint64(2), body.Cursor)
+	assert.Len(t, body.Events, 1)
+
+	status, _ = get(t, "/changes?cursor=0")
+	assert.Equal(t, http.StatusGone, status, "should reject discarded cursors")
+	status, _ = get(t, "/changes?cursor=3")
+	assert.Equal(t, http.StatusGone, status, "should reject future cursors")
+	status, _ = get(t, "/changes?cursor=x")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+type testMetricsRecorder struct {
+	syncs      []error
+	counts     map[string]int
+	bundleSize int
+}
+
+func (r *testMetricsRecorder) RecordSync(_ string, _ time.Time, err error) {
+	r.syncs = append(r.syncs, err)
+}
+
+func (r *testMetricsRecorder) SetRecordCount(_, recordType string, count int) {
+	r.counts[recordType] = count
+}
+
+func (r *testMetricsRecorder) SetBundleSize(_ string, size int) {
+	r.bundleSize = size
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	t.Parallel()
+
+	errUpstream := errors.New("UPSTREAM")
+	fail := true
+	recorder := &testMetricsRecorder{counts: map[string]int{}}
+	h := NewHandler(ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+		if fail {
+			return nil, nil, errUpstream
+		}
+		return []Group{{ID: "g1"}}, []User{{ID: "u1"}, {ID: "u2"}}, nil
+	}), WithMetricsRecorder(recorder))
+
+	_, err := h.refresh(t.Context(), nil)
+	assert.ErrorIs(t, err, errUpstream)
+	h.failure.Store(nil)
+	fail = false
+	s, err := h.refresh(t.Context(), nil)
+	require.NoError(t, err)
+
+	if assert.Len(t, recorder.syncs, 2) {
+		assert.ErrorIs(t, recorder.syncs[0], errUpstream)
+		assert.NoError(t, recorder.syncs[1])
+	}
+	assert.Equal(t, map[string]int{GroupRecordType: 1, UserRecordType: 2}, recorder.counts)
+	assert.Equal(t, int(s.bundle.Size()), recorder.bundleSize)
+}
@@ -2,11 +2,14 @@ package keycloak
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/secretfile"
+	"github.com/pomerium/datasource/pkg/directory"
 )
 
 const (
@@ -17,13 +20,18 @@ const (
 )
 
 type config struct {
-	batchSize    int
-	httpClient   *http.Client
-	clientID     string
-	clientSecret string
-	logger       zerolog.Logger
-	realm        string
-	url          string
+	flattenNestedGroups bool
+	batchSize           int
+	concurrency         int
+	httpClient          *http.Client
+	httpClientOnce      sync.Once
+	clientID            string
+	clientSecret        string
+	clientSecretFile    *secretfile.File
+	logger              zerolog.Logger
+	realm               string
+	retryHTTPClient     *http.Client
+	url                 string
 }
 
 // An Option configures the Keycloak Provider.
@@ -50,6 +58,25 @@ func WithClientSecret(clientSecret string) Option {
 	}
 }
 
+// WithClientSecretFile sets the file to read the client secret from in the
+// config. The file is re-read when it changes, and takes precedence over the
+// client secret.
+func WithClientSecretFile(name string) Option {
+	return func(cfg *config) {
+		cfg.clientSecretFile = nil
+		if name != "" {
+			cfg.clientSecretFile = secretfile.New(name)
+		}
+	}
+}
+
+// WithConcurrency sets the number of groups whose members are fetched concurrently.
+func WithConcurrency(concurrency int) Option {
+	return func(cfg *config) {
+		cfg.concurrency = concurrency
+	}
+}
+
 // WithRealm sets the realm in the config.
 func WithRealm(realm string) Option {
 	return func(cfg *config) {
@@ -57,6 +84,14 @@ func WithRealm(realm string) Option {
 	}
 }
 
+// WithFlattenNestedGroups sets whether members of nested groups are also
+// made members of all the ancestor groups.
+func WithFlattenNestedGroups(flattenNestedGroups bool) Option {
+	return func(cfg *config) {
+		cfg.flattenNestedGroups = flattenNestedGroups
+	}
+}
+
 // WithHTTPClient sets the http client in the config.
 func WithHTTPClient(httpClient *http.Client) Option {
 	return func(cfg *config) {
@@ -81,6 +116,7 @@ func WithURL(url string) Option {
 func getConfig(options ...Option) *config {
 	cfg := new(config)
 	WithBatchSize(DefaultBatchSize)(cfg)
+	WithConcurrency(directory.DefaultConcurrency)(cfg)
 	WithHTTPClient(http.DefaultClient)(cfg)
 	WithLogger(log.Logger)(cfg)
 	WithRealm(DefaultRealm)(cfg)
@@ -90,8 +126,18 @@ func getConfig(options ...Option) *config {
 	return cfg
 }
 
+func (cfg *config) getClientSecret() (string, error) {
+	if cfg.clientSecretFile != nil {
+		return cfg.clientSecretFile.Read()
+	}
+	return cfg.clientSecret, nil
+}
+
 func (cfg *config) getHTTPClient() *http.Client {
-	return httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
-		return event.Str("idp", "keycloak")
+	cfg.httpClientOnce.Do(func() {
+		cfg.retryHTTPClient = httputil.NewRetryClient(httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "keycloak")
+		}))
 	})
+	return cfg.retryHTTPClient
 }
@@ -13,8 +13,10 @@ import (
 )
 
 type apiGroup struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	SubGroupCount int        `json:"subGroupCount"`
+	SubGroups     []apiGroup `json:"subGroups"`
 }
 
 type apiUser struct {
@@ -35,6 +37,18 @@ func listGroups(
 	return list[apiGroup](ctx, client, apiURL, batchSize)
 }
 
+func listSubGroups(
+	ctx context.Context,
+	client *http.Client,
+	baseURL string,
+	realm string,
+	groupID string,
+	batchSize int,
+) iter.Seq2[apiGroup, error] {
+	apiURL := joinURL(baseURL, "/admin/realms/"+url.PathEscape(realm)+"/groups/"+url.PathEscape(groupID)+"/children")
+	return list[apiGroup](ctx, client, apiURL, batchSize)
+}
+
 func listGroupMembers(
 	ctx context.Context,
 	client *http.Client,
@@ -5,12 +5,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 	"github.com/pomerium/datasource/pkg/directory/keycloak"
 )
 
@@ -26,9 +28,14 @@ func newMockAPI(t *testing.T, srv *httptest.Server) http.Handler {
 		M{"id": "g1", "name": "group-1"},
 		M{"id": "g2", "name": "group-2"},
 		M{"id": "g3", "name": "group-3"},
-		M{"id": "g4", "name": "group-4"},
+		M{"id": "g4", "name": "group-4", "subGroupCount": 1},
 		M{"id": "g5", "name": "group-5"},
 	}
+	children := map[string]A{
+		"g4": {M{"id": "g6", "name": "group-6", "subGroupCount": 1, "subGroups": A{
+			M{"id": "g7", "name": "group-7"},
+		}}},
+	}
 	users := A{
 		M{"id": "u1", "email": "u1@example.com", "emailVerified": true, "username": "user-1"},
 		M{"id": "u2", "email": "u2@example.com", "emailVerified": true, "username": "user-2"},
@@ -39,6 +46,8 @@ func newMockAPI(t *testing.T, srv *httptest.Server) http.Handler {
 		"g1": {M{"id": "u1"}, M{"id": "u2"}},
 		"g2": {M{"id": "u3"}},
 		"g3": {M{"id": "u1"}, M{"id": "u2"}, M{"id": "u3"}},
+		"g6": {M{"id": "u4"}},
+		"g7": {M{"id": "u2"}},
 	}
 
 	sendList := func(w http.ResponseWriter, r *http.Request, lst A) {
@@ -77,20 +86,11 @@ func newMockAPI(t *testing.T, srv *httptest.Server) http.Handler {
 	mux.HandleFunc("GET /admin/realms/REALM/groups", func(w http.ResponseWriter, r *http.Request) {
 		sendList(w, r, groups)
 	})
-	mux.HandleFunc("GET /admin/realms/REALM/groups/g1/members", func(w http.ResponseWriter, r *http.Request) {
-		sendList(w, r, lookup["g1"])
-	})
-	mux.HandleFunc("GET /admin/realms/REALM/groups/g2/members", func(w http.ResponseWriter, r *http.Request) {
-		sendList(w, r, lookup["g2"])
-	})
-	mux.HandleFunc("GET /admin/realms/REALM/groups/g3/members", func(w http.ResponseWriter, r *http.Request) {
-		sendList(w, r, lookup["g3"])
-	})
-	mux.HandleFunc("GET /admin/realms/REALM/groups/g4/members", func(w http.ResponseWriter, r *http.Request) {
-		sendList(w, r, lookup["g4"])
+	mux.HandleFunc("GET /admin/realms/REALM/groups/{id}/children", func(w http.ResponseWriter, r *http.Request) {
+		sendList(w, r, children[r.PathValue("id")])
 	})
-	mux.HandleFunc("GET /admin/realms/REALM/groups/g5/members", func(w http.ResponseWriter, r *http.Request) {
-		sendList(w, r, lookup["g5"])
+	mux.HandleFunc("GET /admin/realms/REALM/groups/{id}/members", func(w http.ResponseWriter, r *http.Request) {
+		sendList(w, r, lookup[r.PathValue("id")])
 	})
 	mux.HandleFunc("GET /admin/realms/REALM/users", func(w http.ResponseWriter, r *http.Request) {
 		sendList(w, r, users)
@@ -108,17 +108,20 @@ func TestKeyCloak(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		mockAPI.ServeHTTP(w, r)
 	}))
-	defer srv.Close()
+	t.Cleanup(srv.Close)
 	mockAPI = newMockAPI(t, srv)
 
-	k := keycloak.New(
-		keycloak.WithBatchSize(3),
-		keycloak.WithClientID("CLIENT_ID"),
-		keycloak.WithClientSecret("CLIENT_SECRET"),
-		keycloak.WithRealm("REALM"),
-		keycloak.WithURL(srv.URL),
-	)
-	dgs, dus, err := k.GetDirectory(t.Context())
+	newProvider := func(options ...keycloak.Option) *keycloak.Provider {
+		return keycloak.New(append([]keycloak.Option{
+			keycloak.WithBatchSize(3),
+			keycloak.WithClientID("CLIENT_ID"),
+			keycloak.WithClientSecret("CLIENT_SECRET"),
+			keycloak.WithRealm("REALM"),
+			keycloak.WithURL(srv.URL),
+		}, options...)...)
+	}
+
+	dgs, dus, err := newProvider().GetDirectory(t.Context())
 	assert.NoError(t, err)
 	assert.Equal(t, []directory.Group{
 		{ID: "g1", Name: "group-1"},
@@ -133,4 +136,49 @@ func TestKeyCloak(t *testing.T) {
 		{ID: "u3", DisplayName: "user-3", Email: "u3@example.com", GroupIDs: []string{"g2", "g3"}},
 		{ID: "u4", DisplayName: "user-4"},
 	}, dus)
+
+	t.Run("flatten nested groups", func(t *testing.T) {
+		t.Parallel()
+
+		dgs, dus, err := newProvider(keycloak.WithFlattenNestedGroups(true)).GetDirectory(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, []directory.Group{
+			{ID: "g1", Name: "group-1"},
+			{ID: "g2", Name: "group-2"},
+			{ID: "g3", Name: "group-3"},
+			{ID: "g4", Name: "group-4"},
+			{ID: "g5", Name: "group-5"},
+			{ID: "g6", Name: "group-6"},
+			{ID: "g7", Name: "group-7"},
+		}, dgs)
+		assert.Equal(t, []directory.User{
+			{ID: "u1", DisplayName: "user-1", Email: "u1@example.com", GroupIDs: []string{"g1", "g3"}},
+			{ID: "u2", DisplayName: "user-2", Email: "u2@example.com", GroupIDs: []string{"g1", "g3", "g4", "g6", "g7"}},
+			{ID: "u3", DisplayName: "user-3", Email: "u3@example.com", GroupIDs: []string{"g2", "g3"}},
+			{ID: "u4", DisplayName: "user-4", GroupIDs: []string{"g4", "g6"}},
+		}, dus)
+	})
+}
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: directorytest.NewFakeIDPUpstream,
+		NewProvider: func(_ *testing.T, url string) directory.Provider {
+			return keycloak.New(
+				keycloak.WithBatchSize(directorytest.DefaultPageSize),
+				keycloak.WithClientID("CLIENT_ID"),
+				keycloak.WithClientSecret("CLIENT_SECRET"),
+				keycloak.WithFlattenNestedGroups(true),
+				keycloak.WithRealm("REALM"),
+				keycloak.WithURL(url),
+			)
+		},
+		IsTokenRequest: func(r *http.Request) bool {
+			return strings.HasSuffix(r.URL.Path, "/.well-known/openid-configuration") ||
+				strings.HasSuffix(r.URL.Path, "/protocol/openid-connect/token")
+		},
+		NestedGroups: directorytest.NestedGroupsFlattened,
+	})
 }
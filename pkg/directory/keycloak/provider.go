@@ -4,6 +4,7 @@ import (
 	"cmp"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"slices"
@@ -14,6 +15,7 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -22,7 +24,10 @@ type Provider struct {
 	cfg *config
 
 	tokenSourceMu sync.Mutex
+	tokenConfig   *clientcredentials.Config
 	tokenSource   oauth2.TokenSource
+	// tokenSourceClientSecret is the client secret the token source uses
+	tokenSourceClientSecret string
 }
 
 // New creates a new provider.
@@ -33,13 +38,17 @@ func New(options ...Option) *Provider {
 }
 
 // GetDirectory returns the directory information for a Keycloak realm.
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "keycloak.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
 	client, err := p.getHTTPClient(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	var dgs []directory.Group
+	var todo []apiGroup
 	for g, err := range listGroups(ctx, client, p.cfg.url, p.cfg.realm, p.cfg.batchSize) {
 		if err != nil {
 			return nil, nil, err
@@ -49,18 +58,61 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 			ID:   g.ID,
 			Name: g.Name,
 		})
+		todo = append(todo, g)
+	}
+
+	// subgroups are only included when flattening nested groups
+	nestedGroups := directory.NewGroupLookup()
+	seen := map[string]struct{}{}
+	for p.cfg.flattenNestedGroups && len(todo) > 0 {
+		g := todo[0]
+		todo = todo[1:]
+		if _, ok := seen[g.ID]; ok {
+			continue
+		}
+		seen[g.ID] = struct{}{}
+
+		subGroups, err := p.getSubGroups(ctx, client, g)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var childGroupIDs []string
+		for _, sg := range subGroups {
+			childGroupIDs = append(childGroupIDs, sg.ID)
+			if _, ok := seen[sg.ID]; !ok {
+				dgs = append(dgs, directory.Group{
+					ID:   sg.ID,
+					Name: sg.Name,
+				})
+				todo = append(todo, sg)
+			}
+		}
+		nestedGroups.AddGroup(g.ID, childGroupIDs, nil)
 	}
 	slices.SortFunc(dgs, func(dg1, dg2 directory.Group) int {
 		return cmp.Compare(dg1.ID, dg2.ID)
 	})
 
-	groupLookup := map[string][]string{}
-	for _, dg := range dgs {
-		for u, err := range listGroupMembers(ctx, client, p.cfg.url, p.cfg.realm, dg.ID, p.cfg.batchSize) {
-			if err != nil {
-				return nil, nil, err
+	groupMemberIDs, err := directory.MapConcurrently(ctx, p.cfg.concurrency, dgs,
+		func(ctx context.Context, dg directory.Group) ([]string, error) {
+			var userIDs []string
+			for u, err := range listGroupMembers(ctx, client, p.cfg.url, p.cfg.realm, dg.ID, p.cfg.batchSize) {
+				if err != nil {
+					return nil, err
+				}
+				userIDs = append(userIDs, u.ID)
 			}
-			groupLookup[u.ID] = append(groupLookup[u.ID], dg.ID)
+			return userIDs, nil
+		})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groupLookup := map[string][]string{}
+	for i, dg := range dgs {
+		for _, userID := range groupMemberIDs[i] {
+			groupLookup[userID] = append(groupLookup[userID], dg.ID)
 		}
 	}
 
@@ -84,10 +136,30 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 	slices.SortFunc(dus, func(du1, du2 directory.User) int {
 		return cmp.Compare(du1.ID, du2.ID)
 	})
+	if p.cfg.flattenNestedGroups {
+		nestedGroups.FlattenUsers(ctx, dus)
+	}
 
 	return dgs, dus, nil
 }
 
+// getSubGroups returns the direct subgroups of a group. Older versions of Keycloak
+// return subgroups inline, newer versions require querying them separately.
+func (p *Provider) getSubGroups(ctx context.Context, client *http.Client, g apiGroup) ([]apiGroup, error) {
+	if len(g.SubGroups) > 0 || g.SubGroupCount == 0 {
+		return g.SubGroups, nil
+	}
+
+	var subGroups []apiGroup
+	for sg, err := range listSubGroups(ctx, client, p.cfg.url, p.cfg.realm, g.ID, p.cfg.batchSize) {
+		if err != nil {
+			return nil, err
+		}
+		subGroups = append(subGroups, sg)
+	}
+	return subGroups, nil
+}
+
 func (p *Provider) getHTTPClient(ctx context.Context) (*http.Client, error) {
 	p.tokenSourceMu.Lock()
 	defer p.tokenSourceMu.Unlock()
@@ -98,7 +170,10 @@ func (p *Provider) getHTTPClient(ctx context.Context) (*http.Client, error) {
 	if p.cfg.clientID == "" {
 		return nil, fmt.Errorf("client id is required")
 	}
-	if p.cfg.clientSecret == "" {
+	clientSecret, err := p.cfg.getClientSecret()
+	if err != nil {
+		return nil, fmt.Errorf("error reading client secret: %w", err)
+	} else if clientSecret == "" {
 		return nil, fmt.Errorf("client secret is required")
 	}
 	if p.cfg.url == "" {
@@ -107,27 +182,89 @@ func (p *Provider) getHTTPClient(ctx context.Context) (*http.Client, error) {
 
 	client := p.cfg.getHTTPClient()
 
+	// the client secret was rotated, so drop the tokens for the old one
+	if p.tokenSource != nil && p.tokenSourceClientSecret != clientSecret {
+		p.cfg.logger.Info().Str("idp", "keycloak").Msg("client secret changed, refreshing token")
+		p.tokenSource = nil
+	}
+
 	// set up the token source for oauth
 	if p.tokenSource == nil {
-		tokenSourceCtx := context.Background()
-		tokenSourceCtx = oidc.ClientContext(tokenSourceCtx, client)
-
-		oidcProvider, err := oidc.NewProvider(tokenSourceCtx,
+		// discover the token url with the sync's context, so it can be canceled
+		oidcProvider, err := oidc.NewProvider(oidc.ClientContext(ctx, client),
 			joinURL(p.cfg.url, "/realms/"+url.PathEscape(p.cfg.realm)))
 		if err != nil {
 			return nil, fmt.Errorf("error creating oidc provider (url=%s): %w", p.cfg.url, err)
 		}
 
 		e := oidcProvider.Endpoint()
-		p.tokenSource = (&clientcredentials.Config{
+		p.tokenConfig = &clientcredentials.Config{
 			ClientID:     p.cfg.clientID,
-			ClientSecret: p.cfg.clientSecret,
+			ClientSecret: clientSecret,
 			TokenURL:     e.TokenURL,
 			AuthStyle:    oauth2.AuthStyleInParams,
-		}).TokenSource(tokenSourceCtx)
+		}
+		p.tokenSource = p.tokenConfig.TokenSource(oidc.ClientContext(context.Background(), client))
+		p.tokenSourceClientSecret = clientSecret
+	}
+
+	// the http client is shared, so wrap its transport in a new client
+	return &http.Client{
+		Transport: &unauthorizedTransport{
+			p: p,
+			base: &oauth2.Transport{
+				Source: currentTokenSource{p: p},
+				Base:   client.Transport,
+			},
+		},
+		Timeout: client.Timeout,
+	}, nil
+}
+
+// invalidateToken replaces the token source, so the next request gets a new access token.
+func (p *Provider) invalidateToken() {
+	p.tokenSourceMu.Lock()
+	defer p.tokenSourceMu.Unlock()
+
+	if p.tokenConfig != nil {
+		p.tokenSource = p.tokenConfig.TokenSource(oidc.ClientContext(context.Background(), p.cfg.getHTTPClient()))
+	}
+}
+
+// A currentTokenSource returns tokens from the provider's current token source.
+type currentTokenSource struct {
+	p *Provider
+}
+
+func (ts currentTokenSource) Token() (*oauth2.Token, error) {
+	ts.p.tokenSourceMu.Lock()
+	tokenSource := ts.p.tokenSource
+	ts.p.tokenSourceMu.Unlock()
+
+	if tokenSource == nil {
+		return nil, fmt.Errorf("no token source")
+	}
+	return tokenSource.Token()
+}
+
+// An unauthorizedTransport retries a request with a new access token when the
+// api responds with a 401, like when the token was revoked before it expired.
+type unauthorizedTransport struct {
+	p    *Provider
+	base http.RoundTripper
+}
+
+func (t *unauthorizedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	// only requests without a body can be sent again
+	if err != nil || res.StatusCode != http.StatusUnauthorized || req.Body != nil {
+		return res, err
 	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
 
-	return oauth2.NewClient(oidc.ClientContext(ctx, client), p.tokenSource), nil
+	t.p.invalidateToken()
+	return t.base.RoundTrip(req)
 }
 
 func joinURL(base, path string) string {
@@ -0,0 +1,20 @@
+package directory
+
+import "time"
+
+// A MetricsRecorder records metrics about the syncs of a handler. Sources are
+// the handler names, see WithName.
+type MetricsRecorder interface {
+	// RecordSync records a sync with the provider that started at start.
+	RecordSync(source string, start time.Time, err error)
+	// SetRecordCount sets the number of records of a type returned by the last sync.
+	SetRecordCount(source, recordType string, count int)
+	// SetBundleSize sets the size of the last bundle built for a source.
+	SetBundleSize(source string, size int)
+}
+
+type nopMetricsRecorder struct{}
+
+func (nopMetricsRecorder) RecordSync(string, time.Time, error) {}
+func (nopMetricsRecorder) SetRecordCount(string, string, int)  {}
+func (nopMetricsRecorder) SetBundleSize(string, int)           {}
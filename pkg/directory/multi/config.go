@@ -0,0 +1,64 @@
+package multi
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// A FailureMode determines what happens when one of the sources fails.
+type FailureMode string
+
+// Failure modes.
+const (
+	// FailAll fails the whole directory when any source fails.
+	FailAll FailureMode = "fail-all"
+	// LastGood uses the last good data for a failing source. The data is saved
+	// with the directory state, so it's also used after a restart.
+	LastGood FailureMode = "last-good"
+)
+
+// ParseFailureMode parses a failure mode.
+func ParseFailureMode(raw string) (FailureMode, error) {
+	switch mode := FailureMode(raw); mode {
+	case FailAll, LastGood:
+		return mode, nil
+	case "":
+		return FailAll, nil
+	default:
+		return "", fmt.Errorf("multi: unknown failure mode: %s", raw)
+	}
+}
+
+type config struct {
+	failureMode FailureMode
+	logger      zerolog.Logger
+}
+
+// An Option configures the multi Provider.
+type Option func(cfg *config)
+
+// WithFailureMode sets the failure mode in the config.
+func WithFailureMode(failureMode FailureMode) Option {
+	return func(cfg *config) {
+		cfg.failureMode = failureMode
+	}
+}
+
+// WithLogger sets the logger in the config.
+func WithLogger(logger zerolog.Logger) Option {
+	return func(cfg *config) {
+		cfg.logger = logger
+	}
+}
+
+func getConfig(options ...Option) *config {
+	cfg := new(config)
+	WithFailureMode(FailAll)(cfg)
+	WithLogger(log.Logger)(cfg)
+	for _, option := range options {
+		option(cfg)
+	}
+	return cfg
+}
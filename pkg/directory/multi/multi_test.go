@@ -0,0 +1,171 @@
+package multi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pomerium/datasource/pkg/directory"
+)
+
+type mockPersistentProvider struct {
+	directory.Provider
+	state string
+}
+
+func (p *mockPersistentProvider) LoadDirectoryState(_ context.Context, src io.Reader) error {
+	bs, err := io.ReadAll(src)
+	p.state = string(bs)
+	return err
+}
+
+func (p *mockPersistentProvider) SaveDirectoryState(_ context.Context, dst io.Writer) error {
+	_, err := io.WriteString(dst, p.state)
+	return err
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	okta := directory.ProviderFunc(func(_ context.Context) ([]directory.Group, []directory.User, error) {
+		return []directory.Group{
+			{ID: "g1", Name: "Employees"},
+		}, []directory.User{
+			{ID: "u1", GroupIDs: []string{"g1"}, DisplayName: "User 1", Email: "user1@example.com"},
+			{ID: "u2", GroupIDs: []string{"g1"}, Email: "user2@example.com"},
+		}, nil
+	})
+	auth0 := directory.ProviderFunc(func(_ context.Context) ([]directory.Group, []directory.User, error) {
+		return []directory.Group{
+			{ID: "g1", Name: "Contractors"},
+		}, []directory.User{
+			{ID: "u1", GroupIDs: []string{"g1"}, DisplayName: "Contractor 1", Email: "contractor1@example.com"},
+			{ID: "u2", GroupIDs: []string{"g1"}, DisplayName: "User 2", Email: "USER2@example.com", Attributes: map[string]any{"title": "Engineer"}},
+		}, nil
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		t.Parallel()
+
+		p := New([]Source{
+			{Name: "okta", Prefix: "okta/", MergeByEmail: true, Provider: okta},
+			{Name: "auth0", Prefix: "auth0/", MergeByEmail: true, Provider: auth0},
+		})
+		groups, users, err := p.GetDirectory(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, []directory.Group{
+			{ID: "auth0/g1", Name: "Contractors"},
+			{ID: "okta/g1", Name: "Employees"},
+		}, groups)
+		assert.Equal(t, []directory.User{
+			{ID: "auth0/u1", GroupIDs: []string{"auth0/g1"}, DisplayName: "Contractor 1", Email: "contractor1@example.com"},
+			{ID: "okta/u1", GroupIDs: []string{"okta/g1"}, DisplayName: "User 1", Email: "user1@example.com"},
+			{
+				ID: "okta/u2", GroupIDs: []string{"auth0/g1", "okta/g1"}, DisplayName: "User 2", Email: "user2@example.com",
+				Attributes: map[string]any{"title": "Engineer"},
+			},
+		}, users)
+	})
+	t.Run("untrusted email", func(t *testing.T) {
+		t.Parallel()
+
+		p := New([]Source{
+			{Name: "okta", Prefix: "okta/", MergeByEmail: true, Provider: okta},
+			{Name: "auth0", Prefix: "auth0/", Provider: auth0},
+		})
+		_, users, err := p.GetDirectory(t.Context())
+		assert.NoError(t, err)
+		assert.Len(t, users, 4)
+	})
+	t.Run("failure modes", func(t *testing.T) {
+		t.Parallel()
+
+		fail := false
+		flaky := directory.ProviderFunc(func(ctx context.Context) ([]directory.Group, []directory.User, error) {
+			if fail {
+				return nil, nil, errors.New("unavailable")
+			}
+			return auth0(ctx)
+		})
+		sources := []Source{
+			{Name: "okta", Prefix: "okta/", Provider: okta},
+			{Name: "auth0", Prefix: "auth0/", Provider: flaky},
+		}
+
+		failAll := New(sources, WithFailureMode(FailAll))
+		lastGood := New(sources, WithFailureMode(LastGood))
+
+		_, expect, err := lastGood.GetDirectory(t.Context())
+		assert.NoError(t, err)
+		_, err = ParseFailureMode("bad")
+		assert.Error(t, err)
+
+		fail = true
+		_, _, err = failAll.GetDirectory(t.Context())
+		assert.ErrorContains(t, err, "source auth0: unavailable")
+
+		_, users, err := lastGood.GetDirectory(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, expect, users)
+
+		_, _, err = New(sources, WithFailureMode(LastGood)).GetDirectory(t.Context())
+		assert.Error(t, err, "should fail without any last good data")
+	})
+	t.Run("state", func(t *testing.T) {
+		t.Parallel()
+
+		pp1 := &mockPersistentProvider{Provider: okta, state: "STATE1"}
+		pp2 := &mockPersistentProvider{Provider: okta}
+		p := New([]Source{
+			{Name: "okta", Provider: pp1},
+			{Name: "auth0", Provider: auth0},
+		})
+
+		var buf bytes.Buffer
+		assert.NoError(t, p.SaveDirectoryState(t.Context(), &buf))
+
+		p = New([]Source{
+			{Name: "okta", Provider: pp2},
+			{Name: "auth0", Provider: auth0},
+		})
+		assert.NoError(t, p.LoadDirectoryState(t.Context(), &buf))
+		assert.Equal(t, "STATE1", pp2.state)
+	})
+	t.Run("last good state", func(t *testing.T) {
+		t.Parallel()
+
+		unavailable := directory.ProviderFunc(func(_ context.Context) ([]directory.Group, []directory.User, error) {
+			return nil, nil, errors.New("unavailable")
+		})
+
+		p := New([]Source{
+			{Name: "okta", Prefix: "okta/", Provider: okta},
+			{Name: "auth0", Prefix: "auth0/", Provider: auth0},
+		}, WithFailureMode(LastGood))
+		expectGroups, expectUsers, err := p.GetDirectory(t.Context())
+		assert.NoError(t, err)
+		var buf bytes.Buffer
+		assert.NoError(t, p.SaveDirectoryState(t.Context(), &buf))
+
+		// after a restart, the saved data is used for a failing source
+		sources := []Source{
+			{Name: "okta", Prefix: "okta/", Provider: okta},
+			{Name: "auth0", Prefix: "auth0/", Provider: unavailable},
+		}
+		p = New(sources, WithFailureMode(LastGood))
+		assert.NoError(t, p.LoadDirectoryState(t.Context(), bytes.NewReader(buf.Bytes())))
+		groups, users, err := p.GetDirectory(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, expectGroups, groups)
+		assert.Equal(t, expectUsers, users)
+
+		p = New(sources, WithFailureMode(FailAll))
+		assert.NoError(t, p.LoadDirectoryState(t.Context(), bytes.NewReader(buf.Bytes())))
+		_, _, err = p.GetDirectory(t.Context())
+		assert.Error(t, err, "should only use the saved data with the last-good failure mode")
+	})
+}
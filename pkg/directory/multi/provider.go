@@ -0,0 +1,297 @@
+// Package multi contains a directory provider that combines several other directory providers.
+package multi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pomerium/datasource/internal/tracing"
+	"github.com/pomerium/datasource/pkg/directory"
+)
+
+var _ directory.PersistentProvider = (*Provider)(nil)
+
+// A Source is one of the directory providers combined by the multi Provider.
+type Source struct {
+	// Name uniquely identifies the source.
+	Name string
+	// Prefix is added to the ids of the source's groups and users, so that ids
+	// from different sources don't collide.
+	Prefix string
+	// MergeByEmail merges users with the same email address across all the
+	// sources that have MergeByEmail set. It should only be set for sources
+	// that verify email addresses.
+	MergeByEmail bool
+	// Provider is the directory provider for the source.
+	Provider directory.Provider
+}
+
+type sourceResult struct {
+	groups []directory.Group
+	users  []directory.User
+}
+
+// sourceState is the saved state of a source.
+type sourceState struct {
+	// State is the directory state of a persistent source.
+	State []byte `json:"state,omitempty"`
+	// LastGood is the last good directory data of the source, without the
+	// prefix, saved for the last-good failure mode.
+	LastGood *lastGoodState `json:"lastGood,omitempty"`
+}
+
+type lastGoodState struct {
+	Groups []directory.Group `json:"groups"`
+	Users  []directory.User  `json:"users"`
+}
+
+// The Provider fans out to several directory providers in parallel and
+// combines their groups and users.
+type Provider struct {
+	cfg     *config
+	sources []Source
+
+	mu sync.Mutex
+	// lastGood is the last good directory data of each source, without the prefix
+	lastGood map[int]sourceResult
+}
+
+// New creates a new multi Provider.
+func New(sources []Source, options ...Option) *Provider {
+	return &Provider{
+		cfg:      getConfig(options...),
+		sources:  sources,
+		lastGood: make(map[int]sourceResult),
+	}
+}
+
+// GetDirectory gets the directory from all the sources and combines the results.
+//
+// Users and groups from sources that share an id are merged, as are users from
+// sources with MergeByEmail set that share an email address. The first source
+// wins for conflicting fields.
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "multi.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
+	results := make([]sourceResult, len(p.sources))
+	eg, ectx := errgroup.WithContext(ctx)
+	for i := range p.sources {
+		eg.Go(func() error {
+			res, err := p.getSource(ectx, i)
+			if err != nil {
+				return err
+			}
+			results[i] = res
+			return nil
+		})
+	}
+	err = eg.Wait()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups, users := merge(p.sources, results)
+	return groups, users, nil
+}
+
+func (p *Provider) getSource(ctx context.Context, idx int) (sourceResult, error) {
+	src := p.sources[idx]
+
+	groups, users, err := src.Provider.GetDirectory(ctx)
+	if err == nil {
+		p.mu.Lock()
+		p.lastGood[idx] = sourceResult{groups: groups, users: users}
+		p.mu.Unlock()
+		return prefix(src.Prefix, groups, users), nil
+	}
+	err = fmt.Errorf("multi: error getting directory from source %s: %w", src.Name, err)
+
+	if p.cfg.failureMode == LastGood {
+		p.mu.Lock()
+		res, ok := p.lastGood[idx]
+		p.mu.Unlock()
+		if ok {
+			p.cfg.logger.Warn().Err(err).Str("source", src.Name).Msg("using last good directory data")
+			return prefix(src.Prefix, res.groups, res.users), nil
+		}
+	}
+
+	return sourceResult{}, err
+}
+
+// LoadDirectoryState loads the directory state of each of the persistent sources.
+// With the last-good failure mode, it also loads the last good data of sources
+// that haven't synced yet, so a failing source doesn't fail the whole directory
+// after a restart.
+func (p *Provider) LoadDirectoryState(ctx context.Context, src io.Reader) error {
+	var state map[string]sourceState
+	err := json.NewDecoder(src).Decode(&state)
+	if err != nil {
+		return fmt.Errorf("multi: error decoding directory state: %w", err)
+	}
+
+	for i, s := range p.sources {
+		ss, ok := state[s.Name]
+		if !ok {
+			continue
+		}
+
+		if pp, ok := s.Provider.(directory.PersistentProvider); ok && ss.State != nil {
+			err = pp.LoadDirectoryState(ctx, bytes.NewReader(ss.State))
+			if err != nil {
+				return fmt.Errorf("multi: error loading directory state for source %s: %w", s.Name, err)
+			}
+		}
+
+		if p.cfg.failureMode == LastGood && ss.LastGood != nil {
+			p.mu.Lock()
+			if _, ok := p.lastGood[i]; !ok {
+				p.lastGood[i] = sourceResult{groups: ss.LastGood.Groups, users: ss.LastGood.Users}
+			}
+			p.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// SaveDirectoryState saves the directory state of each of the persistent sources,
+// and with the last-good failure mode, the last good data of each source.
+func (p *Provider) SaveDirectoryState(ctx context.Context, dst io.Writer) error {
+	state := map[string]sourceState{}
+	for i, s := range p.sources {
+		var ss sourceState
+		if pp, ok := s.Provider.(directory.PersistentProvider); ok {
+			var buf bytes.Buffer
+			err := pp.SaveDirectoryState(ctx, &buf)
+			if err != nil {
+				return fmt.Errorf("multi: error saving directory state for source %s: %w", s.Name, err)
+			}
+			ss.State = buf.Bytes()
+		}
+
+		if p.cfg.failureMode == LastGood {
+			p.mu.Lock()
+			res, ok := p.lastGood[i]
+			p.mu.Unlock()
+			if ok {
+				ss.LastGood = &lastGoodState{Groups: res.groups, Users: res.users}
+			}
+		}
+
+		if ss.State != nil || ss.LastGood != nil {
+			state[s.Name] = ss
+		}
+	}
+	return json.NewEncoder(dst).Encode(state)
+}
+
+func prefix(prefix string, groups []directory.Group, users []directory.User) sourceResult {
+	res := sourceResult{
+		groups: make([]directory.Group, len(groups)),
+		users:  make([]directory.User, len(users)),
+	}
+	for i, g := range groups {
+		g.ID = prefix + g.ID
+		res.groups[i] = g
+	}
+	for i, u := range users {
+		u.ID = prefix + u.ID
+		groupIDs := make([]string, len(u.GroupIDs))
+		for j, groupID := range u.GroupIDs {
+			groupIDs[j] = prefix + groupID
+		}
+		u.GroupIDs = groupIDs
+		res.users[i] = u
+	}
+	return res
+}
+
+func merge(sources []Source, results []sourceResult) ([]directory.Group, []directory.User) {
+	groupLookup := map[string]directory.Group{}
+	userLookup := map[string]*directory.User{}
+	emailLookup := map[string]*directory.User{}
+	for i, res := range results {
+		for _, g := range res.groups {
+			if _, ok := groupLookup[g.ID]; !ok {
+				groupLookup[g.ID] = g
+			}
+		}
+
+		for _, u := range res.users {
+			if du, ok := userLookup[u.ID]; ok {
+				mergeUser(du, u)
+				continue
+			}
+
+			var email string
+			if sources[i].MergeByEmail && u.Email != "" {
+				email = strings.ToLower(u.Email)
+				if du, ok := emailLookup[email]; ok {
+					mergeUser(du, u)
+					continue
+				}
+			}
+
+			du := &directory.User{ID: u.ID}
+			mergeUser(du, u)
+			userLookup[u.ID] = du
+			if email != "" {
+				emailLookup[email] = du
+			}
+		}
+	}
+
+	groups := slices.Collect(maps.Values(groupLookup))
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID < groups[j].ID
+	})
+
+	users := make([]directory.User, 0, len(userLookup))
+	for _, du := range userLookup {
+		users = append(users, *du)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	return groups, users
+}
+
+func mergeUser(dst *directory.User, src directory.User) {
+	for _, groupID := range src.GroupIDs {
+		if !slices.Contains(dst.GroupIDs, groupID) {
+			dst.GroupIDs = append(dst.GroupIDs, groupID)
+		}
+	}
+	sort.Strings(dst.GroupIDs)
+
+	if dst.DisplayName == "" {
+		dst.DisplayName = src.DisplayName
+	}
+	if dst.Email == "" {
+		dst.Email = src.Email
+	}
+	if dst.Status == "" {
+		dst.Status = src.Status
+	}
+	for k, v := range src.Attributes {
+		if _, ok := dst.Attributes[k]; ok {
+			continue
+		}
+		if dst.Attributes == nil {
+			dst.Attributes = make(map[string]any)
+		}
+		dst.Attributes[k] = v
+	}
+}
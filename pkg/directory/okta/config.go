@@ -2,12 +2,14 @@ package okta
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/okta/okta-sdk-golang/v2/okta"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/secretfile"
 )
 
 const (
@@ -20,12 +22,16 @@ const (
 )
 
 type config struct {
-	apiKey      string
-	batchSize   int
-	httpClient  *http.Client
-	logger      zerolog.Logger
-	oktaOptions []okta.ConfigSetter
-	url         string
+	apiKey          string
+	apiKeyFile      *secretfile.File
+	batchSize       int
+	httpClient      *http.Client
+	httpClientOnce  sync.Once
+	logger          zerolog.Logger
+	oktaOptions     []okta.ConfigSetter
+	retryHTTPClient *http.Client
+	url             string
+	userAttributes  []string
 }
 
 // An Option configures the Okta Provider.
@@ -38,6 +44,17 @@ func WithAPIKey(apiKey string) Option {
 	}
 }
 
+// WithAPIKeyFile sets the file to read the api key from in the config. The file
+// is re-read when it changes, and takes precedence over the api key.
+func WithAPIKeyFile(name string) Option {
+	return func(cfg *config) {
+		cfg.apiKeyFile = nil
+		if name != "" {
+			cfg.apiKeyFile = secretfile.New(name)
+		}
+	}
+}
+
 // WithBatchSize sets the batch size option.
 func WithBatchSize(batchSize int) Option {
 	return func(cfg *config) {
@@ -73,6 +90,13 @@ func WithURL(url string) Option {
 	}
 }
 
+// WithUserAttributes sets the names of the user attributes to include in the directory.
+func WithUserAttributes(userAttributes []string) Option {
+	return func(cfg *config) {
+		cfg.userAttributes = userAttributes
+	}
+}
+
 func getConfig(options ...Option) *config {
 	cfg := new(config)
 	WithBatchSize(batchSize)(cfg)
@@ -85,8 +109,18 @@ func getConfig(options ...Option) *config {
 	return cfg
 }
 
+func (cfg *config) getAPIKey() (string, error) {
+	if cfg.apiKeyFile != nil {
+		return cfg.apiKeyFile.Read()
+	}
+	return cfg.apiKey, nil
+}
+
 func (cfg *config) getHTTPClient() *http.Client {
-	return httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
-		return event.Str("idp", "okta")
+	cfg.httpClientOnce.Do(func() {
+		cfg.retryHTTPClient = httputil.NewRetryClient(httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "okta")
+		}))
 	})
+	return cfg.retryHTTPClient
 }
@@ -9,6 +9,9 @@ import (
 
 	"github.com/okta/okta-sdk-golang/v2/okta"
 	"github.com/okta/okta-sdk-golang/v2/okta/query"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/pomerium/datasource/internal/tracing"
 )
 
 // errors
@@ -21,7 +24,7 @@ func listAllGroups(
 	client *okta.Client,
 	batchSize int,
 ) ([]*okta.Group, error) {
-	groups, err := listAll(ctx, func(ctx context.Context) ([]*okta.Group, *okta.Response, error) {
+	groups, err := listAll(ctx, "okta.listAllGroups", func(ctx context.Context) ([]*okta.Group, *okta.Response, error) {
 		return client.Group.ListGroups(ctx, &query.Params{
 			Limit: int64(batchSize),
 		})
@@ -39,7 +42,7 @@ func listChangedGroups(
 	lastUpdated, lastMembershipUpdated time.Time,
 	batchSize int,
 ) ([]*okta.Group, error) {
-	groups, err := listAll(ctx, func(ctx context.Context) ([]*okta.Group, *okta.Response, error) {
+	groups, err := listAll(ctx, "okta.listChangedGroups", func(ctx context.Context) ([]*okta.Group, *okta.Response, error) {
 		filter := fmt.Sprintf(`lastUpdated gt "%s" or lastMembershipUpdated gt "%s"`,
 			lastUpdated.UTC().Format(filterDateFormat),
 			lastMembershipUpdated.UTC().Format(filterDateFormat))
@@ -62,7 +65,7 @@ func listGroupUsers(
 	groupID string,
 	batchSize int,
 ) ([]*okta.User, error) {
-	users, err := listAll(ctx, func(ctx context.Context) ([]*okta.User, *okta.Response, error) {
+	users, err := listAll(ctx, "okta.listGroupUsers", func(ctx context.Context) ([]*okta.User, *okta.Response, error) {
 		return client.Group.ListGroupUsers(ctx, groupID, &query.Params{
 			Limit: int64(batchSize),
 		})
@@ -74,7 +77,12 @@ func listGroupUsers(
 	return users, nil
 }
 
-func listAll[T any](ctx context.Context, f func(ctx context.Context) ([]T, *okta.Response, error)) ([]T, error) {
+// listAll retrieves all the pages of a list, recording a span named name.
+func listAll[T any](ctx context.Context, name string, f func(ctx context.Context) ([]T, *okta.Response, error)) (_ []T, err error) {
+	ctx, span := tracing.Start(ctx, name)
+	defer func() { tracing.End(span, err) }()
+
+	pages := 1
 	els, res, err := f(ctx)
 	if err != nil {
 		return nil, err
@@ -87,7 +95,9 @@ func listAll[T any](ctx context.Context, f func(ctx context.Context) ([]T, *okta
 			return nil, err
 		}
 		els = append(els, next...)
+		pages++
 	}
 
+	span.SetAttributes(attribute.Int("pages", pages), attribute.Int("items", len(els)))
 	return els, nil
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 )
 
 type M = map[string]interface{}
@@ -91,14 +92,19 @@ func newMockOkta(userEmailToGroups map[string][]string) http.Handler {
 				for email, groups := range userEmailToGroups {
 					for _, g := range groups {
 						if group == g {
-							result = append(result, M{
+							u := M{
 								"id": email,
 								"profile": M{
-									"email":     email,
-									"firstName": "first",
-									"lastName":  "last",
+									"department": "engineering",
+									"email":      email,
+									"firstName":  "first",
+									"lastName":   "last",
 								},
-							})
+							}
+							if strings.HasPrefix(email, "deprovisioned") {
+								u["status"] = "DEPROVISIONED"
+							}
+							result = append(result, u)
 						}
 					}
 				}
@@ -150,15 +156,17 @@ func TestProvider_GetDirectory(t *testing.T) {
 	}))
 	defer srv.Close()
 	mockOkta = newMockOkta(map[string][]string{
-		"a@example.com": {"user", "admin"},
-		"b@example.com": {"user", "test"},
-		"c@example.com": {"user"},
+		"a@example.com":             {"user", "admin"},
+		"b@example.com":             {"user", "test"},
+		"c@example.com":             {"user"},
+		"deprovisioned@example.com": {"user"},
 	})
 
 	p := New(
 		WithAPIKey("APITOKEN"),
 		WithOktaOptions(okta.WithTestingDisableHttpsCheck(true)),
 		WithURL(srv.URL),
+		WithUserAttributes([]string{"department", "title"}),
 	)
 	groups, users, err := p.GetDirectory(t.Context())
 	assert.NoError(t, err)
@@ -168,18 +176,29 @@ func TestProvider_GetDirectory(t *testing.T) {
 			GroupIDs:    []string{"admin", "user"},
 			DisplayName: "first last",
 			Email:       "a@example.com",
+			Attributes:  map[string]any{"department": "engineering"},
 		},
 		{
 			ID:          "b@example.com",
 			GroupIDs:    []string{"test", "user"},
 			DisplayName: "first last",
 			Email:       "b@example.com",
+			Attributes:  map[string]any{"department": "engineering"},
 		},
 		{
 			ID:          "c@example.com",
 			GroupIDs:    []string{"user"},
 			DisplayName: "first last",
 			Email:       "c@example.com",
+			Attributes:  map[string]any{"department": "engineering"},
+		},
+		{
+			ID:          "deprovisioned@example.com",
+			GroupIDs:    []string{"user"},
+			DisplayName: "first last",
+			Email:       "deprovisioned@example.com",
+			Status:      directory.UserStatusInactive,
+			Attributes:  map[string]any{"department": "engineering"},
 		},
 	}, users)
 	assert.Len(t, groups, 3)
@@ -260,3 +279,18 @@ func TestProvider_UserGroupsQueryUpdated(t *testing.T) {
 	}, users)
 	assert.Len(t, groups, 4)
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: directorytest.NewFakeIDPUpstream,
+		NewProvider: func(_ *testing.T, url string) directory.Provider {
+			return New(
+				WithAPIKey("APITOKEN"),
+				WithOktaOptions(okta.WithTestingDisableHttpsCheck(true)),
+				WithURL(url),
+			)
+		},
+	})
+}
@@ -3,13 +3,15 @@ package okta
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
 	"sort"
 	"sync"
 	"time"
 
 	"github.com/okta/okta-sdk-golang/v2/okta"
-	"golang.org/x/exp/maps"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -17,52 +19,79 @@ import (
 type Provider struct {
 	cfg *config
 
-	mu           sync.Mutex
+	// syncMu serializes syncs, mu guards the current state
+	syncMu sync.Mutex
+	mu     sync.RWMutex
+	state  *state
+}
+
+// a state is never modified once it has been committed to the provider
+type state struct {
 	groups       map[string]okta.Group
 	groupMembers map[string][]okta.User
 }
 
+func newState() *state {
+	return &state{
+		groups:       make(map[string]okta.Group),
+		groupMembers: make(map[string][]okta.User),
+	}
+}
+
+// clone returns a shallow copy of the state. Group members are always replaced, never modified.
+func (s *state) clone() *state {
+	return &state{
+		groups:       maps.Clone(s.groups),
+		groupMembers: maps.Clone(s.groupMembers),
+	}
+}
+
 // New creates a new Provider.
 func New(options ...Option) *Provider {
 	return &Provider{
-		cfg: getConfig(options...),
-
-		groups:       make(map[string]okta.Group),
-		groupMembers: make(map[string][]okta.User),
+		cfg:   getConfig(options...),
+		state: newState(),
 	}
 }
 
 // GetDirectory gets the full directory information for Okta.
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "okta.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
+	apiKey, err := p.cfg.getAPIKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("okta: error reading api key: %w", err)
+	}
+
 	ctx, client, err := okta.NewClient(ctx,
 		append([]okta.ConfigSetter{
 			okta.WithHttpClientPtr(p.cfg.getHTTPClient()),
 			okta.WithOrgUrl(p.cfg.url),
-			okta.WithToken(p.cfg.apiKey),
+			okta.WithToken(apiKey),
 		}, p.cfg.oktaOptions...)...)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error creating okta client: %w", err)
 	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	err = p.sync(ctx, client)
+	s, err := p.sync(ctx, client)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	var groups []directory.Group
 	userLookup := map[string]directory.User{}
-	for _, g := range p.groups {
+	for _, g := range s.groups {
 		groups = append(groups, directory.Group{
 			ID:   g.Id,
 			Name: g.Profile.Name,
 		})
-		for _, u := range p.groupMembers[g.Id] {
+		for _, u := range s.groupMembers[g.Id] {
 			du := userLookup[u.Id]
 			du.DisplayName = getUserDisplayName(u)
 			du.Email = getUserEmail(u)
+			du.Status = getUserStatus(u)
+			du.Attributes = getUserAttributes(u, p.cfg.userAttributes)
 			du.GroupIDs = append(du.GroupIDs, g.Id)
 			sort.Strings(du.GroupIDs)
 			du.ID = u.Id
@@ -70,7 +99,7 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 		}
 	}
 
-	users := maps.Values(userLookup)
+	users := slices.Collect(maps.Values(userLookup))
 
 	// sort groups and users
 	sort.Slice(groups, func(i, j int) bool {
@@ -82,9 +111,16 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 	return groups, users, nil
 }
 
-func (p *Provider) sync(ctx context.Context, client *okta.Client) error {
+// sync applies the latest changes to a copy of the current state and commits it
+// once all the changes have been retrieved.
+func (p *Provider) sync(ctx context.Context, client *okta.Client) (*state, error) {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	current := p.getState()
+
 	var lastMembershipUpdated, lastUpdated time.Time
-	for _, g := range p.groups {
+	for _, g := range current.groups {
 		if g.LastMembershipUpdated != nil && g.LastMembershipUpdated.After(lastMembershipUpdated) {
 			lastMembershipUpdated = *g.LastMembershipUpdated
 		}
@@ -93,52 +129,68 @@ func (p *Provider) sync(ctx context.Context, client *okta.Client) error {
 		}
 	}
 
+	var next *state
 	var changedGroups []*okta.Group
 	if lastMembershipUpdated.IsZero() || lastUpdated.IsZero() {
 		// full sync
-		clear(p.groups)
-		clear(p.groupMembers)
+		next = newState()
 
 		var err error
 		changedGroups, err = listAllGroups(ctx, client, p.cfg.batchSize)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		// sync changes
+		next = current.clone()
+
 		var err error
 		changedGroups, err = listChangedGroups(ctx, client, lastUpdated, lastMembershipUpdated, p.cfg.batchSize)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	err := p.syncGroups(ctx, client, changedGroups)
+	err := p.syncGroups(ctx, client, next, changedGroups)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	p.setState(next)
+	return next, nil
 }
 
-func (p *Provider) syncGroups(ctx context.Context, client *okta.Client, groups []*okta.Group) error {
+func (p *Provider) syncGroups(ctx context.Context, client *okta.Client, s *state, groups []*okta.Group) error {
 	for _, g := range groups {
-		p.groups[g.Id] = *g
+		s.groups[g.Id] = *g
 
 		users, err := listGroupUsers(ctx, client, g.Id, p.cfg.batchSize)
 		if err != nil {
 			return err
 		}
-		s := make([]okta.User, len(users))
+		members := make([]okta.User, len(users))
 		for i, u := range users {
-			s[i] = *u
+			members[i] = *u
 		}
-		p.groupMembers[g.Id] = s
+		s.groupMembers[g.Id] = members
 	}
 
 	return nil
 }
 
+func (p *Provider) getState() *state {
+	p.mu.RLock()
+	s := p.state
+	p.mu.RUnlock()
+	return s
+}
+
+func (p *Provider) setState(s *state) {
+	p.mu.Lock()
+	p.state = s
+	p.mu.Unlock()
+}
+
 func getUserDisplayName(user okta.User) string {
 	if user.Profile == nil {
 		return ""
@@ -149,6 +201,25 @@ func getUserDisplayName(user okta.User) string {
 	return firstName + " " + lastName
 }
 
+func getUserAttributes(user okta.User, names []string) map[string]any {
+	if user.Profile == nil {
+		return nil
+	}
+
+	return directory.SelectAttributes(*user.Profile, names)
+}
+
+func getUserStatus(user okta.User) directory.UserStatus {
+	switch user.Status {
+	case "":
+		return ""
+	case "DEPROVISIONED", "SUSPENDED":
+		return directory.UserStatusInactive
+	default:
+		return directory.UserStatusActive
+	}
+}
+
 func getUserEmail(user okta.User) string {
 	if user.Profile == nil {
 		return ""
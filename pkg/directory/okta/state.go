@@ -19,9 +19,10 @@ var _ directory.PersistentProvider = (*Provider)(nil)
 
 // SaveDirectoryState saves the directory state to a writer.
 func (p *Provider) SaveDirectoryState(_ context.Context, dst io.Writer) error {
+	s := p.getState()
 	return json.NewEncoder(dst).Encode(directoryState{
-		Groups:       p.groups,
-		GroupMembers: p.groupMembers,
+		Groups:       s.groups,
+		GroupMembers: s.groupMembers,
 	})
 }
 
@@ -33,10 +34,18 @@ func (p *Provider) LoadDirectoryState(_ context.Context, src io.Reader) error {
 		return err
 	}
 
-	p.mu.Lock()
-	p.groups = ds.Groups
-	p.groupMembers = ds.GroupMembers
-	p.mu.Unlock()
+	next := newState()
+	if ds.Groups != nil {
+		next.groups = ds.Groups
+	}
+	if ds.GroupMembers != nil {
+		next.groupMembers = ds.GroupMembers
+	}
+
+	// wait for any in-flight sync so it doesn't overwrite the loaded state
+	p.syncMu.Lock()
+	p.setState(next)
+	p.syncMu.Unlock()
 
 	return nil
 }
@@ -3,20 +3,25 @@ package onelogin
 import (
 	"net/http"
 	"net/url"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/secretfile"
 )
 
 type config struct {
-	apiURL       *url.URL
-	batchSize    int
-	clientID     string
-	clientSecret string
-	httpClient   *http.Client
-	logger       zerolog.Logger
+	apiURL           *url.URL
+	batchSize        int
+	clientID         string
+	clientSecret     string
+	clientSecretFile *secretfile.File
+	httpClient       *http.Client
+	httpClientOnce   sync.Once
+	logger           zerolog.Logger
+	retryHTTPClient  *http.Client
 }
 
 // An Option updates the onelogin configuration.
@@ -43,6 +48,18 @@ func WithClientSecret(clientSecret string) Option {
 	}
 }
 
+// WithClientSecretFile sets the file to read the client secret from in the
+// config. The file is re-read when it changes, and takes precedence over the
+// client secret.
+func WithClientSecretFile(name string) Option {
+	return func(cfg *config) {
+		cfg.clientSecretFile = nil
+		if name != "" {
+			cfg.clientSecretFile = secretfile.New(name)
+		}
+	}
+}
+
 // WithHTTPClient sets the http client option.
 func WithHTTPClient(httpClient *http.Client) Option {
 	return func(cfg *config) {
@@ -79,8 +96,18 @@ func getConfig(options ...Option) *config {
 	return cfg
 }
 
+func (cfg *config) getClientSecret() (string, error) {
+	if cfg.clientSecretFile != nil {
+		return cfg.clientSecretFile.Read()
+	}
+	return cfg.clientSecret, nil
+}
+
 func (cfg *config) getHTTPClient() *http.Client {
-	return httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
-		return event.Str("idp", "onelogin")
+	cfg.httpClientOnce.Do(func() {
+		cfg.retryHTTPClient = httputil.NewRetryClient(httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "onelogin")
+		}))
 	})
+	return cfg.retryHTTPClient
 }
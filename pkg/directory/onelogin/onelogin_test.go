@@ -16,6 +16,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 )
 
 type M = map[string]interface{}
@@ -192,3 +193,22 @@ func mustParseURL(rawurl string) *url.URL {
 	}
 	return u
 }
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: directorytest.NewFakeIDPUpstream,
+		NewProvider: func(_ *testing.T, url string) directory.Provider {
+			return New(
+				WithClientID("CLIENTID"),
+				WithClientSecret("CLIENTSECRET"),
+				WithURL(mustParseURL(url)),
+			)
+		},
+		IsTokenRequest: func(r *http.Request) bool {
+			return r.URL.Path == "/auth/oauth2/v2/token"
+		},
+		SingleGroup: true,
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"sort"
@@ -13,6 +14,7 @@ import (
 
 	"golang.org/x/oauth2"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -22,6 +24,8 @@ type Provider struct {
 
 	mu    sync.RWMutex
 	token *oauth2.Token
+	// tokenClientSecret is the client secret the token was retrieved with
+	tokenClientSecret string
 }
 
 // New creates a new Provider.
@@ -33,18 +37,16 @@ func New(options ...Option) *Provider {
 }
 
 // GetDirectory gets the directory user groups for onelogin.
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
-	token, err := p.getToken(ctx)
-	if err != nil {
-		return nil, nil, err
-	}
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "onelogin.GetDirectory")
+	defer func() { tracing.End(span, err) }()
 
-	groups, err := p.listGroups(ctx, token.AccessToken)
+	groups, err := p.listGroups(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	apiUsers, err := p.listUsers(ctx, token.AccessToken)
+	apiUsers, err := p.listUsers(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -59,13 +61,16 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 		})
 	}
 
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID < groups[j].ID
+	})
 	sort.Slice(users, func(i, j int) bool {
 		return users[i].ID < users[j].ID
 	})
 	return groups, users, nil
 }
 
-func (p *Provider) listGroups(ctx context.Context, accessToken string) ([]directory.Group, error) {
+func (p *Provider) listGroups(ctx context.Context) ([]directory.Group, error) {
 	var groups []directory.Group
 	apiURL := p.cfg.apiURL.ResolveReference(&url.URL{
 		Path:     "/api/1/groups",
@@ -76,7 +81,7 @@ func (p *Provider) listGroups(ctx context.Context, accessToken string) ([]direct
 			ID   int    `json:"id"`
 			Name string `json:"name"`
 		}
-		nextLink, err := p.apiGet(ctx, accessToken, apiURL, &result)
+		nextLink, err := p.apiGet(ctx, apiURL, &result)
 		if err != nil {
 			return nil, fmt.Errorf("onelogin: listing groups: %w", err)
 		}
@@ -93,7 +98,7 @@ func (p *Provider) listGroups(ctx context.Context, accessToken string) ([]direct
 	return groups, nil
 }
 
-func (p *Provider) listUsers(ctx context.Context, accessToken string) ([]apiUserObject, error) {
+func (p *Provider) listUsers(ctx context.Context) ([]apiUserObject, error) {
 	var users []apiUserObject
 
 	apiURL := p.cfg.apiURL.ResolveReference(&url.URL{
@@ -102,7 +107,7 @@ func (p *Provider) listUsers(ctx context.Context, accessToken string) ([]apiUser
 	}).String()
 	for apiURL != "" {
 		var result []apiUserObject
-		nextLink, err := p.apiGet(ctx, accessToken, apiURL, &result)
+		nextLink, err := p.apiGet(ctx, apiURL, &result)
 		if err != nil {
 			return nil, fmt.Errorf("onelogin: listing users: %w", err)
 		}
@@ -114,20 +119,45 @@ func (p *Provider) listUsers(ctx context.Context, accessToken string) ([]apiUser
 	return users, nil
 }
 
-func (p *Provider) apiGet(ctx context.Context, accessToken string, uri string, out interface{}) (nextLink string, err error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
-	if err != nil {
-		return "", err
+func (p *Provider) apiGet(ctx context.Context, uri string, out interface{}) (nextLink string, err error) {
+	call := func() (*http.Response, error) {
+		token, err := p.getToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("bearer:%s", token.AccessToken))
+		req.Header.Set("Content-Type", "application/json")
+
+		return p.cfg.getHTTPClient().Do(req)
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("bearer:%s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
 
-	res, err := p.cfg.getHTTPClient().Do(req)
+	res, err := call()
 	if err != nil {
 		return "", err
 	}
 	defer res.Body.Close()
 
+	// if we get unauthorized, invalidate the token and try again
+	if res.StatusCode == http.StatusUnauthorized {
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+
+		p.mu.Lock()
+		p.token = nil
+		p.mu.Unlock()
+
+		res, err = call()
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+	}
+
 	if res.StatusCode/100 != 2 {
 		return "", fmt.Errorf("onelogin: error querying api: %s", res.Status)
 	}
@@ -156,16 +186,19 @@ func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
 	if clientID == "" {
 		return nil, ErrClientIDRequired
 	}
-	clientSecret := p.cfg.clientSecret
-	if clientSecret == "" {
+	clientSecret, err := p.cfg.getClientSecret()
+	if err != nil {
+		return nil, fmt.Errorf("onelogin: error reading client secret: %w", err)
+	} else if clientSecret == "" {
 		return nil, ErrClientSecretRequired
 	}
 
 	p.mu.RLock()
 	token := p.token
+	tokenClientSecret := p.tokenClientSecret
 	p.mu.RUnlock()
 
-	if token != nil && token.Valid() {
+	if token != nil && token.Valid() && tokenClientSecret == clientSecret {
 		return token, nil
 	}
 
@@ -174,7 +207,11 @@ func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
 
 	token = p.token
 	if token != nil && token.Valid() {
-		return token, nil
+		if p.tokenClientSecret == clientSecret {
+			return token, nil
+		}
+		// the client secret was rotated, so get a new token with it
+		p.cfg.logger.Info().Str("idp", "onelogin").Msg("client secret changed, refreshing token")
 	}
 
 	apiURL := p.cfg.apiURL.ResolveReference(&url.URL{
@@ -203,6 +240,7 @@ func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
 		return nil, err
 	}
 	p.token = token
+	p.tokenClientSecret = clientSecret
 
 	return p.token, nil
 }
@@ -93,6 +93,32 @@ func getGroupUsers(ctx context.Context, client *http.Client, apiURL *url.URL, en
 	return apiUsers, err
 }
 
+func getGroupParentGroupIDs(ctx context.Context, client *http.Client, apiURL *url.URL, envID, groupID string) ([]string, error) {
+	nextURL := apiURL.ResolveReference(&url.URL{
+		Path: fmt.Sprintf("/v1/environments/%s/groups/%s/memberOfGroups", url.PathEscape(envID), url.PathEscape(groupID)),
+	}).String()
+
+	var parentGroupIDs []string
+	err := batchAPIRequest(ctx, client, nextURL, func(body []byte) error {
+		var apiResponse struct {
+			Embedded struct {
+				GroupMemberships []struct {
+					ID string `json:"id"`
+				} `json:"groupMemberships"`
+			} `json:"_embedded"`
+		}
+		err := json.Unmarshal(body, &apiResponse)
+		if err != nil {
+			return fmt.Errorf("ping: error decoding API response: %w", err)
+		}
+		for _, gm := range apiResponse.Embedded.GroupMemberships {
+			parentGroupIDs = append(parentGroupIDs, gm.ID)
+		}
+		return nil
+	})
+	return parentGroupIDs, err
+}
+
 func batchAPIRequest(ctx context.Context, client *http.Client, nextURL string, callback func(body []byte) error) error {
 	for nextURL != "" {
 		req, err := http.NewRequestWithContext(ctx, "GET", nextURL, nil)
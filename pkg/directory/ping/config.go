@@ -4,21 +4,29 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pomerium/datasource/internal/httputil"
+	"github.com/pomerium/datasource/internal/secretfile"
+	"github.com/pomerium/datasource/pkg/directory"
 )
 
 type config struct {
-	authURL       *url.URL
-	apiURL        *url.URL
-	clientID      string
-	clientSecret  string
-	environmentID string
-	httpClient    *http.Client
-	logger        zerolog.Logger
+	flattenNestedGroups bool
+	authURL             *url.URL
+	apiURL              *url.URL
+	clientID            string
+	clientSecret        string
+	clientSecretFile    *secretfile.File
+	concurrency         int
+	environmentID       string
+	httpClient          *http.Client
+	httpClientOnce      sync.Once
+	logger              zerolog.Logger
+	retryHTTPClient     *http.Client
 }
 
 // An Option updates the Ping configuration.
@@ -52,6 +60,25 @@ func WithClientSecret(clientSecret string) Option {
 	}
 }
 
+// WithClientSecretFile sets the file to read the client secret from in the
+// config. The file is re-read when it changes, and takes precedence over the
+// client secret.
+func WithClientSecretFile(name string) Option {
+	return func(cfg *config) {
+		cfg.clientSecretFile = nil
+		if name != "" {
+			cfg.clientSecretFile = secretfile.New(name)
+		}
+	}
+}
+
+// WithConcurrency sets the number of groups whose members are fetched concurrently.
+func WithConcurrency(concurrency int) Option {
+	return func(cfg *config) {
+		cfg.concurrency = concurrency
+	}
+}
+
 // WithEnvironmentID sets the environment ID in the config.
 func WithEnvironmentID(environmentID string) Option {
 	return func(cfg *config) {
@@ -59,6 +86,14 @@ func WithEnvironmentID(environmentID string) Option {
 	}
 }
 
+// WithFlattenNestedGroups sets whether members of nested groups are also
+// made members of all the ancestor groups.
+func WithFlattenNestedGroups(flattenNestedGroups bool) Option {
+	return func(cfg *config) {
+		cfg.flattenNestedGroups = flattenNestedGroups
+	}
+}
+
 // WithHTTPClient sets the http client option.
 func WithHTTPClient(httpClient *http.Client) Option {
 	return func(cfg *config) {
@@ -88,6 +123,7 @@ func WithProviderURL(providerURL *url.URL) Option {
 
 func getConfig(options ...Option) *config {
 	cfg := new(config)
+	WithConcurrency(directory.DefaultConcurrency)(cfg)
 	WithAuthURL(&url.URL{
 		Scheme: "https",
 		Host:   "auth.pingone.com",
@@ -104,8 +140,18 @@ func getConfig(options ...Option) *config {
 	return cfg
 }
 
+func (cfg *config) getClientSecret() (string, error) {
+	if cfg.clientSecretFile != nil {
+		return cfg.clientSecretFile.Read()
+	}
+	return cfg.clientSecret, nil
+}
+
 func (cfg *config) getHTTPClient() *http.Client {
-	return httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
-		return event.Str("idp", "ping")
+	cfg.httpClientOnce.Do(func() {
+		cfg.retryHTTPClient = httputil.NewRetryClient(httputil.NewLoggingClient(cfg.logger, cfg.httpClient, func(event *zerolog.Event) *zerolog.Event {
+			return event.Str("idp", "ping")
+		}))
 	})
+	return cfg.retryHTTPClient
 }
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,17 +17,24 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/pomerium/datasource/pkg/directory"
+	"github.com/pomerium/datasource/pkg/directory/directorytest"
 )
 
 type M = map[string]interface{}
 
-func newMockAPI(userIDToGroupIDs map[string][]string) http.Handler {
+func newMockAPI(userIDToGroupIDs, groupIDToParentGroupIDs map[string][]string) http.Handler {
 	lookup := map[string]struct{}{}
 	for _, groups := range userIDToGroupIDs {
 		for _, group := range groups {
 			lookup[group] = struct{}{}
 		}
 	}
+	for group, parents := range groupIDToParentGroupIDs {
+		lookup[group] = struct{}{}
+		for _, parent := range parents {
+			lookup[parent] = struct{}{}
+		}
+	}
 	var allGroups []string
 	for groupID := range lookup {
 		allGroups = append(allGroups, groupID)
@@ -88,6 +96,19 @@ func newMockAPI(userIDToGroupIDs map[string][]string) http.Handler {
 				},
 			})
 		})
+		r.Get("/groups/{group_id}/memberOfGroups", func(w http.ResponseWriter, r *http.Request) {
+			var groupMemberships []M
+			for _, id := range groupIDToParentGroupIDs[chi.URLParam(r, "group_id")] {
+				groupMemberships = append(groupMemberships, M{"id": id, "type": "DIRECT"})
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(M{
+				"_embedded": M{
+					"groupMemberships": groupMemberships,
+				},
+			})
+		})
 		r.Route("/users", func(r chi.Router) {
 			r.Get("/{user_id}", func(w http.ResponseWriter, r *http.Request) {
 				userID := chi.URLParam(r, "user_id")
@@ -115,14 +136,13 @@ func newMockAPI(userIDToGroupIDs map[string][]string) http.Handler {
 			})
 			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 				filter := r.URL.Query().Get("filter")
-				userIDs, ok := filterToUserIDs[filter]
-				if !ok {
+				if filter == "" {
 					http.Error(w, "expected filter", http.StatusBadRequest)
 					return
 				}
 
 				var apiUsers []apiUser
-				for _, id := range userIDs {
+				for _, id := range filterToUserIDs[filter] {
 					apiUsers = append(apiUsers, apiUser{
 						ID:    id,
 						Email: id + "@example.com",
@@ -155,29 +175,72 @@ func TestProvider_GetDirectory(t *testing.T) {
 		"user1": {"group1", "group2"},
 		"user2": {"group1", "group3"},
 		"user3": {"group3"},
+	}, map[string][]string{
+		"group2": {"group4"},
+		"group4": {"group5"},
 	}))
-	defer srv.Close()
+	t.Cleanup(srv.Close)
 
 	u, err := url.Parse(srv.URL)
 	require.NoError(t, err)
 
-	p := New(
-		WithAPIURL(u),
-		WithAuthURL(u),
-		WithClientID("CLIENTID"),
-		WithClientSecret("CLIENTSECRET"),
-		WithEnvironmentID("ENVIRONMENTID"),
-	)
-	dgs, dus, err := p.GetDirectory(ctx)
+	newProvider := func(options ...Option) *Provider {
+		return New(append([]Option{
+			WithAPIURL(u),
+			WithAuthURL(u),
+			WithClientID("CLIENTID"),
+			WithClientSecret("CLIENTSECRET"),
+			WithEnvironmentID("ENVIRONMENTID"),
+		}, options...)...)
+	}
+
+	dgs, dus, err := newProvider().GetDirectory(ctx)
 	require.NoError(t, err)
 	assert.Equal(t, []directory.Group{
 		{ID: "group1", Name: "Group group1"},
 		{ID: "group2", Name: "Group group2"},
 		{ID: "group3", Name: "Group group3"},
+		{ID: "group4", Name: "Group group4"},
+		{ID: "group5", Name: "Group group5"},
 	}, dgs)
 	assert.Equal(t, []directory.User{
 		{ID: "user1", DisplayName: "Given-user1 Middle-user1 Family-user1", Email: "user1@example.com", GroupIDs: []string{"group1", "group2"}},
 		{ID: "user2", DisplayName: "Given-user2 Middle-user2 Family-user2", Email: "user2@example.com", GroupIDs: []string{"group1", "group3"}},
 		{ID: "user3", DisplayName: "Given-user3 Middle-user3 Family-user3", Email: "user3@example.com", GroupIDs: []string{"group3"}},
 	}, dus)
+
+	t.Run("flatten nested groups", func(t *testing.T) {
+		t.Parallel()
+
+		_, dus, err := newProvider(WithFlattenNestedGroups(true)).GetDirectory(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []directory.User{
+			{ID: "user1", DisplayName: "Given-user1 Middle-user1 Family-user1", Email: "user1@example.com", GroupIDs: []string{"group1", "group2", "group4", "group5"}},
+			{ID: "user2", DisplayName: "Given-user2 Middle-user2 Family-user2", Email: "user2@example.com", GroupIDs: []string{"group1", "group3"}},
+			{ID: "user3", DisplayName: "Given-user3 Middle-user3 Family-user3", Email: "user3@example.com", GroupIDs: []string{"group3"}},
+		}, dus)
+	})
+}
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	directorytest.Run(t, directorytest.Harness{
+		NewUpstream: directorytest.NewFakeIDPUpstream,
+		NewProvider: func(t *testing.T, rawURL string) directory.Provider {
+			u, err := url.Parse(rawURL)
+			require.NoError(t, err)
+			return New(
+				WithAPIURL(u),
+				WithAuthURL(u),
+				WithClientID("CLIENTID"),
+				WithClientSecret("CLIENTSECRET"),
+				WithEnvironmentID("ENVIRONMENTID"),
+			)
+		},
+		IsTokenRequest: func(r *http.Request) bool {
+			return strings.HasSuffix(r.URL.Path, "/as/token")
+		},
+		NestedGroups: directorytest.NestedGroupsIgnored,
+	})
 }
@@ -3,6 +3,7 @@ package ping
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"sort"
@@ -11,6 +12,7 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 
+	"github.com/pomerium/datasource/internal/tracing"
 	"github.com/pomerium/datasource/pkg/directory"
 )
 
@@ -19,6 +21,8 @@ type Provider struct {
 	cfg   *config
 	mu    sync.RWMutex
 	token *oauth2.Token
+	// tokenClientSecret is the client secret the token was retrieved with
+	tokenClientSecret string
 }
 
 // New creates a new Ping Provider.
@@ -30,7 +34,10 @@ func New(options ...Option) *Provider {
 }
 
 // GetDirectory returns all the users and groups in the directory.
-func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []directory.User, error) {
+func (p *Provider) GetDirectory(ctx context.Context) (_ []directory.Group, _ []directory.User, err error) {
+	ctx, span := tracing.Start(ctx, "ping.GetDirectory")
+	defer func() { tracing.End(span, err) }()
+
 	client, err := p.getClient(ctx)
 	if err != nil {
 		return nil, nil, err
@@ -41,19 +48,40 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 		return nil, nil, err
 	}
 
+	type groupMembers struct {
+		parentGroupIDs []string
+		users          []apiUser
+	}
+	members, err := directory.MapConcurrently(ctx, p.cfg.concurrency, apiGroups,
+		func(ctx context.Context, ag apiGroup) (gm groupMembers, err error) {
+			if p.cfg.flattenNestedGroups {
+				gm.parentGroupIDs, err = getGroupParentGroupIDs(ctx, client, p.cfg.apiURL, p.cfg.environmentID, ag.ID)
+				if err != nil {
+					return gm, err
+				}
+			}
+
+			gm.users, err = getGroupUsers(ctx, client, p.cfg.apiURL, p.cfg.environmentID, ag.ID)
+			return gm, err
+		})
+	if err != nil {
+		return nil, nil, err
+	}
+
 	directoryUserLookup := map[string]directory.User{}
 	directoryGroups := make([]directory.Group, len(apiGroups))
+	groupLookup := directory.NewGroupLookup()
 	for i, ag := range apiGroups {
 		dg := directory.Group{
 			ID:   ag.ID,
 			Name: ag.Name,
 		}
 
-		apiUsers, err := getGroupUsers(ctx, client, p.cfg.apiURL, p.cfg.environmentID, ag.ID)
-		if err != nil {
-			return nil, nil, err
+		for _, parentGroupID := range members[i].parentGroupIDs {
+			groupLookup.AddGroup(parentGroupID, []string{ag.ID}, nil)
 		}
-		for _, au := range apiUsers {
+
+		for _, au := range members[i].users {
 			du, ok := directoryUserLookup[au.ID]
 			if !ok {
 				du = directory.User{
@@ -74,27 +102,76 @@ func (p *Provider) GetDirectory(ctx context.Context) ([]directory.Group, []direc
 
 	directoryUsers := make([]directory.User, 0, len(directoryUserLookup))
 	for _, du := range directoryUserLookup {
+		sort.Strings(du.GroupIDs)
 		directoryUsers = append(directoryUsers, du)
 	}
 	sort.Slice(directoryUsers, func(i, j int) bool {
 		return directoryUsers[i].ID < directoryUsers[j].ID
 	})
+	if p.cfg.flattenNestedGroups {
+		groupLookup.FlattenUsers(ctx, directoryUsers)
+	}
 
 	return directoryGroups, directoryUsers, nil
 }
 
 func (p *Provider) getClient(ctx context.Context) (*http.Client, error) {
-	token, err := p.getToken(ctx)
+	// get a token up front so configuration errors are returned before any api requests
+	_, err := p.getToken(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	// the http client is shared, so wrap its transport in a new client
 	client := p.cfg.getHTTPClient()
-	client.Transport = &oauth2.Transport{
-		Source: oauth2.StaticTokenSource(token),
-		Base:   client.Transport,
+	return &http.Client{
+		Transport: &unauthorizedTransport{
+			p: p,
+			base: &oauth2.Transport{
+				Source: tokenSource{ctx: ctx, p: p},
+				Base:   client.Transport,
+			},
+		},
+		Timeout: client.Timeout,
+	}, nil
+}
+
+// invalidateToken drops the cached access token, so the next request gets a new one.
+func (p *Provider) invalidateToken() {
+	p.mu.Lock()
+	p.token = nil
+	p.mu.Unlock()
+}
+
+// A tokenSource returns the provider's cached access token, getting a new one
+// when it's expired or was invalidated.
+type tokenSource struct {
+	ctx context.Context
+	p   *Provider
+}
+
+func (ts tokenSource) Token() (*oauth2.Token, error) {
+	return ts.p.getToken(ts.ctx)
+}
+
+// An unauthorizedTransport retries a request with a new access token when the
+// api responds with a 401, like when the token was revoked before it expired.
+type unauthorizedTransport struct {
+	p    *Provider
+	base http.RoundTripper
+}
+
+func (t *unauthorizedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	// only requests without a body can be sent again
+	if err != nil || res.StatusCode != http.StatusUnauthorized || req.Body != nil {
+		return res, err
 	}
-	return client, nil
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+
+	t.p.invalidateToken()
+	return t.base.RoundTrip(req)
 }
 
 func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
@@ -106,16 +183,19 @@ func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
 	if clientID == "" {
 		return nil, ErrClientIDRequired
 	}
-	clientSecret := p.cfg.clientSecret
-	if clientSecret == "" {
+	clientSecret, err := p.cfg.getClientSecret()
+	if err != nil {
+		return nil, fmt.Errorf("ping: error reading client secret: %w", err)
+	} else if clientSecret == "" {
 		return nil, ErrClientSecretRequired
 	}
 
 	p.mu.RLock()
 	token := p.token
+	tokenClientSecret := p.tokenClientSecret
 	p.mu.RUnlock()
 
-	if token != nil && token.Valid() {
+	if token != nil && token.Valid() && tokenClientSecret == clientSecret {
 		return token, nil
 	}
 
@@ -124,7 +204,11 @@ func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
 
 	token = p.token
 	if token != nil && token.Valid() {
-		return token, nil
+		if p.tokenClientSecret == clientSecret {
+			return token, nil
+		}
+		// the client secret was rotated, so get a new token with it
+		p.cfg.logger.Info().Str("idp", "ping").Msg("client secret changed, refreshing token")
 	}
 
 	ocfg := &clientcredentials.Config{
@@ -134,11 +218,11 @@ func (p *Provider) getToken(ctx context.Context) (*oauth2.Token, error) {
 			Path: fmt.Sprintf("/%s/as/token", environmentID),
 		}).String(),
 	}
-	var err error
 	p.token, err = ocfg.Token(ctx)
 	if err != nil {
 		return nil, err
 	}
+	p.tokenClientSecret = clientSecret
 
 	return p.token, nil
 }
@@ -24,3 +24,27 @@ type PersistentProvider interface {
 	LoadDirectoryState(ctx context.Context, src io.Reader) error
 	SaveDirectoryState(ctx context.Context, dst io.Writer) error
 }
+
+// withPersistence returns a Provider that uses p to get the directory and, if wrapped
+// is a PersistentProvider, wrapped to load and save directory state.
+func withPersistence(p Provider, wrapped Provider) Provider {
+	if pp, ok := wrapped.(PersistentProvider); ok {
+		return persistentProvider{Provider: p, persistent: pp}
+	}
+	return p
+}
+
+type persistentProvider struct {
+	Provider
+	persistent PersistentProvider
+}
+
+// LoadDirectoryState loads the directory state from a reader.
+func (p persistentProvider) LoadDirectoryState(ctx context.Context, src io.Reader) error {
+	return p.persistent.LoadDirectoryState(ctx, src)
+}
+
+// SaveDirectoryState saves the directory state to a writer.
+func (p persistentProvider) SaveDirectoryState(ctx context.Context, dst io.Writer) error {
+	return p.persistent.SaveDirectoryState(ctx, dst)
+}
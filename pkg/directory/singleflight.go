@@ -0,0 +1,73 @@
+package directory
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type singleFlightProvider struct {
+	ctx      context.Context
+	provider Provider
+	group    singleflight.Group
+}
+
+// NewSingleFlightProvider creates a new Provider that coalesces concurrent calls to
+// GetDirectory, so that callers arriving while a call is in flight share its result
+// instead of starting another sync.
+//
+// The shared call is detached from the cancellation of the caller that started it, so
+// that one caller going away doesn't fail the others. Instead it's canceled when ctx,
+// the lifetime of the provider, is done. Each caller still stops waiting when its own
+// context is done.
+//
+// If the provider is a PersistentProvider, so is the returned provider.
+func NewSingleFlightProvider(ctx context.Context, provider Provider) Provider {
+	return withPersistence(&singleFlightProvider{ctx: ctx, provider: provider}, provider)
+}
+
+// GetDirectory gets all the groups and users in a directory.
+func (p *singleFlightProvider) GetDirectory(ctx context.Context) ([]Group, []User, error) {
+	type result struct {
+		groups []Group
+		users  []User
+	}
+
+	ch := p.group.DoChan("", func() (any, error) {
+		ctx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+		defer cancel(nil)
+		stop := context.AfterFunc(p.ctx, func() { cancel(context.Cause(p.ctx)) })
+		defer stop()
+
+		groups, users, err := p.provider.GetDirectory(ctx)
+		return result{groups, users}, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, nil, context.Cause(ctx)
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, nil, res.Err
+		}
+		// each caller gets its own copy so that modifications aren't shared
+		r := res.Val.(result)
+		groups, users := cloneDirectory(r.groups, r.users)
+		return groups, users, nil
+	}
+}
+
+func cloneDirectory(groups []Group, users []User) ([]Group, []User) {
+	groups = slices.Clone(groups)
+	for i := range groups {
+		groups[i].Attributes = maps.Clone(groups[i].Attributes)
+	}
+	users = slices.Clone(users)
+	for i := range users {
+		users[i].GroupIDs = slices.Clone(users[i].GroupIDs)
+		users[i].Attributes = maps.Clone(users[i].Attributes)
+	}
+	return groups, users
+}
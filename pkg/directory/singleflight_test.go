@@ -0,0 +1,126 @@
+package directory
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockPersistentProvider struct {
+	Provider
+	state bytes.Buffer
+}
+
+func (p *mockPersistentProvider) LoadDirectoryState(_ context.Context, src io.Reader) error {
+	_, err := io.Copy(&p.state, src)
+	return err
+}
+
+func (p *mockPersistentProvider) SaveDirectoryState(_ context.Context, dst io.Writer) error {
+	_, err := dst.Write(p.state.Bytes())
+	return err
+}
+
+func TestSingleFlightProvider(t *testing.T) {
+	t.Parallel()
+
+	t.Run("coalesce", func(t *testing.T) {
+		t.Parallel()
+
+		synctest.Test(t, func(t *testing.T) {
+			var calls atomic.Int64
+			release := make(chan struct{})
+			p := NewSingleFlightProvider(t.Context(), ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+				calls.Add(1)
+				<-release
+				return []Group{{ID: "g1"}}, []User{{ID: "u1", GroupIDs: []string{"g1"}}}, nil
+			}))
+
+			var wg sync.WaitGroup
+			results := make([][]User, 10)
+			for i := range results {
+				wg.Go(func() {
+					_, users, err := p.GetDirectory(t.Context())
+					assert.NoError(t, err)
+					results[i] = users
+				})
+			}
+			// wait for every caller to be blocked on the shared call
+			synctest.Wait()
+			close(release)
+			wg.Wait()
+
+			assert.Equal(t, int64(1), calls.Load())
+			for _, users := range results {
+				assert.Equal(t, []User{{ID: "u1", GroupIDs: []string{"g1"}}}, users)
+			}
+
+			// results are not shared between callers
+			results[0][0].GroupIDs[0] = "modified"
+			assert.Equal(t, "g1", results[1][0].GroupIDs[0])
+		})
+	})
+	t.Run("shutdown", func(t *testing.T) {
+		t.Parallel()
+
+		lifetime, cancel := context.WithCancel(t.Context())
+		p := NewSingleFlightProvider(lifetime, ProviderFunc(func(ctx context.Context) ([]Group, []User, error) {
+			<-ctx.Done()
+			return nil, nil, context.Cause(ctx)
+		}))
+
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := p.GetDirectory(context.Background())
+			errc <- err
+		}()
+		cancel()
+		select {
+		case err := <-errc:
+			assert.ErrorIs(t, err, context.Canceled, "the shared call should stop when the provider is shut down")
+		case <-time.After(5 * time.Second):
+			t.Fatal("the shared call was not canceled")
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		t.Parallel()
+
+		release := make(chan struct{})
+		defer close(release)
+		p := NewSingleFlightProvider(t.Context(), ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+			<-release
+			return nil, nil, nil
+		}))
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		_, _, err := p.GetDirectory(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("persistent", func(t *testing.T) {
+		t.Parallel()
+
+		p := NewSingleFlightProvider(t.Context(), &mockPersistentProvider{Provider: ProviderFunc(func(_ context.Context) ([]Group, []User, error) {
+			return nil, nil, nil
+		})})
+		pp, ok := p.(PersistentProvider)
+		if !assert.True(t, ok) {
+			return
+		}
+
+		assert.NoError(t, pp.LoadDirectoryState(t.Context(), bytes.NewReader([]byte("STATE"))))
+		var buf bytes.Buffer
+		assert.NoError(t, pp.SaveDirectoryState(t.Context(), &buf))
+		assert.Equal(t, "STATE", buf.String())
+
+		_, ok = NewSingleFlightProvider(t.Context(), ProviderFunc(nil)).(PersistentProvider)
+		assert.False(t, ok)
+	})
+}
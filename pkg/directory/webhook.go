@@ -0,0 +1,144 @@
+package directory
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// WebhookSignatureHeader is the header containing the signature of a webhook request.
+	WebhookSignatureHeader = "X-Datasource-Signature"
+
+	webhookMaxElapsedTime = 5 * time.Minute
+	webhookTimeout        = 30 * time.Second
+)
+
+type webhook struct {
+	url    string
+	secret []byte
+}
+
+// SignWebhook returns the signature header value for a webhook body sent at the
+// given time. The signature has the form "t=<unix timestamp>,v1=<hex>", where
+// the hex value is the HMAC-SHA256 of "<unix timestamp>.<body>" using the secret.
+func SignWebhook(secret []byte, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(webhookMAC(secret, t, body))
+}
+
+// VerifyWebhook verifies the signature header value of a webhook body. If
+// tolerance is positive, signatures older or newer than the tolerance are
+// rejected to prevent replays.
+func VerifyWebhook(secret []byte, signature string, body []byte, tolerance time.Duration) error {
+	var t, v1 string
+	for _, part := range strings.Split(signature, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			v1 = v
+		}
+	}
+
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return fmt.Errorf("directory: invalid webhook signature timestamp: %w", err)
+	}
+	mac, err := hex.DecodeString(v1)
+	if err != nil {
+		return fmt.Errorf("directory: invalid webhook signature: %w", err)
+	}
+	if !hmac.Equal(mac, webhookMAC(secret, t, body)) {
+		return errors.New("directory: webhook signature mismatch")
+	}
+	if d := time.Since(time.Unix(unix, 0)).Abs(); tolerance > 0 && d > tolerance {
+		return errors.New("directory: webhook signature timestamp outside of tolerance")
+	}
+	return nil
+}
+
+func webhookMAC(secret []byte, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// notify sends the change event to all the configured webhooks in the
+// background. Deliveries are retried with an exponential backoff, so receivers
+// may see events out of order and should use the cursor to order them.
+func (h *Handler) notify(ctx context.Context, evt ChangeEvent) {
+	if len(h.cfg.webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(evt)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("directory: error encoding change event")
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	for _, wh := range h.cfg.webhooks {
+		go func() {
+			bo := backoff.NewExponentialBackOff(backoff.WithMaxElapsedTime(webhookMaxElapsedTime))
+			err := backoff.Retry(func() error {
+				return h.sendWebhook(ctx, wh, body)
+			}, backoff.WithContext(bo, ctx))
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).
+					Str("url", wh.url).
+					Uint64("cursor", evt.Cursor).
+					Msg("directory: error sending webhook")
+			}
+		}()
+	}
+}
+
+func (h *Handler) sendWebhook(ctx context.Context, wh webhook, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(fmt.Errorf("failed to create webhook request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(wh.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(wh.secret, time.Now(), body))
+	}
+
+	res, err := h.cfg.webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook request: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode/100 == 2:
+		return nil
+	case res.StatusCode/100 == 4 &&
+		res.StatusCode != http.StatusRequestTimeout &&
+		res.StatusCode != http.StatusTooManyRequests:
+		return backoff.Permanent(fmt.Errorf("unexpected webhook response status code: %d", res.StatusCode))
+	default:
+		return fmt.Errorf("unexpected webhook response status code: %d", res.StatusCode)
+	}
+}